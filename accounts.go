@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// accountsCommand implements the "accounts" subcommand, which operates on
+// accounts files rather than pulling cost data:
+//
+//	costpuller accounts diff <old.yaml> <new.yaml>
+//	costpuller accounts lint [options] <accounts.yaml>
+//	costpuller accounts update-standardvalues [options] <accounts.yaml>
+func accountsCommand(args []string) {
+	if len(args) > 0 && args[0] == "lint" {
+		accountsLintCommand(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "update-standardvalues" {
+		accountsUpdateCommand(args[1:])
+		return
+	}
+	flags := flag.NewFlagSet("accounts", flag.ExitOnError)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "Usage: costpuller accounts diff <old.yaml> <new.yaml>")
+		_, _ = fmt.Fprintln(flags.Output(), "       costpuller accounts lint [options] <accounts.yaml>")
+		_, _ = fmt.Fprintln(flags.Output(), "       costpuller accounts update-standardvalues [options] <accounts.yaml>")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 3 || flags.Arg(0) != "diff" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	oldFile, err := loadAccountsFile(flags.Arg(1))
+	if err != nil {
+		fatalf("[accounts] error loading %s: %v", flags.Arg(1), err)
+	}
+	newFile, err := loadAccountsFile(flags.Arg(2))
+	if err != nil {
+		fatalf("[accounts] error loading %s: %v", flags.Arg(2), err)
+	}
+	differences := diffAccountsFiles(oldFile, newFile)
+	if len(differences) == 0 {
+		fmt.Println("No account differences.")
+		return
+	}
+	for _, line := range differences {
+		fmt.Println(line)
+	}
+}
+
+// accountLocation records where an account was found in an accounts file.
+type accountLocation struct {
+	group string
+	entry AccountEntry
+}
+
+// getAccountLocations indexes the accounts in the file by cloud provider and
+// account ID.
+func getAccountLocations(accountsFile AccountsFile) map[string]map[string]accountLocation {
+	locations := make(map[string]map[string]accountLocation)
+	for provider, groups := range accountsFile.Providers {
+		locations[provider] = make(map[string]accountLocation)
+		for group, entries := range groups {
+			for _, entry := range entries {
+				locations[provider][entry.AccountID] = accountLocation{group: group, entry: entry}
+			}
+		}
+	}
+	return locations
+}
+
+// diffAccountsFiles compares two versions of an accounts file and returns a
+// description of each account which was added, removed, or moved to another
+// group, or whose standard value or deviation percentage was changed.
+func diffAccountsFiles(oldFile AccountsFile, newFile AccountsFile) (differences []string) {
+	oldLocations := getAccountLocations(oldFile)
+	newLocations := getAccountLocations(newFile)
+	providers := make(map[string]struct{})
+	for provider := range oldLocations {
+		providers[provider] = struct{}{}
+	}
+	for provider := range newLocations {
+		providers[provider] = struct{}{}
+	}
+
+	for _, provider := range sortedKeys(providers) {
+		accountIDs := make(map[string]struct{})
+		for accountID := range oldLocations[provider] {
+			accountIDs[accountID] = struct{}{}
+		}
+		for accountID := range newLocations[provider] {
+			accountIDs[accountID] = struct{}{}
+		}
+		for _, accountID := range sortedKeys(accountIDs) {
+			oldLocation, inOld := oldLocations[provider][accountID]
+			newLocation, inNew := newLocations[provider][accountID]
+			switch {
+			case !inOld:
+				differences = append(differences, fmt.Sprintf("added:   %s %s (%q) in group %q, standard value %.2f",
+					provider, accountID, newLocation.entry.Description, newLocation.group, newLocation.entry.StandardValue))
+				continue
+			case !inNew:
+				differences = append(differences, fmt.Sprintf("removed: %s %s (%q) from group %q",
+					provider, accountID, oldLocation.entry.Description, oldLocation.group))
+				continue
+			}
+			if oldLocation.group != newLocation.group {
+				differences = append(differences, fmt.Sprintf("moved:   %s %s (%q) from group %q to %q",
+					provider, accountID, newLocation.entry.Description, oldLocation.group, newLocation.group))
+			}
+			if oldLocation.entry.StandardValue != newLocation.entry.StandardValue {
+				differences = append(differences, fmt.Sprintf("changed: %s %s (%q) standard value %.2f -> %.2f",
+					provider, accountID, newLocation.entry.Description,
+					oldLocation.entry.StandardValue, newLocation.entry.StandardValue))
+			}
+			if oldLocation.entry.DeviationPercent != newLocation.entry.DeviationPercent {
+				differences = append(differences, fmt.Sprintf("changed: %s %s (%q) deviation percent %d -> %d",
+					provider, accountID, newLocation.entry.Description,
+					oldLocation.entry.DeviationPercent, newLocation.entry.DeviationPercent))
+			}
+		}
+	}
+	return
+}
+
+// isRemoteAccountsFile reports whether the accounts file name is an HTTP(S)
+// URL.
+func isRemoteAccountsFile(accountsFileName string) bool {
+	return strings.HasPrefix(accountsFileName, "https://") || strings.HasPrefix(accountsFileName, "http://")
+}
+
+// getAccountsSource returns the contents of the accounts file, which may be a
+// local file or an HTTPS URL.  For URLs, credentials may be supplied in the
+// URL itself (for basic authentication) or as a bearer token in the
+// COSTPULLER_ACCOUNTS_TOKEN environment variable; so that neither is sent in
+// cleartext, plain HTTP URLs (and redirects to them) are refused.
+func getAccountsSource(accountsFileName string) ([]byte, error) {
+	if !isRemoteAccountsFile(accountsFileName) {
+		return os.ReadFile(accountsFileName)
+	}
+	if !strings.HasPrefix(accountsFileName, "https://") {
+		return nil, fmt.Errorf("accounts file %s is not an HTTPS URL; remote accounts files must use HTTPS",
+			accountsFileName)
+	}
+	request, err := http.NewRequest(http.MethodGet, accountsFileName, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("COSTPULLER_ACCOUNTS_TOKEN"); token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := newAuditedHttpClient("accounts", time.Second*60)
+	client.CheckRedirect = func(request *http.Request, via []*http.Request) error {
+		if request.URL.Scheme != "https" {
+			return fmt.Errorf("redirected to %s, which is not an HTTPS URL", request.URL.Redacted())
+		}
+		return nil
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(response)
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching %s: %d, %q", accountsFileName, response.StatusCode, response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// getIncludedFiles returns the names of the files matched by an include
+// pattern.  For local files, the pattern is a glob, relative to the directory
+// of the including file; for remote files, it is a URL reference, relative to
+// the including URL, and is not expanded; it must resolve to an HTTPS URL on
+// the same host, to which the credentials for the including URL may be sent.
+func getIncludedFiles(accountsFileName string, pattern string) ([]string, error) {
+	if isRemoteAccountsFile(accountsFileName) {
+		base, err := url.Parse(accountsFileName)
+		if err != nil {
+			return nil, err
+		}
+		reference, err := url.Parse(pattern)
+		if err != nil {
+			return nil, err
+		}
+		included := base.ResolveReference(reference)
+		if included.Scheme != "https" || included.Host != base.Host {
+			return nil, fmt.Errorf("%s is not an HTTPS URL on %s", included.Redacted(), base.Host)
+		}
+		return []string{included.String()}, nil
+	}
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(accountsFileName), pattern)
+	}
+	return filepath.Glob(pattern)
+}
+
+// gitAccountsPattern matches an accounts file location in a git repository,
+// of the form "git+<repository-url>#[<ref>:]<path>", e.g.,
+// "git+git@github.com:example/finops.git#main:accounts.yaml".
+var gitAccountsPattern = regexp.MustCompile(`^git\+([^#]+)#(?:([^:]+):)?(.+)$`)
+
+// checkoutAccountsRepo makes a shallow clone of the repository named in a
+// "git+" accounts file location, in a temporary directory, and returns the
+// directory and the path of the accounts file within it; the caller is
+// responsible for removing the directory.  Authentication is handled by git,
+// using the user's usual SSH keys or credential helpers.
+func checkoutAccountsRepo(location string) (checkout string, path string, err error) {
+	matches := gitAccountsPattern.FindStringSubmatch(location)
+	if matches == nil {
+		return "", "", fmt.Errorf("unrecognized git location %q, expected \"git+<repository>#[<ref>:]<path>\"", location)
+	}
+	checkout, err = os.MkdirTemp("", "costpuller-accounts-")
+	if err != nil {
+		return "", "", err
+	}
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if matches[2] != "" {
+		args = append(args, "--branch", matches[2])
+	}
+	args = append(args, matches[1], checkout)
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		_ = os.RemoveAll(checkout)
+		return "", "", fmt.Errorf("error cloning %s: %v", matches[1], err)
+	}
+	return checkout, matches[3], nil
+}
+
+// convertAccountsFileToYaml accepts the contents of an accounts file and, if
+// the file name has a ".json" or ".toml" extension, decodes it and re-encodes
+// it as YAML, so that all formats produce the same configuration values;
+// other files are assumed to be YAML and are returned as is.
+func convertAccountsFileToYaml(accountsFileName string, data []byte) ([]byte, error) {
+	if isRemoteAccountsFile(accountsFileName) {
+		if u, err := url.Parse(accountsFileName); err == nil {
+			accountsFileName = u.Path
+		}
+	}
+	var contents any
+	switch strings.ToLower(filepath.Ext(accountsFileName)) {
+	case ".json":
+		if err := json.Unmarshal(data, &contents); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &contents); err != nil {
+			return nil, err
+		}
+	default:
+		return data, nil
+	}
+	return yaml.Marshal(contents)
+}
+
+// envReferencePattern matches a "${VAR}" environment variable reference.
+var envReferencePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnvReferences returns a copy of a configuration value in which each
+// "${VAR}" reference in each string (at any depth) is replaced by the value of
+// the environment variable; a reference to an unset variable is an error.
+func expandEnvReferences(value any) (any, error) {
+	switch v := value.(type) {
+	case string:
+		var err error
+		expanded := envReferencePattern.ReplaceAllStringFunc(v, func(reference string) string {
+			name := envReferencePattern.FindStringSubmatch(reference)[1]
+			envValue, exists := os.LookupEnv(name)
+			if !exists && err == nil {
+				err = fmt.Errorf("environment variable %q is not set", name)
+			}
+			return envValue
+		})
+		return expanded, err
+	case Configuration:
+		result := make(Configuration, len(v))
+		for key, item := range v {
+			expanded, err := expandEnvReferences(item)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = expanded
+		}
+		return result, nil
+	case map[any]any:
+		result := make(map[any]any, len(v))
+		for key, item := range v {
+			expanded, err := expandEnvReferences(item)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = expanded
+		}
+		return result, nil
+	case []any:
+		result := make([]any, len(v))
+		for idx, item := range v {
+			expanded, err := expandEnvReferences(item)
+			if err != nil {
+				return nil, err
+			}
+			result[idx] = expanded
+		}
+		return result, nil
+	default:
+		return value, nil
+	}
+}
+
+// isSopsEncrypted reports whether the contents of an accounts file (YAML or
+// JSON) have been encrypted with sops, which adds a top-level "sops" mapping
+// containing the encryption metadata.
+func isSopsEncrypted(data []byte) bool {
+	var contents struct {
+		Sops map[string]any `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(data, &contents); err != nil {
+		return false
+	}
+	_, hasMac := contents.Sops["mac"]
+	return hasMac
+}
+
+// sopsDecrypt decrypts the contents of a sops-encrypted accounts file by
+// running the sops command (which must be installed), so that all of its key
+// sources (age, PGP, and the cloud KMS services) are supported, using the
+// user's usual sops configuration and credentials.  The encrypted contents
+// are copied to a temporary file with the same extension as the original, so
+// that sops detects the same format.
+func sopsDecrypt(accountsFileName string, data []byte) ([]byte, error) {
+	ext := filepath.Ext(accountsFileName)
+	if isRemoteAccountsFile(accountsFileName) {
+		if u, err := url.Parse(accountsFileName); err == nil {
+			ext = filepath.Ext(u.Path)
+		}
+	}
+	encrypted, err := os.CreateTemp("", "costpuller-accounts-*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(encrypted.Name()) }()
+	_, err = encrypted.Write(data)
+	closeFile(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("sops", "--decrypt", encrypted.Name())
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
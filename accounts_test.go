@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetAccountsSourceRefusesHttp(t *testing.T) {
+	t.Setenv("COSTPULLER_ACCOUNTS_TOKEN", "secret")
+	_, err := getAccountsSource("http://finops.example.com/accounts.yaml")
+	if err == nil || !strings.Contains(err.Error(), "HTTPS") {
+		t.Errorf("fetching an HTTP accounts file returned error %v, expected it to be refused", err)
+	}
+}
+
+func TestGetIncludedFilesRemote(t *testing.T) {
+	base := "https://finops.example.com/accounts/main.yaml"
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{pattern: "teams/eng.yaml", expected: "https://finops.example.com/accounts/teams/eng.yaml"},
+		{pattern: "/shared/ops.yaml", expected: "https://finops.example.com/shared/ops.yaml"},
+		{pattern: "https://finops.example.com/other.yaml", expected: "https://finops.example.com/other.yaml"},
+		{pattern: "http://finops.example.com/other.yaml"},
+		{pattern: "https://elsewhere.example.com/accounts.yaml"},
+		{pattern: "//elsewhere.example.com/accounts.yaml"},
+	}
+	for _, tt := range tests {
+		fileNames, err := getIncludedFiles(base, tt.pattern)
+		switch {
+		case tt.expected == "" && err == nil:
+			t.Errorf("including %q resolved to %q, expected an error", tt.pattern, fileNames)
+		case tt.expected != "" && err != nil:
+			t.Errorf("including %q returned error %v", tt.pattern, err)
+		case tt.expected != "" && (len(fileNames) != 1 || fileNames[0] != tt.expected):
+			t.Errorf("including %q resolved to %q, expected %q", tt.pattern, fileNames, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// AlertRule is a condition, from the "rules" list of the "alerts" subsection
+// of the configuration, which is evaluated against the results of a run.
+type AlertRule struct {
+	Name      string
+	Condition string
+	Team      string // If set, the rule applies only to this team
+
+	scope     string // "team" or "account"
+	metric    string // "total" or "growth"
+	operator  string
+	threshold float64
+	missing   bool // The "missing data source" condition
+}
+
+// alertConditionPattern matches conditions such as "team total > 50000" or
+// "account growth >= 25%".
+var alertConditionPattern = regexp.MustCompile(`^\s*(team|account)\s+(total|growth)\s*(>=|<=|>|<)\s*([0-9.]+)\s*%?\s*$`)
+
+// getAlertRules parses the alert rules from the "alerts" subsection.
+func getAlertRules(configMap Configuration) (rules []AlertRule) {
+	rulesAny := getMapKeyValue(configMap, "rules", "")
+	if rulesAny == nil {
+		return nil
+	}
+	ruleList, ok := rulesAny.([]any)
+	if !ok {
+		fatalf("Error in alerts \"rules\" value (%v), expected a list", rulesAny)
+	}
+	for _, ruleAny := range ruleList {
+		ruleConfig := getConfigurationFromAny(ruleAny, "alert rule")
+		rule := AlertRule{
+			Name:      getMapKeyString(ruleConfig, "name", ""),
+			Condition: getMapKeyString(ruleConfig, "condition", "alert rule"),
+			Team:      getMapKeyString(ruleConfig, "team", ""),
+		}
+		if rule.Name == "" {
+			rule.Name = rule.Condition
+		}
+		if rule.Condition == "missing data source" {
+			rule.missing = true
+		} else if matches := alertConditionPattern.FindStringSubmatch(rule.Condition); matches != nil {
+			rule.scope, rule.metric, rule.operator = matches[1], matches[2], matches[3]
+			rule.threshold, _ = strconv.ParseFloat(matches[4], 64)
+		} else {
+			fatalf("Error in alert rule %q:  unrecognized condition %q; expected "+
+				"\"team|account total|growth >|>=|<|<= <value>[%%]\" or \"missing data source\"",
+				rule.Name, rule.Condition)
+		}
+		rules = append(rules, rule)
+	}
+	return
+}
+
+// compare applies the rule's operator to the value and its threshold.
+func (r AlertRule) compare(value float64) bool {
+	switch r.operator {
+	case ">":
+		return value > r.threshold
+	case ">=":
+		return value >= r.threshold
+	case "<":
+		return value < r.threshold
+	case "<=":
+		return value <= r.threshold
+	}
+	return false
+}
+
+// evaluateAlerts checks the alert rules against the current run's records
+// (and, for growth conditions, the previous month's records from the history
+// database) and returns a message for each alert which fires.
+func evaluateAlerts(
+	accountsFile AccountsFile,
+	records []HistoryRecord,
+	accountsMetadata map[string]*AccountMetadata,
+	month string,
+	costType string,
+) (alerts []string) {
+	rules := getAlertRules(accountsFile.Configuration["alerts"])
+	if len(rules) == 0 {
+		return nil
+	}
+
+	current := make(map[string]float64) // Account totals
+	teams := make(map[string]float64)   // Team totals
+	accountTeam := make(map[string]string)
+	for _, record := range records {
+		current[record.AccountID] += record.Total
+		teams[record.Group] += record.Total
+		accountTeam[record.AccountID] = record.Group
+	}
+
+	var previous, previousTeams map[string]float64
+	for _, rule := range rules {
+		if rule.metric == "growth" && previous == nil {
+			previous, previousTeams = getPreviousMonthTotals(accountsFile, month, costType)
+		}
+		switch {
+		case rule.missing:
+			for _, id := range sortedKeys(accountsMetadata) {
+				entry := accountsMetadata[id]
+				if entry.Excluded {
+					continue
+				}
+				if _, found := current[entry.AccountId]; !found && (rule.Team == "" || rule.Team == entry.Group) {
+					alerts = append(alerts, fmt.Sprintf("%s: no data source found for account %s:%s:%s",
+						rule.Name, entry.CloudProvider, entry.Group, entry.AccountId))
+				}
+			}
+		case rule.scope == "team":
+			for _, team := range sortedKeys(teams) {
+				if rule.Team != "" && rule.Team != team {
+					continue
+				}
+				if value, ok := rule.getValue(teams[team], previousTeams[team]); ok && rule.compare(value) {
+					alerts = append(alerts, fmt.Sprintf("%s: team %s %s is %s", rule.Name, team, rule.metric,
+						rule.formatValue(value)))
+				}
+			}
+		case rule.scope == "account":
+			for _, accountID := range sortedKeys(current) {
+				if rule.Team != "" && rule.Team != accountTeam[accountID] {
+					continue
+				}
+				if value, ok := rule.getValue(current[accountID], previous[accountID]); ok && rule.compare(value) {
+					alerts = append(alerts, fmt.Sprintf("%s: account %s (team %s) %s is %s", rule.Name, accountID,
+						accountTeam[accountID], rule.metric, rule.formatValue(value)))
+				}
+			}
+		}
+	}
+	return
+}
+
+// getValue returns the value of the rule's metric given the current and
+// previous totals; growth is undefined (and not reported) if there is no
+// previous total.
+func (r AlertRule) getValue(current float64, previous float64) (float64, bool) {
+	if r.metric == "growth" {
+		if previous == 0 {
+			return 0, false
+		}
+		return (current - previous) / previous * 100, true
+	}
+	return current, true
+}
+
+func (r AlertRule) formatValue(value float64) string {
+	if r.metric == "growth" {
+		return fmt.Sprintf("%.1f%%", value)
+	}
+	return fmt.Sprintf("%.2f", value)
+}
+
+// getPreviousMonthTotals returns the account and team totals for the month
+// preceding the given one, of the given cost type, from the history database.
+func getPreviousMonthTotals(
+	accountsFile AccountsFile,
+	month string,
+	costType string,
+) (accounts, teams map[string]float64) {
+	accounts, teams = make(map[string]float64), make(map[string]float64)
+	ref, err := time.Parse("2006-01", month)
+	if err != nil {
+		fatalf("[getPreviousMonthTotals] error parsing month value, %q: %v", month, err)
+	}
+	previousMonth := ref.AddDate(0, -1, 0).Format("2006-01")
+	records, err := readHistory(accountsFile, costType)
+	if err != nil {
+		fatalf("[getPreviousMonthTotals] error reading history: %v", err)
+	}
+	for _, record := range records {
+		if record.Month == previousMonth {
+			accounts[record.AccountID] += record.Total
+			teams[record.Group] += record.Total
+		}
+	}
+	return
+}
+
+// defaultSilentAccountThreshold is the cost in the previous month above which
+// an account is reported if it has no cost in the current month, unless the
+// "silent_account_threshold" key of the "alerts" subsection is set.
+const defaultSilentAccountThreshold = 10.0
+
+// findSilentAccounts returns a message for each account (of those in the run)
+// which had a cost above the threshold in the previous month, according to
+// the history database, but has no cost, or no data at all, in this month;
+// this is usually a sign of broken tagging or of an account which has moved.
+// A negative threshold disables the check, as does disabling the history.
+func findSilentAccounts(
+	accountsFile AccountsFile,
+	records []HistoryRecord,
+	accountsMetadata map[string]*AccountMetadata,
+	month string,
+	costType string,
+) (alerts []string) {
+	threshold := defaultSilentAccountThreshold
+	alertsConfig := accountsFile.Configuration["alerts"]
+	if value := getMapKeyValue(alertsConfig, "silent_account_threshold", ""); value != nil {
+		switch v := value.(type) {
+		case int:
+			threshold = float64(v)
+		case float64:
+			threshold = v
+		default:
+			fatalf("Error in alerts \"silent_account_threshold\" value (%v), expected a number", value)
+		}
+	}
+	if threshold < 0 || getMapKeyBool(accountsFile.Configuration["history"], "disabled", "") {
+		return nil
+	}
+
+	current := make(map[string]float64)
+	for _, record := range records {
+		current[record.AccountID] += record.Total
+	}
+	previous, _ := getPreviousMonthTotals(accountsFile, month, costType)
+	for _, id := range sortedKeys(accountsMetadata) {
+		entry := accountsMetadata[id]
+		if entry.Excluded || previous[entry.AccountId] <= threshold {
+			continue
+		}
+		total, found := current[entry.AccountId]
+		switch {
+		case !found:
+			alerts = append(alerts, fmt.Sprintf("account %s:%s:%s cost %.2f last month but has no data this month",
+				entry.CloudProvider, entry.Group, entry.AccountId, previous[entry.AccountId]))
+		case math.Abs(total) < 0.005:
+			alerts = append(alerts, fmt.Sprintf("account %s:%s:%s cost %.2f last month but nothing this month",
+				entry.CloudProvider, entry.Group, entry.AccountId, previous[entry.AccountId]))
+		}
+	}
+	return
+}
+
+// reportAlerts evaluates the alert rules and looks for newly-silent accounts,
+// records the resulting alerts in the report, and dispatches them to the
+// configured notification sinks.
+func reportAlerts(
+	accountsFile AccountsFile,
+	records []HistoryRecord,
+	accountsMetadata map[string]*AccountMetadata,
+	month string,
+	costType string,
+	reportFile *os.File,
+) {
+	alerts := evaluateAlerts(accountsFile, records, accountsMetadata, month, costType)
+	alerts = append(alerts, findSilentAccounts(accountsFile, records, accountsMetadata, month, costType)...)
+	for _, alert := range alerts {
+		log.Printf("[reportAlerts] alert: %s", alert)
+		writeReport(reportFile, "ALERT: "+alert)
+		noteExitStatus(ExitWarnings, "alert: "+alert)
+	}
+	sendNotifications(
+		accountsFile.Configuration["notifications"],
+		fmt.Sprintf("costpuller: %d alert(s) for %s", len(alerts), month),
+		alerts,
+	)
+}
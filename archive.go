@@ -0,0 +1,120 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ResponseArchive saves the raw responses from the providers' APIs, so that
+// the numbers in the output can be audited later.  Each response is saved as
+// gzipped JSON, in a file named <month>/<provider>/<account>/<name>.json.gz
+// in the archive directory; a later run for the same month replaces them.
+// When replaying, the responses are read from the archive instead of being
+// requested from the providers.
+type ResponseArchive struct {
+	dir    string
+	month  string
+	replay bool
+}
+
+// responseArchive is the archive for the process; when it is nil (the
+// default), responses are not saved.
+var responseArchive *ResponseArchive
+
+// openResponseArchive enables archiving of the responses for the given month
+// to the given directory; an empty directory name leaves it disabled.
+func openResponseArchive(dir string, month string) {
+	if dir == "" {
+		return
+	}
+	responseArchive = &ResponseArchive{dir: dir, month: month}
+}
+
+// openReplayArchive arranges for the responses for the given month to be read
+// from the given archive directory, so that no provider API calls are made.
+func openReplayArchive(dir string, month string) {
+	if _, err := os.Stat(filepath.Join(dir, month)); err != nil {
+		exitf(ExitUsage, "[openReplayArchive] no archived responses for %s: %v", month, err)
+	}
+	responseArchive = &ResponseArchive{dir: dir, month: month, replay: true}
+}
+
+// replaying reports whether the responses are being read from the archive.
+func (r *ResponseArchive) replaying() bool {
+	return r != nil && r.replay
+}
+
+// archiveNameRegexp matches the characters which are replaced in the names of
+// the archive files.
+var archiveNameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// getPath returns the path of the archive file for the given response.
+func (r *ResponseArchive) getPath(provider string, account string, name string) string {
+	return filepath.Join(r.dir, r.month, provider,
+		archiveNameRegexp.ReplaceAllString(account, "_"),
+		archiveNameRegexp.ReplaceAllString(name, "_")+".json.gz")
+}
+
+// save writes the response to the archive, if it is enabled; failures are
+// logged but do not stop the run.
+func (r *ResponseArchive) save(provider string, account string, name string, response any) {
+	if r == nil {
+		return
+	}
+	path := r.getPath(provider, account, name)
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	var file *os.File
+	if err == nil {
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	}
+	if err == nil {
+		writer := gzip.NewWriter(file)
+		err = json.NewEncoder(writer).Encode(response)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("[ResponseArchive] error archiving %s response %s/%s: %v", provider, account, name, err)
+	}
+}
+
+// load reads a response from the archive into the given value.
+func (r *ResponseArchive) load(provider string, account string, name string, response any) error {
+	file, err := os.Open(r.getPath(provider, account, name))
+	if err != nil {
+		return err
+	}
+	defer closeFile(file)
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(reader).Decode(response)
+}
+
+// archived returns the response from the given function, saving it in the
+// response archive (if it is enabled) under the given provider, account, and
+// name.  When replaying, the archived response is returned instead; it is an
+// error for it to be missing, since the provider cannot be called.
+func archived[T any](provider string, account string, name string, fetch func() (T, error)) (T, error) {
+	if responseArchive.replaying() {
+		var response T
+		if err := responseArchive.load(provider, account, name, &response); err != nil {
+			exitf(ExitProviderError, "[archived] error replaying %s response %s/%s: %v", provider, account, name, err)
+		}
+		return response, nil
+	}
+	response, err := fetch()
+	if err == nil {
+		responseArchive.save(provider, account, name, response)
+	}
+	return response, err
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	"google.golang.org/api/storage/v1"
+)
+
+// ArtifactUploader stores a copy of an output file (e.g., the CSV data or the
+// JSON run summary) in a Google Drive folder or a Google Cloud Storage bucket.
+type ArtifactUploader interface {
+	upload(name string, contentType string, data []byte) error
+	String() string
+}
+
+// getArtifactScopes returns the OAuth scopes needed to upload the artifacts
+// to the destinations configured by the "driveFolderId" key of the "gsheet"
+// section and by the "gcs" section, if any.
+func getArtifactScopes(accountsFile AccountsFile) (scopes []string) {
+	if getMapKeyId(accountsFile.Configuration["gsheet"], "driveFolderId") != "" {
+		scopes = append(scopes, drive.DriveScope)
+	}
+	if accountsFile.Configuration["gcs"] != nil {
+		scopes = append(scopes, storage.DevstorageReadWriteScope)
+	}
+	return
+}
+
+// newArtifactUploaders returns an uploader for each configured destination,
+// using the given Google client (which must be authorized for the scopes
+// returned by getArtifactScopes()).
+func newArtifactUploaders(accountsFile AccountsFile, client *http.Client) (uploaders []ArtifactUploader) {
+	ctx := context.Background()
+	if folderId := getMapKeyId(accountsFile.Configuration["gsheet"], "driveFolderId"); folderId != "" {
+		srv, err := drive.NewService(ctx, option.WithHTTPClient(client))
+		if err != nil {
+			exitf(ExitOutputFailure, "Unable to create Google Drive client: %v", err)
+		}
+		uploaders = append(uploaders, &DriveUploader{srv: srv, folderId: folderId})
+	}
+	if gcsConfig := accountsFile.Configuration["gcs"]; gcsConfig != nil {
+		srv, err := storage.NewService(ctx, option.WithHTTPClient(client))
+		if err != nil {
+			exitf(ExitOutputFailure, "Unable to create Google Cloud Storage client: %v", err)
+		}
+		uploaders = append(uploaders, &GcsUploader{
+			srv:    srv,
+			bucket: getMapKeyString(gcsConfig, "bucket", "gcs"),
+			prefix: getMapKeyString(gcsConfig, "prefix", ""),
+		})
+	}
+	return
+}
+
+// uploadArtifact uploads the data, under the given name, to each configured
+// destination.  Failures are reported, and reflected in the exit code, but do
+// not stop the run.
+func (o *OutputObject) uploadArtifact(name string, contentType string, data []byte) {
+	for _, uploader := range o.uploaders {
+		if err := uploader.upload(name, contentType, data); err != nil {
+			msg := fmt.Sprintf("error uploading %s to %s: %v", name, uploader, err)
+			log.Printf("[uploadArtifact] %s", msg)
+			noteExitStatus(ExitOutputFailure, msg)
+			continue
+		}
+		log.Printf("[uploadArtifact] uploaded %s to %s", name, uploader)
+	}
+}
+
+// uploadSheetArtifact uploads the sheet data, as CSV, under the given name.
+func (o *OutputObject) uploadSheetArtifact(name string, sheetData []*sheets.RowData) {
+	if len(o.uploaders) == 0 {
+		return
+	}
+	var buffer bytes.Buffer
+	if err := writeCsvFromSheet(&buffer, sheetData); err != nil {
+		exitf(ExitOutputFailure, "[uploadSheetArtifact] error formatting %s: %v", name, err)
+	}
+	o.uploadArtifact(name, "text/csv", buffer.Bytes())
+}
+
+// uploadFileArtifact uploads the named local file, if any.
+func (o *OutputObject) uploadFileArtifact(fileName string, contentType string) {
+	if len(o.uploaders) == 0 || fileName == "" {
+		return
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		log.Printf("[uploadFileArtifact] error reading %s: %v", fileName, err)
+		noteExitStatus(ExitOutputFailure, fmt.Sprintf("error reading %s for upload: %v", fileName, err))
+		return
+	}
+	o.uploadArtifact(filepath.Base(fileName), contentType, data)
+}
+
+// DriveUploader uploads artifacts to a Google Drive folder, replacing any
+// file of the same name in it.
+type DriveUploader struct {
+	srv      *drive.Service
+	folderId string
+}
+
+func (d *DriveUploader) String() string {
+	return "Google Drive folder " + d.folderId
+}
+
+func (d *DriveUploader) upload(name string, contentType string, data []byte) error {
+	query := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false",
+		strings.ReplaceAll(name, "'", `\'`), d.folderId)
+	existing, err := d.srv.Files.List().Q(query).Fields("files(id)").
+		SupportsAllDrives(true).IncludeItemsFromAllDrives(true).Do()
+	if err != nil {
+		return err
+	}
+	media := googleapi.ContentType(contentType)
+	if len(existing.Files) > 0 {
+		_, err = d.srv.Files.Update(existing.Files[0].Id, &drive.File{}).
+			Media(bytes.NewReader(data), media).SupportsAllDrives(true).Do()
+		return err
+	}
+	_, err = d.srv.Files.Create(&drive.File{Name: name, Parents: []string{d.folderId}}).
+		Media(bytes.NewReader(data), media).SupportsAllDrives(true).Do()
+	return err
+}
+
+// GcsUploader uploads artifacts to a Google Cloud Storage bucket, with the
+// configured prefix (e.g., "costpuller/") on the object names.
+type GcsUploader struct {
+	srv    *storage.Service
+	bucket string
+	prefix string
+}
+
+func (g *GcsUploader) String() string {
+	return "gs://" + g.bucket + "/" + g.prefix
+}
+
+func (g *GcsUploader) upload(name string, contentType string, data []byte) error {
+	object := &storage.Object{Name: g.prefix + name, ContentType: contentType}
+	_, err := g.srv.Objects.Insert(g.bucket, object).Media(bytes.NewReader(data)).Do()
+	return err
+}
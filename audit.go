@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+// AuditEntry is the audit log record of a single outbound API call.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Service    string    `json:"service"`
+	Operation  string    `json:"operation"`
+	Account    string    `json:"account,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	Status     int       `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// AuditLog writes a JSON line to a file for each outbound API call.
+type AuditLog struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// auditLog is the audit log for the process; when it is nil (the default),
+// API calls are not recorded.
+var auditLog *AuditLog
+
+// openAuditLog opens the audit log file, appending to it if it exists; an
+// empty file name leaves the audit log disabled.
+func openAuditLog(fileName string) {
+	if fileName == "" {
+		return
+	}
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		exitf(ExitOutputFailure, "[openAuditLog] error opening audit log: %v", err)
+	}
+	auditLog = &AuditLog{file: file, encoder: json.NewEncoder(file)}
+}
+
+// close closes the audit log file, if any.
+func (l *AuditLog) close() {
+	if l != nil {
+		closeFile(l.file)
+	}
+}
+
+// record writes an entry to the audit log, if it is enabled.
+func (l *AuditLog) record(entry AuditEntry) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.encoder.Encode(entry); err != nil {
+		log.Printf("[AuditLog] error writing audit log entry: %v", err)
+	}
+}
+
+// auditTransport is an HTTP transport which records each request in the
+// audit log, labeled with the given service name.
+type auditTransport struct {
+	service string
+	base    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	response, err := t.base.RoundTrip(req)
+	entry := AuditEntry{
+		Time:       start,
+		Service:    t.service,
+		Operation:  req.Method + " " + req.URL.Host + req.URL.Path,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if response != nil {
+		entry.Status = response.StatusCode
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	auditLog.record(entry)
+	return response, err
+}
+
+// newAuditedHttpClient returns an HTTP client with the given timeout whose
+// requests are recorded in the audit log (and whose interactions are recorded
+// or replayed, for the services covered by the HTTP fixtures).
+func newAuditedHttpClient(service string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &auditTransport{service: service, base: httpFixtures.wrap(service, http.DefaultTransport)},
+	}
+}
+
+// auditAwsSession adds a handler to the AWS session which records each
+// completed API call in the audit log, with the account which it concerns,
+// where that can be determined from the request parameters.
+func auditAwsSession(sess *session.Session) {
+	sess.Handlers.Complete.PushBack(func(r *request.Request) {
+		entry := AuditEntry{
+			Time:       r.Time,
+			Service:    r.ClientInfo.ServiceName,
+			Operation:  r.Operation.Name,
+			Account:    getAwsAuditAccount(r.Params),
+			DurationMs: time.Since(r.Time).Milliseconds(),
+		}
+		if r.HTTPResponse != nil {
+			entry.Status = r.HTTPResponse.StatusCode
+		}
+		if r.Error != nil {
+			entry.Error = r.Error.Error()
+		}
+		auditLog.record(entry)
+	})
+}
+
+// getAwsAuditAccount returns the account ID from the parameters of an AWS
+// API request:  the linked account from a Cost Explorer filter, or the
+// "AccountId" or "ResourceId" field of other requests; otherwise, it returns
+// an empty string.
+func getAwsAuditAccount(params any) string {
+	if input, ok := params.(*costexplorer.GetCostAndUsageInput); ok {
+		return getAwsFilterAccount(input.Filter)
+	}
+	if input, ok := params.(*costexplorer.GetCostAndUsageWithResourcesInput); ok {
+		return getAwsFilterAccount(input.Filter)
+	}
+	value := reflect.ValueOf(params)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	for _, name := range []string{"AccountId", "ResourceId"} {
+		field := value.Elem().FieldByName(name)
+		if !field.IsValid() {
+			continue
+		}
+		if id, ok := field.Interface().(*string); ok && id != nil {
+			return *id
+		}
+	}
+	return ""
+}
+
+// getAwsFilterAccount returns the linked account value from a Cost Explorer
+// filter expression, if there is one.
+func getAwsFilterAccount(filter *costexplorer.Expression) string {
+	if filter == nil {
+		return ""
+	}
+	if filter.Dimensions != nil && filter.Dimensions.Key != nil &&
+		*filter.Dimensions.Key == costexplorer.DimensionLinkedAccount && len(filter.Dimensions.Values) > 0 {
+		return *filter.Dimensions.Values[0]
+	}
+	for _, expression := range slices.Concat(filter.And, filter.Or) {
+		if account := getAwsFilterAccount(expression); account != "" {
+			return account
+		}
+	}
+	return getAwsFilterAccount(filter.Not)
+}
@@ -1,12 +1,22 @@
 package main
 
 import (
+	"cmp"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/costexplorer"
 	"github.com/aws/aws-sdk-go/service/organizations"
@@ -15,87 +25,478 @@ import (
 )
 
 const AwsTagCostpullerCategory = "costpuller_category"
+const AwsTagCostpullerOwner = "costpuller_owner"
+const AwsTagCostpullerContact = "costpuller_contact"
+const AwsTagCostpullerCostCenter = "costpuller_cost_center"
 
 const AwsMetadataDescription = "description"
 const AwsMetadataStatus = "status"
 
 // AwsPuller implements the AWS query client
 type AwsPuller struct {
-	session *session.Session
-	debug   bool
+	session   *session.Session
+	ceConfig  *aws.Config
+	orgConfig *aws.Config
+	debug     bool
+
+	// ceClient and orgClient are the Cost Explorer and Organizations
+	// clients, created by NewAwsPuller; they may be replaced (e.g., by
+	// mocks, to exercise the reconciliation and tag-writing logic without
+	// AWS access).
+	ceClient  CostExplorerClient
+	orgClient OrganizationsClient
+
+	// costCategory is the name of the AWS Cost Category by which account
+	// costs are split (if any); costCategoryValues optionally limits the
+	// values which are included.
+	costCategory       string
+	costCategoryValues []string
+
+	// purchaseTypeBreakdown enables splitting the EC2 costs by purchase option.
+	purchaseTypeBreakdown bool
+
+	// dataTransferBreakdown enables splitting the data transfer costs by
+	// direction.
+	dataTransferBreakdown bool
+
+	// rightsizing enables the savings-opportunity column; savings holds the
+	// estimated monthly savings for each account ID.
+	rightsizing bool
+	savings     map[string]float64
+
+	// batchQueries enables organization-wide queries; batchResults caches
+	// their results, keyed by query, then by account ID, then by group key.
+	batchQueries bool
+	batchResults map[string]map[string]map[string]float64
+
+	// batchArchiveName is the name under which the organization-wide
+	// responses (queries, account inventory, organizational units, and
+	// recommendations) are saved in the response archive.
+	batchArchiveName string
+
+	// ouGroups is the OU grouping mode; ouPaths maps account IDs to the
+	// paths of their OUs.
+	ouGroups string
+	ouPaths  map[string]string
+
+	// accountCacheFile and accountCacheTTL control the on-disk cache of the
+	// account inventory; refreshAccounts forces it to be refetched.
+	accountCacheFile string
+	accountCacheTTL  time.Duration
+	refreshAccounts  bool
+
+	// runCache, if set (i.e., with -incremental), holds the results of the
+	// previous run, which are reused for accounts whose data is present and
+	// whose month is closed, and receives the results of this one.
+	runCache *RunCache
+
+	// residuals holds, for each account ID, the difference between the
+	// total reported by AWS and the total of the service costs, for accounts
+	// where they do not reconcile.
+	residuals map[string]float64
+
+	// baselines, if set, holds the trailing-average totals which replace the
+	// accounts' standard values in the consistency check.
+	baselines map[string]float64
+
+	// inactiveAccounts is the payer's InactiveAccounts mode; accountStatuses
+	// holds the AWS Organizations status of the accounts which are not active.
+	inactiveAccounts string
+	accountStatuses  map[string]string
+
+	// organizationInfo holds the account names and the payer account ID,
+	// once they have been fetched.
+	organizationInfo *AwsOrganizationInfo
+
+	// costCenterTag and accountTags are the payer's CostCenterTag and
+	// AccountTags; inventory holds the organization's account inventory
+	// (with the tags), once it has been fetched.
+	costCenterTag string
+	accountTags   []string
+	inventory     map[string]map[string]string
+}
+
+// AwsOrganizationInfo holds the names of the accounts in an AWS organization
+// (keyed by account ID) and the ID of its management (payer) account.
+type AwsOrganizationInfo struct {
+	PayerAccountId string            `json:"payer_account_id"`
+	AccountNames   map[string]string `json:"account_names"`
 }
 
-// NewAwsPuller returns a new AWS client.
-func NewAwsPuller(profile string, debug bool) *AwsPuller {
+// AwsPayer describes an AWS organization (payer account) from which data is
+// pulled:  the name used to identify it in the output, the credentials profile
+// used to access it, and the key in the "cloud_providers" section of the
+// accounts file which lists its accounts.
+type AwsPayer struct {
+	Name     string
+	Profile  string
+	Accounts string
+
+	// Region, Partition, and the endpoints allow access to accounts outside
+	// the commercial partition (e.g., "aws-us-gov"); when they are empty,
+	// the SDK defaults are used.
+	Region                string
+	Partition             string
+	CostExplorerEndpoint  string
+	OrganizationsEndpoint string
+
+	// CostCategory, if set, is the name of an AWS Cost Category by which each
+	// account's costs are split into separate rows; CostCategoryValues, if
+	// set, limits the Cost Category values which are included.
+	CostCategory       string
+	CostCategoryValues []string
+
+	// PurchaseTypeBreakdown, if set, adds columns splitting the EC2
+	// ("machines") costs by purchase option.
+	PurchaseTypeBreakdown bool
+
+	// DataTransferBreakdown, if set, adds columns splitting the
+	// "dataTransfer" costs by direction.
+	DataTransferBreakdown bool
+
+	// Rightsizing, if set, adds a column with the estimated monthly savings
+	// from the EC2 rightsizing recommendations, which are also reported in a
+	// separate sheet.
+	Rightsizing bool
+
+	// BatchQueries, if set, pulls the data for all the payer's accounts with
+	// a single, organization-wide set of queries instead of querying each
+	// account separately.
+	BatchQueries bool
+
+	// OUGroups, if set, enables using the AWS Organizations OU hierarchy:
+	// "replace" groups the accounts by their OU instead of by the groups in
+	// the accounts file; "check" reports accounts whose group in the
+	// accounts file does not match their OU.  Either way, the OU path is
+	// added as a column.
+	OUGroups string
+
+	// AccountCacheTTL is how long the cached organization account inventory
+	// (names, statuses, and tags) remains valid; zero disables the cache.
+	AccountCacheTTL time.Duration
+
+	// InactiveAccounts, if set, enables checking the AWS Organizations status
+	// of the accounts in the accounts file:  "warn" reports those which are
+	// not active (e.g., suspended or closed); "annotate" also adds a note with
+	// the status to their rows.
+	InactiveAccounts string
+
+	// CostCenterTag, if set, is the key of the AWS Organizations account tag
+	// which holds the account's cost center, which is shown in the "Cost
+	// Center" column; active accounts tagged with one of our cost centers
+	// which are not in the accounts file are reported.
+	CostCenterTag string
+
+	// AccountTags lists the keys of other AWS Organizations account tags
+	// (e.g., the owner) whose values are added to the output as columns.
+	AccountTags []string
+}
+
+// defaultAwsAccountCacheTTL is the default lifetime of the cached account
+// inventory; the organization changes far less often than the tool is run.
+const defaultAwsAccountCacheTTL = 24 * time.Hour
+
+// awsPartitionDefaultRegions maps the AWS partition IDs to the region used when
+// a partition is configured without a region.
+var awsPartitionDefaultRegions = map[string]string{
+	endpoints.AwsPartitionID:      endpoints.UsEast1RegionID,
+	endpoints.AwsUsGovPartitionID: endpoints.UsGovWest1RegionID,
+	endpoints.AwsCnPartitionID:    endpoints.CnNorthwest1RegionID,
+}
+
+// setAwsPayerOptions sets the region, partition, endpoints, and reporting
+// options for the payer from the given configuration mapping, using the values
+// from the defaults mapping (i.e., the "aws" subsection) for any which are not
+// specified.
+func setAwsPayerOptions(payer *AwsPayer, config Configuration, defaults Configuration) {
+	get := func(key string) string {
+		if value := getMapKeyString(config, key, ""); value != "" {
+			return value
+		}
+		return getMapKeyString(defaults, key, "")
+	}
+	payer.Region = get("region")
+	payer.Partition = get("partition")
+	payer.CostExplorerEndpoint = get("ce_endpoint")
+	payer.OrganizationsEndpoint = get("organizations_endpoint")
+	payer.CostCategory = get("cost_category")
+	payer.CostCategoryValues = getMapKeyStringList(config, "cost_category_values", "")
+	if payer.CostCategoryValues == nil {
+		payer.CostCategoryValues = getMapKeyStringList(defaults, "cost_category_values", "")
+	}
+	// A payer's own value, even false, overrides the default.
+	getBool := func(key string) bool {
+		if _, exists := config[key]; exists {
+			return getMapKeyBool(config, key, "")
+		}
+		return getMapKeyBool(defaults, key, "")
+	}
+	payer.PurchaseTypeBreakdown = getBool("purchase_type_breakdown")
+	payer.DataTransferBreakdown = getBool("data_transfer_breakdown")
+	payer.Rightsizing = getBool("rightsizing")
+	payer.BatchQueries = getBool("batch_queries")
+	payer.OUGroups = get("ou_groups")
+	if payer.OUGroups != "" && payer.OUGroups != "replace" && payer.OUGroups != "check" {
+		fatalf("Error in AWS configuration for payer %q:  \"ou_groups\" must be \"replace\" or \"check\", "+
+			"found %q", payer.Name, payer.OUGroups)
+	}
+	payer.InactiveAccounts = get("inactive_accounts")
+	if payer.InactiveAccounts != "" && payer.InactiveAccounts != "warn" && payer.InactiveAccounts != "annotate" {
+		fatalf("Error in AWS configuration for payer %q:  \"inactive_accounts\" must be \"warn\" or "+
+			"\"annotate\", found %q", payer.Name, payer.InactiveAccounts)
+	}
+	payer.CostCenterTag = get("cost_center_tag")
+	payer.AccountTags = getMapKeyStringList(config, "account_tags", "")
+	if payer.AccountTags == nil {
+		payer.AccountTags = getMapKeyStringList(defaults, "account_tags", "")
+	}
+	payer.AccountCacheTTL = defaultAwsAccountCacheTTL
+	if ttl := get("account_cache_ttl"); ttl != "" {
+		var err error
+		payer.AccountCacheTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			fatalf("Error in AWS configuration for payer %q:  bad \"account_cache_ttl\" value %q: %v",
+				payer.Name, ttl, err)
+		}
+	}
+	if payer.BatchQueries && payer.CostCategory != "" {
+		fatalf("Error in AWS configuration for payer %q:  \"batch_queries\" cannot be combined with "+
+			"\"cost_category\"", payer.Name)
+	}
+	if payer.Partition != "" {
+		defaultRegion, ok := awsPartitionDefaultRegions[payer.Partition]
+		if !ok {
+			fatalf("Error in AWS configuration:  unrecognized partition %q", payer.Partition)
+		}
+		if payer.Region == "" {
+			payer.Region = defaultRegion
+		} else if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), payer.Region); ok &&
+			p.ID() != payer.Partition {
+			fatalf("Error in AWS configuration:  region %q is not in partition %q", payer.Region, payer.Partition)
+		}
+	}
+}
+
+// getAwsPayers returns the list of AWS payers from the "aws" subsection of the
+// configuration.  If the subsection contains a "payers" list, each entry
+// describes a payer; otherwise, a single, unnamed payer is returned using the
+// "profile" value and the "aws" account set.
+func getAwsPayers(awsConfig Configuration) (payers []AwsPayer) {
+	payersAny := getMapKeyValue(awsConfig, "payers", "")
+	if payersAny == nil {
+		awsProfile := getMapKeyString(awsConfig, "profile", "")
+		if awsProfile == "" {
+			awsProfile = "default"
+			log.Printf(
+				"[getAwsPayers] no \"profile\" key found in the \"aws\" section of the configuration file; "+
+					"using AWS credentials profile %q",
+				awsProfile,
+			)
+		}
+		payer := AwsPayer{Profile: awsProfile, Accounts: "aws"}
+		setAwsPayerOptions(&payer, awsConfig, awsConfig)
+		return []AwsPayer{payer}
+	}
+	payerList, ok := payersAny.([]any)
+	if !ok || len(payerList) == 0 {
+		fatalf("Error in AWS \"payers\" value (%v), expected a non-empty list", payersAny)
+	}
+	for idx, payerAny := range payerList {
+		payerConfig := getConfigurationFromAny(payerAny, "AWS payer entry")
+		payer := AwsPayer{
+			Name:     getMapKeyString(payerConfig, "name", ""),
+			Profile:  getMapKeyString(payerConfig, "profile", ""),
+			Accounts: getMapKeyString(payerConfig, "accounts", ""),
+		}
+		if payer.Profile == "" {
+			payer.Profile = "default"
+		}
+		if payer.Name == "" {
+			payer.Name = payer.Profile
+		}
+		if payer.Accounts == "" {
+			fatalf("Error in AWS payer entry %d (%q):  missing \"accounts\" key", idx, payer.Name)
+		}
+		setAwsPayerOptions(&payer, payerConfig, awsConfig)
+		payers = append(payers, payer)
+	}
+	return
+}
+
+// getAwsAccountsKeys returns the "cloud_providers" keys which list the
+// accounts of the AWS payers:  "aws" and the "accounts" key of each payer.
+func getAwsAccountsKeys(awsConfig Configuration) []string {
+	keys := []string{"aws"}
+	payerList, _ := getMapKeyValue(awsConfig, "payers", "").([]any)
+	for _, payerAny := range payerList {
+		payerConfig := getConfigurationFromAny(payerAny, "AWS payer entry")
+		if key := getMapKeyString(payerConfig, "accounts", ""); key != "" && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// NewAwsPuller returns a new AWS client for the given payer.
+func NewAwsPuller(payer AwsPayer, debug bool) *AwsPuller {
 	awsP := new(AwsPuller)
-	awsP.session = session.Must(session.NewSessionWithOptions(session.Options{
-		Profile:           profile,
+	sessionConfig := aws.Config{}
+	if payer.Region != "" {
+		sessionConfig.Region = aws.String(payer.Region)
+	}
+	sessionOptions := session.Options{
+		Config:            sessionConfig,
+		Profile:           payer.Profile,
 		SharedConfigState: session.SharedConfigEnable,
-	}))
+	}
+	if responseArchive.replaying() {
+		// The responses are read from the archive, so the credentials
+		// profile, which might not exist here, is not needed.
+		sessionOptions = session.Options{Config: sessionConfig}
+	}
+	awsP.session = session.Must(session.NewSessionWithOptions(sessionOptions))
+	auditAwsSession(awsP.session)
+	awsP.ceConfig = &aws.Config{}
+	if payer.CostExplorerEndpoint != "" {
+		awsP.ceConfig.Endpoint = aws.String(payer.CostExplorerEndpoint)
+	}
+	awsP.orgConfig = &aws.Config{}
+	if payer.OrganizationsEndpoint != "" {
+		awsP.orgConfig.Endpoint = aws.String(payer.OrganizationsEndpoint)
+	}
+	awsP.ceClient = costexplorer.New(awsP.session, awsP.ceConfig)
+	awsP.orgClient = organizations.New(awsP.session, awsP.orgConfig)
+	awsP.costCategory = payer.CostCategory
+	awsP.costCategoryValues = payer.CostCategoryValues
+	awsP.purchaseTypeBreakdown = payer.PurchaseTypeBreakdown
+	awsP.dataTransferBreakdown = payer.DataTransferBreakdown
+	awsP.rightsizing = payer.Rightsizing
+	awsP.batchQueries = payer.BatchQueries
+	awsP.batchArchiveName = "organization-" + cmp.Or(payer.Name, payer.Profile)
+	awsP.ouGroups = payer.OUGroups
+	awsP.accountCacheTTL = payer.AccountCacheTTL
+	awsP.inactiveAccounts = payer.InactiveAccounts
+	awsP.costCenterTag = payer.CostCenterTag
+	awsP.accountTags = payer.AccountTags
+	awsP.accountCacheFile = fmt.Sprintf("aws-accounts-%s.json", payer.Profile)
+	awsP.batchResults = make(map[string]map[string]map[string]float64)
 	awsP.debug = debug
 	return awsP
 }
 
+// CostExplorerClient is the part of the Cost Explorer API used by AwsPuller
+// (which *costexplorer.CostExplorer implements).
+type CostExplorerClient interface {
+	GetCostAndUsage(*costexplorer.GetCostAndUsageInput) (*costexplorer.GetCostAndUsageOutput, error)
+	GetCostAndUsageWithResources(
+		*costexplorer.GetCostAndUsageWithResourcesInput,
+	) (*costexplorer.GetCostAndUsageWithResourcesOutput, error)
+	GetRightsizingRecommendation(
+		*costexplorer.GetRightsizingRecommendationInput,
+	) (*costexplorer.GetRightsizingRecommendationOutput, error)
+}
+
+// OrganizationsClient is the part of the Organizations API used by AwsPuller
+// (which *organizations.Organizations implements).
+type OrganizationsClient interface {
+	DescribeOrganization(*organizations.DescribeOrganizationInput) (*organizations.DescribeOrganizationOutput, error)
+	ListAccounts(*organizations.ListAccountsInput) (*organizations.ListAccountsOutput, error)
+	ListAccountsForParentPages(
+		*organizations.ListAccountsForParentInput,
+		func(*organizations.ListAccountsForParentOutput, bool) bool,
+	) error
+	ListOrganizationalUnitsForParentPages(
+		*organizations.ListOrganizationalUnitsForParentInput,
+		func(*organizations.ListOrganizationalUnitsForParentOutput, bool) bool,
+	) error
+	ListRootsPages(*organizations.ListRootsInput, func(*organizations.ListRootsOutput, bool) bool) error
+	ListTagsForResource(*organizations.ListTagsForResourceInput) (*organizations.ListTagsForResourceOutput, error)
+	TagResource(*organizations.TagResourceInput) (*organizations.TagResourceOutput, error)
+	UntagResource(*organizations.UntagResourceInput) (*organizations.UntagResourceOutput, error)
+}
+
 // PullData retrieves a raw data set.
 func (a *AwsPuller) PullData(accountID string, month string, costType string) (map[string]float64, error) {
-	// check month format
-	focusMonth, err := time.Parse("2006-01", month)
+	results, err := a.PullDataByCostCategory(accountID, month, costType)
+	if err != nil {
+		return nil, err
+	}
+	serviceResults := make(map[string]float64)
+	for _, categoryResults := range results {
+		for service, value := range categoryResults {
+			serviceResults[service] += value
+		}
+	}
+	return serviceResults, nil
+}
+
+// PullDataByCostCategory retrieves a raw data set, split by the values of the
+// configured AWS Cost Category; the result maps each Cost Category value to a
+// map of service costs.  If no Cost Category is configured, the result
+// contains a single entry with an empty key.
+func (a *AwsPuller) PullDataByCostCategory(
+	accountID string,
+	month string,
+	costType string,
+) (map[string]map[string]float64, error) {
+	if a.batchQueries {
+		return a.pullBatchedServiceCosts(accountID, month, costType)
+	}
+	timePeriod, err := getAwsMonthPeriod(month)
 	if err != nil {
-		log.Printf("[pullawsdata] month format error: %v\n", err)
 		return nil, err
 	}
-	beginningOfMonth := now.With(focusMonth).BeginningOfMonth()
-	endOfMonth := now.With(focusMonth).EndOfMonth().Add(time.Hour * 24)
-	dayStart := beginningOfMonth.Format("2006-01-02")
-	dayEnd := endOfMonth.Format("2006-01-02")
 	// retrieve AWS cost
-	svc := costexplorer.New(a.session)
+	svc := a.ceClient
 	granularity := "MONTHLY"
-	dimensionLinkedAccountKey := "LINKED_ACCOUNT"
-	dimensionLinkedAccountValue := accountID
 	groupByDimension := "DIMENSION"
 	groupByService := "SERVICE"
-	costAndUsageService, err := svc.GetCostAndUsage(&costexplorer.GetCostAndUsageInput{
-		TimePeriod: &costexplorer.DateInterval{
-			Start: &dayStart,
-			End:   &dayEnd,
-		},
-		Granularity: &granularity,
-		Metrics:     []*string{&costType},
-		Filter: &costexplorer.Expression{
-			Dimensions: &costexplorer.DimensionValues{
-				Key:    &dimensionLinkedAccountKey,
-				Values: []*string{&dimensionLinkedAccountValue},
-			},
+	serviceGroupBy := []*costexplorer.GroupDefinition{
+		{
+			Type: &groupByDimension,
+			Key:  &groupByService,
 		},
-		GroupBy: []*costexplorer.GroupDefinition{
-			{
-				Type: &groupByDimension,
-				Key:  &groupByService,
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("[pullawsdata] error retrieving aws service cost report: %v\n", err)
-		return nil, err
 	}
-	if a.debug {
-		log.Println("[pullawsdata] received service breakdown report:")
-		log.Println(*costAndUsageService)
+	var totalGroupBy []*costexplorer.GroupDefinition
+	if a.costCategory != "" {
+		costCategoryGroup := &costexplorer.GroupDefinition{
+			Type: aws.String(costexplorer.GroupDefinitionTypeCostCategory),
+			Key:  aws.String(a.costCategory),
+		}
+		serviceGroupBy = append(serviceGroupBy, costCategoryGroup)
+		totalGroupBy = append(totalGroupBy, costCategoryGroup)
 	}
-	costAndUsageTotal, err := svc.GetCostAndUsage(&costexplorer.GetCostAndUsageInput{
-		TimePeriod: &costexplorer.DateInterval{
-			Start: &dayStart,
-			End:   &dayEnd,
-		},
+	var serviceResultsByTime []*costexplorer.ResultByTime
+	var nextPageToken *string
+	for page := 1; ; page++ {
+		costAndUsageService, err := a.getCostAndUsage(svc, accountID, fmt.Sprintf("services-%d", page), &costexplorer.GetCostAndUsageInput{
+			TimePeriod:    timePeriod,
+			Granularity:   &granularity,
+			Metrics:       []*string{&costType},
+			Filter:        a.getAccountFilter(accountID),
+			GroupBy:       serviceGroupBy,
+			NextPageToken: nextPageToken,
+		})
+		if err != nil {
+			log.Printf("[pullawsdata] error retrieving aws service cost report: %v\n", err)
+			return nil, err
+		}
+		if a.debug {
+			log.Println("[pullawsdata] received service breakdown report:")
+			log.Println(*costAndUsageService)
+		}
+		serviceResultsByTime = mergeResultsByTime(serviceResultsByTime, costAndUsageService.ResultsByTime)
+		nextPageToken = costAndUsageService.NextPageToken
+		if nextPageToken == nil || *nextPageToken == "" {
+			break
+		}
+	}
+	costAndUsageTotal, err := a.getCostAndUsage(svc, accountID, "total", &costexplorer.GetCostAndUsageInput{
+		TimePeriod:  timePeriod,
 		Granularity: &granularity,
 		Metrics:     []*string{&costType},
-		Filter: &costexplorer.Expression{
-			Dimensions: &costexplorer.DimensionValues{
-				Key:    &dimensionLinkedAccountKey,
-				Values: []*string{&dimensionLinkedAccountValue},
-			},
-		},
+		Filter:      a.getAccountFilter(accountID),
+		GroupBy:     totalGroupBy,
 	})
 	if err != nil {
 		log.Printf("[pullawsdata] error retrieving aws total cost report: %v\n", err)
@@ -105,72 +506,656 @@ func (a *AwsPuller) PullData(accountID string, month string, costType string) (m
 		log.Println("[pullawsdata] received total report:")
 		log.Println(*costAndUsageTotal)
 	}
-	// decode total value
-	totalAWSStr := *costAndUsageTotal.ResultsByTime[0].Total[costType].Amount
-	totalAWS, err := strconv.ParseFloat(totalAWSStr, 64)
-	if err != nil {
-		log.Printf("[pullawsdata] error converting aws total value: %v", err)
-		return nil, err
-	}
-	unitAWS := *costAndUsageTotal.ResultsByTime[0].Total[costType].Unit
-	if unitAWS != "USD" {
-		log.Printf("[pullawsdata] pulled unit is not USD: %s", unitAWS)
-		return nil, fmt.Errorf("pulled unit is not USD: %s", unitAWS)
+	// decode total value(s)
+	unitAWS := "USD"
+	totalsAWS := make(map[string]float64)
+	if a.costCategory == "" {
+		totalAWS, err := decodeAwsMetric(costAndUsageTotal.ResultsByTime[0].Total[costType], unitAWS)
+		if err != nil {
+			log.Printf("[pullawsdata] error decoding aws total value: %v", err)
+			return nil, err
+		}
+		totalsAWS[""] = totalAWS
+	} else {
+		for _, group := range costAndUsageTotal.ResultsByTime[0].Groups {
+			totalAWS, err := decodeAwsMetric(group.Metrics[costType], unitAWS)
+			if err != nil {
+				log.Printf("[pullawsdata] error decoding aws total value: %v", err)
+				return nil, err
+			}
+			totalsAWS[a.getCostCategoryValue(*group.Keys[0])] += totalAWS
+		}
 	}
 	// decode service data
-	var totalService float64 = 0
-	serviceResults := make(map[string]float64)
-	resultsByTime := costAndUsageService.ResultsByTime
-	if len(resultsByTime) != 1 {
+	totalsService := make(map[string]float64)
+	results := make(map[string]map[string]float64)
+	if len(serviceResultsByTime) != 1 {
 		log.Printf(
 			"[pullawsdata] warning account %s does not have exactly one service results by time (has %d)",
 			accountID,
-			len(resultsByTime),
+			len(serviceResultsByTime),
 		)
-		return serviceResults, nil
+		return results, nil
 	}
-	serviceGroups := resultsByTime[0].Groups
+	serviceGroups := serviceResultsByTime[0].Groups
 	for _, group := range serviceGroups {
-		if len(group.Keys) != 1 {
+		if len(group.Keys) != len(serviceGroupBy) {
 			err := fmt.Errorf(
-				"[pullawsdata] warning account %s service group does not have exactly one key",
+				"[pullawsdata] warning account %s service group does not have exactly %d key(s)",
 				accountID,
+				len(serviceGroupBy),
 			)
 			log.Printf(err.Error())
-			return serviceResults, err
+			return results, err
 		}
 		key := group.Keys[0]
-		valueStr := group.Metrics[costType].Amount
-		unit := group.Metrics[costType].Unit
-		if *unit != unitAWS {
-			err := fmt.Errorf(
-				"[pullawsdata] error: inconsistent units (%s vs %s) for account %s",
-				unitAWS,
-				*unit,
-				accountID,
-			)
+		var categoryValue string
+		if a.costCategory != "" {
+			categoryValue = a.getCostCategoryValue(*group.Keys[1])
+		}
+		value, err := decodeAwsMetric(group.Metrics[costType], unitAWS)
+		if err != nil {
+			err := fmt.Errorf("[pullawsdata] error decoding service value for account %s: %v", accountID, err)
 			log.Printf(err.Error())
 			return nil, err
 		}
-		value, err := strconv.ParseFloat(*valueStr, 64)
+		if _, exists := results[categoryValue]; !exists {
+			results[categoryValue] = make(map[string]float64)
+		}
+		results[categoryValue][*key] += value
+		totalsService[categoryValue] += value
+	}
+	for categoryValue, totalAWS := range totalsAWS {
+		a.noteResidual(accountID, totalsService[categoryValue], totalAWS)
+	}
+	return results, nil
+}
+
+// pullBatchedServiceCosts returns the service costs for the given account from
+// the organization-wide queries, in the same form as PullDataByCostCategory,
+// checking that they match the account's total.
+func (a *AwsPuller) pullBatchedServiceCosts(
+	accountID string,
+	month string,
+	costType string,
+) (map[string]map[string]float64, error) {
+	serviceResults, err := a.getBatchResults(month, costType, nil, costexplorer.DimensionService)
+	if err != nil {
+		return nil, err
+	}
+	totalResults, err := a.getBatchResults(month, costType, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]map[string]float64)
+	if _, exists := serviceResults[accountID]; !exists {
+		log.Printf("[pullawsdata] warning account %s has no results in the organization-wide query", accountID)
+		return results, nil
+	}
+	var totalService float64
+	for _, value := range serviceResults[accountID] {
+		totalService += value
+	}
+	a.noteResidual(accountID, totalService, totalResults[accountID][""])
+	results[""] = serviceResults[accountID]
+	return results, nil
+}
+
+// getBatchResults returns the results of an organization-wide query for the
+// given month, grouped by linked account and (if not empty) the indicated
+// dimension, and optionally limited to the indicated services.  The results
+// are keyed by account ID and then by the dimension value (or by an empty
+// string if there is no dimension).  Query results are cached, so that each
+// query is made only once per run.
+func (a *AwsPuller) getBatchResults(
+	month string,
+	costType string,
+	services []string,
+	dimension string,
+) (map[string]map[string]float64, error) {
+	cacheKey := strings.Join(append([]string{month, costType, dimension}, services...), "|")
+	if results, exists := a.batchResults[cacheKey]; exists {
+		return results, nil
+	}
+	timePeriod, err := getAwsMonthPeriod(month)
+	if err != nil {
+		return nil, err
+	}
+	groupBy := []*costexplorer.GroupDefinition{
+		{
+			Type: aws.String(costexplorer.GroupDefinitionTypeDimension),
+			Key:  aws.String(costexplorer.DimensionLinkedAccount),
+		},
+	}
+	if dimension != "" {
+		groupBy = append(groupBy, &costexplorer.GroupDefinition{
+			Type: aws.String(costexplorer.GroupDefinitionTypeDimension),
+			Key:  aws.String(dimension),
+		})
+	}
+	var filter *costexplorer.Expression
+	if services != nil {
+		filter = &costexplorer.Expression{
+			Dimensions: &costexplorer.DimensionValues{
+				Key:    aws.String(costexplorer.DimensionService),
+				Values: aws.StringSlice(services),
+			},
+		}
+	}
+	log.Printf("[pullawsdata] pulling organization-wide data grouped by %q", dimension)
+	svc := a.ceClient
+	results := make(map[string]map[string]float64)
+	var nextPageToken *string
+	name := strings.Join(append([]string{"by", dimension}, services...), "-")
+	for page := 1; ; page++ {
+		output, err := a.getCostAndUsage(svc, a.batchArchiveName, fmt.Sprintf("%s-%d", name, page), &costexplorer.GetCostAndUsageInput{
+			TimePeriod:    timePeriod,
+			Granularity:   aws.String(costexplorer.GranularityMonthly),
+			Metrics:       []*string{&costType},
+			Filter:        filter,
+			GroupBy:       groupBy,
+			NextPageToken: nextPageToken,
+		})
 		if err != nil {
-			log.Printf("[pullawsdata] error converting aws service value: %v", err)
+			log.Printf("[pullawsdata] error retrieving aws organization-wide cost report: %v\n", err)
 			return nil, err
 		}
-		serviceResults[*key] = value
-		totalService += value
+		if a.debug {
+			log.Println("[pullawsdata] received organization-wide report:")
+			log.Println(*output)
+		}
+		for _, result := range output.ResultsByTime {
+			for _, group := range result.Groups {
+				value, err := decodeAwsMetric(group.Metrics[costType], "USD")
+				if err != nil {
+					log.Printf("[pullawsdata] error decoding aws organization-wide value: %v", err)
+					return nil, err
+				}
+				accountID := *group.Keys[0]
+				var key string
+				if dimension != "" {
+					key = *group.Keys[1]
+				}
+				if _, exists := results[accountID]; !exists {
+					results[accountID] = make(map[string]float64)
+				}
+				results[accountID][key] += value
+			}
+		}
+		nextPageToken = output.NextPageToken
+		if nextPageToken == nil || *nextPageToken == "" {
+			break
+		}
 	}
-	if math.Round(totalService*100)/100 != math.Round(totalAWS*100)/100 {
-		err := fmt.Errorf(
-			"[pullawsdata] error: account %s service total %f does not match aws total %f",
-			accountID,
-			totalService,
-			totalAWS,
-		)
-		log.Printf(err.Error())
+	a.batchResults[cacheKey] = results
+	return results, nil
+}
+
+// getCostAndUsage sends a Cost Explorer query, saving its response in the
+// response archive (if it is enabled) under the given account and name.
+func (a *AwsPuller) getCostAndUsage(
+	svc CostExplorerClient,
+	accountID string,
+	name string,
+	input *costexplorer.GetCostAndUsageInput,
+) (*costexplorer.GetCostAndUsageOutput, error) {
+	return archived("aws", accountID, name, func() (*costexplorer.GetCostAndUsageOutput, error) {
+		return svc.GetCostAndUsage(input)
+	})
+}
+
+// getAwsMonthPeriod returns the Cost Explorer time period covering the given
+// month (in the format yyyy-mm).
+func getAwsMonthPeriod(month string) (*costexplorer.DateInterval, error) {
+	// check month format
+	focusMonth, err := time.Parse("2006-01", month)
+	if err != nil {
+		log.Printf("[pullawsdata] month format error: %v\n", err)
 		return nil, err
 	}
-	return serviceResults, nil
+	beginningOfMonth := now.With(focusMonth).BeginningOfMonth()
+	endOfMonth := now.With(focusMonth).EndOfMonth().Add(time.Hour * 24)
+	return &costexplorer.DateInterval{
+		Start: aws.String(beginningOfMonth.Format("2006-01-02")),
+		End:   aws.String(endOfMonth.Format("2006-01-02")),
+	}, nil
+}
+
+// AwsPurchaseTypeBuckets are the columns into which the EC2 ("machines") costs
+// are split by purchase option, in output order.
+var AwsPurchaseTypeBuckets = []string{"On-Demand", "Spot", "Savings Plan", "Reserved"}
+
+// getAwsPurchaseTypeBucket maps a Cost Explorer PURCHASE_TYPE value (e.g.,
+// "Spot Instances" or "Standard Reserved Instances") onto one of the
+// AwsPurchaseTypeBuckets.
+func getAwsPurchaseTypeBucket(purchaseType string) string {
+	switch {
+	case strings.Contains(purchaseType, "Spot"):
+		return "Spot"
+	case strings.Contains(purchaseType, "Savings Plan"):
+		return "Savings Plan"
+	case strings.Contains(purchaseType, "Reserved"):
+		return "Reserved"
+	default:
+		return "On-Demand"
+	}
+}
+
+// PullPurchaseTypeCosts retrieves the EC2 costs of the given account for the
+// given month, split by purchase option into the AwsPurchaseTypeBuckets.  Like
+// PullDataByCostCategory, the result is keyed by the Cost Category value, if
+// one is configured, or by an empty string otherwise.
+func (a *AwsPuller) PullPurchaseTypeCosts(
+	accountID string,
+	month string,
+	costType string,
+) (map[string]map[string]float64, error) {
+	return a.pullBucketedCosts(
+		accountID,
+		month,
+		costType,
+		[]string{"Amazon Elastic Compute Cloud - Compute", "EC2 - Other"},
+		costexplorer.DimensionPurchaseType,
+		getAwsPurchaseTypeBucket,
+	)
+}
+
+// AwsDataTransferBuckets are the columns into which the "dataTransfer" costs
+// are split by direction, in output order.
+var AwsDataTransferBuckets = []string{"Inter-Region", "Internet Egress", "Intra-Region", "Other Transfer"}
+
+// awsInterRegionUsageType matches the usage types for data transfer between
+// regions, e.g., "USE1-USW2-AWS-Out-Bytes".
+var awsInterRegionUsageType = regexp.MustCompile(`^[A-Z0-9]+-[A-Z0-9]+-AWS-(In|Out)-Bytes$`)
+
+// getAwsDataTransferBucket maps a Cost Explorer USAGE_TYPE value for data
+// transfer onto one of the AwsDataTransferBuckets.
+func getAwsDataTransferBucket(usageType string) string {
+	switch {
+	case awsInterRegionUsageType.MatchString(usageType):
+		return "Inter-Region"
+	case strings.HasSuffix(usageType, "DataTransfer-Out-Bytes"):
+		return "Internet Egress"
+	case strings.HasSuffix(usageType, "DataTransfer-Regional-Bytes"):
+		return "Intra-Region"
+	default:
+		return "Other Transfer"
+	}
+}
+
+// PullDataTransferCosts retrieves the "AWS Data Transfer" costs of the given
+// account for the given month, split by direction into the
+// AwsDataTransferBuckets, keyed like PullDataByCostCategory.
+func (a *AwsPuller) PullDataTransferCosts(
+	accountID string,
+	month string,
+	costType string,
+) (map[string]map[string]float64, error) {
+	return a.pullBucketedCosts(
+		accountID,
+		month,
+		costType,
+		[]string{"AWS Data Transfer"},
+		costexplorer.DimensionUsageType,
+		getAwsDataTransferBucket,
+	)
+}
+
+// pullBucketedCosts retrieves the costs of the given account for the given
+// month for the indicated services, grouped by the indicated dimension, and
+// sums them into buckets using the provided mapping function.  The result is
+// keyed by the Cost Category value, if one is configured, or by an empty
+// string otherwise, and then by bucket.
+func (a *AwsPuller) pullBucketedCosts(
+	accountID string,
+	month string,
+	costType string,
+	services []string,
+	dimension string,
+	getBucket func(string) string,
+) (map[string]map[string]float64, error) {
+	if a.batchQueries {
+		accountResults, err := a.getBatchResults(month, costType, services, dimension)
+		if err != nil {
+			return nil, err
+		}
+		buckets := make(map[string]float64)
+		for key, value := range accountResults[accountID] {
+			buckets[getBucket(key)] += value
+		}
+		return map[string]map[string]float64{"": buckets}, nil
+	}
+	timePeriod, err := getAwsMonthPeriod(month)
+	if err != nil {
+		return nil, err
+	}
+	groupBy := []*costexplorer.GroupDefinition{
+		{
+			Type: aws.String(costexplorer.GroupDefinitionTypeDimension),
+			Key:  aws.String(dimension),
+		},
+	}
+	if a.costCategory != "" {
+		groupBy = append(groupBy, &costexplorer.GroupDefinition{
+			Type: aws.String(costexplorer.GroupDefinitionTypeCostCategory),
+			Key:  aws.String(a.costCategory),
+		})
+	}
+	svc := a.ceClient
+	results := make(map[string]map[string]float64)
+	var nextPageToken *string
+	for page := 1; ; page++ {
+		output, err := a.getCostAndUsage(svc, accountID, fmt.Sprintf("by-%s-%d", dimension, page), &costexplorer.GetCostAndUsageInput{
+			TimePeriod:  timePeriod,
+			Granularity: aws.String(costexplorer.GranularityMonthly),
+			Metrics:     []*string{&costType},
+			Filter: &costexplorer.Expression{
+				And: []*costexplorer.Expression{
+					a.getAccountFilter(accountID),
+					{
+						Dimensions: &costexplorer.DimensionValues{
+							Key:    aws.String(costexplorer.DimensionService),
+							Values: aws.StringSlice(services),
+						},
+					},
+				},
+			},
+			GroupBy:       groupBy,
+			NextPageToken: nextPageToken,
+		})
+		if err != nil {
+			log.Printf("[pullawsdata] error retrieving aws %s cost report: %v\n", dimension, err)
+			return nil, err
+		}
+		if a.debug {
+			log.Printf("[pullawsdata] received %s report:", dimension)
+			log.Println(*output)
+		}
+		for _, result := range output.ResultsByTime {
+			for _, group := range result.Groups {
+				var categoryValue string
+				if a.costCategory != "" {
+					categoryValue = a.getCostCategoryValue(*group.Keys[1])
+				}
+				value, err := decodeAwsMetric(group.Metrics[costType], "USD")
+				if err != nil {
+					log.Printf("[pullawsdata] error decoding aws %s value: %v", dimension, err)
+					return nil, err
+				}
+				if _, exists := results[categoryValue]; !exists {
+					results[categoryValue] = make(map[string]float64)
+				}
+				results[categoryValue][getBucket(*group.Keys[0])] += value
+			}
+		}
+		nextPageToken = output.NextPageToken
+		if nextPageToken == nil || *nextPageToken == "" {
+			break
+		}
+	}
+	return results, nil
+}
+
+// getAccountFilter returns the Cost Explorer filter expression which selects
+// the given account and, if configured, the selected Cost Category values.
+func (a *AwsPuller) getAccountFilter(accountID string) *costexplorer.Expression {
+	accountExpression := &costexplorer.Expression{
+		Dimensions: &costexplorer.DimensionValues{
+			Key:    aws.String(costexplorer.DimensionLinkedAccount),
+			Values: []*string{aws.String(accountID)},
+		},
+	}
+	if a.costCategory == "" || len(a.costCategoryValues) == 0 {
+		return accountExpression
+	}
+	return &costexplorer.Expression{
+		And: []*costexplorer.Expression{
+			accountExpression,
+			{
+				CostCategories: &costexplorer.CostCategoryValues{
+					Key:    aws.String(a.costCategory),
+					Values: aws.StringSlice(a.costCategoryValues),
+				},
+			},
+		},
+	}
+}
+
+// getCostCategoryValue extracts the value from a Cost Category group key,
+// which Cost Explorer returns in the form "<name>$<value>"; an empty value
+// indicates costs which are not assigned to any category value.
+func (a *AwsPuller) getCostCategoryValue(key string) string {
+	return strings.TrimPrefix(key, a.costCategory+"$")
+}
+
+// decodeAwsMetric parses the amount of the given Cost Explorer metric value,
+// checking that it is expressed in the expected unit.
+func decodeAwsMetric(metric *costexplorer.MetricValue, expectedUnit string) (float64, error) {
+	if metric == nil || metric.Amount == nil || metric.Unit == nil {
+		return 0, fmt.Errorf("missing metric value")
+	}
+	if *metric.Unit != expectedUnit {
+		return 0, fmt.Errorf("pulled unit is not %s: %s", expectedUnit, *metric.Unit)
+	}
+	return strconv.ParseFloat(*metric.Amount, 64)
+}
+
+// mergeResultsByTime combines the groups from a page of Cost Explorer results
+// with those from previous pages; the pages share the same time periods.
+func mergeResultsByTime(
+	previous []*costexplorer.ResultByTime,
+	page []*costexplorer.ResultByTime,
+) []*costexplorer.ResultByTime {
+	if previous == nil {
+		return page
+	}
+	for idx, result := range page {
+		if idx < len(previous) {
+			previous[idx].Groups = append(previous[idx].Groups, result.Groups...)
+		}
+	}
+	return previous
+}
+
+// PullDailyServiceCosts retrieves the given account's costs for each day of
+// the month, by service, keyed by day (yyyy-mm-dd) and then by service.
+func (a *AwsPuller) PullDailyServiceCosts(
+	accountID string,
+	month string,
+	costType string,
+) (map[string]map[string]float64, error) {
+	timePeriod, err := getAwsMonthPeriod(month)
+	if err != nil {
+		return nil, err
+	}
+	svc := a.ceClient
+	days := make(map[string]map[string]float64)
+	var nextPageToken *string
+	for page := 1; ; page++ {
+		output, err := a.getCostAndUsage(svc, accountID, fmt.Sprintf("daily-services-%d", page), &costexplorer.GetCostAndUsageInput{
+			TimePeriod:  timePeriod,
+			Granularity: aws.String(costexplorer.GranularityDaily),
+			Metrics:     []*string{&costType},
+			Filter:      a.getAccountFilter(accountID),
+			GroupBy: []*costexplorer.GroupDefinition{
+				{
+					Type: aws.String(costexplorer.GroupDefinitionTypeDimension),
+					Key:  aws.String(costexplorer.DimensionService),
+				},
+			},
+			NextPageToken: nextPageToken,
+		})
+		if err != nil {
+			log.Printf("[pullawsdata] error retrieving aws daily service cost report: %v\n", err)
+			return nil, err
+		}
+		for _, result := range output.ResultsByTime {
+			day := aws.StringValue(result.TimePeriod.Start)
+			if _, exists := days[day]; !exists {
+				days[day] = make(map[string]float64)
+			}
+			for _, group := range result.Groups {
+				value, err := decodeAwsMetric(group.Metrics[costType], "USD")
+				if err != nil {
+					log.Printf("[pullawsdata] error decoding aws daily service value: %v", err)
+					return nil, err
+				}
+				days[day][*group.Keys[0]] += value
+			}
+		}
+		nextPageToken = output.NextPageToken
+		if nextPageToken == nil || *nextPageToken == "" {
+			break
+		}
+	}
+	return days, nil
+}
+
+// AwsResourceCost is the cost of a single AWS resource over the drill-down
+// period.
+type AwsResourceCost struct {
+	ResourceID string
+	Cost       float64
+}
+
+// awsResourceDataDays is the number of days of resource-level data which Cost
+// Explorer makes available.
+const awsResourceDataDays = 14
+
+// PullResourceCosts retrieves the resource-level costs of the given account for
+// the last 14 days (the period for which Cost Explorer provides them) and
+// returns the most expensive resources, up to the given limit, in order of
+// decreasing cost.  Resource-level data is only available for EC2 compute.
+func (a *AwsPuller) PullResourceCosts(accountID string, costType string, limit int) ([]AwsResourceCost, error) {
+	// The end date is exclusive, so the period ends tomorrow to include today.
+	today := time.Now().UTC()
+	dayStart := today.AddDate(0, 0, -awsResourceDataDays+1).Format("2006-01-02")
+	dayEnd := today.AddDate(0, 0, 1).Format("2006-01-02")
+	svc := a.ceClient
+	costs := make(map[string]float64)
+	var nextPageToken *string
+	for {
+		output, err := svc.GetCostAndUsageWithResources(&costexplorer.GetCostAndUsageWithResourcesInput{
+			TimePeriod: &costexplorer.DateInterval{
+				Start: &dayStart,
+				End:   &dayEnd,
+			},
+			Granularity: aws.String(costexplorer.GranularityDaily),
+			Metrics:     []*string{&costType},
+			Filter: &costexplorer.Expression{
+				And: []*costexplorer.Expression{
+					a.getAccountFilter(accountID),
+					{
+						Dimensions: &costexplorer.DimensionValues{
+							Key:    aws.String(costexplorer.DimensionService),
+							Values: []*string{aws.String("Amazon Elastic Compute Cloud - Compute")},
+						},
+					},
+				},
+			},
+			GroupBy: []*costexplorer.GroupDefinition{
+				{
+					Type: aws.String(costexplorer.GroupDefinitionTypeDimension),
+					Key:  aws.String(costexplorer.DimensionResourceId),
+				},
+			},
+			NextPageToken: nextPageToken,
+		})
+		if err != nil {
+			log.Printf("[pullawsdata] error retrieving aws resource cost report: %v\n", err)
+			return nil, err
+		}
+		for _, result := range output.ResultsByTime {
+			for _, group := range result.Groups {
+				value, err := decodeAwsMetric(group.Metrics[costType], "USD")
+				if err != nil {
+					log.Printf("[pullawsdata] error decoding aws resource value: %v", err)
+					return nil, err
+				}
+				costs[*group.Keys[0]] += value
+			}
+		}
+		nextPageToken = output.NextPageToken
+		if nextPageToken == nil || *nextPageToken == "" {
+			break
+		}
+	}
+	var resources []AwsResourceCost
+	for resourceID, cost := range costs {
+		resources = append(resources, AwsResourceCost{ResourceID: resourceID, Cost: cost})
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Cost > resources[j].Cost })
+	if limit > 0 && len(resources) > limit {
+		resources = resources[:limit]
+	}
+	return resources, nil
+}
+
+// AwsRightsizingRecommendation is a single rightsizing (modify or terminate)
+// recommendation for an EC2 instance.
+type AwsRightsizingRecommendation struct {
+	AccountID               string
+	ResourceID              string
+	InstanceName            string
+	Action                  string
+	CurrentType             string
+	TargetType              string
+	EstimatedMonthlySavings float64
+}
+
+// PullRightsizingRecommendations retrieves the Cost Explorer EC2 rightsizing
+// recommendations for all the accounts in the organization.
+func (a *AwsPuller) PullRightsizingRecommendations() ([]AwsRightsizingRecommendation, error) {
+	svc := a.ceClient
+	var recommendations []AwsRightsizingRecommendation
+	var nextPageToken *string
+	for {
+		output, err := svc.GetRightsizingRecommendation(&costexplorer.GetRightsizingRecommendationInput{
+			Service:       aws.String("AmazonEC2"),
+			NextPageToken: nextPageToken,
+		})
+		if err != nil {
+			log.Printf("[pullawsdata] error retrieving aws rightsizing recommendations: %v", err)
+			return nil, err
+		}
+		for _, r := range output.RightsizingRecommendations {
+			rec := AwsRightsizingRecommendation{
+				AccountID: aws.StringValue(r.AccountId),
+				Action:    aws.StringValue(r.RightsizingType),
+			}
+			if r.CurrentInstance != nil {
+				rec.ResourceID = aws.StringValue(r.CurrentInstance.ResourceId)
+				rec.InstanceName = aws.StringValue(r.CurrentInstance.InstanceName)
+				if details := r.CurrentInstance.ResourceDetails; details != nil && details.EC2ResourceDetails != nil {
+					rec.CurrentType = aws.StringValue(details.EC2ResourceDetails.InstanceType)
+				}
+			}
+			var savings string
+			if r.ModifyRecommendationDetail != nil && len(r.ModifyRecommendationDetail.TargetInstances) > 0 {
+				// The first target is the one which Cost Explorer recommends.
+				target := r.ModifyRecommendationDetail.TargetInstances[0]
+				savings = aws.StringValue(target.EstimatedMonthlySavings)
+				if details := target.ResourceDetails; details != nil && details.EC2ResourceDetails != nil {
+					rec.TargetType = aws.StringValue(details.EC2ResourceDetails.InstanceType)
+				}
+			} else if r.TerminateRecommendationDetail != nil {
+				savings = aws.StringValue(r.TerminateRecommendationDetail.EstimatedMonthlySavings)
+			}
+			if savings != "" {
+				rec.EstimatedMonthlySavings, err = strconv.ParseFloat(savings, 64)
+				if err != nil {
+					log.Printf("[pullawsdata] error converting aws estimated savings value: %v", err)
+					return nil, err
+				}
+			}
+			recommendations = append(recommendations, rec)
+		}
+		nextPageToken = output.NextPageToken
+		if nextPageToken == nil || *nextPageToken == "" {
+			break
+		}
+	}
+	return recommendations, nil
 }
 
 // NormalizeResponse normalizes a Response object data into report categories.
@@ -180,6 +1165,7 @@ func (a *AwsPuller) NormalizeResponse(
 	accountID string,
 	serviceResults map[string]float64,
 ) (*sheets.RowData, error) {
+	defer startPhase("aws.normalize")()
 	// Format is:
 	//   [0-9]    group, date, clusterId, accountId, PO, clusterType, usageType, product, infra, numberUsers,
 	//   [10-18]  dataTransfer, machines, storage, keyManagement, registrar, dns, other, tax, rebate
@@ -242,6 +1228,21 @@ func (a *AwsPuller) NormalizeResponse(
 	return &output, nil
 }
 
+// noteResidual compares the total of an account's service costs with the total
+// reported by AWS and, if they do not reconcile, records the difference (which
+// is not included in the service columns) to be reported for the account.
+func (a *AwsPuller) noteResidual(accountID string, totalService float64, totalAWS float64) {
+	if reconciliation.reconciles(totalService, totalAWS) {
+		return
+	}
+	log.Printf("[pullawsdata] warning: account %s service total %f does not match aws total %f",
+		accountID, totalService, totalAWS)
+	if a.residuals == nil {
+		a.residuals = make(map[string]float64)
+	}
+	a.residuals[accountID] += totalAWS - totalService
+}
+
 // CheckResponseConsistency checks the response consistency with various checks. Returns the calculated total.
 func (a *AwsPuller) CheckResponseConsistency(account AccountEntry, results map[string]float64) (float64, error) {
 	var total float64 = 0
@@ -249,19 +1250,27 @@ func (a *AwsPuller) CheckResponseConsistency(account AccountEntry, results map[s
 		// add up value
 		total += value
 	}
-	// check account meta deviation if standard value is given
-	if account.StandardValue > 0 {
-		diff := account.StandardValue - total
+	// check account meta deviation if standard value is given; in the
+	// trailing-average mode, the average replaces the standard value of the
+	// accounts which have one (or a deviation percentage) and have history.
+	standardValue, baselineName := account.StandardValue, "standard value"
+	if baseline, exists := a.baselines[account.AccountID]; exists &&
+		(account.StandardValue > 0 || account.DeviationPercent > 0) {
+		standardValue, baselineName = baseline, "trailing average"
+	}
+	if standardValue > 0 {
+		diff := standardValue - total
 		diffAbs := math.Abs(diff)
-		diffPercent := (diffAbs / account.StandardValue) * 100
+		diffPercent := (diffAbs / standardValue) * 100
 		if diffPercent > float64(account.DeviationPercent) {
 			return total, fmt.Errorf(
-				"deviation check failed: deviation is %.2f (%.2f%%), max deviation allowed is %d%% (value was %.2f, standard value %.2f)",
+				"deviation check failed: deviation is %.2f (%.2f%%), max deviation allowed is %d%% (value was %.2f, %s %.2f)",
 				diffAbs,
 				diffPercent,
 				account.DeviationPercent,
 				total,
-				account.StandardValue,
+				baselineName,
+				standardValue,
 			)
 		}
 	}
@@ -273,6 +1282,72 @@ func (a *AwsPuller) CheckResponseConsistency(account AccountEntry, results map[s
 	return total, nil
 }
 
+// checkBucketConsistency applies the account's cost category thresholds to
+// its service costs, grouped into the normalized categories (see
+// awsNormalizedColumns) and mapped into the output categories.
+func (a *AwsPuller) checkBucketConsistency(
+	group string,
+	month string,
+	account AccountEntry,
+	results map[string]float64,
+) []string {
+	normalized, err := a.NormalizeResponse(group, month, account.AccountID, results)
+	if err != nil {
+		return []string{fmt.Sprintf("error normalizing the costs for the category check: %v", err)}
+	}
+	records := getHistoryRecordsFromAwsRows([]*sheets.RowData{normalized})
+	return checkBucketThresholds(account.Buckets, records[0].Costs)
+}
+
+// awsAccountCache is the content of the account inventory cache file.
+type awsAccountCache struct {
+	Fetched  time.Time                    `json:"fetched"`
+	Accounts map[string]map[string]string `json:"accounts"`
+}
+
+// GetCachedAwsAccountMetadata returns the same data as GetAwsAccountMetadata,
+// using the on-disk cache if it is present and not expired (and a refresh was
+// not requested); otherwise, it pulls the data and updates the cache.
+func (a *AwsPuller) GetCachedAwsAccountMetadata() (map[string]map[string]string, error) {
+	if a.accountCacheTTL <= 0 {
+		return a.GetAwsAccountMetadata()
+	}
+	cachePath, err := getCachePath(a.accountCacheFile)
+	if err != nil {
+		log.Printf("[GetCachedAwsAccountMetadata] not caching account metadata: %v", err)
+		return a.GetAwsAccountMetadata()
+	}
+	if !a.refreshAccounts {
+		var cache awsAccountCache
+		data, err := os.ReadFile(cachePath)
+		if err == nil {
+			err = json.Unmarshal(data, &cache)
+		}
+		if err == nil && time.Since(cache.Fetched) < a.accountCacheTTL {
+			log.Printf("[GetCachedAwsAccountMetadata] using account metadata cached at %s in %q",
+				cache.Fetched.Format(time.RFC3339), cachePath)
+			return cache.Accounts, nil
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[GetCachedAwsAccountMetadata] ignoring unreadable account cache %q: %v", cachePath, err)
+		}
+	}
+	accounts, err := a.GetAwsAccountMetadata()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(awsAccountCache{Fetched: time.Now(), Accounts: accounts})
+	if err == nil {
+		err = os.WriteFile(cachePath, data, 0600)
+	}
+	if err != nil {
+		log.Printf("[GetCachedAwsAccountMetadata] unable to cache account metadata: %v", err)
+	} else {
+		log.Printf("[GetCachedAwsAccountMetadata] cached account metadata in %q", cachePath)
+	}
+	return accounts, nil
+}
+
 // GetAwsAccountMetadata returns a map with accountIDs as keys and metadata key-value pairs map as value.
 func (a *AwsPuller) GetAwsAccountMetadata() (map[string]map[string]string, error) {
 	// get account list and basic metadata
@@ -300,7 +1375,7 @@ func (a *AwsPuller) GetAwsAccountMetadata() (map[string]map[string]string, error
 
 func (a *AwsPuller) getTagsForAWSAccount(accountID string) (map[string]string, error) {
 	result := map[string]string{}
-	svo := organizations.New(a.session)
+	svo := a.orgClient
 	output, err := svo.ListTagsForResource(&organizations.ListTagsForResourceInput{
 		NextToken:  nil,
 		ResourceId: &accountID,
@@ -329,7 +1404,7 @@ func (a *AwsPuller) getTagsForAWSAccount(accountID string) (map[string]string, e
 }
 
 func (a *AwsPuller) pullAccountData(
-	svo *organizations.Organizations,
+	svo OrganizationsClient,
 	result *map[string]map[string]string,
 	nextToken *string,
 ) (*string, error) {
@@ -353,7 +1428,7 @@ func (a *AwsPuller) pullAccountData(
 
 func (a *AwsPuller) getAllAWSAccountData() (map[string]map[string]string, error) {
 	result := map[string]map[string]string{}
-	svo := organizations.New(a.session)
+	svo := a.orgClient
 	log.Println("[pullawsdata] pulling all accounts metadata")
 	nextToken, err := a.pullAccountData(svo, &result, nil)
 	if err != nil {
@@ -371,27 +1446,234 @@ func (a *AwsPuller) getAllAWSAccountData() (map[string]map[string]string, error)
 	return result, nil
 }
 
-func (a *AwsPuller) WriteAwsTags(accounts map[string][]AccountEntry) error {
-	svo := organizations.New(a.session)
-	categoryTag := AwsTagCostpullerCategory
-	for category, accountEntries := range accounts {
-		for _, accountEntry := range accountEntries {
-			fmt.Printf("setting tag %s == %s for account %s...", categoryTag, category, accountEntry.AccountID)
-			if !a.debug {
-				_, err := svo.TagResource(&organizations.TagResourceInput{
-					ResourceId: &accountEntry.AccountID,
-					Tags: []*organizations.Tag{
-						{Key: &categoryTag, Value: &category},
-					},
-				})
-				if err != nil {
-					return err
+// getAccountInventory returns the organization's account inventory (see
+// GetCachedAwsAccountMetadata), which is fetched (and archived) on the first
+// call.
+func (a *AwsPuller) getAccountInventory() (map[string]map[string]string, error) {
+	if a.inventory == nil {
+		inventory, err := archived("aws", a.batchArchiveName, "accounts", a.GetCachedAwsAccountMetadata)
+		if err != nil {
+			return nil, err
+		}
+		a.inventory = inventory
+	}
+	return a.inventory, nil
+}
+
+// getOrganizationInfo returns the names of the organization's accounts and the
+// ID of its payer account, which are fetched (and archived) on the first call.
+// They are only used to label the output, so, if they cannot be fetched
+// (e.g., the profile lacks access to AWS Organizations), a warning is logged
+// and they are left empty.
+func (a *AwsPuller) getOrganizationInfo() *AwsOrganizationInfo {
+	if a.organizationInfo != nil {
+		return a.organizationInfo
+	}
+	info, err := archived("aws", a.batchArchiveName, "organization", func() (*AwsOrganizationInfo, error) {
+		svo := a.orgClient
+		output, err := svo.DescribeOrganization(&organizations.DescribeOrganizationInput{})
+		if err != nil {
+			return nil, err
+		}
+		accounts, err := a.getAllAWSAccountData()
+		if err != nil {
+			return nil, err
+		}
+		info := &AwsOrganizationInfo{
+			PayerAccountId: aws.StringValue(output.Organization.MasterAccountId),
+			AccountNames:   make(map[string]string, len(accounts)),
+		}
+		for accountID, metadata := range accounts {
+			info.AccountNames[accountID] = metadata[AwsMetadataDescription]
+		}
+		return info, nil
+	})
+	if err != nil {
+		log.Printf("[getOrganizationInfo] Warning:  unable to get the account names and payer account ID: %v", err)
+		info = &AwsOrganizationInfo{}
+	}
+	a.organizationInfo = info
+	return info
+}
+
+// GetAccountOUPaths returns a map with account IDs as keys and the paths of
+// the organizational units which contain them (e.g., "Root/Engineering/Tools")
+// as values, found by walking the organization's OU hierarchy.
+func (a *AwsPuller) GetAccountOUPaths() (map[string]string, error) {
+	svo := a.orgClient
+	result := map[string]string{}
+	log.Println("[GetAccountOUPaths] pulling organizational unit hierarchy")
+	var walkErr error
+	err := svo.ListRootsPages(&organizations.ListRootsInput{},
+		func(page *organizations.ListRootsOutput, lastPage bool) bool {
+			for _, root := range page.Roots {
+				walkErr = a.walkOrganizationalUnit(svo, root.Id, aws.StringValue(root.Name), result)
+				if walkErr != nil {
+					return false
+				}
+			}
+			return true
+		})
+	if err != nil {
+		log.Printf("[GetAccountOUPaths] error getting organization roots: %v", err)
+		return nil, err
+	}
+	if walkErr != nil {
+		// Don't return (and so cache) the partial hierarchy.
+		log.Printf("[GetAccountOUPaths] error walking organizational units: %v", walkErr)
+		return nil, walkErr
+	}
+	return result, nil
+}
+
+// walkOrganizationalUnit records the path of the given parent (a root or an
+// OU) for each account which it directly contains, and then descends into its
+// child OUs.
+func (a *AwsPuller) walkOrganizationalUnit(
+	svo OrganizationsClient,
+	parentID *string,
+	path string,
+	result map[string]string,
+) error {
+	err := svo.ListAccountsForParentPages(&organizations.ListAccountsForParentInput{ParentId: parentID},
+		func(page *organizations.ListAccountsForParentOutput, lastPage bool) bool {
+			for _, account := range page.Accounts {
+				result[*account.Id] = path
+			}
+			return true
+		})
+	if err != nil {
+		return err
+	}
+	var children []*organizations.OrganizationalUnit
+	err = svo.ListOrganizationalUnitsForParentPages(
+		&organizations.ListOrganizationalUnitsForParentInput{ParentId: parentID},
+		func(page *organizations.ListOrganizationalUnitsForParentOutput, lastPage bool) bool {
+			children = append(children, page.OrganizationalUnits...)
+			return true
+		})
+	if err != nil {
+		return err
+	}
+	for _, ou := range children {
+		if err := a.walkOrganizationalUnit(svo, ou.Id, path+"/"+aws.StringValue(ou.Name), result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AwsTagChange describes a change to a tag on an AWS account:  the tag is
+// added if it is not present (OldValue is empty), changed if it has a
+// different value, or removed if NewValue is empty.
+type AwsTagChange struct {
+	AccountID string
+	Key       string
+	OldValue  string
+	NewValue  string
+}
+
+// String formats the change for display in a plan.
+func (c AwsTagChange) String() string {
+	switch {
+	case c.OldValue == "":
+		return fmt.Sprintf("account %s: add %s = %q", c.AccountID, c.Key, c.NewValue)
+	case c.NewValue == "":
+		return fmt.Sprintf("account %s: remove %s (was %q)", c.AccountID, c.Key, c.OldValue)
+	default:
+		return fmt.Sprintf("account %s: change %s from %q to %q", c.AccountID, c.Key, c.OldValue, c.NewValue)
+	}
+}
+
+// PlanAwsTags compares the tags on each of the given accounts with the
+// category in which it is listed and with its ownership metadata (owner,
+// contact, and cost center, when they are provided) and returns the changes
+// needed to bring the tags up to date; tags which already match are omitted.
+func (a *AwsPuller) PlanAwsTags(accounts map[string][]AccountEntry) ([]AwsTagChange, error) {
+	var changes []AwsTagChange
+	for _, category := range sortedKeys(accounts) {
+		for _, accountEntry := range accounts[category] {
+			tags, err := a.getTagsForAWSAccount(accountEntry.AccountID)
+			if err != nil {
+				return nil, err
+			}
+			desired := []struct{ key, value string }{
+				{AwsTagCostpullerCategory, category},
+				{AwsTagCostpullerOwner, accountEntry.Owner},
+				{AwsTagCostpullerContact, accountEntry.Contact},
+				{AwsTagCostpullerCostCenter, accountEntry.CostCenter},
+			}
+			for _, tag := range desired {
+				if tag.value != "" && tags[tag.key] != tag.value {
+					changes = append(changes, AwsTagChange{
+						AccountID: accountEntry.AccountID,
+						Key:       tag.key,
+						OldValue:  tags[tag.key],
+						NewValue:  tag.value,
+					})
 				}
-				fmt.Println("done.")
-			} else {
-				fmt.Println("not done (debug mode).")
 			}
 		}
 	}
+	return changes, nil
+}
+
+// PlanStaleAwsTags finds the accounts in the organization which carry a
+// category tag but which are not listed in the given accounts, and returns
+// the changes which would remove their tags.
+func (a *AwsPuller) PlanStaleAwsTags(accounts map[string][]AccountEntry) ([]AwsTagChange, error) {
+	listed := make(map[string]struct{})
+	for _, accountEntries := range accounts {
+		for _, accountEntry := range accountEntries {
+			// Compare the IDs in one format, since the accounts file may
+			// list them with or without hyphens.
+			accountID, _ := getCanonicalAccountId("Amazon", accountEntry.AccountID)
+			listed[accountID] = struct{}{}
+		}
+	}
+	metadata, err := a.GetAwsAccountMetadata()
+	if err != nil {
+		return nil, err
+	}
+	var changes []AwsTagChange
+	for _, accountID := range sortedKeys(metadata) {
+		category, tagged := metadata[accountID][AwsTagCostpullerCategory]
+		canonicalID, _ := getCanonicalAccountId("Amazon", accountID)
+		if _, exists := listed[canonicalID]; tagged && !exists {
+			changes = append(changes, AwsTagChange{
+				AccountID: accountID,
+				Key:       AwsTagCostpullerCategory,
+				OldValue:  category,
+			})
+		}
+	}
+	return changes, nil
+}
+
+// ApplyAwsTags makes the given tag changes.
+func (a *AwsPuller) ApplyAwsTags(changes []AwsTagChange) error {
+	svo := a.orgClient
+	for _, change := range changes {
+		fmt.Printf("%s...", change)
+		var err error
+		if change.NewValue == "" {
+			_, err = svo.UntagResource(&organizations.UntagResourceInput{
+				ResourceId: aws.String(change.AccountID),
+				TagKeys:    []*string{aws.String(change.Key)},
+			})
+		} else {
+			_, err = svo.TagResource(&organizations.TagResourceInput{
+				ResourceId: aws.String(change.AccountID),
+				Tags: []*organizations.Tag{
+					{Key: aws.String(change.Key), Value: aws.String(change.NewValue)},
+				},
+			})
+		}
+		if err != nil {
+			fmt.Println("failed.")
+			return err
+		}
+		fmt.Println("done.")
+	}
 	return nil
 }
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"google.golang.org/api/sheets/v4"
+)
+
+// fakeCostExplorer serves canned GetCostAndUsage responses:  the service
+// breakdown (queries with a GroupBy) in pages, chained by their page numbers
+// as the NextPageToken, and the total (queries without one).
+type fakeCostExplorer struct {
+	servicePages []*costexplorer.GetCostAndUsageOutput
+	total        *costexplorer.GetCostAndUsageOutput
+	inputs       []*costexplorer.GetCostAndUsageInput
+}
+
+func (f *fakeCostExplorer) GetCostAndUsage(
+	input *costexplorer.GetCostAndUsageInput,
+) (*costexplorer.GetCostAndUsageOutput, error) {
+	f.inputs = append(f.inputs, input)
+	if len(input.GroupBy) == 0 {
+		return f.total, nil
+	}
+	page := 0
+	if input.NextPageToken != nil {
+		var err error
+		if page, err = strconv.Atoi(*input.NextPageToken); err != nil || page >= len(f.servicePages) {
+			return nil, errors.New("bad page token " + *input.NextPageToken)
+		}
+	}
+	return f.servicePages[page], nil
+}
+
+func (f *fakeCostExplorer) GetCostAndUsageWithResources(
+	*costexplorer.GetCostAndUsageWithResourcesInput,
+) (*costexplorer.GetCostAndUsageWithResourcesOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeCostExplorer) GetRightsizingRecommendation(
+	*costexplorer.GetRightsizingRecommendationInput,
+) (*costexplorer.GetRightsizingRecommendationOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+// newServicePage returns a page of the service breakdown with the given costs
+// (by service) and, if it is not empty, the token of the next page.
+func newServicePage(nextPageToken string, costs map[string]string) *costexplorer.GetCostAndUsageOutput {
+	result := &costexplorer.ResultByTime{}
+	for _, service := range sortedKeys(costs) {
+		result.Groups = append(result.Groups, &costexplorer.Group{
+			Keys:    []*string{aws.String(service)},
+			Metrics: map[string]*costexplorer.MetricValue{"UnblendedCost": newUsdMetric(costs[service])},
+		})
+	}
+	output := &costexplorer.GetCostAndUsageOutput{ResultsByTime: []*costexplorer.ResultByTime{result}}
+	if nextPageToken != "" {
+		output.NextPageToken = aws.String(nextPageToken)
+	}
+	return output
+}
+
+// newTotalOutput returns the response to a total cost query.
+func newTotalOutput(amount string) *costexplorer.GetCostAndUsageOutput {
+	return &costexplorer.GetCostAndUsageOutput{ResultsByTime: []*costexplorer.ResultByTime{{
+		Total: map[string]*costexplorer.MetricValue{"UnblendedCost": newUsdMetric(amount)},
+	}}}
+}
+
+func newUsdMetric(amount string) *costexplorer.MetricValue {
+	return &costexplorer.MetricValue{Amount: aws.String(amount), Unit: aws.String("USD")}
+}
+
+// fakeOrganizations holds the accounts of an organization and their tags,
+// and records the tag changes made to them.
+type fakeOrganizations struct {
+	OrganizationsClient // The methods which are not faked panic
+
+	accounts []*organizations.Account
+	tags     map[string]map[string]string // Account ID -> key -> value
+	tagged   []string                     // "<account ID> <key>=<value>"
+	untagged []string                     // "<account ID> <key>"
+}
+
+// ListAccounts returns the accounts in pages of two, using the index of the
+// first account of the page as its token.
+func (f *fakeOrganizations) ListAccounts(
+	input *organizations.ListAccountsInput,
+) (*organizations.ListAccountsOutput, error) {
+	start := 0
+	if input.NextToken != nil {
+		start, _ = strconv.Atoi(*input.NextToken)
+	}
+	end := min(start+2, len(f.accounts))
+	output := &organizations.ListAccountsOutput{Accounts: f.accounts[start:end]}
+	if end < len(f.accounts) {
+		output.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return output, nil
+}
+
+func (f *fakeOrganizations) ListTagsForResource(
+	input *organizations.ListTagsForResourceInput,
+) (*organizations.ListTagsForResourceOutput, error) {
+	output := &organizations.ListTagsForResourceOutput{}
+	tags := f.tags[*input.ResourceId]
+	for _, key := range sortedKeys(tags) {
+		output.Tags = append(output.Tags, &organizations.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return output, nil
+}
+
+func (f *fakeOrganizations) TagResource(
+	input *organizations.TagResourceInput,
+) (*organizations.TagResourceOutput, error) {
+	for _, tag := range input.Tags {
+		f.tagged = append(f.tagged, *input.ResourceId+" "+*tag.Key+"="+*tag.Value)
+	}
+	return &organizations.TagResourceOutput{}, nil
+}
+
+func (f *fakeOrganizations) UntagResource(
+	input *organizations.UntagResourceInput,
+) (*organizations.UntagResourceOutput, error) {
+	for _, key := range input.TagKeys {
+		f.untagged = append(f.untagged, *input.ResourceId+" "+*key)
+	}
+	return &organizations.UntagResourceOutput{}, nil
+}
+
+func newFakeAccount(id string, name string) *organizations.Account {
+	return &organizations.Account{Id: aws.String(id), Name: aws.String(name), Status: aws.String("ACTIVE")}
+}
+
+func TestPullDataMergesPages(t *testing.T) {
+	ce := &fakeCostExplorer{
+		servicePages: []*costexplorer.GetCostAndUsageOutput{
+			newServicePage("1", map[string]string{"Amazon Elastic Compute Cloud - Compute": "100.25"}),
+			newServicePage("2", map[string]string{"Amazon Simple Storage Service": "20.50"}),
+			newServicePage("", map[string]string{"AWS Key Management Service": "1.25", "Tax": "3"}),
+		},
+		total: newTotalOutput("125.00"),
+	}
+	puller := &AwsPuller{ceClient: ce}
+
+	results, err := puller.PullData("123456789012", "2024-03", "UnblendedCost")
+	if err != nil {
+		t.Fatalf("PullData returned an error: %v", err)
+	}
+	expected := map[string]float64{
+		"Amazon Elastic Compute Cloud - Compute": 100.25,
+		"Amazon Simple Storage Service":          20.50,
+		"AWS Key Management Service":             1.25,
+		"Tax":                                    3,
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("PullData returned %v, expected %v", results, expected)
+	}
+	if len(ce.inputs) != 4 {
+		t.Errorf("PullData sent %d queries, expected 3 pages and the total", len(ce.inputs))
+	}
+	if start := aws.StringValue(ce.inputs[0].TimePeriod.Start); start != "2024-03-01" {
+		t.Errorf("the query starts on %s, expected 2024-03-01", start)
+	}
+	if puller.residuals != nil {
+		t.Errorf("PullData noted residuals %v for reconciling totals", puller.residuals)
+	}
+}
+
+func TestPullDataNotesReconciliationMismatch(t *testing.T) {
+	ce := &fakeCostExplorer{
+		servicePages: []*costexplorer.GetCostAndUsageOutput{
+			newServicePage("", map[string]string{"Amazon Elastic Compute Cloud - Compute": "90.00"}),
+		},
+		total: newTotalOutput("100.00"),
+	}
+	puller := &AwsPuller{ceClient: ce}
+
+	if _, err := puller.PullData("123456789012", "2024-03", "UnblendedCost"); err != nil {
+		t.Fatalf("PullData returned an error: %v", err)
+	}
+	if residual := puller.residuals["123456789012"]; math.Abs(residual-10) > 1e-9 {
+		t.Errorf("the residual is %.2f, expected 10.00", residual)
+	}
+}
+
+func TestCheckResponseConsistency(t *testing.T) {
+	results := map[string]float64{"storage": 60, "machines": 50}
+	tests := []struct {
+		name      string
+		account   AccountEntry
+		baselines map[string]float64
+		fails     bool
+	}{
+		{"no standard value", AccountEntry{AccountID: "1"}, nil, false},
+		{"within deviation", AccountEntry{AccountID: "1", StandardValue: 100, DeviationPercent: 20}, nil, false},
+		{"beyond deviation", AccountEntry{AccountID: "1", StandardValue: 100, DeviationPercent: 5}, nil, true},
+		{
+			"trailing average replaces the standard value",
+			AccountEntry{AccountID: "1", StandardValue: 100, DeviationPercent: 5},
+			map[string]float64{"1": 108},
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			puller := &AwsPuller{baselines: test.baselines}
+			total, err := puller.CheckResponseConsistency(test.account, results)
+			if total != 110 {
+				t.Errorf("the total is %.2f, expected 110.00", total)
+			}
+			if (err != nil) != test.fails {
+				t.Errorf("CheckResponseConsistency returned error %v, expected failure %t", err, test.fails)
+			}
+		})
+	}
+}
+
+func TestPlanAndApplyAwsTags(t *testing.T) {
+	org := &fakeOrganizations{tags: map[string]map[string]string{
+		"111111111111": {AwsTagCostpullerCategory: "dev", AwsTagCostpullerOwner: "alice"},
+		"222222222222": {AwsTagCostpullerCategory: "old"},
+	}}
+	puller := &AwsPuller{orgClient: org}
+	accounts := map[string][]AccountEntry{
+		"dev":  {{AccountID: "111111111111", Owner: "alice"}},
+		"prod": {{AccountID: "222222222222", Owner: "bob", CostCenter: "123"}},
+	}
+
+	changes, err := puller.PlanAwsTags(accounts)
+	if err != nil {
+		t.Fatalf("PlanAwsTags returned an error: %v", err)
+	}
+	expected := []AwsTagChange{
+		{AccountID: "222222222222", Key: AwsTagCostpullerCategory, OldValue: "old", NewValue: "prod"},
+		{AccountID: "222222222222", Key: AwsTagCostpullerOwner, NewValue: "bob"},
+		{AccountID: "222222222222", Key: AwsTagCostpullerCostCenter, NewValue: "123"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("PlanAwsTags returned %v, expected %v", changes, expected)
+	}
+
+	if err := puller.ApplyAwsTags(append(changes, AwsTagChange{AccountID: "333333333333",
+		Key: AwsTagCostpullerCategory, OldValue: "stale"})); err != nil {
+		t.Fatalf("ApplyAwsTags returned an error: %v", err)
+	}
+	expectedTagged := []string{
+		"222222222222 " + AwsTagCostpullerCategory + "=prod",
+		"222222222222 " + AwsTagCostpullerOwner + "=bob",
+		"222222222222 " + AwsTagCostpullerCostCenter + "=123",
+	}
+	if !reflect.DeepEqual(org.tagged, expectedTagged) {
+		t.Errorf("ApplyAwsTags tagged %v, expected %v", org.tagged, expectedTagged)
+	}
+	if expectedUntagged := []string{"333333333333 " + AwsTagCostpullerCategory}; !reflect.DeepEqual(org.untagged,
+		expectedUntagged) {
+		t.Errorf("ApplyAwsTags untagged %v, expected %v", org.untagged, expectedUntagged)
+	}
+}
+
+func TestPlanStaleAwsTags(t *testing.T) {
+	org := &fakeOrganizations{
+		accounts: []*organizations.Account{
+			newFakeAccount("111111111111", "listed"),
+			newFakeAccount("222222222222", "listed with hyphens"),
+			newFakeAccount("333333333333", "stale"),
+			newFakeAccount("444444444444", "untagged"),
+			newFakeAccount("555555555555", "stale, on the second page"),
+		},
+		tags: map[string]map[string]string{
+			"111111111111": {AwsTagCostpullerCategory: "dev"},
+			"222222222222": {AwsTagCostpullerCategory: "prod"},
+			"333333333333": {AwsTagCostpullerCategory: "retired"},
+			"555555555555": {AwsTagCostpullerCategory: "retired", AwsTagCostpullerOwner: "carol"},
+		},
+	}
+	puller := &AwsPuller{orgClient: org}
+	accounts := map[string][]AccountEntry{
+		"dev":  {{AccountID: "111111111111"}},
+		"prod": {{AccountID: "2222-2222-2222"}},
+	}
+
+	changes, err := puller.PlanStaleAwsTags(accounts)
+	if err != nil {
+		t.Fatalf("PlanStaleAwsTags returned an error: %v", err)
+	}
+	expected := []AwsTagChange{
+		{AccountID: "333333333333", Key: AwsTagCostpullerCategory, OldValue: "retired"},
+		{AccountID: "555555555555", Key: AwsTagCostpullerCategory, OldValue: "retired"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("PlanStaleAwsTags returned %v, expected %v", changes, expected)
+	}
+}
+
+// isolateRunNotes clears the run's notes of skipped costs, untracked
+// accounts, warnings, and check failures for the duration of the test, and
+// restores them afterwards.
+func isolateRunNotes(t *testing.T) {
+	t.Helper()
+	savedSkipped, savedUntracked := skippedCosts, untrackedAccounts
+	savedStatus, savedWarnings, savedFailures := exitStatus, runWarnings, checkFailures
+	t.Cleanup(func() {
+		skippedCosts, untrackedAccounts = savedSkipped, savedUntracked
+		exitStatus, runWarnings, checkFailures = savedStatus, savedWarnings, savedFailures
+	})
+	skippedCosts = make(map[SkippedCostKey]*SkippedCost)
+	untrackedAccounts = nil
+	exitStatus, runWarnings, checkFailures = ExitSuccess, nil, nil
+}
+
+func TestPullAwsByAccountReplaysCachedFindings(t *testing.T) {
+	isolateRunNotes(t)
+	ce := &fakeCostExplorer{
+		servicePages: []*costexplorer.GetCostAndUsageOutput{
+			newServicePage("", map[string]string{"Amazon Elastic Compute Cloud - Compute": "150.00"}),
+		},
+		total: newTotalOutput("150.00"),
+	}
+	accounts := map[string][]AccountEntry{
+		"dev": {{AccountID: "111111111111", StandardValue: 100, DeviationPercent: 10}},
+	}
+	month, costType := "2024-03", "UnblendedCost"
+	options := CommandLineOptions{monthPtr: &month, costTypePtr: &costType}
+	runCache := &RunCache{Accounts: make(map[string]*CachedAccount)}
+	pull := func() (rows []*sheets.RowData) {
+		puller := &AwsPuller{ceClient: ce, runCache: runCache}
+		puller.pullAwsByAccount(accounts, []string{"dev"}, AwsPayer{}, options, nil, nil,
+			func(emitted []*sheets.RowData) { rows = append(rows, emitted...) })
+		return
+	}
+
+	pulled := pull()
+	queries := len(ce.inputs)
+	if len(checkFailures) != 1 || len(runCache.Accounts["/111111111111"].Findings) != 1 {
+		t.Fatalf("the pull noted check failures %q and cached findings %v, expected one deviation failure",
+			checkFailures, runCache.Accounts["/111111111111"])
+	}
+	failure := checkFailures[0]
+
+	checkFailures, exitStatus = nil, ExitSuccess
+	reused := pull()
+	if len(ce.inputs) != queries {
+		t.Errorf("the cached account was pulled again (%d queries)", len(ce.inputs)-queries)
+	}
+	if !reflect.DeepEqual(reused, pulled) {
+		t.Errorf("the cached rows %v differ from the pulled rows %v", reused, pulled)
+	}
+	if !reflect.DeepEqual(checkFailures, []string{failure}) || exitStatus != ExitConsistencyFailure {
+		t.Errorf("the reused account noted check failures %q (exit status %d), expected %q", checkFailures,
+			exitStatus, failure)
+	}
+}
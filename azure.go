@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+)
+
+// AzureBillingScope identifies an Azure billing account (an Enterprise
+// Agreement enrollment or a Microsoft Customer Agreement billing account)
+// and, optionally, one of its billing profiles (MCA only).
+type AzureBillingScope struct {
+	BillingAccount string
+	BillingProfile string
+}
+
+// String returns the Azure Resource Manager scope for the billing account or
+// profile, as used by the Cost Management APIs.
+func (s AzureBillingScope) String() string {
+	scope := "/providers/Microsoft.Billing/billingAccounts/" + s.BillingAccount
+	if s.BillingProfile != "" {
+		scope += "/billingProfiles/" + s.BillingProfile
+	}
+	return scope
+}
+
+// getAzureBillingScopes returns the billing scopes listed by the
+// "billing_scopes" key in the "azure" subsection of the configuration; each
+// entry is a mapping with a "billing_account" and an optional
+// "billing_profile".
+func getAzureBillingScopes(configMap Configuration) (scopes []AzureBillingScope) {
+	scopesAny := getMapKeyValue(configMap, "billing_scopes", "")
+	if scopesAny == nil {
+		return nil
+	}
+	entries, ok := scopesAny.([]any)
+	if !ok {
+		fatalf("Error in Azure \"billing_scopes\" value (%v), type is %T, expected a list", scopesAny, scopesAny)
+	}
+	for _, entryAny := range entries {
+		entry := getConfigurationFromAny(entryAny, "Azure billing scope")
+		scopes = append(scopes, AzureBillingScope{
+			BillingAccount: getMapKeyString(entry, "billing_account", "azure billing_scopes"),
+			BillingProfile: getMapKeyString(entry, "billing_profile", ""),
+		})
+	}
+	return
+}
+
+// getAzureScopeFilter returns a Cloudability filter which limits the Azure
+// results to the configured billing accounts (which Cloudability reports as
+// the payer account), while passing the results for the other providers; it
+// returns an empty string if no scopes are configured.  (Cloudability does
+// not report the MCA billing profile, so profiles do not narrow the results.)
+func getAzureScopeFilter(configMap Configuration) string {
+	scopes := getAzureBillingScopes(configMap)
+	if scopes == nil {
+		return ""
+	}
+	conditions := []string{"vendor!=Azure"}
+	for _, scope := range scopes {
+		conditions = append(conditions, "account_identifier=="+scope.BillingAccount)
+	}
+	return strings.Join(conditions, ",")
+}
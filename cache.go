@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// getCachePath returns the path to the named file in the tool's cache
+// directory (the same directory which holds the cached OAuth tokens).
+func getCachePath(fileName string) (string, error) {
+	tokenPath, err := getCacheFileName(defaultTokenCachePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(tokenPath), fileName), nil
+}
+
+// RunCache holds the per-account results from a previous run for a given
+// month and cost type, so that an incremental run can reuse them instead of
+// pulling the data again.  The results are keyed by payer and account ID.
+// The ConfigHash identifies the configuration which produced them (see
+// getRunCacheConfigHash()).
+type RunCache struct {
+	Month      string                    `json:"month"`
+	CostType   string                    `json:"costType"`
+	ConfigHash string                    `json:"configHash"`
+	Updated    time.Time                 `json:"updated"`
+	Accounts   map[string]*CachedAccount `json:"accounts"`
+
+	path string
+}
+
+// CachedAccount is the result of pulling an account:  its output rows, and
+// the problems which the checks found in its data, which are reported again
+// when the rows are reused.
+type CachedAccount struct {
+	Rows     []*sheets.RowData `json:"rows"`
+	Findings []AccountFinding  `json:"findings,omitempty"`
+}
+
+// getRunCache returns the cache of the results for the month and cost type,
+// for an -incremental run; otherwise, it returns nil, since the rows are
+// neither reused nor saved.
+func getRunCache(accountsFile AccountsFile, options CommandLineOptions) *RunCache {
+	if !*options.incrementalPtr {
+		return nil
+	}
+	return loadRunCache(*options.monthPtr, *options.costTypePtr, getRunCacheConfigHash(accountsFile, options))
+}
+
+// loadRunCache reads the cached results for the given month and cost type; if
+// there are none (or they cannot be read, or they were produced with a
+// different configuration), it returns an empty cache.
+func loadRunCache(month string, costType string, configHash string) *RunCache {
+	cache := &RunCache{Month: month, CostType: costType, ConfigHash: configHash,
+		Accounts: make(map[string]*CachedAccount)}
+	path, err := getCachePath(fmt.Sprintf("results-%s-%s.json", month, costType))
+	if err != nil {
+		log.Printf("[loadRunCache] results will not be cached: %v", err)
+		return cache
+	}
+	cache.path = path
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, cache)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[loadRunCache] ignoring unreadable results cache %q: %v", path, err)
+	}
+	if cache.ConfigHash != configHash {
+		log.Printf("[loadRunCache] discarding the cached results in %q, which were produced with a different "+
+			"configuration", path)
+		cache.ConfigHash = configHash
+		cache.Accounts = nil
+	}
+	if cache.Accounts == nil {
+		cache.Accounts = make(map[string]*CachedAccount)
+	}
+	return cache
+}
+
+// getRunCacheConfigHash returns a digest of the parts of the configuration
+// and options which determine the cached rows:  the accounts and their
+// groups, the AWS section (the payers, breakdowns, OU grouping, and account
+// tags), the taxonomy, and the layout and account list options.
+func getRunCacheConfigHash(accountsFile AccountsFile, options CommandLineOptions) string {
+	// (The accounts are encoded as JSON, to follow their pointers; the
+	// configuration sections hold only YAML values.)
+	providers, err := json.Marshal(accountsFile.Providers)
+	if err != nil {
+		log.Printf("[getRunCacheConfigHash] error encoding the accounts: %v", err)
+	}
+	digest := sha256.Sum256(fmt.Appendf(providers, "|%v|%v|%t|%t", accountsFile.Configuration["aws"],
+		accountsFile.Configuration["taxonomy"], *options.legacyLayoutPtr, *options.taggedAccountsPtr))
+	return hex.EncodeToString(digest[:])
+}
+
+// save writes the cache (if any) to its file; errors are reported but are not
+// fatal.
+func (c *RunCache) save() {
+	if c == nil || c.path == "" {
+		return
+	}
+	c.Updated = time.Now()
+	data, err := json.Marshal(c)
+	if err == nil {
+		err = os.WriteFile(c.path, data, 0600)
+	}
+	if err != nil {
+		log.Printf("[RunCache.save] unable to cache results: %v", err)
+		return
+	}
+	log.Printf("[RunCache.save] cached results for %d accounts in %q", len(c.Accounts), c.path)
+}
+
+// get returns the cached results with the given key, if there is a cache and
+// it holds them.
+func (c *RunCache) get(key string) (*CachedAccount, bool) {
+	if c == nil {
+		return nil, false
+	}
+	account, exists := c.Accounts[key]
+	return account, exists
+}
+
+// set caches the results with the given key, if there is a cache.
+func (c *RunCache) set(key string, account *CachedAccount) {
+	if c != nil {
+		c.Accounts[key] = account
+	}
+}
+
+// isMonthOpen reports whether the given month (in the format yyyy-mm) has not
+// yet ended, in which case its data is still changing.
+func isMonthOpen(month string) bool {
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return true
+	}
+	return time.Now().Before(start.AddDate(0, 1, 0))
+}
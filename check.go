@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/IBM/platform-services-go-sdk/enterpriseusagereportsv1"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// CheckResult is the outcome of the health check of one integration.
+type CheckResult struct {
+	Name   string
+	Detail string // What was verified, on success
+	Err    error
+}
+
+// checkCommand implements the "check" subcommand:
+//
+//	costpuller check [options]
+//
+// It verifies the credentials and connectivity of each enabled provider and
+// of the output destinations, with light, read-only requests, so that
+// problems are found before the data is needed.  Each integration is reported
+// as passing or failing on the standard output, and the exit code is
+// ExitProviderError if any of them failed.  The options are those of the data
+// pull (e.g., -providers and -output select what is checked).
+func checkCommand(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	options, accountsFile, _ := getOptions(flags, args)
+	if flags.NArg() != 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "Usage: costpuller check [options]")
+		os.Exit(2)
+	}
+	if len(accountsFile.Configuration) == 0 {
+		fatalf("[check] error in accounts file: empty or missing \"configuration\" section")
+	}
+
+	var results []CheckResult
+	check := func(name string, run func() (string, error)) {
+		log.Printf("[check] checking %s", name)
+		result := CheckResult{Name: name}
+		func() {
+			// Some of the clients panic on bad credentials configuration
+			// (e.g., an unknown AWS profile).
+			defer func() {
+				if r := recover(); r != nil {
+					result.Err = fmt.Errorf("%v", r)
+				}
+			}()
+			result.Detail, result.Err = run()
+		}()
+		results = append(results, result)
+	}
+
+	providers := getEnabledProviders(accountsFile, options)
+	if providers["aws"] {
+		for _, payer := range getAwsPayers(getMapKeyValue(accountsFile.Configuration, "aws", "configuration")) {
+			check("aws "+payer.Name, func() (string, error) {
+				return NewAwsPuller(payer, *options.debugPtr).checkAccess(*options.monthPtr)
+			})
+		}
+	}
+	if providers["cloudability"] {
+		check("cloudability", func() (string, error) {
+			return checkCloudabilityAccess(accountsFile.Configuration["cloudability"])
+		})
+	}
+	if providers["ibmcloud"] {
+		check("ibmcloud", func() (string, error) {
+			return checkIbmcloudAccess(accountsFile.Configuration["ibmcloud"], *options.monthPtr)
+		})
+	}
+	switch *options.outputTypePtr {
+	case "gsheet":
+		oauthConfig := getMapKeyValue(accountsFile.Configuration, "oauth", "configuration")
+		gsheetConfig := getMapKeyValue(accountsFile.Configuration, "gsheet", "configuration")
+		var srv *sheets.Service
+		for _, configMap := range getGsheetDestinations(gsheetConfig) {
+			check("gsheet "+getMapKeyString(configMap, "spreadsheetId", "gsheet"), func() (string, error) {
+				if srv == nil {
+					var err error
+					srv, err = sheets.NewService(context.Background(),
+						option.WithHTTPClient(getGoogleOAuthHttpClient(oauthConfig)))
+					if err != nil {
+						return "", fmt.Errorf("unable to create Google Sheets client: %w", err)
+					}
+				}
+				return checkGsheetAccess(srv, configMap)
+			})
+		}
+	case "smartsheet":
+		check("smartsheet", func() (string, error) {
+			smartsheet := newSmartsheetOutput(getMapKeyValue(accountsFile.Configuration, "smartsheet", "configuration"))
+			return smartsheet.checkAccess()
+		})
+	}
+
+	failures := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failures++
+			fmt.Printf("FAIL  %s: %v\n", result.Name, result.Err)
+		} else {
+			fmt.Printf("PASS  %s: %s\n", result.Name, result.Detail)
+		}
+	}
+	log.Printf("[check] %d of %d checks failed", failures, len(results))
+	if failures > 0 {
+		os.Exit(ExitProviderError)
+	}
+}
+
+// checkAccess verifies the payer's credentials (with an STS GetCallerIdentity
+// request) and its access to Cost Explorer (with a query for the total cost
+// of the first day of the month).
+func (a *AwsPuller) checkAccess(month string) (string, error) {
+	identity, err := sts.New(a.session).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("error verifying the credentials: %w", err)
+	}
+	period, err := getAwsMonthPeriod(month)
+	if err != nil {
+		return "", err
+	}
+	start, _ := time.Parse("2006-01-02", *period.Start)
+	_, err = a.ceClient.GetCostAndUsage(&costexplorer.GetCostAndUsageInput{
+		Granularity: aws.String("DAILY"),
+		Metrics:     []*string{aws.String("UnblendedCost")},
+		TimePeriod: &costexplorer.DateInterval{
+			Start: period.Start,
+			End:   aws.String(start.AddDate(0, 0, 1).Format("2006-01-02")),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error querying Cost Explorer: %w", err)
+	}
+	return fmt.Sprintf("authenticated as %s; Cost Explorer is accessible", aws.StringValue(identity.Arn)), nil
+}
+
+// checkCloudabilityAccess obtains the Cloudability authorization and verifies
+// it by listing the cost report measures.
+func checkCloudabilityAccess(configMap Configuration) (string, error) {
+	cUrl, err := url.Parse(getMapKeyString(configMap, "api", "cloudability"))
+	if err != nil {
+		return "", fmt.Errorf("error in \"api\" value (%q): %w", configMap["api"], err)
+	}
+	client := newAuditedHttpClient("cloudability", time.Second*60)
+	authorize, err := getCloudabilityAuthorizer(configMap, client)
+	if err != nil {
+		return "", err
+	}
+	measuresUrl := &url.URL{Scheme: "https", Host: cUrl.Host, Path: cUrl.Path}
+	request, err := http.NewRequest("GET", measuresUrl.JoinPath("/v3/reporting/cost/measures").String(), http.NoBody)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	authorize(request)
+	request.Header.Add("Accept", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("error sending request: %w", err)
+	}
+	defer closeBody(response)
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error listing the cost report measures: %d, %q", response.StatusCode, response.Status)
+	}
+	return "authorized; the cost report API is accessible", nil
+}
+
+// checkIbmcloudAccess obtains an IAM token and verifies it by fetching the
+// usage report of the configured account group (without its accounts).
+func checkIbmcloudAccess(configMap Configuration, month string) (string, error) {
+	authenticator := getIbmcloudAuthenticator(configMap)
+	request, err := http.NewRequest("GET", "https://iam.cloud.ibm.com", http.NoBody)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	if err := authenticator.Authenticate(request); err != nil {
+		return "", fmt.Errorf("error obtaining an IAM token: %w", err)
+	}
+	client, err := enterpriseusagereportsv1.NewEnterpriseUsageReportsV1(
+		&enterpriseusagereportsv1.EnterpriseUsageReportsV1Options{Authenticator: authenticator})
+	if err != nil {
+		return "", fmt.Errorf("error creating enterprise usage reports client: %w", err)
+	}
+	configureIbmcloudService(client.Service, configMap, "ibmcloud")
+	reportOptions := client.NewGetResourceUsageReportOptions().
+		SetAccountGroupID(getMapKeyString(configMap, "account_id", ConfigSect)).
+		SetMonth(month).
+		SetChildren(false).
+		SetLimit(1)
+	result, _, err := client.GetResourceUsageReport(reportOptions)
+	if err != nil {
+		return "", fmt.Errorf("error getting the account group usage report: %w", err)
+	}
+	if len(result.Reports) == 0 || result.Reports[0].EntityName == nil {
+		return "", fmt.Errorf("the account group usage report is empty")
+	}
+	return fmt.Sprintf("the usage report of account group %q is accessible", *result.Reports[0].EntityName), nil
+}
+
+// checkGsheetAccess verifies that the spreadsheet can be read and that it has
+// the configured main sheet.
+func checkGsheetAccess(srv *sheets.Service, configMap Configuration) (string, error) {
+	spreadsheetId := getMapKeyString(configMap, "spreadsheetId", "gsheet")
+	spreadsheet, err := srv.Spreadsheets.Get(spreadsheetId).Fields("properties/title,sheets/properties/title").Do()
+	if err != nil {
+		return "", fmt.Errorf("error retrieving spreadsheet: %w", err)
+	}
+	mainSheetName := getMapKeyString(configMap, "mainSheetName", "gsheet")
+	if getSheetIdFromName(spreadsheet, mainSheetName) == nil {
+		return "", fmt.Errorf("main sheet %q not found in %q", mainSheetName, spreadsheet.Properties.Title)
+	}
+	return fmt.Sprintf("spreadsheet %q is accessible", spreadsheet.Properties.Title), nil
+}
+
+// checkAccess verifies the access token by fetching the current user and, if
+// one is configured, the destination folder.
+func (s *SmartsheetOutput) checkAccess() (string, error) {
+	var user struct {
+		Email string `json:"email"`
+	}
+	if err := s.request("GET", "users/me", nil, nil, &user); err != nil {
+		return "", err
+	}
+	if s.folderId != "" {
+		if err := s.request("GET", "folders/"+s.folderId, nil, nil, nil); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("authenticated as %s", user.Email), nil
+}
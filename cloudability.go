@@ -2,14 +2,22 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net/http"
 	"net/url"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+
+	"google.golang.org/api/sheets/v4"
 )
 
 type CloudabilityCostData struct {
@@ -25,13 +33,89 @@ type CloudabilityCostData struct {
 }
 
 type ResultsEntry struct {
-	AccountID      string `json:"vendor_account_identifier"`
-	AccountName    string `json:"vendor_account_name"`
-	CloudProvider  string `json:"vendor"`
-	Cost           string `json:"unblended_cost"`
-	CostCenter     string `json:"category4"`
-	PayerAccountId string `json:"account_identifier"`
-	UsageFamily    string `json:"usage_family"`
+	AccountID      string            `json:"vendor_account_identifier"`
+	AccountName    string            `json:"vendor_account_name"`
+	CloudProvider  string            `json:"vendor"`
+	Cost           string            `json:"-"` // The requested metric; see UnmarshalJSON()
+	CostCenter     string            `json:"category4"`
+	Date           string            `json:"date"`
+	Tags           map[string]string `json:"-"` // Values of the configured tag dimensions
+	PayerAccountId string            `json:"account_identifier"`
+	UsageFamily    string            `json:"usage_family"`
+
+	fields []string // The names of the fields in the response, for checkCloudabilitySchema()
+}
+
+// resultsEntryFields is the set of the JSON field names of ResultsEntry.
+var resultsEntryFields = func() map[string]struct{} {
+	fields := make(map[string]struct{})
+	entryType := reflect.TypeFor[ResultsEntry]()
+	for i := range entryType.NumField() {
+		if entryType.Field(i).IsExported() {
+			fields[strings.Split(entryType.Field(i).Tag.Get("json"), ",")[0]] = struct{}{}
+		}
+	}
+	return fields
+}()
+
+// UnmarshalJSON decodes a results entry, setting the Cost field from
+// whichever of the Cloudability cost metrics is present (only one is
+// requested), and collecting the values of any other dimensions (i.e., the
+// configured tag dimensions) in the Tags field.  The names of the fields are
+// noted, so that the schema of the response can be checked.
+func (e *ResultsEntry) UnmarshalJSON(data []byte) error {
+	type resultsEntry ResultsEntry // Lacks this method, to avoid recursion
+	if err := json.Unmarshal(data, (*resultsEntry)(e)); err != nil {
+		return err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	e.fields = slices.Sorted(maps.Keys(fields))
+	metrics := slices.Collect(maps.Values(cloudabilityMetrics))
+	for key, valueAny := range fields {
+		value, ok := valueAny.(string)
+		if _, known := resultsEntryFields[key]; known || !ok {
+			continue
+		}
+		if slices.Contains(metrics, key) {
+			e.Cost = value
+			continue
+		}
+		if e.Tags == nil {
+			e.Tags = make(map[string]string)
+		}
+		e.Tags[key] = value
+	}
+	return nil
+}
+
+// cloudabilityMetrics maps the values of the -costtype option (which are the
+// AWS Cost Explorer metric names) to the corresponding Cloudability metrics.
+// The usage metrics and blended cost have no Cloudability equivalent.
+var cloudabilityMetrics = map[string]string{
+	"AmortizedCost":    "total_amortized_cost",
+	"NetAmortizedCost": "adjusted_amortized_cost",
+	"NetUnblendedCost": "adjusted_cost",
+	"UnblendedCost":    "unblended_cost",
+}
+
+// getCloudabilityMetric returns the Cloudability metric for the given cost
+// type; the Cloudability metric names themselves are also accepted.  If there
+// is no corresponding metric, the program exits with an error.
+func getCloudabilityMetric(costType string) string {
+	if metric, exists := cloudabilityMetrics[costType]; exists {
+		return metric
+	}
+	for _, metric := range cloudabilityMetrics {
+		if metric == costType {
+			return metric
+		}
+	}
+	exitf(ExitUsage, "Cost type %q is not supported with Cloudability; use one of %s",
+		costType, strings.Join(sortedKeys(cloudabilityMetrics), ", "))
+	return ""
 }
 
 type MetaSection struct {
@@ -78,19 +162,20 @@ type Element struct {
 	//Type string `json:"type"`
 }
 
-func getCloudabilityData(configMap Configuration, options CommandLineOptions) *CloudabilityCostData {
-	uri := "/v3/reporting/cost/run"
+func getCloudabilityData(configMap Configuration, azureConfig Configuration, options CommandLineOptions) *CloudabilityCostData {
+	defer startPhase("cloudability.pull")()
+	uri := "/v3/reporting/cost"
 
 	cUrl, err := url.Parse(getMapKeyString(configMap, "api", "cloudability"))
 	if err != nil {
-		log.Fatalf("Error in Cloudability \"api_host\" value (%q): %v", configMap["api"], err)
+		fatalf("Error in Cloudability \"api_host\" value (%q): %v", configMap["api"], err)
 	}
 
 	now := time.Now()
 	var startString, endString string
 	if inTime, err := time.Parse("2006-01", *options.monthPtr); err == nil {
 		if inTime.After(now) {
-			log.Fatalf(
+			fatalf(
 				"Error:  specified month, %q, is in the future.",
 				*options.monthPtr,
 			)
@@ -106,129 +191,286 @@ func getCloudabilityData(configMap Configuration, options CommandLineOptions) *C
 		}
 		endString = endTime.Format("2006-01-02")
 	} else {
-		log.Fatalf("Error in Cloudability \"month\" value (%q): %v", *options.monthPtr, err)
+		fatalf("Error in Cloudability \"month\" value (%q): %v", *options.monthPtr, err)
 	}
 
-	costType := *options.costTypePtr
-	if costType == "UnblendedCost" {
-		costType = "unblended_cost"
-	}
+	costType := getCloudabilityMetric(*options.costTypePtr)
 
 	qParams := cUrl.Query()
 	qParams.Set("start_date", startString)
 	qParams.Set("end_date", endString)
-	qParams.Set("dimensions", "vendor,category4,account_identifier,vendor_account_name,vendor_account_identifier,usage_family")
+	dimensions := "vendor,category4,account_identifier,vendor_account_name,vendor_account_identifier,usage_family"
+	if getCloudabilityGranularity(configMap, options) != "monthly" {
+		dimensions += ",date"
+	}
+	for _, tag := range getMapKeyStringList(configMap, "tag_dimensions", "") {
+		dimensions += "," + tag
+	}
+	qParams.Set("dimensions", dimensions)
 	qParams.Set("metrics", costType)
 	filtersAny := getMapKeyValue(configMap, "filters", "")
-	if filters, ok := filtersAny.(map[any]any); ok {
-		for filterAny, expAny := range filters {
-			filter := getStringFromAny(filterAny, "Cloudability filter name")
-			if expAny == nil {
-				log.Fatalf("Missing value(s) for Cloudability filter %q", filter)
-			}
-			exp, ok := expAny.([]any)
-			if !ok {
-				log.Fatalf(
-					"Unexpected value (%v) for Cloudability filter values for filter %q, expected an array of strings",
-					expAny,
-					filter,
-				)
-			}
-			for _, valAny := range exp {
-				val := getStringFromAny(valAny, "Cloudability filter value")
-				qParams.Add("filters", filter+"=="+val)
+	for _, filter := range getCloudabilityFilters(filtersAny) {
+		qParams.Add("filters", filter)
+	}
+	// Unless the filters select the cost center dimension explicitly, select
+	// the configured cost center(s), if the query can express them (i.e.,
+	// they are not patterns, nor compared without regard to case).  As with
+	// the matcher, the negated conditions are separate filters (AND), and the
+	// others are combined into one (OR).
+	filters, _ := filtersAny.(map[any]any)
+	switch {
+	case filters["category4"] != nil:
+		// The filters select the cost centers.
+	case !getCostCenterMatcher(configMap).isQueryable():
+		log.Printf("[getCloudabilityData] the \"cost_center\" entries cannot be selected in the query; " +
+			"requesting all cost centers")
+	default:
+		var group []string
+		for _, costCenter := range getMapKeyStringList(configMap, "cost_center", "") {
+			condition := getCloudabilityCondition("category4", strings.TrimSpace(costCenter))
+			if strings.HasPrefix(strings.TrimSpace(costCenter), "!=") {
+				qParams.Add("filters", condition)
+			} else {
+				group = append(group, condition)
 			}
 		}
-	} else if filtersAny != nil {
-		log.Fatalf("Error in Cloudability \"filters\" value (%q), type is %T, expected a mapping",
-			filtersAny, filtersAny)
+		if group != nil {
+			qParams.Add("filters", strings.Join(group, ","))
+		}
+	}
+	if filter := getAzureScopeFilter(azureConfig); filter != "" {
+		qParams.Add("filters", filter)
 	}
 	//qParams.Add("filters", "unblended_cost>0")
 	qParams.Set("view_id", "0")
-	qParams.Set("limit", "0")
 	path, err := url.JoinPath(cUrl.Path, uri)
 	if err != nil {
-		log.Fatalf("Error composing Cloudability API path, joining %q to %q: %v", cUrl.Path, uri, err)
+		fatalf("Error composing Cloudability API path, joining %q to %q: %v", cUrl.Path, uri, err)
 	}
-
 	cUrl = &url.URL{
-		Scheme:   "https",
-		Host:     cUrl.Host,
-		Path:     path,
-		RawQuery: qParams.Encode(),
+		Scheme: "https",
+		Host:   cUrl.Host,
+		Path:   path,
+	}
+
+	pageSize := getMapKeyInt(configMap, "page_size", "")
+	if pageSize <= 0 {
+		pageSize = defaultCloudabilityPageSize
+	}
+	maxResponseBytes := int64(getMapKeyInt(configMap, "max_response_mb", "")) << 20
+	if maxResponseBytes <= 0 {
+		maxResponseBytes = defaultCloudabilityMaxResponseMB << 20
 	}
 
-	client := http.Client{Timeout: time.Second * 180}
+	// With the asynchronous report API, the report is generated by the
+	// service while we poll for its completion, so only the polling is bounded
+	// by a timeout; otherwise, the whole report must be produced within the
+	// request timeout.
+	async := getMapKeyBool(configMap, "async", "")
+	timeout := time.Second * 180
+	if async {
+		timeout = 0
+	}
+	client := newAuditedHttpClient("cloudability", timeout)
+	var authorize func(request *http.Request)
+	if responseArchive.replaying() {
+		// The responses are read from the archive, so no credentials are needed.
+		async = false
+	} else if authorize, err = getCloudabilityAuthorizer(configMap, client); err != nil {
+		exitf(ExitAuthFailure, "Error getting Cloudability authorization:  %v", err)
+	}
 
-	request, err := http.NewRequest("GET", cUrl.String(), http.NoBody)
-	if err != nil {
-		log.Fatalf("Error creating Cloudability request:  %v", err)
+	resultsUrl := cUrl.JoinPath("run")
+	if async {
+		resultsUrl = enqueueCloudabilityReport(configMap, client, cUrl, qParams, authorize)
+		qParams = make(url.Values)
 	}
 
-	if _, ok := configMap["api_key"]; ok {
-		apiKey := getMapKeyString(configMap, "api_key", "cloudability")
-		request.SetBasicAuth(apiKey, "")
-	} else {
-		request.Header.Add("apptio-opentoken", getApptioOpentoken(configMap, client))
-		environmentId := getMapKeyString(configMap, "environmentId", "cloudability")
-		request.Header.Add("apptio-environmentid", environmentId)
+	// Request the results in windows of pageSize entries, so that the size
+	// of each response is bounded, and accumulate them into the first page.
+	// Each page is archived as it was received.
+	var responseData *CloudabilityCostData
+	for offset := 0; ; {
+		qParams.Set("limit", strconv.Itoa(pageSize))
+		qParams.Set("offset", strconv.Itoa(offset))
+		resultsUrl.RawQuery = qParams.Encode()
+		log.Println("[getCloudabilityData] Sending request for data")
+		raw, _ := archived("cloudability", "all", fmt.Sprintf("results-%d", offset), func() (json.RawMessage, error) {
+			var raw json.RawMessage
+			getCloudabilityResponse(client, resultsUrl.String(), authorize, maxResponseBytes, &raw)
+			return raw, nil
+		})
+		page := new(CloudabilityCostData)
+		if err := json.Unmarshal(raw, page); err != nil {
+			exitf(ExitProviderError, "Error unmarshalling the Cloudability response body: %v\n", err)
+		}
+		if responseData == nil {
+			responseData = page
+		} else {
+			responseData.Results = append(responseData.Results, page.Results...)
+		}
+		offset += len(page.Results)
+		if len(page.Results) < pageSize || offset >= page.TotalResults {
+			break
+		}
+		log.Printf("[getCloudabilityData] Received %d of %d results", offset, page.TotalResults)
 	}
-	request.Header.Add("Accept", "application/json")
+	responseData.Limit = 0
+	responseData.Offset = 0
+	responseData.Pagination.Next = ""
+	checkCloudabilitySchema(responseData, strings.Split(dimensions, ","), costType, *options.debugPtr)
 
-	log.Println("[getCloudabilityData] Sending request for data")
-	response, err := client.Do(request)
-	if err != nil {
-		log.Fatalf("Error sending request to Cloudability:  %v", err)
+	return responseData
+}
+
+// defaultCloudabilityPageSize is the number of results requested from the
+// Cloudability API in each request, unless the "page_size" key is configured.
+const defaultCloudabilityPageSize = 10000
+
+// defaultCloudabilityMaxResponseMB is the largest Cloudability response body
+// (in megabytes) which is accepted, unless the "max_response_mb" key is
+// configured.
+const defaultCloudabilityMaxResponseMB = 64
+
+// defaultCloudabilityPollSeconds and defaultCloudabilityPollMinutes are the
+// interval between checks on the state of an asynchronous report and the time
+// allowed for it to finish, unless the "poll_interval_seconds" and
+// "poll_timeout_minutes" keys are configured.
+const defaultCloudabilityPollSeconds = 10
+const defaultCloudabilityPollMinutes = 30
+
+// CloudabilityReportState is the response to requests to enqueue an
+// asynchronous report and to check its state.
+type CloudabilityReportState struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// enqueueCloudabilityReport requests an asynchronous report with the given
+// query parameters, polls until it has finished, and returns the URL from
+// which its results can be fetched.
+func enqueueCloudabilityReport(
+	configMap Configuration,
+	client *http.Client,
+	baseUrl *url.URL,
+	qParams url.Values,
+	authorize func(request *http.Request),
+) *url.URL {
+	interval := getMapKeyInt(configMap, "poll_interval_seconds", "")
+	if interval <= 0 {
+		interval = defaultCloudabilityPollSeconds
 	}
-	if response.StatusCode != http.StatusOK {
-		log.Fatalf("Error getting data from Cloudability:  %d, %q", response.StatusCode, response.Status)
+	timeout := getMapKeyInt(configMap, "poll_timeout_minutes", "")
+	if timeout <= 0 {
+		timeout = defaultCloudabilityPollMinutes
 	}
-	defer func(Body io.ReadCloser) {
-		if err := Body.Close(); err != nil {
-			log.Fatalf("Ignoring error closing Cloudability body: %v", err)
+
+	enqueueUrl := baseUrl.JoinPath("enqueue")
+	enqueueUrl.RawQuery = qParams.Encode()
+	log.Println("[getCloudabilityData] Requesting report")
+	var state CloudabilityReportState
+	getCloudabilityResponse(client, enqueueUrl.String(), authorize, 1<<20, &state)
+	if state.ID == "" {
+		exitf(ExitProviderError, "Cloudability did not return a report ID")
+	}
+
+	reportUrl := baseUrl.JoinPath("reports", state.ID)
+	deadline := time.Now().Add(time.Duration(timeout) * time.Minute)
+	for state.Status != "finished" {
+		switch state.Status {
+		case "errored", "failed":
+			exitf(ExitProviderError, "Cloudability report %s failed", state.ID)
+		}
+		if time.Now().After(deadline) {
+			exitf(ExitProviderError, "Cloudability report %s did not finish within %d minutes (status %q)",
+				state.ID, timeout, state.Status)
 		}
-	}(response.Body)
-	responseBytes, err := io.ReadAll(response.Body)
+		log.Printf("[getCloudabilityData] Waiting for report %s (status %q)", state.ID, state.Status)
+		time.Sleep(time.Duration(interval) * time.Second)
+		getCloudabilityResponse(client, reportUrl.JoinPath("state").String(), authorize, 1<<20, &state)
+	}
+	return reportUrl.JoinPath("results")
+}
+
+// getCloudabilityResponse sends a single request to the Cloudability API and
+// decodes the response into responseData as it is read; if the response body
+// is larger than maxBytes, the program exits with an error.
+func getCloudabilityResponse(
+	client *http.Client,
+	requestUrl string,
+	authorize func(request *http.Request),
+	maxBytes int64,
+	responseData any,
+) {
+	request, err := http.NewRequest("GET", requestUrl, http.NoBody)
 	if err != nil {
-		log.Fatalf("Error reading Cloudability response body: %v", err)
+		fatalf("Error creating Cloudability request:  %v", err)
 	}
+	authorize(request)
+	request.Header.Add("Accept", "application/json")
 
-	log.Println("[getCloudabilityData] Processing results")
-	responseData := new(CloudabilityCostData)
-	err = json.Unmarshal(responseBytes, responseData)
+	response, err := client.Do(request)
 	if err != nil {
-		log.Fatalf("Error unmarshalling the Cloudability response body: %v\n", err)
+		exitf(ExitProviderError, "Error sending request to Cloudability:  %v", err)
 	}
+	if response.StatusCode != http.StatusOK {
+		exitf(getHttpExitCode(response.StatusCode), "Error getting data from Cloudability:  %d, %q",
+			response.StatusCode, response.Status)
+	}
+	defer closeBody(response)
 
-	if responseData.Pagination.Next != "" {
-		log.Fatal("Cloudability result is unexpectedly paginated")
+	err = json.NewDecoder(http.MaxBytesReader(nil, response.Body, maxBytes)).Decode(responseData)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		exitf(ExitProviderError, "Cloudability response exceeds %d MB; reduce the \"page_size\" or raise \"max_response_mb\"",
+			maxBytes>>20)
+	} else if err != nil {
+		exitf(ExitProviderError, "Error unmarshalling the Cloudability response body: %v\n", err)
 	}
+}
 
-	return responseData
+// getCloudabilityAuthorizer returns a function which adds the credentials to a
+// Cloudability request:  the "api_key" (as the basic authentication user) or,
+// if it is not configured, an Apptio opentoken obtained with the
+// "api_key_pair", along with the "environmentId".
+func getCloudabilityAuthorizer(configMap Configuration, client *http.Client) (func(request *http.Request), error) {
+	if _, ok := configMap["api_key"]; ok {
+		apiKey := getMapKeyString(configMap, "api_key", "cloudability")
+		return func(request *http.Request) { request.SetBasicAuth(apiKey, "") }, nil
+	}
+	opentoken, err := getApptioOpentoken(configMap, *client)
+	if err != nil {
+		return nil, err
+	}
+	environmentId := getMapKeyString(configMap, "environmentId", "cloudability")
+	return func(request *http.Request) {
+		request.Header.Add("apptio-opentoken", opentoken)
+		request.Header.Add("apptio-environmentid", environmentId)
+	}, nil
 }
 
-func getApptioOpentoken(configMap Configuration, client http.Client) string {
+func getApptioOpentoken(configMap Configuration, client http.Client) (string, error) {
 	apiKeyPairAny := getMapKeyValue(configMap, "api_key_pair", "cloudability")
 	apiKeyPair, ok := apiKeyPairAny.([]any)
 	if !ok {
-		log.Fatalf("Error reading Cloudability API keypair, expected an array, found %v",
+		fatalf("Error reading Cloudability API keypair, expected an array, found %v",
 			reflect.TypeOf(apiKeyPairAny).String())
 	}
 	if len(apiKeyPair) != 2 {
-		log.Fatalf("Error reading Cloudability API keypair, expected 2 items, found %d",
+		fatalf("Error reading Cloudability API keypair, expected 2 items, found %d",
 			len(apiKeyPair))
 	}
 	apiAccessKey, ok1 := apiKeyPair[0].(string)
 	apiSecret, ok2 := apiKeyPair[1].(string)
 	if !ok1 || !ok2 {
-		log.Fatalf(
+		fatalf(
 			"Error reading Cloudability API keypair, expected entries to be strings, found %v and %v",
 			reflect.TypeOf(apiKeyPair[0]).String(), reflect.TypeOf(apiKeyPair[1]).String())
 	}
 	body := bytes.NewBufferString(`{"keyAccess":"` + apiAccessKey + `","keySecret":"` + apiSecret + `"}`)
 	authRequest, err := http.NewRequest("POST", "https://frontdoor.apptio.com/service/apikeylogin", body)
 	if err != nil {
-		log.Fatalf("Error creating Cloudability authorization request:  %v", err)
+		fatalf("Error creating Cloudability authorization request:  %v", err)
 	}
 	authRequest.Header.Add("Accept", "application/json")
 	authRequest.Header.Add("content-type", "application/json")
@@ -236,18 +478,18 @@ func getApptioOpentoken(configMap Configuration, client http.Client) string {
 	log.Println("[getCloudabilityData] Sending request for authorization")
 	authResponse, err := client.Do(authRequest)
 	if err != nil {
-		log.Fatalf("Error sending authorization request to Cloudability:  %v", err)
+		return "", fmt.Errorf("error sending authorization request to Cloudability: %w", err)
 	}
 	if authResponse.StatusCode != http.StatusOK {
-		log.Fatalf("Error getting authorization data from Cloudability:  %d, %q",
+		return "", fmt.Errorf("error getting authorization data from Cloudability: %d, %q",
 			authResponse.StatusCode, authResponse.Status)
 	}
 	defer func(Body io.ReadCloser) {
 		if err := Body.Close(); err != nil {
-			log.Fatalf("Ignoring error closing Cloudability body: %v", err)
+			fatalf("Ignoring error closing Cloudability body: %v", err)
 		}
 	}(authResponse.Body)
-	return authResponse.Header.Get("apptio-opentoken")
+	return authResponse.Header.Get("apptio-opentoken"), nil
 }
 
 func getSheetDataFromCloudability(
@@ -258,33 +500,46 @@ func getSheetDataFromCloudability(
 	columnHeadsSet map[string]struct{},
 	metadata map[string]providerAccountMetadata,
 ) {
+	defer startPhase("cloudability.normalize")()
+	duplicates := getCloudabilityDuplicatePolicy(configMap)
 	// Build a two-dimensional map in which the first key is the account ID,
 	// the second key is the usage family, and the value is the corresponding
 	// cost -- this amounts to a sparse sheet grid.  While we're at it, collect
 	// the column headers for the grid (using a map "trick" where we only care
 	// about the keys), and collect some metadata for each account.
-	ignored := make(map[string]struct{}) // Suppress multiple warnings
+	seen := make(map[string]float64) // Cost by account, usage family, and date
+	ourCostCenters := getCostCenterMatcher(configMap)
 	for _, entry := range cldy.Results {
-		// Skip accounts that we're not looking for, but keep a list of them so
-		// that we don't issue multiple warnings for them; warn about accounts
-		// attributed to our cost center that we're not currently tracking.
+		entry.AccountID, _ = getCanonicalAccountId(getCanonicalProvider(entry.CloudProvider), entry.AccountID)
+		// Skip accounts that we're not looking for, accounting for their
+		// costs; warn about accounts attributed to our cost center that we're
+		// not currently tracking.  (An unparseable cost is an error only for
+		// the accounts which we are looking for.)
+		cost, costErr := strconv.ParseFloat(entry.Cost, 64)
 		if skipAccountEntry(
 			accountsMetadata[entry.AccountID],
 			entry.AccountID,
 			entry.CostCenter,
 			entry.CloudProvider,
 			entry.AccountName,
-			ignored,
-			configMap,
+			cost,
+			ourCostCenters,
 			"Cloudability",
 		) {
 			continue
 		}
+		if costErr != nil {
+			fatalf("Error parsing %s:%s Cost value (%v) as a float: %v",
+				entry.AccountID, entry.UsageFamily, entry.Cost, costErr)
+		}
 
 		// Note the current entry's usage family so that we can use it as a
 		// column header; and, if this is the first time we've seen this
 		// account, note its account-specific metadata.
-		columnHeadsSet[entry.UsageFamily] = struct{}{}
+		// (Azure reports meter categories, which are mapped to the usage
+		// families used for the other providers; see CategoryTaxonomy.)
+		family := getCloudabilityCategory(entry)
+		columnHeadsSet[family] = struct{}{}
 		if _, exists := metadata[entry.AccountID]; !exists {
 			metadata[entry.AccountID] = providerAccountMetadata{
 				AccountName:    entry.AccountName,
@@ -292,28 +547,178 @@ func getSheetDataFromCloudability(
 				CostCenter:     entry.CostCenter,
 				Date:           cldy.Meta.Dates.Start.Format("2006-01"),
 				PayerAccountId: entry.PayerAccountId,
+				Tags:           make(map[string][]string),
+				Currency:       cmp.Or(getMapKeyString(configMap, "currency", ""), reportingCurrency),
+			}
+		}
+		for tag, value := range entry.Tags {
+			if value != "" && value != "(not set)" && !slices.Contains(metadata[entry.AccountID].Tags[tag], value) {
+				metadata[entry.AccountID].Tags[tag] = append(metadata[entry.AccountID].Tags[tag], value)
 			}
 		}
 
 		// Capture the cost data.  If this is the first data for this account,
-		// create its "row".  If the cell has already been written, exit with
-		// an error.
-		cost, err := strconv.ParseFloat(entry.Cost, 64)
-		if err != nil {
-			log.Fatalf("Error parsing %s:%s Cost value (%v) as a float: %v",
-				entry.AccountID, entry.UsageFamily, entry.Cost, err)
-		}
+		// create its "row".  If the cell has already been written for the
+		// same date (e.g., when Cloudability splits a usage family across
+		// payers), the duplicate is handled according to the policy; when the
+		// data has the date dimension, the costs for each date are summed for
+		// the month.
 		if _, exists := costCells[entry.AccountID]; !exists {
 			costCells[entry.AccountID] = make(map[string]float64)
 		}
-		if _, exists := costCells[entry.AccountID][entry.UsageFamily]; exists {
-			log.Fatalf(
-				"Duplicate entry for %s:%s, values %f and %f",
-				entry.AccountID,
-				entry.UsageFamily,
-				costCells[entry.AccountID][entry.UsageFamily],
-				cost)
+		key := entry.AccountID + ":" + entry.UsageFamily + ":" + entry.Date + ":" + fmt.Sprint(entry.Tags)
+		if previous, exists := seen[key]; exists {
+			msg := fmt.Sprintf("duplicate Cloudability entry for %s:%s, values %f and %f", entry.AccountID,
+				entry.UsageFamily, previous, cost)
+			switch duplicates {
+			case "fail":
+				exitf(ExitProviderError, "[getSheetDataFromCloudability] %s", msg)
+			case "warn":
+				log.Printf("Warning:  %s; summing them", msg)
+				noteExitStatus(ExitWarnings, msg)
+			default:
+				log.Printf("[getSheetDataFromCloudability] %s; summing them", msg)
+			}
+		}
+		seen[key] += cost
+		costCells[entry.AccountID][family] += cost
+	}
+}
+
+// getCloudabilityFilters returns the values for the "filters" query parameters
+// from the "filters" mapping in the configuration.  Each key is a dimension or
+// metric, whose value is a list of conditions; each condition is a value,
+// optionally prefixed by one of the Cloudability comparators:  "==" (the
+// default), "!=", ">", ">=", "<", "<=", "=@" (contains), or "!=@" (does not
+// contain); or it is a list of values, "@(value1,value2,...)", which matches
+// any of them.  Cloudability combines separate filters with AND, except that
+// equality filters on the same dimension are combined with OR.  The special
+// "any_of" key lists conditions in the form "<dimension><comparator><value>",
+// which are combined with OR into a single filter.
+func getCloudabilityFilters(filtersAny any) (result []string) {
+	if filtersAny == nil {
+		return
+	}
+	filters, ok := filtersAny.(map[any]any)
+	if !ok {
+		fatalf("Error in Cloudability \"filters\" value (%q), type is %T, expected a mapping",
+			filtersAny, filtersAny)
+	}
+	for filterAny, expAny := range filters {
+		filter := getStringFromAny(filterAny, "Cloudability filter name")
+		if expAny == nil {
+			fatalf("Missing value(s) for Cloudability filter %q", filter)
+		}
+		exp, ok := expAny.([]any)
+		if !ok {
+			fatalf(
+				"Unexpected value (%v) for Cloudability filter values for filter %q, expected an array of strings",
+				expAny,
+				filter,
+			)
+		}
+		var group []string
+		for _, valAny := range exp {
+			val := getStringFromAny(valAny, "Cloudability filter value")
+			if filter != "any_of" {
+				result = append(result, getCloudabilityCondition(filter, val))
+				continue
+			}
+			idx := strings.IndexAny(val, "=!<>")
+			if idx <= 0 {
+				fatalf("Error in Cloudability \"any_of\" filter (%q), expected <dimension><comparator><value>", val)
+			}
+			group = append(group, getCloudabilityCondition(val[:idx], val[idx:]))
+		}
+		if group != nil {
+			result = append(result, strings.Join(group, ","))
+		}
+	}
+	return
+}
+
+// cloudabilityComparators lists the Cloudability filter comparators, with
+// each one preceding any which is a prefix of it.
+var cloudabilityComparators = []string{"!=@", "=@", "==", "!=", ">=", "<=", ">", "<"}
+
+// getCloudabilityCondition returns the Cloudability filter condition for the
+// dimension and the value, which may be prefixed by a comparator (see
+// getCloudabilityFilters()).
+func getCloudabilityCondition(dimension string, value string) string {
+	if list, found := strings.CutPrefix(value, "@("); found && strings.HasSuffix(list, ")") {
+		var conditions []string
+		for _, item := range strings.Split(strings.TrimSuffix(list, ")"), ",") {
+			conditions = append(conditions, dimension+"=="+strings.TrimSpace(item))
+		}
+		return strings.Join(conditions, ",")
+	}
+	for _, comparator := range cloudabilityComparators {
+		if operand, found := strings.CutPrefix(value, comparator); found {
+			return dimension + comparator + strings.TrimSpace(operand)
+		}
+	}
+	return dimension + "==" + strings.TrimSpace(value)
+}
+
+// getCloudabilityDuplicatePolicy returns the value of the "duplicates" key in
+// the Cloudability configuration, which determines the handling of duplicate
+// entries (for the same account, usage family, date, and tags):  "sum" (the
+// default) adds them, "warn" also adds them but notes a warning, and "fail"
+// stops the pull.
+func getCloudabilityDuplicatePolicy(configMap Configuration) string {
+	policy := cmp.Or(getMapKeyString(configMap, "duplicates", ""), "sum")
+	if !slices.Contains([]string{"sum", "warn", "fail"}, policy) {
+		fatalf("Error in Cloudability \"duplicates\" value (%q), expected \"sum\", \"warn\", or \"fail\"", policy)
+	}
+	return policy
+}
+
+// getCloudabilityGranularity returns the -granularity option or, if it is not
+// set, the value of the "granularity" key in the Cloudability configuration:
+// "daily" or "weekly" requests the date dimension, so that day- or week-level
+// rows can be produced in addition to the monthly sheet; the default is
+// "monthly".
+func getCloudabilityGranularity(configMap Configuration, options CommandLineOptions) string {
+	return getGranularity(options, getMapKeyString(configMap, "granularity", ""))
+}
+
+// getCloudabilityCategory returns the output category of the entry's usage
+// family (for Azure, its meter category).
+func getCloudabilityCategory(entry ResultsEntry) string {
+	if getCanonicalProvider(entry.CloudProvider) == "Azure" {
+		return categoryTaxonomy.getCategory("azure", entry.UsageFamily)
+	}
+	return categoryTaxonomy.getCategory("cloudability", entry.UsageFamily)
+}
+
+// getPeriodSheetFromCloudability builds a sheet with a row for the cost of
+// each usage family for each account in each day or (ISO) week of the month,
+// from Cloudability data requested with the date dimension.  Entries for
+// accounts which are not in the accounts file, or which are excluded, are
+// skipped (they are reported when the main sheet is built).
+func getPeriodSheetFromCloudability(
+	cldy *CloudabilityCostData,
+	accountsMetadata map[string]*AccountMetadata,
+	granularity string,
+) (output []*sheets.RowData) {
+	costs := make(map[PeriodCostKey]float64)
+	names := make(map[string]string)
+	for _, entry := range cldy.Results {
+		entry.AccountID, _ = getCanonicalAccountId(getCanonicalProvider(entry.CloudProvider), entry.AccountID)
+		if md := accountsMetadata[entry.AccountID]; md == nil || md.Excluded {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", entry.Date[:min(len(entry.Date), 10)])
+		if err != nil {
+			fatalf("Error parsing %s:%s date value (%q): %v", entry.AccountID, entry.UsageFamily, entry.Date, err)
+		}
+		cost, err := strconv.ParseFloat(entry.Cost, 64)
+		if err != nil {
+			fatalf("Error parsing %s:%s Cost value (%v) as a float: %v",
+				entry.AccountID, entry.UsageFamily, entry.Cost, err)
 		}
-		costCells[entry.AccountID][entry.UsageFamily] = cost
+		costs[PeriodCostKey{getPeriod(date, granularity), entry.AccountID, getCloudabilityCategory(entry)}] += cost
+		names[entry.AccountID] = entry.AccountName
 	}
+	return getPeriodSheet(costs, names)
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// CloudabilitySchema describes the shape of a Cloudability response:  the
+// dimensions and metrics which its "meta" section lists, and the fields found
+// in its results, with the number of results which have each one.
+type CloudabilitySchema struct {
+	Dimensions []string       `json:"dimensions"`
+	Metrics    []string       `json:"metrics"`
+	Results    int            `json:"results"`
+	Fields     map[string]int `json:"fields"`
+}
+
+// getCloudabilitySchema returns the schema of the response.
+func getCloudabilitySchema(cldy *CloudabilityCostData) CloudabilitySchema {
+	schema := CloudabilitySchema{Results: len(cldy.Results), Fields: make(map[string]int)}
+	for _, dimension := range cldy.Meta.Dimensions {
+		schema.Dimensions = append(schema.Dimensions, dimension.Name)
+	}
+	for _, metric := range cldy.Meta.Metrics {
+		schema.Metrics = append(schema.Metrics, metric.Name)
+	}
+	for _, entry := range cldy.Results {
+		for _, field := range entry.fields {
+			schema.Fields[field]++
+		}
+	}
+	return schema
+}
+
+// checkCloudabilitySchema compares the schema of the response with the
+// request, so that changes in the Cloudability API are noticed rather than
+// silently producing empty columns:  it warns of requested dimensions and
+// metrics which the response lacks (in the "meta" section or in any of the
+// results, e.g., "category4", which supplies the cost center), and of
+// dimensions, metrics, and result fields which were not requested (and so
+// are not mapped to the output).  With -debug, the schema is logged.
+func checkCloudabilitySchema(cldy *CloudabilityCostData, dimensions []string, metric string, debug bool) {
+	schema := getCloudabilitySchema(cldy)
+	if debug {
+		data, _ := json.MarshalIndent(schema, "", "  ")
+		log.Printf("[checkCloudabilitySchema] response schema:\n%s", data)
+	}
+	requested := append(slices.Clone(dimensions), metric)
+
+	var problems []string
+	if len(schema.Dimensions) == 0 && len(schema.Metrics) == 0 {
+		problems = append(problems, "the response has no \"meta\" dimensions or metrics")
+	} else {
+		if missing := getMissingNames(dimensions, schema.Dimensions); len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("the response lists no %s dimension(s)",
+				strings.Join(missing, ", ")))
+		}
+		if !slices.Contains(schema.Metrics, metric) {
+			problems = append(problems, fmt.Sprintf("the response lists no %s metric", metric))
+		}
+		if extra := getMissingNames(slices.Concat(schema.Dimensions, schema.Metrics), requested); len(extra) > 0 {
+			problems = append(problems, fmt.Sprintf("the response lists unexpected dimension(s) or metric(s) %s",
+				strings.Join(extra, ", ")))
+		}
+	}
+	for _, name := range requested {
+		if count := schema.Fields[name]; count < schema.Results {
+			problems = append(problems, fmt.Sprintf("%d of %d results lack the %s field",
+				schema.Results-count, schema.Results, name))
+		}
+	}
+	if extra := getMissingNames(sortedKeys(schema.Fields), requested); len(extra) > 0 {
+		problems = append(problems, fmt.Sprintf("the results have unexpected field(s) %s, which are not mapped",
+			strings.Join(extra, ", ")))
+	}
+
+	for _, problem := range problems {
+		msg := "Cloudability schema change:  " + problem
+		log.Printf("Warning:  %s", msg)
+		noteExitStatus(ExitWarnings, msg)
+	}
+}
+
+// getMissingNames returns the names in the first list which are not in the
+// second, in order.
+func getMissingNames(names []string, present []string) (missing []string) {
+	set := make(map[string]struct{}, len(present))
+	for _, name := range present {
+		set[name] = struct{}{}
+	}
+	for _, name := range names {
+		if _, exists := set[name]; !exists && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+	}
+	return
+}
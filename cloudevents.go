@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// The types of the CloudEvents emitted over the course of a run.
+const (
+	EventRunStarted        = "costpuller.run.started"
+	EventProviderCompleted = "costpuller.provider.completed"
+	EventRunFinished       = "costpuller.run.finished"
+	EventChecksFailed      = "costpuller.checks.failed"
+)
+
+// CloudEvent is an event in the CloudEvents (v1.0) JSON format.
+type CloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	Id              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            any       `json:"data"`
+}
+
+// CloudEventEmitter sends the run's lifecycle events to the HTTP endpoint
+// and/or the Kafka topic configured in the "cloudevents" configuration
+// section.
+type CloudEventEmitter struct {
+	configMap   Configuration
+	kafkaConfig Configuration
+	client      *http.Client
+	runId       string
+	sequence    int
+}
+
+// runEvents emits the run's lifecycle events; it is nil if they are not
+// configured.
+var runEvents *CloudEventEmitter
+
+// initCloudEvents sets up the emission of CloudEvents, if the "cloudevents"
+// configuration section is present, and emits the "run started" event.  The
+// events are POSTed (in structured mode) to the "url", with an optional bearer
+// "token", and/or produced to the "kafka_topic" through the REST proxy
+// configured in the "kafka" section; the event "source" defaults to
+// "costpuller".  Events are not emitted for runs restricted to selected
+// accounts.
+func initCloudEvents(configMap Configuration, kafkaConfig Configuration, options CommandLineOptions, started time.Time) {
+	if configMap == nil {
+		return
+	}
+	if hasAccountFilter(options) {
+		log.Printf("[initCloudEvents] not emitting events for a partial run")
+		return
+	}
+	url := getMapKeyString(configMap, "url", "")
+	topic := getMapKeyString(configMap, "kafka_topic", "")
+	if url == "" && topic == "" {
+		fatalf("[initCloudEvents] the \"cloudevents\" section must have a \"url\" or a \"kafka_topic\"")
+	}
+	if topic != "" && kafkaConfig == nil {
+		fatalf("[initCloudEvents] a \"kafka_topic\" requires the \"kafka\" section")
+	}
+	runEvents = &CloudEventEmitter{
+		configMap:   configMap,
+		kafkaConfig: kafkaConfig,
+		client:      newAuditedHttpClient("cloudevents", time.Second*30),
+		runId:       started.UTC().Format("20060102T150405.000000000Z"),
+	}
+	emitCloudEvent(EventRunStarted, *options.monthPtr, map[string]any{
+		"month":     *options.monthPtr,
+		"cost_type": *options.costTypePtr,
+		"started":   started,
+	})
+}
+
+// emitCloudEvent emits an event of the given type, if events are configured.
+// Failures are reported, and reflected in the exit code, but do not stop the
+// run.
+func emitCloudEvent(eventType string, subject string, data any) {
+	if runEvents == nil {
+		return
+	}
+	runEvents.sequence++
+	event := CloudEvent{
+		SpecVersion:     "1.0",
+		Id:              fmt.Sprintf("%s-%d", runEvents.runId, runEvents.sequence),
+		Source:          cmp.Or(getMapKeyString(runEvents.configMap, "source", ""), "costpuller"),
+		Type:            eventType,
+		Subject:         subject,
+		Time:            time.Now(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+	if err := runEvents.send(event); err != nil {
+		msg := fmt.Sprintf("error emitting the %s event: %v", eventType, err)
+		log.Printf("[emitCloudEvent] %s", msg)
+		noteExitStatus(ExitOutputFailure, msg)
+	}
+}
+
+// send delivers the event to each configured destination.
+func (e *CloudEventEmitter) send(event CloudEvent) error {
+	if url := getMapKeyString(e.configMap, "url", ""); url != "" {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/cloudevents+json")
+		if token := getMapKeyString(e.configMap, "token", ""); token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := e.client.Do(request)
+		if err != nil {
+			return err
+		}
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		closeBody(response)
+		if response.StatusCode/100 != 2 {
+			return fmt.Errorf("%d, %q: %s", response.StatusCode, response.Status, message)
+		}
+	}
+	if topic := getMapKeyString(e.configMap, "kafka_topic", ""); topic != "" {
+		return produceKafkaRecords(e.kafkaConfig, topic, []KafkaRecord{{Key: event.Subject, Value: event}})
+	}
+	return nil
+}
+
+// emitProviderCompleted emits the "provider completed" event, once the data
+// from the provider has been pulled.
+func emitProviderCompleted(options CommandLineOptions, provider string) {
+	emitCloudEvent(EventProviderCompleted, provider, map[string]any{"month": *options.monthPtr, "provider": provider})
+}
+
+// emitRunFinishedEvents emits the "checks failed" event, if any consistency
+// checks failed, and the "run finished" event, whose data is the run summary.
+func emitRunFinishedEvents(
+	options CommandLineOptions,
+	started time.Time,
+	providers []string,
+	records []HistoryRecord,
+	missing []string,
+) {
+	if runEvents == nil {
+		return
+	}
+	if len(checkFailures) > 0 {
+		emitCloudEvent(EventChecksFailed, *options.monthPtr, map[string]any{
+			"month":    *options.monthPtr,
+			"failures": checkFailures,
+		})
+	}
+	emitCloudEvent(EventRunFinished, *options.monthPtr, getRunSummary(options, started, providers, records, missing))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Option value sources, in order of decreasing precedence.
+const (
+	OptionSourceFlag    = "flag"
+	OptionSourceEnv     = "env"
+	OptionSourceConfig  = "config"
+	OptionSourceDefault = "default"
+)
+
+// getOptions defines the command line options on the given flag set, parses
+// the arguments, and loads the accounts file.  Each option which was not set
+// on the command line is taken from the environment variable named for it
+// (e.g., COSTPULLER_MONTH for -month, COSTPULLER_REFRESH_ACCOUNTS for
+// -refresh-accounts) or, failing that, from the "defaults" subsection of the
+// configuration section of the accounts file (except, of course, -accounts
+// itself).  The returned map gives the source of each option's value.
+func getOptions(flags *flag.FlagSet, args []string) (
+	options CommandLineOptions,
+	accountsFile AccountsFile,
+	sources map[string]string,
+) {
+	nowTime := time.Now()
+	lastMonth := time.Date(nowTime.Year(), nowTime.Month()-1, 1, 0, 0, 0, 0, nowTime.Location())
+	nowStr := nowTime.Format("20060102150405")
+	defaultMonth := lastMonth.Format("2006-01")
+	defaultCsvFile := fmt.Sprintf("output-%s.csv", defaultMonth)
+	defaultReportFile := fmt.Sprintf("report-%s.txt", nowStr)
+	options = CommandLineOptions{
+		accountPtr:         flags.String("account", "", "pull only the account with this ID"),
+		accountsFilePtr:    flags.String("accounts", "accounts.yaml", `file to read accounts list from (or an HTTPS URL, or "git+<repository>#[<ref>:]<path>")`),
+		applyPtr:           flags.Bool("apply", false, "with -awswritetags, write the planned tag changes (otherwise, they are only listed)"),
+		archiveDirPtr:      flags.String("archive-dir", "", "directory in which to save the raw provider responses (gzipped JSON)"),
+		auditLogPtr:        flags.String("audit-log", "", "file to which a JSON line is appended for each external API call"),
+		awsWriteTagsPtr:    flags.Bool("awswritetags", false, "write tags to AWS accounts (USE WITH CARE!)"),
+		costTypePtr:        flags.String("costtype", "UnblendedCost", `cost type to pull, one of "AmortizedCost", "BlendedCost", "NetAmortizedCost", "NetUnblendedCost", "NormalizedUsageAmount", "UnblendedCost", or "UsageQuantity"`),
+		csvfilePtr:         flags.String("csv", defaultCsvFile, "output file for csv data"),
+		debugPtr:           flags.Bool("debug", false, "outputs debug info"),
+		drilldownFilePtr:   flags.String("drilldown", "", "output file for resource costs of accounts failing the consistency check (AWS only)"),
+		forcePtr:           flags.Bool("force", false, "overwrite the month's raw data sheet even if it holds different data"),
+		granularityPtr:     flags.String("granularity", "", `also write a sheet of the costs by "daily" or "weekly" (ISO week) period (default "monthly", i.e., none, or the Cloudability "granularity")`),
+		groupPtr:           flags.String("group", "", "pull only the accounts in this group (team)"),
+		incrementalPtr:     flags.Bool("incremental", false, "reuse cached results from the previous run for accounts whose month is closed (AWS only)"),
+		legacyLayoutPtr:    flags.Bool("legacy-layout", false, "write the direct AWS data in the legacy fixed-column layout"),
+		monthPtr:           flags.String("month", defaultMonth, `context month in format yyyy-mm`),
+		outputTypePtr:      flags.String("output", "gsheet", `output destination, needs to be one of "csv", "gsheet", or "smartsheet"`),
+		providersPtr:       flags.String("providers", "", `comma-separated list of the configured providers to pull from ("aws", "cloudability", "ibmcloud"; default all)`),
+		quarterPtr:         flags.String("quarter", "", `pull each month of the fiscal quarter (e.g., "2024-Q3") and write a quarterly sheet`),
+		quarterlyOnlyPtr:   flags.Bool("quarterly-only", false, "with -quarter, write only the quarterly sheet (not the monthly sheets)"),
+		recordHttpDirPtr:   flags.String("record-http", "", "directory in which to record the Cloudability and IBM Cloud HTTP interactions (sanitized), for -replay-http"),
+		refreshAccountsPtr: flags.Bool("refresh-accounts", false, "refetch the cached AWS account inventory"),
+		replayDirPtr:       flags.String("replay", "", "archive directory from which to replay the provider responses, instead of calling the providers"),
+		replayHttpDirPtr:   flags.String("replay-http", "", "directory from which to replay the recorded Cloudability and IBM Cloud HTTP interactions, instead of calling their APIs"),
+		reportFilePtr:      flags.String("report", defaultReportFile, "output file for data consistency report"),
+		rollForwardPtr:     flags.Bool("roll-forward", false, "create the month's reference block on the main sheet, if it is missing, by copying the previous month's"),
+		skipEmptyPtr:       flags.Bool("skip-empty-accounts", false, "omit accounts whose total for the month is zero from the output (they are listed in the report)"),
+		staticTotalsPtr:    flags.Bool("static-totals", false, `write the "TOTAL" column as values instead of =SUM() formulas`),
+		summaryFilePtr:     flags.String("summary-file", "", "output file for a JSON summary of the run"),
+		taggedAccountsPtr:  flags.Bool("taggedaccounts", false, "use the AWS tags as account list source"),
+		untagStalePtr:      flags.Bool("untag-stale", false, "with -awswritetags, remove category tags from accounts not in the accounts file"),
+		untrackedFilePtr:   flags.String("untracked-file", "", "output file for a YAML snippet listing the accounts in our cost centers which are not in the accounts file"),
+	}
+	_ = flags.Parse(args)
+
+	sources = make(map[string]string)
+	flags.VisitAll(func(f *flag.Flag) { sources[f.Name] = OptionSourceDefault })
+	flags.Visit(func(f *flag.Flag) { sources[f.Name] = OptionSourceFlag })
+	flags.VisitAll(func(f *flag.Flag) {
+		if sources[f.Name] != OptionSourceDefault || slices.Contains(commandLineOnlyOptions, f.Name) {
+			return
+		}
+		if value, exists := os.LookupEnv(getOptionEnvName(f.Name)); exists {
+			if err := f.Value.Set(value); err != nil {
+				fatalf("[getOptions] invalid value %q for %s: %v", value, getOptionEnvName(f.Name), err)
+			}
+			sources[f.Name] = OptionSourceEnv
+		}
+	})
+
+	accountsFile, err := loadAccountsFile(*options.accountsFilePtr)
+	if err != nil {
+		fatalf("[getOptions] error loading accounts file: %v", err)
+	}
+
+	defaults := accountsFile.Configuration["defaults"]
+	for _, name := range sortedKeys(defaults) {
+		f := flags.Lookup(name)
+		if f == nil || name == "accounts" {
+			fatalf("[getOptions] unrecognized option %q in the \"defaults\" configuration section", name)
+		}
+		if slices.Contains(commandLineOnlyOptions, name) {
+			fatalf("[getOptions] option %q cannot be set in the \"defaults\" configuration section; "+
+				"it must be given on the command line", name)
+		}
+		if sources[name] != OptionSourceDefault {
+			continue
+		}
+		value := fmt.Sprint(defaults[name])
+		if err := f.Value.Set(value); err != nil {
+			fatalf("[getOptions] invalid value %q for option %q in the \"defaults\" configuration section: %v",
+				value, name, err)
+		}
+		sources[name] = OptionSourceConfig
+	}
+
+	if sources["csv"] == OptionSourceDefault && *options.monthPtr != defaultMonth {
+		*options.csvfilePtr = fmt.Sprintf("output-%s.csv", *options.monthPtr)
+	}
+	return
+}
+
+// commandLineOnlyOptions are the options which write to AWS or overwrite data
+// (rather than only selecting what is pulled and where it goes), so they must
+// be given on the command line:  they are not taken from the environment, and
+// they are rejected in the "defaults" configuration section.
+var commandLineOnlyOptions = []string{"apply", "awswritetags", "force", "untag-stale"}
+
+// getOptionEnvName returns the name of the environment variable which
+// supplies the value for the given command line option.
+func getOptionEnvName(option string) string {
+	return "COSTPULLER_" + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// configCommand implements the "config" subcommand:
+//
+//	costpuller config show [options]
+//
+// It prints the effective value of each option (resolved from the given
+// options, the environment, and the accounts file) and where it came from,
+// followed by the configuration section of the accounts file, after include
+// files and environment references have been processed.  Values whose keys
+// look like they hold secrets are masked.
+func configCommand(args []string) {
+	if len(args) == 0 || args[0] != "show" {
+		_, _ = fmt.Fprintln(os.Stderr, "Usage: costpuller config show [options]")
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("config show", flag.ExitOnError)
+	_, accountsFile, sources := getOptions(flags, args[1:])
+
+	fmt.Println("Options:")
+	flags.VisitAll(func(f *flag.Flag) {
+		source := sources[f.Name]
+		if source == OptionSourceEnv {
+			source += " " + getOptionEnvName(f.Name)
+		}
+		fmt.Printf("  %-18s %-30q (%s)\n", f.Name, f.Value.String(), source)
+	})
+
+	configuration := make(map[string]any, len(accountsFile.Configuration))
+	for section, config := range accountsFile.Configuration {
+		configuration[section] = maskSecrets(config)
+	}
+	out, err := yaml.Marshal(map[string]any{"configuration": configuration})
+	if err != nil {
+		fatalf("[config] error encoding configuration: %v", err)
+	}
+	fmt.Printf("\n%s", out)
+}
+
+// secretKeyPattern matches configuration keys whose values should not be
+// displayed.
+var secretKeyPattern = regexp.MustCompile(`(?i)key|secret|token|password`)
+
+// maskSecrets returns a copy of a configuration value in which the values of
+// keys which look like they hold secrets are replaced with asterisks.
+func maskSecrets(value any) any {
+	switch v := value.(type) {
+	case Configuration:
+		result := make(map[any]any, len(v))
+		for key, item := range v {
+			result[key] = maskSecretValue(key, item)
+		}
+		return result
+	case map[any]any:
+		result := make(map[any]any, len(v))
+		for key, item := range v {
+			result[key] = maskSecretValue(fmt.Sprint(key), item)
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for idx, item := range v {
+			result[idx] = maskSecrets(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// maskSecretValue is a helper function for maskSecrets() which masks a single
+// mapping entry.  The whole value of a secret key is masked, whatever its
+// type (e.g., the Cloudability "api_key_pair" is a list).
+func maskSecretValue(key string, value any) any {
+	if value != nil && secretKeyPattern.MatchString(key) {
+		return "********"
+	}
+	return maskSecrets(value)
+}
+
+// CostProviders lists the names of the configuration sections of the
+// providers which can be selected with the -providers option.
+var CostProviders = []string{"aws", "cloudability", "ibmcloud"}
+
+// getEnabledProviders returns the set of providers which are configured in
+// the accounts file, which are not disabled by an "enabled: false" key in
+// their configuration section, and which are selected by the -providers
+// option (if it is specified).
+func getEnabledProviders(accountsFile AccountsFile, options CommandLineOptions) map[string]bool {
+	var selected []string
+	if *options.providersPtr != "" {
+		selected = strings.Split(*options.providersPtr, ",")
+		for _, name := range selected {
+			if !slices.Contains(CostProviders, name) {
+				fatalf("[getEnabledProviders] unrecognized provider %q, expected one of %q", name, CostProviders)
+			}
+		}
+	}
+	enabled := make(map[string]bool)
+	for _, name := range CostProviders {
+		config, exists := accountsFile.Configuration[name]
+		if !exists || (selected != nil && !slices.Contains(selected, name)) {
+			continue
+		}
+		if value, exists := config["enabled"]; exists {
+			if flag, ok := value.(bool); !ok {
+				fatalf("[getEnabledProviders] unexpected value (%v) for \"enabled\" in the %q section, expected a boolean",
+					value, name)
+			} else if !flag {
+				log.Printf("[getEnabledProviders] provider %q is disabled in the configuration", name)
+				continue
+			}
+		}
+		enabled[name] = true
+	}
+	return enabled
+}
+
+// isAccountSelected reports whether the account passes the -account and
+// -group filters (account IDs are compared ignoring hyphens).
+func isAccountSelected(options CommandLineOptions, accountID string, group string) bool {
+	if *options.accountPtr != "" &&
+		strings.ReplaceAll(*options.accountPtr, "-", "") != strings.ReplaceAll(accountID, "-", "") {
+		return false
+	}
+	return *options.groupPtr == "" || *options.groupPtr == group
+}
+
+// hasAccountFilter reports whether either of the -account and -group filters
+// was specified.
+func hasAccountFilter(options CommandLineOptions) bool {
+	return *options.accountPtr != "" || *options.groupPtr != ""
+}
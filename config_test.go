@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMaskSecrets(t *testing.T) {
+	config := Configuration{
+		"api":          "api.cloudability.com",
+		"api_key":      "abc123",
+		"api_key_pair": []any{"access-id", "access-secret"},
+		"oauth":        map[any]any{"client_secret": map[any]any{"value": "s3cr3t"}, "scope": "read"},
+		"accounts":     []any{map[any]any{"token": "t0k3n", "id": "1234"}},
+		"secret_file":  nil,
+	}
+	expected := map[any]any{
+		"api":          "api.cloudability.com",
+		"api_key":      "********",
+		"api_key_pair": "********",
+		"oauth":        map[any]any{"client_secret": "********", "scope": "read"},
+		"accounts":     []any{map[any]any{"token": "********", "id": "1234"}},
+		"secret_file":  nil,
+	}
+	if masked := maskSecrets(config); !reflect.DeepEqual(masked, expected) {
+		t.Errorf("maskSecrets returned %v, expected %v", masked, expected)
+	}
+}
+
+func TestGetOptionsIgnoresEnvForCommandLineOnlyOptions(t *testing.T) {
+	accountsFile := filepath.Join(t.TempDir(), "accounts.yaml")
+	if err := os.WriteFile(accountsFile, []byte("configuration: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("COSTPULLER_MONTH", "2024-03")
+	for _, name := range commandLineOnlyOptions {
+		t.Setenv(getOptionEnvName(name), "true")
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	options, _, sources := getOptions(flags, []string{"-accounts", accountsFile})
+	if *options.monthPtr != "2024-03" || sources["month"] != OptionSourceEnv {
+		t.Errorf("-month is %q (from %s), expected \"2024-03\" from the environment", *options.monthPtr, sources["month"])
+	}
+	for _, name := range commandLineOnlyOptions {
+		if value := flags.Lookup(name).Value.String(); value != "false" || sources[name] != OptionSourceDefault {
+			t.Errorf("-%s is %s (from %s), expected the default", name, value, sources[name])
+		}
+	}
+}
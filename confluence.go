@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultConfluenceTitleTemplate is the template (see
+// https://pkg.go.dev/time#Layout) for the title of the Confluence page, unless
+// the "titleTemplate" key of the "confluence" configuration section is set.
+const defaultConfluenceTitleTemplate = "Cloud Costs 01/2006"
+
+// ConfluencePage is a Confluence page, as sent to and received from the
+// content API.
+type ConfluencePage struct {
+	Id        string               `json:"id,omitempty"`
+	Type      string               `json:"type"`
+	Title     string               `json:"title"`
+	Space     *ConfluenceSpace     `json:"space,omitempty"`
+	Ancestors []ConfluenceAncestor `json:"ancestors,omitempty"`
+	Version   *ConfluenceVersion   `json:"version,omitempty"`
+	Body      *ConfluenceBody      `json:"body,omitempty"`
+}
+
+type ConfluenceSpace struct {
+	Key string `json:"key"`
+}
+
+type ConfluenceAncestor struct {
+	Id string `json:"id"`
+}
+
+type ConfluenceVersion struct {
+	Number int `json:"number"`
+}
+
+type ConfluenceBody struct {
+	Storage ConfluenceStorage `json:"storage"`
+}
+
+type ConfluenceStorage struct {
+	Value          string `json:"value"`
+	Representation string `json:"representation"`
+}
+
+// publishConfluenceSummary creates or updates a Confluence page summarizing
+// the run, for the monthly review:  a table of the teams' totals, followed by
+// the accounts for which no data was found and the run's warnings.  The page
+// is configured by the "confluence" configuration section:  the wiki's "url"
+// (e.g., "https://example.atlassian.net/wiki"), the "space" key, the
+// "parentPageId" (optional), and the page "titleTemplate"; the credentials are
+// either a "username" and "api_token" (for Confluence Cloud) or a personal
+// access "token".  Nothing is published if the section is absent or if the
+// run was restricted to selected accounts.  Failures are reported but do not
+// stop the run.
+func publishConfluenceSummary(
+	configMap Configuration,
+	options CommandLineOptions,
+	records []HistoryRecord,
+	missing []string,
+) {
+	if configMap == nil {
+		return
+	}
+	if hasAccountFilter(options) {
+		log.Printf("[publishConfluenceSummary] not publishing the summary of a partial run")
+		return
+	}
+	ref, _ := time.Parse("2006-01", *options.monthPtr)
+	title := ref.Format(getMapKeyString(configMap, "titleTemplate", ""))
+	if title == "" {
+		title = ref.Format(defaultConfluenceTitleTemplate)
+	}
+	if err := publishConfluencePage(configMap, title, getConfluenceSummary(records, missing, runWarnings)); err != nil {
+		msg := fmt.Sprintf("error publishing the summary to Confluence: %v", err)
+		log.Printf("[publishConfluenceSummary] %s", msg)
+		noteExitStatus(ExitOutputFailure, msg)
+		return
+	}
+	log.Printf("[publishConfluenceSummary] published %q", title)
+}
+
+// getConfluenceSummary returns the body of the summary page, in Confluence
+// storage format.
+func getConfluenceSummary(records []HistoryRecord, missing []string, warnings []string) string {
+	teamTotals := make(map[string]float64)
+	teamAccounts := make(map[string]int)
+	var total float64
+	for _, record := range records {
+		teamTotals[record.Group] += record.Total
+		teamAccounts[record.Group]++
+		total += record.Total
+	}
+	var body strings.Builder
+	body.WriteString("<h2>Team Totals</h2><table><tbody>")
+	body.WriteString("<tr><th>Team</th><th>Accounts</th><th>Total</th></tr>")
+	for _, team := range sortedKeys(teamTotals) {
+		_, _ = fmt.Fprintf(&body, "<tr><td>%s</td><td>%d</td><td>%.2f</td></tr>", html.EscapeString(team),
+			teamAccounts[team], reconciliation.round(teamTotals[team]))
+	}
+	_, _ = fmt.Fprintf(&body, "<tr><td><strong>TOTAL</strong></td><td>%d</td><td><strong>%.2f</strong></td></tr>",
+		len(records), reconciliation.round(total))
+	body.WriteString("</tbody></table>")
+	for _, section := range []struct {
+		heading string
+		items   []string
+	}{
+		{"Accounts Without Data", missing},
+		{"Warnings", warnings},
+	} {
+		if len(section.items) == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(&body, "<h2>%s</h2><ul>", section.heading)
+		for _, item := range section.items {
+			_, _ = fmt.Fprintf(&body, "<li>%s</li>", html.EscapeString(item))
+		}
+		body.WriteString("</ul>")
+	}
+	_, _ = fmt.Fprintf(&body, "<p><em>Generated by costpuller at %s.</em></p>",
+		html.EscapeString(time.Now().Format(time.RFC1123)))
+	return body.String()
+}
+
+// publishConfluencePage creates the page with the given title in the
+// configured space or, if it already exists, replaces its content.
+func publishConfluencePage(configMap Configuration, title string, content string) error {
+	baseUrl, err := url.Parse(getMapKeyString(configMap, "url", "confluence"))
+	if err != nil {
+		return fmt.Errorf("error parsing the Confluence URL: %w", err)
+	}
+	space := getMapKeyString(configMap, "space", "confluence")
+	client := newAuditedHttpClient("confluence", time.Second*60)
+	authorize := func(request *http.Request) {
+		if token := getMapKeyString(configMap, "token", ""); token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			request.SetBasicAuth(getMapKeyString(configMap, "username", "confluence"),
+				getMapKeyString(configMap, "api_token", "confluence"))
+		}
+	}
+	do := func(method string, pageId string, query url.Values, body any, result any) error {
+		requestUrl := baseUrl.JoinPath("rest", "api", "content", pageId)
+		requestUrl.RawQuery = query.Encode()
+		var reader io.Reader
+		if body != nil {
+			encoded, err := json.Marshal(body)
+			if err != nil {
+				return err
+			}
+			reader = bytes.NewReader(encoded)
+		}
+		request, err := http.NewRequest(method, requestUrl.String(), reader)
+		if err != nil {
+			return err
+		}
+		authorize(request)
+		request.Header.Set("Accept", "application/json")
+		if body != nil {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer closeBody(response)
+		if response.StatusCode/100 != 2 {
+			message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+			return fmt.Errorf("%s %s: %d, %q: %s", method, requestUrl.Path, response.StatusCode, response.Status,
+				message)
+		}
+		if result != nil {
+			return json.NewDecoder(response.Body).Decode(result)
+		}
+		return nil
+	}
+
+	var existing struct {
+		Results []ConfluencePage `json:"results"`
+	}
+	query := url.Values{"spaceKey": {space}, "title": {title}, "expand": {"version"}}
+	if err := do(http.MethodGet, "", query, nil, &existing); err != nil {
+		return err
+	}
+	page := &ConfluencePage{
+		Type:  "page",
+		Title: title,
+		Space: &ConfluenceSpace{Key: space},
+		Body:  &ConfluenceBody{Storage: ConfluenceStorage{Value: content, Representation: "storage"}},
+	}
+	if len(existing.Results) == 0 {
+		if parent := getMapKeyId(configMap, "parentPageId"); parent != "" {
+			page.Ancestors = []ConfluenceAncestor{{Id: parent}}
+		}
+		return do(http.MethodPost, "", nil, page, nil)
+	}
+	page.Id = existing.Results[0].Id
+	page.Version = &ConfluenceVersion{Number: 1}
+	if existing.Results[0].Version != nil {
+		page.Version.Number = existing.Results[0].Version.Number + 1
+	}
+	return do(http.MethodPut, page.Id, nil, page, nil)
+}
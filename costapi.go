@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// defaultCostApiBatchSize is the number of records sent in each request to
+// the cost API, unless the "batch_size" key is configured.
+const defaultCostApiBatchSize = 500
+
+// defaultCostApiRetries and defaultCostApiRetrySeconds are the number of
+// times that a failed request to the cost API (with a 429 or 5xx status, or a
+// connection error) is retried and the longest wait between attempts, unless
+// the "retries" and "max_retry_interval_seconds" keys are configured.
+const defaultCostApiRetries = 3
+const defaultCostApiRetrySeconds = 30
+
+// CostApiBatch is the body of each request to the cost API:  one page of the
+// run's records.  The run ID and the page numbers allow the receiver to tell
+// when it has all of a run's records, and to ignore repeated pages.
+type CostApiBatch struct {
+	RunId   string          `json:"run_id"`
+	Month   string          `json:"month"`
+	Page    int             `json:"page"`
+	Pages   int             `json:"pages"`
+	Records []HistoryRecord `json:"records"`
+}
+
+// postToCostApi sends the run's normalized records to the HTTP endpoint
+// configured in the "cost_api" configuration section (e.g., an internal
+// FinOps service), as a series of JSON POST requests (see CostApiBatch).
+// The requests are authorized either with a static bearer "token" or with a
+// token obtained from an OIDC provider with the client credentials flow (the
+// "oidc" subsection's "token_url", "client_id", "client_secret", and optional
+// "scopes" and "audience").  Nothing is sent if the section is absent or if
+// the run was restricted to selected accounts; failures are reported but do
+// not stop the run.
+func postToCostApi(configMap Configuration, options CommandLineOptions, started time.Time, records []HistoryRecord) {
+	if configMap == nil {
+		return
+	}
+	if hasAccountFilter(options) {
+		log.Printf("[postToCostApi] not sending the results of a partial run")
+		return
+	}
+	endpoint := getMapKeyString(configMap, "url", "cost_api")
+	batchSize := defaultCostApiBatchSize
+	if getMapKeyValue(configMap, "batch_size", "") != nil {
+		batchSize = getMapKeyInt(configMap, "batch_size", "cost_api")
+	}
+	client := getCostApiClient(configMap)
+
+	stamped := make([]HistoryRecord, len(records))
+	for idx, record := range records {
+		record.RunTime = started
+		stamped[idx] = record
+	}
+	pages := max((len(records)+batchSize-1)/batchSize, 1)
+	for page := 0; page < pages; page++ {
+		batch := CostApiBatch{
+			RunId:   started.UTC().Format(time.RFC3339),
+			Month:   *options.monthPtr,
+			Page:    page + 1,
+			Pages:   pages,
+			Records: stamped[page*batchSize : min((page+1)*batchSize, len(records))],
+		}
+		if err := postCostApiBatch(client, configMap, endpoint, batch); err != nil {
+			msg := fmt.Sprintf("error sending page %d of %d to the cost API: %v", page+1, pages, err)
+			log.Printf("[postToCostApi] %s", msg)
+			noteExitStatus(ExitOutputFailure, msg)
+			return
+		}
+	}
+	log.Printf("[postToCostApi] sent %d records in %d requests to %s", len(records), pages, endpoint)
+}
+
+// getCostApiClient returns an (audited) HTTP client which adds the configured
+// authorization to its requests.
+func getCostApiClient(configMap Configuration) *http.Client {
+	client := newAuditedHttpClient("cost_api", time.Second*60)
+	if oidcConfig := getMapKeyValue(configMap, "oidc", ""); oidcConfig != nil {
+		oidc := getConfigurationFromAny(oidcConfig, "cost_api oidc section")
+		credentials := clientcredentials.Config{
+			ClientID:     getMapKeyString(oidc, "client_id", "cost_api oidc"),
+			ClientSecret: getMapKeyString(oidc, "client_secret", "cost_api oidc"),
+			TokenURL:     getMapKeyString(oidc, "token_url", "cost_api oidc"),
+			Scopes:       getMapKeyStringList(oidc, "scopes", ""),
+		}
+		if audience := getMapKeyString(oidc, "audience", ""); audience != "" {
+			credentials.EndpointParams = map[string][]string{"audience": {audience}}
+		}
+		// The token requests use the audited client, too.
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+		return credentials.Client(ctx)
+	}
+	if token := getMapKeyString(configMap, "token", ""); token != "" {
+		source := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token, TokenType: "Bearer"})
+		return oauth2.NewClient(context.WithValue(context.Background(), oauth2.HTTPClient, client), source)
+	}
+	return client
+}
+
+// postCostApiBatch sends one batch to the endpoint, retrying with exponential
+// backoff (or after the interval given by a Retry-After header) when the
+// request fails with a connection error or a 429 or 5xx status.
+func postCostApiBatch(client *http.Client, configMap Configuration, endpoint string, batch CostApiBatch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	retries := defaultCostApiRetries
+	if getMapKeyValue(configMap, "retries", "") != nil {
+		retries = getMapKeyInt(configMap, "retries", "")
+	}
+	maxInterval := time.Duration(getMapKeyInt(configMap, "max_retry_interval_seconds", "")) * time.Second
+	if maxInterval <= 0 {
+		maxInterval = defaultCostApiRetrySeconds * time.Second
+	}
+
+	wait := time.Second
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		response, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+		if err == nil {
+			message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+			closeBody(response)
+			if response.StatusCode/100 == 2 {
+				return nil
+			}
+			err = fmt.Errorf("%d, %q: %s", response.StatusCode, response.Status, message)
+			if response.StatusCode != http.StatusTooManyRequests && response.StatusCode/100 != 5 {
+				return err // Not worth retrying
+			}
+			if seconds, parseErr := strconv.Atoi(response.Header.Get("Retry-After")); parseErr == nil {
+				retryAfter = time.Duration(seconds) * time.Second
+			}
+		}
+		if attempt >= retries {
+			return err
+		}
+		delay := min(max(wait, retryAfter), maxInterval)
+		log.Printf("[postToCostApi] page %d failed (%v); retrying in %v", batch.Page, err, delay)
+		time.Sleep(delay)
+		wait *= 2
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// CostCenterMatcher recognizes our cost centers, as configured by the
+// "cost_center" value of a provider's section:  a single cost center or a
+// list.  Each entry is a cost center, a regular expression written between
+// slashes (e.g., "/^eng-[0-9]+$/"), or one of the Cloudability conditions
+// which select the cost centers in the query:  "==<value>", "!=<value>",
+// "=@<substring>" (contains), "!=@<substring>" (does not contain), or
+// "@(<value>,<value>,...)".  (The ordering comparators, e.g., ">=", are not
+// supported.)  As in the query, a cost center is ours if it matches any of
+// the other entries (or there are none), and none of the negated ("!=" and
+// "!=@") ones.  The comparisons ignore leading and trailing spaces and, with
+// "cost_center_ignore_case: true", case, since the cost center labels in the
+// providers' data are not always consistent.
+type CostCenterMatcher struct {
+	values        []string
+	notValues     []string
+	substrings    []string
+	notSubstrings []string
+	patterns      []*regexp.Regexp
+	ignoreCase    bool
+}
+
+// getCostCenterMatcher returns the matcher for the cost centers configured in
+// the given provider section.
+func getCostCenterMatcher(configMap Configuration) (matcher CostCenterMatcher) {
+	matcher.ignoreCase = getMapKeyBool(configMap, "cost_center_ignore_case", "")
+	for _, entry := range getMapKeyStringList(configMap, "cost_center", "") {
+		entry = strings.TrimSpace(entry)
+		if pattern, isPattern := getCostCenterPattern(entry); isPattern {
+			if matcher.ignoreCase {
+				pattern = "(?i)" + pattern
+			}
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				fatalf("Error in \"cost_center\" pattern %q: %v", entry, err)
+			}
+			matcher.patterns = append(matcher.patterns, compiled)
+		} else if list, found := strings.CutPrefix(entry, "@("); found && strings.HasSuffix(list, ")") {
+			for _, item := range strings.Split(strings.TrimSuffix(list, ")"), ",") {
+				matcher.values = append(matcher.values, matcher.normalize(item))
+			}
+		} else if substring, found := strings.CutPrefix(entry, "!=@"); found {
+			matcher.notSubstrings = append(matcher.notSubstrings, matcher.normalize(substring))
+		} else if value, found := strings.CutPrefix(entry, "!="); found {
+			matcher.notValues = append(matcher.notValues, matcher.normalize(value))
+		} else if substring, found := strings.CutPrefix(entry, "=@"); found {
+			matcher.substrings = append(matcher.substrings, matcher.normalize(substring))
+		} else if strings.HasPrefix(entry, ">") || strings.HasPrefix(entry, "<") {
+			fatalf("Error in \"cost_center\" entry %q:  the comparator is not supported; use \"==\", "+
+				"\"!=\", \"=@\", \"!=@\", \"@(...)\", or a regular expression", entry)
+		} else {
+			matcher.values = append(matcher.values, matcher.normalize(strings.TrimPrefix(entry, "==")))
+		}
+	}
+	return
+}
+
+// getCostCenterPattern returns the regular expression of a "cost_center"
+// entry written between slashes, and whether it is one.
+func getCostCenterPattern(entry string) (string, bool) {
+	if len(entry) < 2 || !strings.HasPrefix(entry, "/") || !strings.HasSuffix(entry, "/") {
+		return "", false
+	}
+	return entry[1 : len(entry)-1], true
+}
+
+// normalize returns the cost center as it is compared.
+func (m CostCenterMatcher) normalize(costCenter string) string {
+	costCenter = strings.TrimSpace(costCenter)
+	if m.ignoreCase {
+		costCenter = strings.ToLower(costCenter)
+	}
+	return costCenter
+}
+
+// isEmpty reports whether no cost centers are configured.
+func (m CostCenterMatcher) isEmpty() bool {
+	return len(m.values) == 0 && len(m.notValues) == 0 && len(m.substrings) == 0 && len(m.notSubstrings) == 0 &&
+		len(m.patterns) == 0
+}
+
+// isQueryable reports whether the Cloudability query can select the cost
+// centers, i.e., whether none of the entries is a regular expression and they
+// are compared with regard to case.
+func (m CostCenterMatcher) isQueryable() bool {
+	return !m.ignoreCase && len(m.patterns) == 0
+}
+
+// matches reports whether the cost center is one of ours.
+func (m CostCenterMatcher) matches(costCenter string) bool {
+	normalized := m.normalize(costCenter)
+	if slices.Contains(m.notValues, normalized) {
+		return false
+	}
+	for _, substring := range m.notSubstrings {
+		if strings.Contains(normalized, substring) {
+			return false
+		}
+	}
+	if len(m.values) == 0 && len(m.substrings) == 0 && len(m.patterns) == 0 {
+		return len(m.notValues) != 0 || len(m.notSubstrings) != 0
+	}
+	if slices.Contains(m.values, normalized) {
+		return true
+	}
+	for _, substring := range m.substrings {
+		if strings.Contains(normalized, substring) {
+			return true
+		}
+	}
+	for _, pattern := range m.patterns {
+		if pattern.MatchString(strings.TrimSpace(costCenter)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package main
+
+import "testing"
+
+func TestCostCenterMatcherMatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		costCenters []any
+		ignoreCase  bool
+		matched     []string
+		unmatched   []string
+	}{
+		{
+			name:        "values",
+			costCenters: []any{"eng", "==ops"},
+			matched:     []string{"eng", " ops "},
+			unmatched:   []string{"Eng", "eng-legacy", ""},
+		},
+		{
+			name:        "list",
+			costCenters: []any{"@(eng,ops)"},
+			matched:     []string{"eng", "ops"},
+			unmatched:   []string{"sales"},
+		},
+		{
+			name:        "negated value only",
+			costCenters: []any{"!=eng-legacy"},
+			matched:     []string{"eng", "sales"},
+			unmatched:   []string{"eng-legacy"},
+		},
+		{
+			name:        "negated values are all applied",
+			costCenters: []any{"!=eng", "!=ops"},
+			matched:     []string{"sales"},
+			unmatched:   []string{"eng", "ops"},
+		},
+		{
+			name:        "substring except value",
+			costCenters: []any{"=@eng", "!=eng-legacy"},
+			matched:     []string{"eng", "eng-prod"},
+			unmatched:   []string{"eng-legacy", "sales"},
+		},
+		{
+			name:        "value except substring",
+			costCenters: []any{"==eng-legacy", "=@ops", "!=@legacy"},
+			matched:     []string{"ops", "devops"},
+			unmatched:   []string{"eng-legacy", "ops-legacy", "eng"},
+		},
+		{
+			name:        "pattern except value",
+			costCenters: []any{"/^eng-[0-9]+$/", "!=eng-0"},
+			matched:     []string{"eng-1", "eng-42"},
+			unmatched:   []string{"eng-0", "eng-x"},
+		},
+		{
+			name:        "ignore case",
+			costCenters: []any{"/^ENG-[0-9]+$/", "Ops", "!=@Legacy"},
+			ignoreCase:  true,
+			matched:     []string{"eng-1", "OPS"},
+			unmatched:   []string{"ops-legacy", "ENG-LEGACY"},
+		},
+		{
+			name:      "none",
+			unmatched: []string{"eng", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configMap := Configuration{"cost_center": tt.costCenters, "cost_center_ignore_case": tt.ignoreCase}
+			matcher := getCostCenterMatcher(configMap)
+			for _, costCenter := range tt.matched {
+				if !matcher.matches(costCenter) {
+					t.Errorf("%q does not match %q", costCenter, tt.costCenters)
+				}
+			}
+			for _, costCenter := range tt.unmatched {
+				if matcher.matches(costCenter) {
+					t.Errorf("%q matches %q", costCenter, tt.costCenters)
+				}
+			}
+		})
+	}
+}
+
+func TestCostCenterMatcherIsQueryable(t *testing.T) {
+	tests := []struct {
+		costCenters []any
+		ignoreCase  bool
+		queryable   bool
+	}{
+		{costCenters: []any{"eng", "!=eng-legacy", "=@ops", "!=@test", "@(a,b)"}, queryable: true},
+		{costCenters: []any{"eng", "/^ops/"}, queryable: false},
+		{costCenters: []any{"eng"}, ignoreCase: true, queryable: false},
+	}
+	for _, tt := range tests {
+		configMap := Configuration{"cost_center": tt.costCenters, "cost_center_ignore_case": tt.ignoreCase}
+		if queryable := getCostCenterMatcher(configMap).isQueryable(); queryable != tt.queryable {
+			t.Errorf("%q (ignoring case: %t) is queryable: %t, expected %t", tt.costCenters, tt.ignoreCase,
+				queryable, tt.queryable)
+		}
+	}
+}
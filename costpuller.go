@@ -1,36 +1,67 @@
 package main
 
 import (
+	"cmp"
 	"encoding/csv"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/api/sheets/v4"
 	"gopkg.in/yaml.v2"
 )
 
 type CommandLineOptions struct {
-	debugPtr          *bool
-	awsWriteTagsPtr   *bool
-	accountsFilePtr   *string
-	taggedAccountsPtr *bool
-	monthPtr          *string
-	costTypePtr       *string
-	csvfilePtr        *string
-	reportFilePtr     *string
-	outputTypePtr     *string
+	accountPtr         *string
+	groupPtr           *string
+	applyPtr           *bool
+	debugPtr           *bool
+	drilldownFilePtr   *string
+	forcePtr           *bool
+	awsWriteTagsPtr    *bool
+	accountsFilePtr    *string
+	archiveDirPtr      *string
+	auditLogPtr        *string
+	taggedAccountsPtr  *bool
+	untagStalePtr      *bool
+	untrackedFilePtr   *string
+	monthPtr           *string
+	costTypePtr        *string
+	csvfilePtr         *string
+	replayDirPtr       *string
+	recordHttpDirPtr   *string
+	replayHttpDirPtr   *string
+	reportFilePtr      *string
+	summaryFilePtr     *string
+	outputTypePtr      *string
+	granularityPtr     *string
+	providersPtr       *string
+	quarterPtr         *string
+	quarterlyOnlyPtr   *bool
+	refreshAccountsPtr *bool
+	rollForwardPtr     *bool
+	skipEmptyPtr       *bool
+	staticTotalsPtr    *bool
+	incrementalPtr     *bool
+	legacyLayoutPtr    *bool
 }
 
 type AccountsFile struct {
 	Configuration map[string]Configuration `yaml:"configuration"`
 	Providers     map[string]Team          `yaml:"cloud_providers"`
+	Include       []string                 `yaml:"include"`
 }
 
 type Configuration map[string]any
@@ -43,146 +74,606 @@ type AccountEntry struct {
 	DeviationPercent int     `yaml:"deviationpercent"`
 	Category         string  `yaml:"category"`
 	Description      string  `yaml:"description"`
+	Owner            string  `yaml:"owner"`
+	Contact          string  `yaml:"contact"`
+	CostCenter       string  `yaml:"cost_center"`
+
+	// Buckets optionally sets limits on the account's costs in individual
+	// cost categories (e.g., "storage"), keyed by the bucket name.
+	Buckets map[string]BucketThreshold `yaml:"buckets"`
+
+	// Labels holds arbitrary metadata (e.g., environment or business unit),
+	// which is written to the columns listed in the "labels" section.
+	Labels map[string]string `yaml:"labels"`
+
+	// Notes is an explanation of the account's costs (e.g., "decommissioning
+	// in March"), which is written to the "Notes" column and as a note on the
+	// account's row.
+	Notes string `yaml:"notes"`
+}
+
+// BucketThreshold is the expected cost of an account in one cost category:
+// a standard value, with the allowed percentage deviation from it, and/or a
+// maximum.
+type BucketThreshold struct {
+	StandardValue    float64  `yaml:"standardvalue"`
+	DeviationPercent int      `yaml:"deviationpercent"`
+	Max              *float64 `yaml:"max"`
+}
+
+// subcommands maps the names of the subcommands to their implementations;
+// when the first command line argument names one of them, it is run in
+// place of the default (data pull) operation.
+var subcommands = map[string]func(args []string){
+	"accounts":     accountsCommand,
+	"check":        checkCommand,
+	"config":       configCommand,
+	"diff":         diffCommand,
+	"history":      historyCommand,
+	"tags":         tagsCommand,
+	"upload":       uploadCommand,
+	"verify-sheet": verifySheetCommand,
 }
 
 func main() {
+	// Exit with the status noted during the run (if any), after the other
+	// deferred functions have completed.
+	defer func() {
+		if exitStatus != ExitSuccess {
+			os.Exit(exitStatus)
+		}
+	}()
+
+	if len(os.Args) > 1 {
+		if command, exists := subcommands[os.Args[1]]; exists {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	log.Println("[main] costpuller starting.")
-	nowTime := time.Now()
-	lastMonth := time.Date(nowTime.Year(), nowTime.Month()-1, 1, 0, 0, 0, 0, nowTime.Location())
-	nowStr := nowTime.Format("20060102150405")
-	defaultMonth := lastMonth.Format("2006-01")
-	defaultCsvFile := fmt.Sprintf("output-%s.csv", defaultMonth)
-	defaultReportFile := fmt.Sprintf("report-%s.txt", nowStr)
-	options := CommandLineOptions{
-		accountsFilePtr:   flag.String("accounts", "accounts.yaml", "file to read accounts list from"),
-		awsWriteTagsPtr:   flag.Bool("awswritetags", false, "write tags to AWS accounts (USE WITH CARE!)"),
-		costTypePtr:       flag.String("costtype", "UnblendedCost", `cost type to pull, one of "AmortizedCost", "BlendedCost", "NetAmortizedCost", "NetUnblendedCost", "NormalizedUsageAmount", "UnblendedCost", or "UsageQuantity"`),
-		csvfilePtr:        flag.String("csv", defaultCsvFile, "output file for csv data"),
-		debugPtr:          flag.Bool("debug", false, "outputs debug info"),
-		monthPtr:          flag.String("month", defaultMonth, `context month in format yyyy-mm`),
-		outputTypePtr:     flag.String("output", "gsheet", `output destination, needs to be one of "csv" or "gsheet"`),
-		reportFilePtr:     flag.String("report", defaultReportFile, "output file for data consistency report"),
-		taggedAccountsPtr: flag.Bool("taggedaccounts", false, "use the AWS tags as account list source"),
-	}
-	flag.Parse()
-
-	if *options.csvfilePtr == defaultCsvFile && *options.monthPtr != defaultMonth {
-		newDefaultCsvFile := fmt.Sprintf("output-%s.csv", *options.monthPtr)
-		options.csvfilePtr = &newDefaultCsvFile
+	startTime := time.Now()
+	options, accountsFile, sources := getOptions(flag.CommandLine, os.Args[1:])
+	openAuditLog(*options.auditLogPtr)
+	defer auditLog.close()
+	if *options.quarterPtr != "" {
+		runQuarter(options, accountsFile, sources)
+		return
 	}
-	accountsFile, err := loadAccountsFile(*options.accountsFilePtr)
-	if err != nil {
-		log.Fatalf("[main] error loading accounts file: %v", err)
+	if *options.replayDirPtr != "" {
+		if *options.archiveDirPtr != "" || *options.awsWriteTagsPtr {
+			exitf(ExitUsage, "[main] -replay cannot be used with -archive-dir or -awswritetags")
+		}
+		openReplayArchive(*options.replayDirPtr, *options.monthPtr)
 	}
+	openResponseArchive(*options.archiveDirPtr, *options.monthPtr)
+	openHttpFixtures(*options.recordHttpDirPtr, *options.replayHttpDirPtr)
+	if httpFixtures != nil && !httpFixtures.replay {
+		defer httpFixtures.close()
+	}
+	telemetryConfig, telemetryConfigured := accountsFile.Configuration["telemetry"]
+	defer initTelemetry(telemetryConfig, telemetryConfigured, options)()
 	if len(accountsFile.Configuration) == 0 {
-		log.Fatalf("[main] error in accounts file: empty or missing \"configuration\" section")
+		fatalf("[main] error in accounts file: empty or missing \"configuration\" section")
 	}
 	if len(accountsFile.Providers) == 0 {
-		log.Fatalf("[main] error in accounts file: empty or missing \"cloud_providers\" section")
+		fatalf("[main] error in accounts file: empty or missing \"cloud_providers\" section")
+	}
+	accountMetadata := getAccountMetadata(accountsFile.Providers, getAwsAccountsKeys(accountsFile.Configuration["aws"]))
+	if hasAccountFilter(options) {
+		for _, entry := range accountMetadata {
+			entry.Excluded = !isAccountSelected(options, entry.AccountId, entry.Group)
+		}
+		if *options.outputTypePtr != "csv" {
+			log.Printf("[main] warning: the -account and -group filters produce a partial sheet")
+		}
 	}
-	accountMetadata := getAccountMetadata(accountsFile.Providers)
 
 	output := newOutputObject(options, accountsFile)
 	defer output.close()
+	var runLock *RunLock
+	if !*options.awsWriteTagsPtr {
+		runLock = acquireRunLock(accountsFile.Configuration["run_lock"], output, *options.monthPtr)
+	}
+	defer runLock.release()
+	initCloudEvents(accountsFile.Configuration["cloudevents"], accountsFile.Configuration["kafka"], options, startTime)
 
-	var sheetData []*sheets.RowData
+	var historyRecords []HistoryRecord
+	var queriedProviders, missingAccounts []string
+	historyConfig := accountsFile.Configuration["history"]
+	useHistory := !getMapKeyBool(historyConfig, "disabled", "")
+	useForecast := useHistory && getMapKeyValue(historyConfig, "forecast", "") != nil
 
-	cldy, useCldyData := accountsFile.Configuration["cloudability"]
-	if *options.awsWriteTagsPtr || !useCldyData {
-		awsConfig := getMapKeyValue(accountsFile.Configuration, "aws", "configuration")
-		awsProfile := getMapKeyString(awsConfig, "profile", "")
-		if awsProfile == "" {
-			awsProfile = "default"
-			log.Printf(
-				"[main] no \"profile\" key found in the \"aws\" section of the configuration file; "+
-					"using AWS credentials profile %q",
-				awsProfile,
-			)
+	configureReconciliation(accountsFile.Configuration["reconciliation"])
+	configureCsvFormat(accountsFile.Configuration["csv"])
+	configureTaxonomy(accountsFile.Configuration["taxonomy"], accountsFile.Configuration["azure"])
+	labelColumns := getLabelColumns(accountsFile.Configuration["labels"])
+	providers := getEnabledProviders(accountsFile, options)
+	if *options.awsWriteTagsPtr {
+		for _, payer := range getAwsPayers(getMapKeyValue(accountsFile.Configuration, "aws", "configuration")) {
+			writeAwsTags(NewAwsPuller(payer, *options.debugPtr), payer, options)
 		}
-		awsPuller := NewAwsPuller(awsProfile, *options.debugPtr)
+		return // (Running the deferred functions, e.g., closing the audit log)
+	}
+	if providers["cloudability"] {
+		getCloudabilityMetric(*options.costTypePtr) // Validate the cost type before pulling anything
+	}
 
-		if *options.awsWriteTagsPtr {
-			writeAwsTags(awsPuller, options)
-			os.Exit(0)
+	reportFile := getReportFile(options)
+	defer closeFile(reportFile)
+	if !providers["cloudability"] && !providers["ibmcloud"] {
+		if !providers["aws"] {
+			fatalf("[main] no cost providers are enabled")
 		}
+		queriedProviders = []string{"aws"}
+		historyRecords = pullAwsProvider(accountsFile, options, output, accountMetadata, reportFile, labelColumns,
+			useForecast)
+	} else {
+		queriedProviders, historyRecords, missingAccounts = pullCombinedProviders(accountsFile, options, providers,
+			output, accountMetadata, reportFile, labelColumns, useForecast)
+	}
 
-		reportFile := getReportFile(options)
-		defer closeFile(reportFile)
+	reportAlerts(accountsFile, historyRecords, accountMetadata, *options.monthPtr, *options.costTypePtr, reportFile)
+	writeUntrackedAccounts(*options.untrackedFilePtr)
 
-		awsAccounts, sortedAccountKeys := awsPuller.getAwsAccounts(accountsFile, options)
+	if useHistory {
+		recordHistory(accountsFile, options, historyRecords)
+		if getMapKeyBool(historyConfig, "trends", "") {
+			output.writeAuxiliarySheet("trends", "Trends", getTrendsSheet(accountsFile, output.refTime, *options.costTypePtr))
+		}
+	}
 
-		sheetData = awsPuller.pullAwsByAccount(awsAccounts, sortedAccountKeys, options, reportFile)
-	} else {
+	publishConfluenceSummary(accountsFile.Configuration["confluence"], options, historyRecords, missingAccounts)
+	if output.sftp != nil {
+		output.sftp.deliver(output, options)
+	}
+	publishToKafka(accountsFile.Configuration["kafka"], options, startTime, queriedProviders, historyRecords,
+		missingAccounts)
+	postToCostApi(accountsFile.Configuration["cost_api"], options, startTime, historyRecords)
+	emitRunFinishedEvents(options, startTime, queriedProviders, historyRecords, missingAccounts)
+
+	logTimings()
+	writeRunSummary(options, startTime, queriedProviders, historyRecords, missingAccounts)
+	output.uploadFileArtifact(*options.summaryFilePtr, "application/json")
+
+	log.Println("[main] operation done")
+}
+
+// pullAwsProvider pulls the costs of the AWS accounts directly from each of
+// the configured payers, and writes the main sheet (in the common or the
+// legacy layout) and the auxiliary sheets; it returns the history records.
+func pullAwsProvider(
+	accountsFile AccountsFile,
+	options CommandLineOptions,
+	output *OutputObject,
+	accountMetadata map[string]*AccountMetadata,
+	reportFile *os.File,
+	labelColumns []string,
+	useForecast bool,
+) (historyRecords []HistoryRecord) {
+	var drilldown *csv.Writer
+	if *options.drilldownFilePtr != "" {
+		drilldownFile := getDrilldownFile(options)
+		defer closeFile(drilldownFile)
+		drilldown = csv.NewWriter(drilldownFile)
+		defer drilldown.Flush()
+		if err := drilldown.Write(awsDrilldownHeader); err != nil {
+			exitf(ExitOutputFailure, "[pullAwsProvider] error writing drill-down file header: %v", err)
+		}
+	}
+
+	payers := getAwsPayers(getMapKeyValue(accountsFile.Configuration, "aws", "configuration"))
+	run := newAwsRun(accountsFile, options)
+	granularity := getGranularity(options, "")
+	periodCosts := make(map[PeriodCostKey]float64)
+	periodNames := make(map[string]string)
+	var forecaster *Forecaster
+	if useForecast {
+		forecaster = newForecaster(accountsFile, *options.monthPtr, *options.costTypePtr)
+	}
+
+	if !*options.legacyLayoutPtr {
+		// Render the normalized data in the same layout as the
+		// Cloudability and IBM Cloud data.  (The columns are known only
+		// once every account is pulled, so the rows cannot be streamed
+		// as they are with -legacy-layout.)
 		costCells := make(map[string]map[string]float64)
-		columnHeadsSet := make(map[string]struct{}) // This is the Go equivalent of a "set".
+		columnHeadsSet := categoryTaxonomy.newColumnHeadsSet()
 		metadata := make(map[string]providerAccountMetadata)
+		var tagColumns, detailColumns []string
+		for _, payer := range payers {
+			awsPuller := run.newPuller(payer)
+			tagColumns, detailColumns = awsPuller.addDetailColumnNames(tagColumns, detailColumns)
+			awsAccounts, sortedAccountKeys := awsPuller.getAwsAccounts(accountsFile, payer, options)
+			awsPuller.pullAwsByAccount(awsAccounts, sortedAccountKeys, payer, options, reportFile, drilldown,
+				func(rows []*sheets.RowData) {
+					awsPuller.addRowsToCostCells(rows, payer.Name, accountMetadata, costCells, columnHeadsSet, metadata)
+				})
+			if granularity != "monthly" {
+				awsPuller.addPeriodCosts(awsAccounts, options, granularity, periodCosts, periodNames)
+			}
+		}
+		historyRecords = getHistoryRecordsFromCostCells(costCells, accountMetadata, metadata)
+		var forecasts map[string]float64
+		if forecaster != nil {
+			forecasts = forecaster.getForecasts(historyRecords)
+		}
+		if *options.skipEmptyPtr {
+			costCells = skipEmptyAccounts(costCells, accountMetadata, reportFile)
+		}
+		costCells, columnHeadsSet = foldSmallColumns(accountsFile.Configuration["fold_columns"], costCells,
+			columnHeadsSet)
+		output.writeSheet(getSheetFromCostCells(costCells, columnHeadsSet, accountMetadata, metadata, forecasts,
+			tagColumns, labelColumns, detailColumns, false))
+	} else {
+		// The rows for each account are written to the output as they are
+		// pulled, rather than being accumulated, so that the memory used does
+		// not grow with the size of the estate.
+		if *options.skipEmptyPtr {
+			log.Printf("[pullAwsProvider] -skip-empty-accounts is not supported with -legacy-layout; ignoring it")
+		}
+		sink := output.newRowSink()
+		streamRows(
+			func(emit func(rows []*sheets.RowData)) {
+				for _, payer := range payers {
+					awsPuller := run.newPuller(payer)
+					awsAccounts, sortedAccountKeys := awsPuller.getAwsAccounts(accountsFile, payer, options)
+					awsPuller.pullAwsByAccount(awsAccounts, sortedAccountKeys, payer, options, reportFile, drilldown, emit)
+					if granularity != "monthly" {
+						awsPuller.addPeriodCosts(awsAccounts, options, granularity, periodCosts, periodNames)
+					}
+				}
+			},
+			func(rows []*sheets.RowData) {
+				records := getHistoryRecordsFromAwsRows(rows)
+				historyRecords = append(historyRecords, records...)
+				if forecaster != nil {
+					forecasts := forecaster.getForecasts(records)
+					for idx, row := range rows {
+						// Copy the row, so that the cached copy is unchanged.
+						accountID := *row.Values[2].UserEnteredValue.StringValue
+						rows[idx] = &sheets.RowData{
+							Values: append(slices.Clone(row.Values), newNumberCell(forecasts[accountID])),
+						}
+					}
+				}
+				sink.writeRows(rows)
+			},
+		)
+		sink.finish()
+	}
+	run.runCache.save()
+	emitProviderCompleted(options, "aws")
+	if run.recommendations != nil {
+		output.writeAuxiliarySheet("recommendations", "Recommendations 01/2006",
+			getSheetFromRecommendations(run.recommendations))
+	}
+	if granularity != "monthly" {
+		output.writeAuxiliarySheet(granularity, getPeriodSheetName(granularity),
+			getPeriodSheet(periodCosts, periodNames))
+	}
+	return
+}
 
-		cldyCostData := getCloudabilityData(cldy, options)
-		if cldyCostData == nil || cldyCostData.TotalResults == 0 || len(cldyCostData.Results) == 0 {
-			log.Fatalf("[main] no Cloudability data")
+// pullCombinedProviders pulls the costs from Cloudability and IBM Cloud, and
+// those of any AWS "detailed_accounts" directly from AWS, isolating the
+// failure of each provider, and writes the merged sheet; it returns the
+// providers which were queried, the history records, and the accounts for
+// which no data was found.
+func pullCombinedProviders(
+	accountsFile AccountsFile,
+	options CommandLineOptions,
+	providers map[string]bool,
+	output *OutputObject,
+	accountMetadata map[string]*AccountMetadata,
+	reportFile *os.File,
+	labelColumns []string,
+	useForecast bool,
+) (queriedProviders []string, historyRecords []HistoryRecord, missingAccounts []string) {
+	// The accounts listed in the "detailed_accounts" key of the "aws"
+	// section are pulled directly from AWS, in place of their
+	// Cloudability data.
+	var detailedAccounts []string
+	if providers["aws"] {
+		detailedAccounts = getMapKeyStringList(accountsFile.Configuration["aws"], "detailed_accounts", "")
+		if detailedAccounts == nil {
+			log.Printf("[pullCombinedProviders] warning: the \"aws\" provider is not used when pulling " +
+				"Cloudability or IBM Cloud data, unless \"detailed_accounts\" are configured")
 		}
-		getSheetDataFromCloudability(cldyCostData, accountMetadata, cldy, costCells, columnHeadsSet, metadata)
+	}
+	for _, accountID := range detailedAccounts {
+		key, _ := getCanonicalAccountId("Amazon", accountID)
+		if entry, exists := accountMetadata[key]; exists {
+			entry.PulledDirectly = true
+		} else {
+			fatalf("[pullCombinedProviders] AWS detailed account %s is not in the accounts file", accountID)
+		}
+	}
+	// Likewise, when IBM Cloud is pulled directly, any IBM Cloud rows in
+	// the Cloudability data are used only for reconciliation.
+	if providers["ibmcloud"] {
+		for _, entry := range accountMetadata {
+			if getCanonicalProvider(entry.CloudProvider) == CloudProvider {
+				entry.PulledDirectly = true
+			}
+		}
+	}
 
-		ibmc, fetchIbmcloudData := accountsFile.Configuration["ibmcloud"]
-		if fetchIbmcloudData {
-			ibmCostData := getIbmcloudData(ibmc, options)
-			if ibmCostData == nil || len(ibmCostData) == 0 {
-				log.Fatal("[main] no IBM Cloud data")
+	costCells := make(map[string]map[string]float64)
+	columnHeadsSet := categoryTaxonomy.newColumnHeadsSet() // This is the Go equivalent of a "set".
+	metadata := make(map[string]providerAccountMetadata)
+
+	var cldyCostData *CloudabilityCostData
+	var tagColumns, detailColumns []string
+	if providers["cloudability"] {
+		queriedProviders = append(queriedProviders, "cloudability")
+		cldy := accountsFile.Configuration["cloudability"]
+		azureConfig := accountsFile.Configuration["azure"]
+		rows := newProviderRows()
+		failure := pullIsolated("cloudability", func() {
+			cldyCostData = getCloudabilityData(cldy, azureConfig, options)
+			if cldyCostData == nil || cldyCostData.TotalResults == 0 || len(cldyCostData.Results) == 0 {
+				cldyCostData = nil
+				exitf(ExitProviderError, "[pullCombinedProviders] no Cloudability data")
+			}
+			getSheetDataFromCloudability(cldyCostData, accountMetadata, cldy, rows.costCells, rows.columnHeadsSet,
+				rows.metadata)
+		})
+		if failure != nil {
+			rows.discard(accountMetadata)
+		} else {
+			rows.merge(costCells, columnHeadsSet, metadata)
+			checkCloudabilityAggregate(cldyCostData, getCloudabilityMetric(*options.costTypePtr), costCells,
+				accountMetadata, reportFile)
+			tagColumns = getMapKeyStringList(cldy, "tag_dimensions", "")
+			if granularity := getCloudabilityGranularity(cldy, options); granularity != "monthly" {
+				output.writeAuxiliarySheet(granularity, getPeriodSheetName(granularity),
+					getPeriodSheetFromCloudability(cldyCostData, accountMetadata, granularity))
 			}
-			getSheetDataFromIbmcloud(ibmCostData, accountMetadata, ibmc, costCells, metadata)
+			emitProviderCompleted(options, "cloudability")
 		}
+	}
 
-		checkMissing(accountMetadata, cldyCostData)
+	if providers["ibmcloud"] {
+		queriedProviders = append(queriedProviders, "ibmcloud")
+		ibmc := accountsFile.Configuration["ibmcloud"]
+		var ibmCostData []IbmcResultsEntry
+		rows := newProviderRows()
+		failure := pullIsolated("ibmcloud", func() {
+			ibmCostData = getIbmcloudData(ibmc, options, accountMetadata)
+			if len(ibmCostData) == 0 {
+				exitf(ExitProviderError, "[pullCombinedProviders] no IBM Cloud data")
+			}
+			getSheetDataFromIbmcloud(ibmCostData, accountMetadata, ibmc, rows.costCells, rows.columnHeadsSet,
+				rows.metadata, reportFile)
+		})
+		if failure != nil {
+			rows.discard(accountMetadata)
+		} else {
+			rows.merge(costCells, columnHeadsSet, metadata)
+			if tagKey := getMapKeyString(ibmc, "tag_key", ""); tagKey != "" {
+				output.writeAuxiliarySheet("ibmcloudTags", "IBM Cloud Tags 01/2006",
+					getSheetFromIbmcloudTagCosts(ibmCostData, accountMetadata, tagKey))
+			}
+			if cldyCostData != nil {
+				if cldyTotals := getCloudabilityTotals(cldyCostData, CloudProvider); len(cldyTotals) > 0 {
+					reconcileWithCloudability("IBM Cloud", getPulledDirectly(accountMetadata, CloudProvider),
+						costCells, cldyTotals, reportFile)
+				}
+			}
+			emitProviderCompleted(options, "ibmcloud")
+		}
+	}
 
-		sheetData = getSheetFromCostCells(costCells, columnHeadsSet, accountMetadata, metadata)
+	if detailedAccounts != nil {
+		queriedProviders = append(queriedProviders, "aws")
+		rows := newProviderRows()
+		var recommendations []AwsRightsizingRecommendation
+		failure := pullIsolated("aws", func() {
+			tagColumns, detailColumns, recommendations = pullDetailedAwsAccounts(accountsFile, options, reportFile,
+				accountMetadata, rows.costCells, rows.columnHeadsSet, rows.metadata, tagColumns)
+		})
+		if failure != nil {
+			rows.discard(accountMetadata)
+		} else {
+			rows.merge(costCells, columnHeadsSet, metadata)
+			if cldyCostData != nil {
+				reconcileWithCloudability("AWS", getPulledDirectly(accountMetadata, "Amazon"), costCells,
+					getCloudabilityTotals(cldyCostData, "Amazon"), reportFile)
+			}
+			if recommendations != nil {
+				output.writeAuxiliarySheet("recommendations", "Recommendations 01/2006",
+					getSheetFromRecommendations(recommendations))
+			}
+			emitProviderCompleted(options, "aws")
+		}
 	}
+	checkProviderFailures(queriedProviders, reportFile)
+	reportSkippedCosts(reportFile)
 
-	output.writeSheet(sheetData)
+	checkMissing(accountMetadata, cldyCostData)
+	checkBucketConsistency(costCells, accountMetadata, reportFile)
+	if cldyCostData == nil {
+		// Without Cloudability data, the columns are just the IBM Cloud
+		// buckets which were populated.
+		for _, row := range costCells {
+			for bucket := range row {
+				columnHeadsSet[bucket] = struct{}{}
+			}
+		}
+	}
 
-	log.Println("[main] operation done")
+	// (On the AWS path, every listed account is pulled, or the run fails,
+	// so only this path can have missing accounts.)
+	missingAccounts = getMissingAccounts(accountMetadata)
+	converted := convertCostCells(costCells, metadata,
+		newExchangeRateSource(accountsFile.Configuration["conversion_rates"]), *options.monthPtr)
+	historyRecords = getHistoryRecordsFromCostCells(costCells, accountMetadata, metadata)
+	var forecasts map[string]float64
+	if useForecast {
+		forecasts = getForecasts(accountsFile, historyRecords, *options.monthPtr, *options.costTypePtr)
+	}
+	if *options.skipEmptyPtr {
+		costCells = skipEmptyAccounts(costCells, accountMetadata, reportFile)
+	}
+	costCells, columnHeadsSet = foldSmallColumns(accountsFile.Configuration["fold_columns"], costCells,
+		columnHeadsSet)
+	output.writeSheet(getSheetFromCostCells(costCells, columnHeadsSet, accountMetadata, metadata, forecasts,
+		tagColumns, labelColumns, detailColumns, converted))
+	if len(providerFailures) > 0 {
+		output.writeAuxiliarySheet("failures", "Failed Providers 01/2006", getSheetFromProviderFailures())
+	}
+	return
 }
 
 // OutputObject encapsulates the destination for the output, hiding the details
-// of whether it goes to a local CSV file or a Google sheet (or both).
+// of whether it goes to a local CSV file, a Google sheet, or Smartsheet.
 type OutputObject struct {
-	csvFile      *os.File
-	httpClient   *http.Client
-	gsheetConfig Configuration
-	refTime      time.Time
+	csvFile       *os.File
+	httpClient    *http.Client
+	gsheetConfigs []Configuration // One for each destination spreadsheet
+	refTime       time.Time
+	rollForward   bool // Copy the previous month's main sheet block if there is none for this month
+	force         bool // Overwrite a raw data sheet which holds different data
+	staticTotals  bool // Write the values of the "TOTAL" formulas instead of the formulas
+	smartsheet    *SmartsheetOutput
+	uploaders     []ArtifactUploader // Destinations for copies of the output files
+	artifactName  string             // The name of the uploaded copy of the main output
+	sftp          *SftpDelivery
 }
 
 func newOutputObject(options CommandLineOptions, accountsFile AccountsFile) *OutputObject {
 	refTime, err := time.Parse("2006-01", *options.monthPtr)
 	if err != nil {
-		log.Fatalf("[main] error parsing month value, %q: %v", *options.monthPtr, err)
+		fatalf("[main] error parsing month value, %q: %v", *options.monthPtr, err)
 	}
 
-	obj := &OutputObject{refTime: refTime}
+	obj := &OutputObject{
+		refTime:      refTime,
+		artifactName: filepath.Base(*options.csvfilePtr),
+		staticTotals: *options.staticTotalsPtr,
+	}
+	oauthConfig := getMapKeyValue(accountsFile.Configuration, "oauth", "configuration")
+	artifactScopes := getArtifactScopes(accountsFile)
 
 	if *options.outputTypePtr == "csv" {
 		obj.csvFile = getCsvFile(options)
 	} else if *options.outputTypePtr == "gsheet" {
-		oauthConfig := getMapKeyValue(accountsFile.Configuration, "oauth", "configuration")
-		obj.httpClient = getGoogleOAuthHttpClient(oauthConfig)
-		obj.gsheetConfig = getMapKeyValue(accountsFile.Configuration, "gsheet", "configuration")
+		obj.httpClient = getGoogleOAuthHttpClient(oauthConfig, artifactScopes...)
+		obj.gsheetConfigs = getGsheetDestinations(getMapKeyValue(accountsFile.Configuration, "gsheet", "configuration"))
+		obj.rollForward = *options.rollForwardPtr
+		obj.force = *options.forcePtr
+	} else if *options.outputTypePtr == "smartsheet" {
+		obj.smartsheet = newSmartsheetOutput(getMapKeyValue(accountsFile.Configuration, "smartsheet", "configuration"))
 	} else {
-		log.Fatalf("[main] Unexpected value for output type, %q", *options.outputTypePtr)
+		fatalf("[main] Unexpected value for output type, %q", *options.outputTypePtr)
+	}
+	obj.sftp = newSftpDelivery(accountsFile.Configuration["sftp"])
+	if len(artifactScopes) > 0 {
+		client := obj.httpClient
+		if client == nil {
+			client = getGoogleOAuthHttpClient(oauthConfig, artifactScopes...)
+		}
+		obj.uploaders = newArtifactUploaders(accountsFile, client)
 	}
 	return obj
 }
 
 func (o *OutputObject) writeSheet(sheetData []*sheets.RowData) {
-	if sheetData == nil || len(sheetData) == 0 {
-		log.Fatal("[writeSheet] no sheet data")
+	sink := o.newRowSink()
+	sink.writeRows(sheetData)
+	sink.finish()
+}
+
+// writeAuxiliarySheet writes supplementary data (such as the rightsizing
+// recommendations) alongside the main output:  for CSV output, it goes to a
+// separate file whose name is derived from the CSV file name and the given
+// name; for Google Sheets output, it goes to a separate sheet whose name is
+// built from the "<name>SheetNameTemplate" configuration value or, if that is
+// not set, from the provided default template.
+func (o *OutputObject) writeAuxiliarySheet(name string, defaultTemplate string, sheetData []*sheets.RowData) {
+	defer startPhase("output.write_auxiliary", attribute.String("sheet", name))()
+	if o.staticTotals {
+		sheetData = getStaticTotalsRows(sheetData)
 	}
 	if o.csvFile != nil {
-		err := writeCsvFromSheet(o.csvFile, sheetData)
+		auxFileName := strings.TrimSuffix(o.csvFile.Name(), ".csv") + "-" + name + ".csv"
+		auxFile, err := os.Create(auxFileName)
 		if err != nil {
-			log.Fatalf("[writeSheet] error writing to output file: %v", err)
+			exitf(ExitOutputFailure, "[writeAuxiliarySheet] error creating output file: %v", err)
+		}
+		defer closeFile(auxFile)
+		log.Printf("[writeAuxiliarySheet] writing %s to %s\n", name, auxFileName)
+		if err := writeCsvFromSheet(auxFile, sheetData); err != nil {
+			exitf(ExitOutputFailure, "[writeAuxiliarySheet] error writing to output file: %v", err)
+		}
+	}
+	if o.httpClient != nil {
+		o.postToSpreadsheets(name, func(configMap Configuration) error {
+			template := getMapKeyString(configMap, name+"SheetNameTemplate", "")
+			if template == "" {
+				template = defaultTemplate
+			}
+			return postAuxiliaryToGSheet(sheetData, o.httpClient, configMap, o.refTime.Format(template))
+		})
+	}
+	if o.smartsheet != nil {
+		template := getMapKeyString(o.smartsheet.configMap, name+"SheetNameTemplate", "")
+		if template == "" {
+			template = defaultTemplate
+		}
+		if err := o.smartsheet.postAuxiliarySheet(sheetData, o.refTime.Format(template)); err != nil {
+			exitf(ExitOutputFailure, "[writeAuxiliarySheet] error writing %s to Smartsheet: %v", name, err)
+		}
+	}
+	o.uploadSheetArtifact(strings.TrimSuffix(o.artifactName, ".csv")+"-"+name+".csv", sheetData)
+}
+
+// writeNamedSheet writes data which is not tied to a month (such as the
+// quarterly sheet) as the main output:  for CSV output, it goes to the CSV
+// file; for Google Sheets and Smartsheet output, it goes to a separate sheet
+// with the given name.
+func (o *OutputObject) writeNamedSheet(name string, sheetName string, sheetData []*sheets.RowData) {
+	defer startPhase("output.write_named", attribute.String("sheet", sheetName))()
+	if o.staticTotals {
+		sheetData = getStaticTotalsRows(sheetData)
+	}
+	if o.csvFile != nil {
+		log.Printf("[writeNamedSheet] writing %s to %s\n", name, o.csvFile.Name())
+		if err := writeCsvFromSheet(o.csvFile, sheetData); err != nil {
+			exitf(ExitOutputFailure, "[writeNamedSheet] error writing to output file: %v", err)
 		}
 	}
 	if o.httpClient != nil {
-		postToGSheet(sheetData, o.httpClient, o.gsheetConfig, o.refTime)
+		o.postToSpreadsheets(name, func(configMap Configuration) error {
+			return postAuxiliaryToGSheet(sheetData, o.httpClient, configMap, sheetName)
+		})
+	}
+	if o.smartsheet != nil {
+		if err := o.smartsheet.postAuxiliarySheet(sheetData, sheetName); err != nil {
+			exitf(ExitOutputFailure, "[writeNamedSheet] error writing %s to Smartsheet: %v", name, err)
+		}
+	}
+	o.uploadSheetArtifact(o.artifactName, sheetData)
+}
+
+// postToSpreadsheets calls the given function to post the named data to each
+// destination spreadsheet.  A failure to update one spreadsheet does not
+// prevent the others from being updated:  it is logged and reflected in the
+// exit code, and the run is stopped only if every update fails.
+func (o *OutputObject) postToSpreadsheets(name string, post func(configMap Configuration) error) {
+	failures := 0
+	for _, configMap := range o.gsheetConfigs {
+		spreadsheetId := getMapKeyString(configMap, "spreadsheetId", "gsheet")
+		if err := post(configMap); err != nil {
+			failures++
+			msg := fmt.Sprintf("error writing %s to spreadsheet %s: %v", name, spreadsheetId, err)
+			log.Printf("[postToSpreadsheets] %s", msg)
+			noteExitStatus(ExitOutputFailure, msg)
+		}
+	}
+	if failures == len(o.gsheetConfigs) {
+		exitf(ExitOutputFailure, "[postToSpreadsheets] unable to write %s to any spreadsheet", name)
 	}
 }
 
@@ -200,32 +691,148 @@ func (o *OutputObject) close() {
 
 func (a *AwsPuller) getAwsAccounts(
 	accountsFile AccountsFile,
+	payer AwsPayer,
 	options CommandLineOptions,
 ) (accounts map[string][]AccountEntry, keys []string) {
-	//var accounts map[string][]AccountEntry
+	defer startPhase("aws.accounts", attribute.String("payer", payer.Name))()
+	var err error
 	if *options.taggedAccountsPtr {
-		a, err := getAccountSetsFromAws(a)
+		accounts, err = getAccountSetsFromAws(a)
 		if err != nil {
-			log.Fatalf("[getAwsAccounts] error getting accounts list: %v", err)
+			fatalf("[getAwsAccounts] error getting accounts list: %v", err)
 		}
-		accounts = a
 	} else {
-		accounts = getMapKeyValue(accountsFile.Providers, "aws", "cloud_providers")
+		accounts = getMapKeyValue(accountsFile.Providers, payer.Accounts, "cloud_providers")
+	}
+	if a.ouGroups != "" {
+		a.ouPaths, err = archived("aws", a.batchArchiveName, "organizational-units", a.GetAccountOUPaths)
+		if err != nil {
+			exitf(getAwsExitCode(err), "[getAwsAccounts] error getting organizational units: %v", err)
+		}
+		if a.ouGroups == "replace" {
+			accounts = a.regroupByOU(accounts)
+		}
+	}
+	if a.inactiveAccounts != "" && !*options.taggedAccountsPtr {
+		// (With -taggedaccounts, only active accounts are listed.)
+		a.checkAccountStatuses(accounts)
+	}
+	ourCostCenters := getCostCenterMatcher(accountsFile.Configuration["aws"])
+	if payer.CostCenterTag != "" && !ourCostCenters.isEmpty() && !*options.taggedAccountsPtr {
+		a.checkUntrackedAccounts(accounts, payer, ourCostCenters)
+	}
+	if hasAccountFilter(options) {
+		filtered := make(map[string][]AccountEntry)
+		for group, accountList := range accounts {
+			for _, account := range accountList {
+				if isAccountSelected(options, account.AccountID, group) {
+					filtered[group] = append(filtered[group], account)
+				}
+			}
+		}
+		accounts = filtered
 	}
 	if len(accounts) == 0 {
-		fmt.Println("[getAwsAccounts] Warning:  No AWS accounts found!")
+		fmt.Printf("[getAwsAccounts] Warning:  No AWS accounts found for payer %q!\n", payer.Name)
 	}
 	return accounts, sortedKeys(accounts)
 }
 
+// checkAccountStatuses looks up the accounts' statuses in the AWS
+// Organizations account inventory and warns of each one which is not active
+// (e.g., suspended or closed) but is still in the accounts file.
+func (a *AwsPuller) checkAccountStatuses(accounts map[string][]AccountEntry) {
+	inventory, err := a.getAccountInventory()
+	if err != nil {
+		exitf(getAwsExitCode(err), "[checkAccountStatuses] error getting the accounts list: %v", err)
+	}
+	a.accountStatuses = make(map[string]string)
+	for _, group := range sortedKeys(accounts) {
+		for _, account := range accounts[group] {
+			status := inventory[account.AccountID][AwsMetadataStatus]
+			if status == "" || status == "ACTIVE" {
+				continue
+			}
+			a.accountStatuses[account.AccountID] = status
+			msg := fmt.Sprintf("account %s (group %q) is %s in AWS Organizations but is still in the accounts file",
+				account.AccountID, group, status)
+			log.Printf("[checkAccountStatuses] Warning:  %s", msg)
+			noteExitStatus(ExitWarnings, msg)
+		}
+	}
+}
+
+// regroupByOU regroups the accounts according to the last element of the path
+// of the organizational unit which contains each one.  Accounts which are not
+// found in the organization keep their original group.
+func (a *AwsPuller) regroupByOU(accounts map[string][]AccountEntry) map[string][]AccountEntry {
+	regrouped := make(map[string][]AccountEntry)
+	for group, accountList := range accounts {
+		for _, account := range accountList {
+			target := group
+			ouPath, exists := a.ouPaths[account.AccountID]
+			if !exists {
+				log.Printf("[regroupByOU] Warning: account %s was not found in the organization; keeping group %q",
+					account.AccountID, group)
+			} else {
+				target = getOUName(ouPath)
+			}
+			account.Category = target
+			regrouped[target] = append(regrouped[target], account)
+		}
+	}
+	return regrouped
+}
+
+// getOUName returns the name of the OU at the end of the given OU path.
+func getOUName(ouPath string) string {
+	return ouPath[strings.LastIndex(ouPath, "/")+1:]
+}
+
+// AwsRun holds what the direct AWS pullers of a run share:  the results cache
+// (with -incremental), the deviation baselines, and the rightsizing
+// recommendations collected from the payers which request them.
+type AwsRun struct {
+	options         CommandLineOptions
+	runCache        *RunCache
+	baselines       map[string]float64
+	recommendations []AwsRightsizingRecommendation
+}
+
+// newAwsRun loads the results cache and the deviation baselines for the run.
+func newAwsRun(accountsFile AccountsFile, options CommandLineOptions) *AwsRun {
+	return &AwsRun{
+		options:   options,
+		runCache:  getRunCache(accountsFile, options),
+		baselines: getDeviationBaselines(accountsFile, *options.monthPtr, *options.costTypePtr),
+	}
+}
+
+// newPuller returns the puller for the payer, set up for the run; if the
+// payer requests rightsizing, its recommendations are pulled (for the
+// savings-opportunity column) and collected.
+func (r *AwsRun) newPuller(payer AwsPayer) *AwsPuller {
+	awsPuller := NewAwsPuller(payer, *r.options.debugPtr)
+	awsPuller.refreshAccounts = *r.options.refreshAccountsPtr
+	awsPuller.runCache = r.runCache
+	awsPuller.baselines = r.baselines
+	if payer.Rightsizing {
+		r.recommendations = append(r.recommendations, awsPuller.getRightsizingSavings()...)
+	}
+	return awsPuller
+}
+
 func (a *AwsPuller) pullAwsByAccount(
 	accounts map[string][]AccountEntry,
 	sortedAccountKeys []string,
+	payer AwsPayer,
 	options CommandLineOptions,
 	reportFile *os.File,
-) (sheetData []*sheets.RowData) {
+	drilldown *csv.Writer,
+	emit func(rows []*sheets.RowData),
+) {
 	if *options.monthPtr == "" || *options.costTypePtr == "" {
-		log.Fatal("[pullAwsByAccount] missing month or cost type (use --month=yyyy-mm, --costtype=type)")
+		fatalf("[pullAwsByAccount] missing month or cost type (use --month=yyyy-mm, --costtype=type)")
 	}
 	for _, group := range sortedAccountKeys {
 		accountList := accounts[group]
@@ -233,48 +840,285 @@ func (a *AwsPuller) pullAwsByAccount(
 			log.Printf("[pullAwsByAccount] Warning: no accounts found in group %q!", group)
 		}
 		for _, account := range accountList {
+			cacheKey := payer.Name + "/" + account.AccountID
+			if cachedAccount, cached := a.runCache.get(cacheKey); cached && !isMonthOpen(*options.monthPtr) {
+				log.Printf("[pullAwsByAccount] using cached data for account %s (group %s)\n", account.AccountID, group)
+				for _, finding := range cachedAccount.Findings {
+					a.reportFinding(account, finding, *options.costTypePtr, reportFile, drilldown)
+				}
+				emit(cachedAccount.Rows)
+				continue
+			}
 			log.Printf("[pullAwsByAccount] pulling data for account %s (group %s)\n", account.AccountID, group)
-			rowData, _, err := a.pullAwsAccount(
+			rows, findings, err := a.pullAwsAccount(
 				account,
 				group,
 				*options.monthPtr,
 				*options.costTypePtr,
 				reportFile,
+				drilldown,
 			)
 			if err != nil {
-				log.Fatalf("[pullAwsByAccount] error pulling data: %v", err)
+				exitf(getAwsExitCode(err), "[pullAwsByAccount] error pulling data: %v", err)
+			}
+			for _, rowData := range rows {
+				// When pulling from multiple payers, the rows are merged into a
+				// single sheet, so add a column identifying the payer.
+				if payer.Name != "" {
+					rowData.Values = append(rowData.Values, newStringCell(payer.Name))
+				}
 			}
-			sheetData = append(sheetData, rowData)
+			a.runCache.set(cacheKey, &CachedAccount{Rows: rows, Findings: findings})
+			emit(rows)
+		}
+	}
+}
+
+// AwsDetailColumn describes one of the columns which follow the normalized
+// costs in the rows produced by pullAwsAccount().
+type AwsDetailColumn struct {
+	Name    string
+	Numeric bool
+}
+
+// getDetailColumns returns the columns which pullAwsAccount() adds to the
+// normalized rows, in order, according to the puller's configuration.
+func (a *AwsPuller) getDetailColumns() (columns []AwsDetailColumn) {
+	if a.purchaseTypeBreakdown {
+		for _, bucket := range AwsPurchaseTypeBuckets {
+			columns = append(columns, AwsDetailColumn{Name: "EC2 " + bucket, Numeric: true})
+		}
+	}
+	if a.rightsizing {
+		columns = append(columns, AwsDetailColumn{Name: "Savings Opportunity", Numeric: true})
+	}
+	if a.dataTransferBreakdown {
+		for _, bucket := range AwsDataTransferBuckets {
+			columns = append(columns, AwsDetailColumn{Name: "Data Transfer " + bucket, Numeric: true})
 		}
 	}
+	if a.ouGroups != "" {
+		columns = append(columns, AwsDetailColumn{Name: "OU Path"})
+	}
+	if a.costCategory != "" {
+		columns = append(columns, AwsDetailColumn{Name: a.costCategory})
+	}
 	return
 }
 
-func writeAwsTags(awsPuller *AwsPuller, options CommandLineOptions) {
+// addDetailColumnNames adds the names of the puller's detail columns to the
+// lists of tag (non-numeric) and detail (numeric) columns of the cost grid,
+// along with the account tags which are added as columns.
+func (a *AwsPuller) addDetailColumnNames(tagColumns []string, detailColumns []string) ([]string, []string) {
+	for _, column := range a.getDetailColumns() {
+		if column.Numeric && !slices.Contains(detailColumns, column.Name) {
+			detailColumns = append(detailColumns, column.Name)
+		} else if !column.Numeric && !slices.Contains(tagColumns, column.Name) {
+			tagColumns = append(tagColumns, column.Name)
+		}
+	}
+	for _, tag := range a.accountTags {
+		if !slices.Contains(tagColumns, tag) {
+			tagColumns = append(tagColumns, tag)
+		}
+	}
+	return tagColumns, detailColumns
+}
+
+// addRowsToCostCells adds the data from the normalized rows produced by the
+// direct AWS pull to the cost grid used for the Cloudability and IBM Cloud
+// data:  the normalized costs (see awsNormalizedColumns) become the cost
+// columns of their categories (see CategoryTaxonomy), the numeric detail columns are kept as details, and the others
+// (such as the Cost Category value of split rows, which are combined) as
+// tags.  The account names and the payer account ID are taken from AWS
+// Organizations (the payer's name is used if its ID is not available), as
+// are the cost center and the other configured account tags.  Accounts which are not in the accounts file (e.g., with -taggedaccounts)
+// are added to the metadata.
+func (a *AwsPuller) addRowsToCostCells(
+	rows []*sheets.RowData,
+	payerName string,
+	accountMetadata map[string]*AccountMetadata,
+	costCells map[string]map[string]float64,
+	columnHeadsSet map[string]struct{},
+	metadata map[string]providerAccountMetadata,
+) {
+	detailColumns := a.getDetailColumns()
+	organization := a.getOrganizationInfo()
+	var inventory map[string]map[string]string
+	if a.costCenterTag != "" || len(a.accountTags) > 0 {
+		var err error
+		inventory, err = a.getAccountInventory()
+		if err != nil {
+			exitf(getAwsExitCode(err), "[addRowsToCostCells] error getting the account tags: %v", err)
+		}
+	}
+	for _, row := range rows {
+		group := *row.Values[0].UserEnteredValue.StringValue
+		rawID := *row.Values[2].UserEnteredValue.StringValue
+		accountID, _ := getCanonicalAccountId("Amazon", rawID)
+		entry := accountMetadata[accountID]
+		if entry == nil {
+			entry = &AccountMetadata{AccountId: rawID, CloudProvider: "Amazon"}
+			accountMetadata[accountID] = entry
+		}
+		entry.Group = group // The group may have been replaced by the OU
+		entry.DataFound = true
+		entry.Note = cmp.Or(row.Values[2].Note, entry.Note)
+
+		if _, exists := costCells[accountID]; !exists {
+			costCells[accountID] = make(map[string]float64)
+		}
+		for i, column := range awsNormalizedColumns {
+			category := categoryTaxonomy.getCategory("aws", column)
+			costCells[accountID][category] += *row.Values[4+i].UserEnteredValue.NumberValue
+			columnHeadsSet[category] = struct{}{}
+		}
+
+		md, exists := metadata[accountID]
+		if !exists {
+			md = providerAccountMetadata{
+				AccountName:    organization.AccountNames[rawID],
+				CloudProvider:  entry.CloudProvider,
+				Date:           *row.Values[1].UserEnteredValue.StringValue,
+				PayerAccountId: cmp.Or(organization.PayerAccountId, payerName),
+				CostCenter:     inventory[rawID][a.costCenterTag],
+				Currency:       reportingCurrency,
+				Details:        make(map[string]float64),
+				Tags:           make(map[string][]string),
+			}
+		}
+		for i, column := range detailColumns {
+			value := row.Values[13+i].UserEnteredValue
+			if column.Numeric {
+				md.Details[column.Name] += *value.NumberValue
+			} else if !slices.Contains(md.Tags[column.Name], *value.StringValue) {
+				md.Tags[column.Name] = append(md.Tags[column.Name], *value.StringValue)
+			}
+		}
+		for _, tag := range a.accountTags {
+			if value := inventory[rawID][tag]; value != "" && !slices.Contains(md.Tags[tag], value) {
+				md.Tags[tag] = append(md.Tags[tag], value)
+			}
+		}
+		metadata[accountID] = md
+	}
+}
+
+// getPulledDirectly returns the keys of the given provider's accounts which
+// were pulled directly from the provider (rather than from Cloudability) and
+// for which data was found, in order.
+func getPulledDirectly(accountMetadata map[string]*AccountMetadata, provider string) (keys []string) {
+	for _, key := range sortedKeys(accountMetadata) {
+		entry := accountMetadata[key]
+		if entry.PulledDirectly && entry.DataFound && getCanonicalProvider(entry.CloudProvider) == provider {
+			keys = append(keys, key)
+		}
+	}
+	return
+}
+
+// pullDetailedAwsAccounts pulls the data for the AWS accounts which are marked
+// to be pulled directly, when Cloudability is also used, and adds their
+// normalized costs (see awsNormalizedColumns) to the cost grid, so that the
+// two sources are merged into a single output.  The rightsizing
+// recommendations of the payers which request them are returned, for the
+// recommendations sheet.
+func pullDetailedAwsAccounts(
+	accountsFile AccountsFile,
+	options CommandLineOptions,
+	reportFile *os.File,
+	accountMetadata map[string]*AccountMetadata,
+	costCells map[string]map[string]float64,
+	columnHeadsSet map[string]struct{},
+	metadata map[string]providerAccountMetadata,
+	tagColumns []string,
+) (_ []string, detailColumns []string, _ []AwsRightsizingRecommendation) {
+	run := newAwsRun(accountsFile, options)
+	// Save the accounts which were pulled, even if a later one fails.
+	defer run.runCache.save()
+	for _, payer := range getAwsPayers(accountsFile.Configuration["aws"]) {
+		awsPuller := run.newPuller(payer)
+		tagColumns, detailColumns = awsPuller.addDetailColumnNames(tagColumns, detailColumns)
+		accounts, _ := awsPuller.getAwsAccounts(accountsFile, payer, options)
+		detailed := make(map[string][]AccountEntry)
+		for group, accountList := range accounts {
+			for _, account := range accountList {
+				key, _ := getCanonicalAccountId("Amazon", account.AccountID)
+				if entry := accountMetadata[key]; entry != nil && entry.PulledDirectly {
+					detailed[group] = append(detailed[group], account)
+				}
+			}
+		}
+		awsPuller.pullAwsByAccount(detailed, sortedKeys(detailed), payer, options, reportFile, nil,
+			func(rows []*sheets.RowData) {
+				awsPuller.addRowsToCostCells(rows, payer.Name, accountMetadata, costCells, columnHeadsSet, metadata)
+			})
+	}
+	return tagColumns, detailColumns, run.recommendations
+}
+
+func writeAwsTags(awsPuller *AwsPuller, payer AwsPayer, options CommandLineOptions) {
 	accountsFile, err := loadAccountsFile(*options.accountsFilePtr)
 	if err != nil {
-		log.Fatalf("[writeAwsTags] error getting accounts list: %v", err)
+		exitf(getAwsExitCode(err), "[writeAwsTags] error getting accounts list: %v", err)
+	}
+	accounts := getMapKeyValue(accountsFile.Providers, payer.Accounts, "cloud_providers")
+	changes, err := awsPuller.PlanAwsTags(accounts)
+	if err != nil {
+		exitf(getAwsExitCode(err), "[writeAwsTags] error reading account tags: %v", err)
 	}
-	accounts := getMapKeyValue(accountsFile.Providers, "aws", "cloud_providers")
-	err = awsPuller.WriteAwsTags(accounts)
+	staleChanges, err := awsPuller.PlanStaleAwsTags(accounts)
 	if err != nil {
-		log.Fatalf("[writeAwsTags] error writing account tag: %v", err)
+		exitf(getAwsExitCode(err), "[writeAwsTags] error reading organization account tags: %v", err)
+	}
+	if *options.untagStalePtr {
+		changes = append(changes, staleChanges...)
+	} else {
+		for _, change := range staleChanges {
+			fmt.Printf("Warning:  account %s is tagged %s = %q but is not in the accounts file "+
+				"(use -untag-stale to remove the tag)\n", change.AccountID, change.Key, change.OldValue)
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Printf("All account tags are up to date for payer %q.\n", payer.Name)
+		return
+	}
+	fmt.Printf("Planned tag changes for payer %q:\n", payer.Name)
+	for _, change := range changes {
+		fmt.Printf("  %s\n", change)
+	}
+	if !*options.applyPtr {
+		fmt.Println("Not applied; re-run with -apply to write these tags.")
+		return
+	}
+	err = awsPuller.ApplyAwsTags(changes)
+	if err != nil {
+		exitf(getAwsExitCode(err), "[writeAwsTags] error writing account tag: %v", err)
 	}
 }
 
 func getCsvFile(options CommandLineOptions) *os.File {
 	outfile, err := os.Create(*options.csvfilePtr)
 	if err != nil {
-		log.Fatalf("[getCsvFile] error creating output file: %v", err)
+		exitf(ExitOutputFailure, "[getCsvFile] error creating output file: %v", err)
 	}
 	log.Printf("[getCsvFile] using csv output file %s\n", *options.csvfilePtr)
 	return outfile
 }
 
+func getDrilldownFile(options CommandLineOptions) *os.File {
+	drilldownFile, err := os.Create(*options.drilldownFilePtr)
+	if err != nil {
+		exitf(ExitOutputFailure, "[getDrilldownFile] error creating drill-down file: %v", err)
+	}
+	log.Printf("[getDrilldownFile] using drill-down output file %s\n", *options.drilldownFilePtr)
+	return drilldownFile
+}
+
 func getReportFile(options CommandLineOptions) *os.File {
 	reportFile, err := os.Create(*options.reportFilePtr)
 	if err != nil {
-		log.Fatalf("[getReportFile] error creating report file: %v", err)
+		exitf(ExitOutputFailure, "[getReportFile] error creating report file: %v", err)
 	}
 	log.Printf("[getReportFile] using report output file %s\n", *options.reportFilePtr)
 	return reportFile
@@ -295,44 +1139,226 @@ func (a *AwsPuller) pullAwsAccount(
 	month string,
 	costType string,
 	reportFile *os.File,
-) (normalized *sheets.RowData, total float64, err error) {
-	result, err := a.PullData(account.AccountID, month, costType)
+	drilldown *csv.Writer,
+) (rows []*sheets.RowData, findings []AccountFinding, err error) {
+	defer startPhase("aws.pull_account", attribute.String("account", account.AccountID),
+		attribute.String("group", group))()
+	results, err := a.PullDataByCostCategory(account.AccountID, month, costType)
 	if err != nil {
-		log.Fatalf("[pullAwsAccount] error pulling data from AWS for account %s: %v", account.AccountID, err)
+		exitf(getAwsExitCode(err), "[pullAwsAccount] error pulling data from AWS for account %s: %v", account.AccountID, err)
 	}
-	total, err = a.CheckResponseConsistency(account, result)
-	if err != nil {
-		log.Printf(
-			"[pullAwsAccount] consistency check failed on response for account data %s: %v",
-			account.AccountID,
-			err,
-		)
-		writeReport(reportFile, account.AccountID+": "+err.Error())
+	// The consistency check applies to the account as a whole, so combine
+	// the Cost Category splits, if any.
+	result := make(map[string]float64)
+	for _, categoryResults := range results {
+		for service, value := range categoryResults {
+			result[service] += value
+		}
+	}
+	if _, err := a.CheckResponseConsistency(account, result); err != nil {
+		findings = append(findings, AccountFinding{Message: err.Error(), CheckFailed: true, Drilldown: true})
+	}
+	if account.Buckets != nil {
+		for _, problem := range a.checkBucketConsistency(group, month, account, result) {
+			findings = append(findings, AccountFinding{Message: problem, CheckFailed: true})
+		}
+	}
+	if residual, exists := a.residuals[account.AccountID]; exists {
+		findings = append(findings, AccountFinding{
+			Message: fmt.Sprintf("service costs differ from the AWS total by a residual of %.2f", residual),
+		})
+		delete(a.residuals, account.AccountID)
+	}
+	if a.ouGroups == "check" {
+		if ouName := getOUName(a.ouPaths[account.AccountID]); ouName != group {
+			findings = append(findings, AccountFinding{
+				Message: fmt.Sprintf("group %q does not match organizational unit %q (%s)",
+					group, ouName, a.ouPaths[account.AccountID]),
+			})
+		}
+	}
+	for _, finding := range findings {
+		a.reportFinding(account, finding, costType, reportFile, drilldown)
+	}
+	if a.costCategory == "" || len(results) == 0 {
+		results = map[string]map[string]float64{"": result}
+	}
+	var purchaseTypeResults map[string]map[string]float64
+	if a.purchaseTypeBreakdown {
+		purchaseTypeResults, err = a.PullPurchaseTypeCosts(account.AccountID, month, costType)
+		if err != nil {
+			exitf(getAwsExitCode(err), "[pullAwsAccount] error pulling purchase type data from AWS for account %s: %v",
+				account.AccountID, err)
+		}
+	}
+	var dataTransferResults map[string]map[string]float64
+	if a.dataTransferBreakdown {
+		dataTransferResults, err = a.PullDataTransferCosts(account.AccountID, month, costType)
+		if err != nil {
+			exitf(getAwsExitCode(err), "[pullAwsAccount] error pulling data transfer data from AWS for account %s: %v",
+				account.AccountID, err)
+		}
+	}
+	for _, categoryValue := range sortedKeys(results) {
+		normalized, err := a.NormalizeResponse(group, month, account.AccountID, results[categoryValue])
+		if err != nil {
+			exitf(ExitProviderError, "[pullAwsAccount] error normalizing data from AWS for account %s: %v", account.AccountID, err)
+		}
+		if status, exists := a.accountStatuses[account.AccountID]; exists && a.inactiveAccounts == "annotate" {
+			normalized.Values[2].Note = status + " in AWS Organizations"
+		}
+		// When requested, add columns splitting the "machines" value by
+		// EC2 purchase option.
+		if a.purchaseTypeBreakdown {
+			for _, bucket := range AwsPurchaseTypeBuckets {
+				normalized.Values = append(normalized.Values, newNumberCell(purchaseTypeResults[categoryValue][bucket]))
+			}
+		}
+		// The savings opportunity applies to the account as a whole, so
+		// report it only on the account's first row.
+		if a.rightsizing {
+			normalized.Values = append(normalized.Values, newNumberCell(a.savings[account.AccountID]))
+			delete(a.savings, account.AccountID)
+		}
+		// Likewise, split the "dataTransfer" value by direction.
+		if a.dataTransferBreakdown {
+			for _, bucket := range AwsDataTransferBuckets {
+				normalized.Values = append(normalized.Values, newNumberCell(dataTransferResults[categoryValue][bucket]))
+			}
+		}
+		if a.ouGroups != "" {
+			normalized.Values = append(normalized.Values, newStringCell(a.ouPaths[account.AccountID]))
+		}
+		// When splitting by Cost Category, add a column with the value.
+		if a.costCategory != "" {
+			normalized.Values = append(normalized.Values, newStringCell(categoryValue))
+		}
+		rows = append(rows, normalized)
+	}
+	return
+}
+
+// AccountFinding is a problem found by the checks on an account's data (see
+// pullAwsAccount()).  Failures of the consistency checks (CheckFailed) affect
+// the exit status, and a failure of the total's check (Drilldown) calls for
+// the resource drill-down.  The findings are cached with the account's rows,
+// so that they are reported again when the rows are reused.
+type AccountFinding struct {
+	Message     string `json:"message"`
+	CheckFailed bool   `json:"checkFailed,omitempty"`
+	Drilldown   bool   `json:"drilldown,omitempty"`
+}
+
+// reportFinding logs the finding for the account, writes it to the report,
+// notes a failed check for the exit status, and, if it calls for it, writes
+// the account's resource costs to the drill-down file (if there is one).
+func (a *AwsPuller) reportFinding(
+	account AccountEntry,
+	finding AccountFinding,
+	costType string,
+	reportFile *os.File,
+	drilldown *csv.Writer,
+) {
+	writeReport(reportFile, account.AccountID+": "+finding.Message)
+	if !finding.CheckFailed {
+		log.Printf("[pullAwsAccount] account %s: %s", account.AccountID, finding.Message)
+		return
+	}
+	log.Printf("[pullAwsAccount] consistency check failed for account %s: %s", account.AccountID, finding.Message)
+	noteExitStatus(ExitConsistencyFailure, "consistency check failed for account "+account.AccountID+": "+
+		finding.Message)
+	if finding.Drilldown && drilldown != nil {
+		a.writeResourceDrilldown(drilldown, account, costType)
 	}
-	normalized, err = a.NormalizeResponse(group, month, account.AccountID, result)
+}
+
+// getRightsizingSavings pulls the rightsizing recommendations for the payer,
+// totals the estimated savings for each account for use in the
+// savings-opportunity column, and returns the recommendations.
+func (a *AwsPuller) getRightsizingSavings() []AwsRightsizingRecommendation {
+	defer startPhase("aws.rightsizing")()
+	log.Println("[getRightsizingSavings] pulling rightsizing recommendations")
+	recommendations, err := archived("aws", a.batchArchiveName, "rightsizing", a.PullRightsizingRecommendations)
 	if err != nil {
-		log.Fatalf("[pullAwsAccount] error normalizing data from AWS for account %s: %v", account.AccountID, err)
+		exitf(getAwsExitCode(err), "[getRightsizingSavings] error pulling rightsizing recommendations: %v", err)
+	}
+	a.savings = make(map[string]float64)
+	for _, rec := range recommendations {
+		a.savings[rec.AccountID] += rec.EstimatedMonthlySavings
+	}
+	return recommendations
+}
+
+// getSheetFromRecommendations converts the rightsizing recommendations into
+// sheet rows, with a header row, ordered by account and decreasing savings.
+func getSheetFromRecommendations(recommendations []AwsRightsizingRecommendation) (output []*sheets.RowData) {
+	sort.SliceStable(recommendations, func(i, j int) bool {
+		if recommendations[i].AccountID != recommendations[j].AccountID {
+			return recommendations[i].AccountID < recommendations[j].AccountID
+		}
+		return recommendations[i].EstimatedMonthlySavings > recommendations[j].EstimatedMonthlySavings
+	})
+	output = append(output, newHeaderRow([]string{"Account ID", "Resource ID", "Instance Name", "Action",
+		"Current Type", "Target Type", "Estimated Monthly Savings"}))
+	for _, rec := range recommendations {
+		output = append(output, &sheets.RowData{Values: []*sheets.CellData{
+			newStringCell(rec.AccountID),
+			newStringCell(rec.ResourceID),
+			newStringCell(rec.InstanceName),
+			newStringCell(rec.Action),
+			newStringCell(rec.CurrentType),
+			newStringCell(rec.TargetType),
+			newNumberCell(rec.EstimatedMonthlySavings),
+		}})
 	}
 	return
 }
 
-func writeCsvFromSheet(outfile *os.File, data []*sheets.RowData) error {
+// awsDrilldownLimit is the number of resources reported for each account in
+// the resource drill-down file.
+const awsDrilldownLimit = 25
+
+// awsDrilldownHeader is the header row of the resource drill-down file.
+var awsDrilldownHeader = []string{"Account ID", "Account Name", "Resource ID", "Cost"}
+
+// writeResourceDrilldown pulls the most expensive resources for the given
+// account and writes them to the drill-down CSV.  Errors are reported but are
+// not fatal, since the drill-down is only an aid to explaining a deviation.
+func (a *AwsPuller) writeResourceDrilldown(drilldown *csv.Writer, account AccountEntry, costType string) {
+	log.Printf("[writeResourceDrilldown] pulling resource costs for account %s", account.AccountID)
+	resources, err := archived("aws", account.AccountID, "resources", func() ([]AwsResourceCost, error) {
+		return a.PullResourceCosts(account.AccountID, costType, awsDrilldownLimit)
+	})
+	if err != nil {
+		log.Printf("[writeResourceDrilldown] error pulling resource costs for account %s: %v", account.AccountID, err)
+		return
+	}
+	for _, resource := range resources {
+		err = drilldown.Write([]string{
+			account.AccountID,
+			account.Description,
+			resource.ResourceID,
+			strconv.FormatFloat(resource.Cost, 'f', 2, 64),
+		})
+		if err != nil {
+			log.Printf("[writeResourceDrilldown] error writing drill-down data to file: %v", err)
+			return
+		}
+	}
+}
+
+func writeCsvFromSheet(outfile io.Writer, data []*sheets.RowData) error {
 	writer := csv.NewWriter(outfile)
 	defer writer.Flush()
+	return writeCsvRows(writer, data)
+}
+
+// writeCsvRows writes the sheet rows with the given CSV writer.
+func writeCsvRows(writer *csv.Writer, data []*sheets.RowData) error {
 	for _, row := range data {
 		rowData := make([]string, len(row.Values))
 		for i, cell := range row.Values {
-			var cellData string
-			if cell.UserEnteredValue.StringValue != nil {
-				cellData = *cell.UserEnteredValue.StringValue
-			} else if cell.UserEnteredValue.FormulaValue != nil {
-				cellData = *cell.UserEnteredValue.FormulaValue
-			} else if cell.UserEnteredValue.NumberValue != nil {
-				cellData = fmt.Sprintf("%f", *cell.UserEnteredValue.NumberValue)
-			} else {
-				log.Fatalf("Unexpected sheet cell value:  %v", cell.UserEnteredValue)
-			}
-			rowData[i] = cellData
+			rowData[i] = getCellText(cell, row)
 		}
 		err := writer.Write(rowData)
 		if err != nil {
@@ -343,6 +1369,58 @@ func writeCsvFromSheet(outfile *os.File, data []*sheets.RowData) error {
 	return nil
 }
 
+// csvDecimalPlaces is the number of decimal places with which amounts are
+// written to CSV files, set from the "decimal_places" key of the "csv"
+// configuration section by configureCsvFormat(); if it is not set, amounts
+// are written with the number of places to which they are rounded (see
+// ReconciliationPolicy).
+var csvDecimalPlaces *int
+
+// configureCsvFormat sets the CSV number format from the given configuration
+// section (which may be nil).
+func configureCsvFormat(config Configuration) {
+	if getMapKeyValue(config, "decimal_places", "") != nil {
+		places := getMapKeyInt(config, "decimal_places", "")
+		csvDecimalPlaces = &places
+	}
+}
+
+// getCellText returns the text of a cell in the given sheet row, as written to
+// the CSV file, matching what the spreadsheet displays:  the "TOTAL" formulas
+// (see getTotalsFormula) are replaced by their values, and other formulas are
+// returned as such; amounts are formatted with the configured number of
+// decimal places (see csvDecimalPlaces), while other numbers (those with a
+// "NUMBER" format, such as exchange rates) are written in full.
+func getCellText(cell *sheets.CellData, row *sheets.RowData) string {
+	places := reconciliation.DecimalPlaces
+	if csvDecimalPlaces != nil {
+		places = *csvDecimalPlaces
+	}
+	return formatCellText(cell, row, places)
+}
+
+// formatCellText returns the text of a cell in the given sheet row (see
+// getCellText), with amounts formatted with the given number of decimal
+// places (or in full, if it is negative).
+func formatCellText(cell *sheets.CellData, row *sheets.RowData, places int) string {
+	if cell.UserEnteredFormat != nil && cell.UserEnteredFormat.NumberFormat != nil &&
+		cell.UserEnteredFormat.NumberFormat.Type == "NUMBER" {
+		places = -1
+	}
+	if cell.UserEnteredValue.StringValue != nil {
+		return *cell.UserEnteredValue.StringValue
+	} else if cell.UserEnteredValue.FormulaValue != nil {
+		if total, ok := getTotalsFormulaValue(*cell.UserEnteredValue.FormulaValue, row); ok {
+			return strconv.FormatFloat(total, 'f', max(places, -1), 64)
+		}
+		return *cell.UserEnteredValue.FormulaValue
+	} else if cell.UserEnteredValue.NumberValue != nil {
+		return strconv.FormatFloat(*cell.UserEnteredValue.NumberValue, 'f', max(places, -1), 64)
+	}
+	fatalf("Unexpected sheet cell value:  %v", cell.UserEnteredValue)
+	return ""
+}
+
 func writeReport(outfile *os.File, data string) {
 	_, err := outfile.WriteString(data + "\n")
 	if err != nil {
@@ -350,18 +1428,108 @@ func writeReport(outfile *os.File, data string) {
 	}
 }
 
+// loadAccountsFile reads the accounts file and merges into it the
+// "cloud_providers" sections of any files named by its "include" list.  Include
+// entries are glob patterns, relative to the directory of the including file;
+// an account ID which appears in more than one file is an error.  The accounts
+// file may also be a remote source (see getAccountsSource()).
 func loadAccountsFile(accountsFileName string) (accountsFile AccountsFile, err error) {
-	yamlFile, err := os.ReadFile(accountsFileName)
+	if strings.HasPrefix(accountsFileName, "git+") {
+		checkout, path, err := checkoutAccountsRepo(accountsFileName)
+		if err != nil {
+			return accountsFile, fmt.Errorf("[loadAccountsFile] error fetching accounts file: %v", err)
+		}
+		defer func() { _ = os.RemoveAll(checkout) }()
+		return loadAccountsFile(filepath.Join(checkout, path))
+	}
+	accountsFile, err = readAccountsFile(accountsFileName)
+	if err != nil {
+		return
+	}
+	for section, config := range accountsFile.Configuration {
+		expanded, err := expandEnvReferences(config)
+		if err != nil {
+			return accountsFile, fmt.Errorf("[loadAccountsFile] in the %q configuration section: %v", section, err)
+		}
+		accountsFile.Configuration[section] = expanded.(Configuration)
+	}
+	sources := make(map[string]map[string]string) // provider -> account ID -> file
+	addSources := func(providers map[string]Team, fileName string) error {
+		for provider, groups := range providers {
+			if sources[provider] == nil {
+				sources[provider] = make(map[string]string)
+			}
+			for _, entries := range groups {
+				for _, entry := range entries {
+					if other, exists := sources[provider][entry.AccountID]; exists && other != fileName {
+						return fmt.Errorf("[loadAccountsFile] %s account %s appears in both %s and %s",
+							provider, entry.AccountID, other, fileName)
+					}
+					sources[provider][entry.AccountID] = fileName
+				}
+			}
+		}
+		return nil
+	}
+	if err = addSources(accountsFile.Providers, accountsFileName); err != nil {
+		return
+	}
+	for _, pattern := range accountsFile.Include {
+		fileNames, err := getIncludedFiles(accountsFileName, pattern)
+		if err != nil {
+			return accountsFile, fmt.Errorf("[loadAccountsFile] bad include pattern %q: %v", pattern, err)
+		}
+		if len(fileNames) == 0 {
+			log.Printf("[loadAccountsFile] warning: include pattern %q matches no files", pattern)
+		}
+		for _, fileName := range fileNames {
+			included, err := readAccountsFile(fileName)
+			if err != nil {
+				return accountsFile, err
+			}
+			if len(included.Configuration) > 0 || len(included.Include) > 0 {
+				return accountsFile, fmt.Errorf(
+					"[loadAccountsFile] included file %s may contain only a \"cloud_providers\" section", fileName)
+			}
+			if err = addSources(included.Providers, fileName); err != nil {
+				return accountsFile, err
+			}
+			for provider, groups := range included.Providers {
+				if accountsFile.Providers[provider] == nil {
+					accountsFile.Providers[provider] = make(Team)
+				}
+				for group, entries := range groups {
+					accountsFile.Providers[provider][group] = append(accountsFile.Providers[provider][group], entries...)
+				}
+			}
+		}
+	}
+	return
+}
+
+// readAccountsFile reads and decodes a single accounts file.
+func readAccountsFile(accountsFileName string) (accountsFile AccountsFile, err error) {
+	yamlFile, err := getAccountsSource(accountsFileName)
 	if err != nil {
 		return accountsFile, fmt.Errorf("[loadAccountsFile] error loading accounts file: %v", err)
 	}
+	if isSopsEncrypted(yamlFile) {
+		yamlFile, err = sopsDecrypt(accountsFileName, yamlFile)
+		if err != nil {
+			return accountsFile, fmt.Errorf("[loadAccountsFile] error decrypting accounts file %s: %v", accountsFileName, err)
+		}
+	}
+	yamlFile, err = convertAccountsFileToYaml(accountsFileName, yamlFile)
+	if err != nil {
+		return accountsFile, fmt.Errorf("[loadAccountsFile] error decoding accounts file %s: %v", accountsFileName, err)
+	}
 	accountsFile = AccountsFile{
 		Configuration: make(map[string]Configuration),
 		Providers:     make(map[string]Team),
 	}
-	err = yaml.Unmarshal(yamlFile, accountsFile)
+	err = yaml.Unmarshal(yamlFile, &accountsFile)
 	if err != nil {
-		return accountsFile, fmt.Errorf("[loadAccountsFile] error unmarshalling accounts file: %v", err)
+		return accountsFile, fmt.Errorf("[loadAccountsFile] error unmarshalling accounts file %s: %v", accountsFileName, err)
 	}
 	// set category manually on all entries
 	for _, group := range accountsFile.Providers {
@@ -376,9 +1544,9 @@ func loadAccountsFile(accountsFileName string) (accountsFile AccountsFile, err e
 
 func getAccountSetsFromAws(awsPuller *AwsPuller) (map[string][]AccountEntry, error) {
 	log.Println("[getAccountSetsFromAws] initiating account metadata pull")
-	metadata, err := awsPuller.GetAwsAccountMetadata()
+	metadata, err := archived("aws", awsPuller.batchArchiveName, "accounts", awsPuller.GetCachedAwsAccountMetadata)
 	if err != nil {
-		log.Fatalf("[getAccountSetsFromAws] error getting accounts list from metadata: %v", err)
+		exitf(getAwsExitCode(err), "[getAccountSetsFromAws] error getting accounts list from metadata: %v", err)
 	}
 	log.Println("[getAccountSetsFromAws] processing account metadata pull")
 	accounts := make(map[string][]AccountEntry)
@@ -414,46 +1582,96 @@ func getAccountSetsFromAws(awsPuller *AwsPuller) (map[string][]AccountEntry, err
 // AccountMetadata is an object which encapsulates the information from the
 // accounts YAML file which is associated with a given account.
 type AccountMetadata struct {
-	AccountId     string
-	Category      string
-	CloudProvider string
-	DataFound     bool
-	Description   string
-	Group         string
+	AccountId      string
+	Category       string
+	CloudProvider  string
+	DataFound      bool
+	Description    string
+	Excluded       bool // Excluded from this run by the -account or -group filter
+	PulledDirectly bool // Pulled from the provider directly, rather than from Cloudability
+	Group          string
+	Buckets        map[string]BucketThreshold
+	Note           string // Shown as a note on the account's row (e.g., its status, if not active)
+	Labels         map[string]string
+	Notes          string // From the accounts file; shown in the "Notes" column and on the account's row
 }
 
 var accountIdPatterns = map[string]*regexp.Regexp{
 	"Amazon": regexp.MustCompile(`^([0-9]{4})-?([0-9]{4})-?([0-9]{4})$`),                                         // e.g., "5901-8385-7305"
 	"Azure":  regexp.MustCompile(`^([0-9a-f]{8})-?([0-9a-f]{4})-?([0-9a-f]{4})-?([0-9a-f]{4})-?([0-9a-f]{12})$`), // e.g., "b0ad4737-8299-4c0a-9dd5-959cbcf8d81c"
+	"GCP":    regexp.MustCompile(`^([a-z][a-z0-9-]{4,28}[a-z0-9]|[0-9]{6,})$`),                                   // Project ID or number, e.g., "my-project-123"
+}
+
+// providerAliases maps the names by which the cloud providers are known in
+// the accounts file and the provider APIs (in lower case) to the names which
+// Cloudability uses.
+var providerAliases = map[string]string{
+	"amazon":       "Amazon",
+	"aws":          "Amazon",
+	"azure":        "Azure",
+	"microsoft":    "Azure",
+	"gcp":          "GCP",
+	"google":       "GCP",
+	"google cloud": "GCP",
+	"ibm":          "IBM",
+	"ibm cloud":    "IBM",
+	"ibmcloud":     "IBM",
+}
+
+// getCanonicalProvider returns the Cloudability name for the given cloud
+// provider name, or the name itself, if it is not a known alias.
+func getCanonicalProvider(provider string) string {
+	if canonical, exists := providerAliases[strings.ToLower(provider)]; exists {
+		return canonical
+	}
+	return provider
+}
+
+// getCanonicalAccountId returns the account ID in the format that
+// Cloudability uses for the (canonical) provider:  Amazon and Azure IDs are
+// hyphenated (for historical compatibility, IDs without hyphens are accepted);
+// GCP project IDs and IBM Cloud account IDs are case-insensitive, so they are
+// folded to lower case.  If the provider has a fixed ID format and the ID
+// does not match it, it returns false.
+func getCanonicalAccountId(provider string, accountId string) (string, bool) {
+	if provider == "GCP" || provider == "IBM" {
+		accountId = strings.ToLower(accountId)
+	}
+	translate, exists := accountIdPatterns[provider]
+	if !exists {
+		return accountId, true
+	}
+	matches := translate.FindStringSubmatch(accountId)
+	if matches == nil {
+		return accountId, false
+	}
+	return strings.Join(matches[1:], "-"), true
 }
 
 // getAccountMetadata takes the hierarchy from the accounts YAML file and
 // inverts it, so that, given an account ID, we can find the cloud provider
-// and group that the account is associated with.
-func getAccountMetadata(providers map[string]Team) (metadata map[string]*AccountMetadata) {
+// and group that the account is associated with.  The keys which list the
+// accounts of the AWS payers (e.g., "aws-<payer>") are all Amazon.
+func getAccountMetadata(providers map[string]Team, awsAccountsKeys []string) (metadata map[string]*AccountMetadata) {
 	metadata = make(map[string]*AccountMetadata)
 	for provider, groups := range providers {
-		if provider == "aws" { // Convert for historical compatibility
+		// Use the Cloudability provider names (e.g., "aws" is "Amazon", for
+		// historical compatibility).
+		if slices.Contains(awsAccountsKeys, provider) {
 			provider = "Amazon"
+		} else {
+			provider = getCanonicalProvider(provider)
 		}
 		for group, groupEntries := range groups {
 			for _, entry := range groupEntries {
-				// Use the account ID as the key to the map.  Amazon and Azure
-				// use IDs with a fixed format -- check that the ID from the
-				// accounts file matches the format.  For historical
-				// compatibility, we accept IDs which contain no hyphens, but
-				// we add the hyphens to match the format that Cloudability uses.
-				var key string
-				translate, exists := accountIdPatterns[provider]
-				if exists {
-					if matches := translate.FindStringSubmatch(entry.AccountID); matches != nil {
-						key = strings.Join(matches[1:], "-")
-					} else {
-						log.Fatalf("[getAccountMetadata] unrecognized account id format, %q, must match %q",
-							entry.AccountID, translate.String())
-					}
-				} else {
-					key = entry.AccountID
+				// Use the account ID, in the format which Cloudability uses,
+				// as the key to the map; Amazon, Azure, and GCP use IDs with a
+				// fixed format -- check that the ID from the accounts file
+				// matches the format.
+				key, ok := getCanonicalAccountId(provider, entry.AccountID)
+				if !ok {
+					fatalf("[getAccountMetadata] unrecognized account id format, %q, must match %q",
+						entry.AccountID, accountIdPatterns[provider].String())
 				}
 				metadata[key] = &AccountMetadata{
 					AccountId:     entry.AccountID,
@@ -462,6 +1680,9 @@ func getAccountMetadata(providers map[string]Team) (metadata map[string]*Account
 					DataFound:     false, // Will be set when cost data is found
 					Description:   entry.Description,
 					Group:         group,
+					Buckets:       entry.Buckets,
+					Labels:        entry.Labels,
+					Notes:         entry.Notes,
 				}
 			}
 		}
@@ -486,7 +1707,7 @@ func getMapKeyValue[V any](configMap map[string]V, key string, section string) (
 	}
 
 	if section != "" {
-		log.Fatalf("Key %q is missing from the %q section of the configuration file", key, section)
+		fatalf("Key %q is missing from the %q section of the configuration file", key, section)
 	}
 
 	return
@@ -507,54 +1728,146 @@ func getMapKeyString(configMap map[string]any, key string, section string) (valu
 		if section != "" {
 			msg += fmt.Sprintf("%q section of the ", section)
 		}
-		log.Fatalf(msg+"configuration file must be a string; found %v, type %T",
+		fatalf(msg+"configuration file must be a string; found %v, type %T",
 			key, valueAny, valueAny)
 	}
 
 	return
 }
 
+// getMapKeyBool is a helper function which fetches a boolean from the given
+// key in the given map; if the key is not in the map or the value is not a
+// boolean, and the caller has provided the section name, the program exits
+// with an error; otherwise, it returns false.
+func getMapKeyBool(configMap map[string]any, key string, section string) (value bool) {
+	valueAny := getMapKeyValue(configMap, key, section)
+	if value, ok := valueAny.(bool); ok {
+		return value
+	}
+
+	if valueAny != nil {
+		fatalf("%q key in the configuration file must be a boolean; found %v, type %T",
+			key, valueAny, valueAny)
+	}
+
+	return
+}
+
+// getMapKeyInt is a helper function which fetches an integer from the given
+// key in the given map; if the key is not in the map or the value is not an
+// integer, and the caller has provided the section name, the program exits
+// with an error; otherwise, it returns zero.
+func getMapKeyInt(configMap map[string]any, key string, section string) (value int) {
+	valueAny := getMapKeyValue(configMap, key, section)
+	if value, ok := valueAny.(int); ok {
+		return value
+	}
+
+	if valueAny != nil {
+		fatalf("%q key in the configuration file must be an integer; found %v, type %T",
+			key, valueAny, valueAny)
+	}
+
+	return
+}
+
+// getMapKeyId returns the value of the given key, which may be a number or a
+// string (e.g., a numeric ID), as a string, or "" if it is not set.
+func getMapKeyId(configMap map[string]any, key string) string {
+	if value := getMapKeyValue(configMap, key, ""); value != nil {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// getMapKeyStringList is a helper function which fetches a list of strings
+// from the given key in the given map; a single string value is accepted as a
+// list of one.  If the key is not in the map, and the caller has provided the
+// section name, the program exits with an error; otherwise, it returns nil.
+func getMapKeyStringList(configMap map[string]any, key string, section string) (values []string) {
+	valueAny := getMapKeyValue(configMap, key, section)
+	switch v := valueAny.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case []any:
+		for _, item := range v {
+			values = append(values, getStringFromAny(item, fmt.Sprintf("%q list item", key)))
+		}
+		return values
+	default:
+		fatalf("%q key in the configuration file must be a string or a list of strings; found %v, type %T",
+			key, valueAny, valueAny)
+	}
+	return
+}
+
 // getStringFromAny encapsulates and centralizes the operation of converting an
 // `any` value to a string and takes care of checking for and handling failures.
 func getStringFromAny(anyValue any, message string) (value string) {
 	value, ok := anyValue.(string)
 	if !ok && anyValue != nil {
-		log.Fatalf("Unexpected value (%v) for %s, expected a string", anyValue, message)
+		fatalf("Unexpected value (%v) for %s, expected a string", anyValue, message)
 	}
 	return
 }
 
+// getConfigurationFromAny converts a nested mapping from the configuration
+// file (which the YAML parser produces with keys of type `any`) into a
+// Configuration, exiting with an error if the value is not a mapping or if
+// any of its keys are not strings.
+func getConfigurationFromAny(anyValue any, message string) Configuration {
+	if config, ok := anyValue.(Configuration); ok {
+		return config
+	}
+	mapValue, ok := anyValue.(map[any]any)
+	if !ok {
+		fatalf("Unexpected value (%v) for %s, expected a mapping", anyValue, message)
+	}
+	config := make(Configuration, len(mapValue))
+	for k, v := range mapValue {
+		config[getStringFromAny(k, message+" key")] = v
+	}
+	return config
+}
+
 // skipAccountEntry is a helper function which determines whether to skip
-// account entries that we're not looking for.  It updates a list of them so
-// that we don't issue multiple warnings for them; it warns about account
-// entries attributed to our cost center that we're not currently tracking.
+// account entries that we're not looking for.  The entries (and their costs)
+// for accounts which are not in the accounts file are recorded as
+// unattributed (see noteSkippedCost); it warns about account entries
+// attributed to our cost center that we're not currently tracking (once for
+// each account, since any of its entries may have our cost center).
 func skipAccountEntry(
 	accountMetadata *AccountMetadata,
 	accountId string,
 	costCenter string,
 	providerConfigName string,
 	accountName string,
-	ignored map[string]struct{},
-	configMap Configuration,
+	cost float64,
+	ourCostCenters CostCenterMatcher,
 	dataSource string,
 ) bool {
+	if accountMetadata != nil && (accountMetadata.Excluded ||
+		(accountMetadata.PulledDirectly && dataSource == "Cloudability")) {
+		return true
+	}
 	if accountMetadata == nil {
-		if _, exists := ignored[accountId]; !exists {
-			ourCostCenter := getMapKeyString(configMap, "cost_center", "")
-			if costCenter == ourCostCenter {
-				log.Printf("Warning:  found account which is not in the accounts file:  "+
-					"%s:%s:%s:%s (%s); ignoring",
-					dataSource, costCenter, providerConfigName, accountId, accountName)
-			}
-			ignored[accountId] = struct{}{}
+		noteSkippedCost(dataSource, costCenter, cost)
+		if ourCostCenters.matches(costCenter) {
+			noteUntrackedAccount(UntrackedAccount{
+				Provider:   providerConfigName,
+				AccountId:  accountId,
+				Name:       accountName,
+				CostCenter: costCenter,
+				Source:     dataSource,
+			})
 		}
 		return true
 	}
 	// Note the cloud provider which corresponds to the account ID, and
 	// warn about errors in the accounts file.
-	if accountMetadata.CloudProvider != providerConfigName &&
-		// Accept "AWS" as an alias for "Amazon"
-		!(providerConfigName == "Amazon" && accountMetadata.CloudProvider == "AWS") {
+	if getCanonicalProvider(accountMetadata.CloudProvider) != getCanonicalProvider(providerConfigName) {
 		log.Printf(
 			"For account %s, the accounts file has cloud provider %q, but it should be %q; using %q",
 			accountId,
@@ -569,21 +1882,116 @@ func skipAccountEntry(
 	return false
 }
 
+// checkBucketThresholds compares the account's costs in each cost category
+// (keyed by bucket name, which is matched without regard to case, either as
+// an output category or as an AWS normalized column, see CategoryTaxonomy)
+// with the configured thresholds, and returns a description of each one
+// exceeded.
+func checkBucketThresholds(thresholds map[string]BucketThreshold, costs map[string]float64) (problems []string) {
+	for _, bucket := range sortedKeys(thresholds) {
+		threshold := thresholds[bucket]
+		var cost float64
+		category := categoryTaxonomy.getCategory("aws", bucket)
+		for name, value := range costs {
+			if strings.EqualFold(name, bucket) || strings.EqualFold(name, category) {
+				cost += value
+			}
+		}
+		if threshold.Max != nil && cost > *threshold.Max {
+			problems = append(problems, fmt.Sprintf("%s cost %.2f exceeds the maximum of %.2f",
+				bucket, cost, *threshold.Max))
+		}
+		if threshold.StandardValue > 0 {
+			diffAbs := math.Abs(threshold.StandardValue - cost)
+			diffPercent := (diffAbs / threshold.StandardValue) * 100
+			if diffPercent > float64(threshold.DeviationPercent) {
+				problems = append(problems, fmt.Sprintf("%s deviation is %.2f (%.2f%%), max deviation allowed "+
+					"is %d%% (value was %.2f, standard value %.2f)", bucket, diffAbs, diffPercent,
+					threshold.DeviationPercent, cost, threshold.StandardValue))
+			}
+		}
+	}
+	return
+}
+
+// checkBucketConsistency applies the accounts' cost category thresholds to
+// the cost grid (the AWS accounts pulled directly are checked as they are
+// pulled).
+func checkBucketConsistency(
+	costCells map[string]map[string]float64,
+	accountsMetadata map[string]*AccountMetadata,
+	reportFile *os.File,
+) {
+	for _, key := range sortedKeys(costCells) {
+		entry := accountsMetadata[key]
+		if entry == nil || entry.Buckets == nil || (entry.PulledDirectly && entry.CloudProvider == "Amazon") {
+			continue
+		}
+		for _, problem := range checkBucketThresholds(entry.Buckets, costCells[key]) {
+			log.Printf("[checkBucketConsistency] consistency check failed for account %s: %s", entry.AccountId, problem)
+			writeReport(reportFile, entry.AccountId+": "+problem)
+			noteExitStatus(ExitConsistencyFailure, "consistency check failed for account "+entry.AccountId+": "+problem)
+		}
+	}
+}
+
+// skipEmptyAccounts returns a copy of the cost grid without the accounts whose
+// total (as rounded for the output) is zero, for -skip-empty-accounts; the
+// omitted accounts are listed in the report.
+func skipEmptyAccounts(
+	costCells map[string]map[string]float64,
+	accountsMetadata map[string]*AccountMetadata,
+	reportFile *os.File,
+) map[string]map[string]float64 {
+	kept := make(map[string]map[string]float64, len(costCells))
+	skipped := 0
+	for _, key := range sortedKeys(costCells) {
+		var total float64
+		for _, value := range costCells[key] {
+			total += value
+		}
+		if reconciliation.round(total) != 0 {
+			kept[key] = costCells[key]
+			continue
+		}
+		accountId := key
+		if entry := accountsMetadata[key]; entry != nil {
+			accountId = entry.AccountId
+		}
+		writeReport(reportFile, accountId+": omitted from the output (no costs for the month)")
+		skipped++
+	}
+	if skipped > 0 {
+		log.Printf("[skipEmptyAccounts] omitted %d accounts with no costs from the output", skipped)
+	}
+	return kept
+}
+
 func checkMissing(accountsMetadata map[string]*AccountMetadata, cldy *CloudabilityCostData) {
-	// Check for accounts from the YAML file which were not found in the
-	// Cloudability data.
+	// Check for accounts from the YAML file which were not found in the data
+	// from their source:  the provider, for those pulled directly, or else
+	// Cloudability (in which case, its filters are shown, since they are the
+	// likely reason).  Without Cloudability data, only the accounts pulled
+	// directly are checked.
 	var filters []string
 	for id, entry := range accountsMetadata {
-		if !entry.DataFound {
-			if filters == nil {
-				for _, filter := range cldy.Meta.Filters {
-					filters = append(filters, fmt.Sprintf("%q %s %q", filter.Label, filter.Comparator, filter.Value))
-				}
+		if entry.DataFound || entry.Excluded || (cldy == nil && !entry.PulledDirectly) {
+			continue
+		}
+		source := "Cloudability"
+		if entry.PulledDirectly {
+			source = entry.CloudProvider
+		} else if filters == nil {
+			for _, filter := range cldy.Meta.Filters {
+				filters = append(filters, fmt.Sprintf("%q %s %q", filter.Label, filter.Comparator, filter.Value))
 			}
-			msg := fmt.Sprintf("Warning:  no data source found for account %s:%s:%s",
-				entry.CloudProvider, entry.Group, id)
-			msg += fmt.Sprintf("; filters: %s", strings.Join(filters, " && "))
-			log.Printf(msg)
 		}
+		msg := fmt.Sprintf("no %s data found for account %s:%s:%s", source, entry.CloudProvider, entry.Group, id)
+		if source == "Cloudability" {
+			log.Printf("Warning:  %s; filters: %s", msg, strings.Join(filters, " && "))
+		} else {
+			log.Printf("Warning:  %s", msg)
+		}
+		noteExitStatus(ExitWarnings, msg)
 	}
 }
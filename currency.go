@@ -0,0 +1,54 @@
+package main
+
+// reportingCurrency is the currency to which costs are converted when
+// conversion rates are configured.
+const reportingCurrency = "USD"
+
+// convertCostCells converts each account's costs from its native currency
+// (as noted in its metadata) to the reporting currency, using the configured
+// exchange rates, and notes the rate and the native total in the metadata.
+// It returns false, leaving the costs unchanged, if there is no exchange rate
+// source; an account whose currency has no rate is an error.
+func convertCostCells(
+	costCells map[string]map[string]float64,
+	metadata map[string]providerAccountMetadata,
+	rates ExchangeRateSource,
+	month string,
+) bool {
+	if rates == nil {
+		return false
+	}
+	for accountId, row := range costCells {
+		md := metadata[accountId]
+		if md.Currency == "" {
+			md.Currency = reportingCurrency
+		}
+		rate, err := rates.getRate(md.Currency, month)
+		if err != nil {
+			fatalf("[convertCostCells] unable to convert from %s to %s for account %s: %v",
+				md.Currency, reportingCurrency, accountId, err)
+		}
+		md.ExchangeRate = rate
+		md.NativeTotal = 0
+		for column, value := range row {
+			md.NativeTotal += value
+			row[column] = value * rate
+		}
+		metadata[accountId] = md
+	}
+	return true
+}
+
+// getFloatFromAny converts a numeric value from the configuration file (which
+// the YAML parser produces as an int or a float64) to a float64, exiting with
+// an error if it is not a number.
+func getFloatFromAny(anyValue any, message string) float64 {
+	switch value := anyValue.(type) {
+	case int:
+		return float64(value)
+	case float64:
+		return value
+	}
+	fatalf("Unexpected value (%v) for %s, expected a number", anyValue, message)
+	return 0
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// diffCommand implements the "diff" subcommand, which compares the output
+// CSV files of two runs and reports the accounts and cost categories whose
+// values differ, so that changes to the tool can be checked for their effect
+// on the numbers.  The exit code is ExitConsistencyFailure if there are any
+// differences.
+func diffCommand(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	tolerancePtr := flags.Float64("tolerance", 0.01, "largest difference in a value which is not reported")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "Usage: costpuller diff [options] <run-A.csv> <run-B.csv>")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	runA, err := getRunRecordsFromCsv(flags.Arg(0))
+	if err != nil {
+		fatalf("[diff] error reading %s: %v", flags.Arg(0), err)
+	}
+	runB, err := getRunRecordsFromCsv(flags.Arg(1))
+	if err != nil {
+		fatalf("[diff] error reading %s: %v", flags.Arg(1), err)
+	}
+
+	differences := 0
+	for _, key := range sortedKeys(mergeMaps(runA, runB)) {
+		a, inA := runA[key]
+		b, inB := runB[key]
+		switch {
+		case !inA:
+			fmt.Printf("%s  %-20s only in B  %14.2f\n", b.Month, b.AccountID, b.Total)
+			differences++
+			continue
+		case !inB:
+			fmt.Printf("%s  %-20s only in A  %14.2f\n", a.Month, a.AccountID, a.Total)
+			differences++
+			continue
+		}
+		if math.Abs(a.Total-b.Total) > *tolerancePtr {
+			fmt.Printf("%s  %-20s TOTAL  %14.2f  %14.2f  %+14.2f\n", a.Month, a.AccountID, a.Total, b.Total,
+				b.Total-a.Total)
+			differences++
+		}
+		for _, category := range sortedKeys(mergeMaps(a.Costs, b.Costs)) {
+			if diff := b.Costs[category] - a.Costs[category]; math.Abs(diff) > *tolerancePtr {
+				fmt.Printf("%s  %-20s   %s  %14.2f  %14.2f  %+14.2f\n", a.Month, a.AccountID, category,
+					a.Costs[category], b.Costs[category], diff)
+				differences++
+			}
+		}
+	}
+	log.Printf("[diff] %d accounts in A, %d in B, %d differences", len(runA), len(runB), differences)
+	if differences > 0 {
+		os.Exit(ExitConsistencyFailure)
+	}
+}
+
+// mergeMaps returns a map containing the keys of both maps (with the values
+// from the first, where present); it is used to iterate over their union.
+func mergeMaps[T any](a map[string]T, b map[string]T) map[string]T {
+	merged := make(map[string]T, len(a))
+	for key, value := range b {
+		merged[key] = value
+	}
+	for key, value := range a {
+		merged[key] = value
+	}
+	return merged
+}
+
+// getRunRecordsFromCsv reads an output CSV file and returns its costs as
+// history records, keyed by month and account ID.  Both output layouts are
+// supported:  the headed usage-family grid of the Cloudability and IBM Cloud
+// pull, and the fixed (headerless) layout of the direct AWS pull.
+func getRunRecordsFromCsv(fileName string) (map[string]HistoryRecord, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile(file)
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return getRunRecordsFromRows(rows)
+}
+
+// getRunRecordsFromRows returns the costs in the rows of an output sheet (as
+// for getRunRecordsFromCsv()) as history records, keyed by month and account
+// ID.
+func getRunRecordsFromRows(rows [][]string) (map[string]HistoryRecord, error) {
+	records := make(map[string]HistoryRecord)
+	add := func(month string, accountID string, provider string, group string, category string, cell string) error {
+		value, err := strconv.ParseFloat(cell, 64)
+		if err != nil && cell != "" {
+			return fmt.Errorf("account %s %s value %q: %v", accountID, category, cell, err)
+		}
+		key := month + "|" + accountID
+		record, exists := records[key]
+		if !exists {
+			record = HistoryRecord{Month: month, AccountID: accountID, CloudProvider: provider, Group: group,
+				Costs: make(map[string]float64)}
+		}
+		record.Costs[category] += value
+		record.Total += value
+		records[key] = record
+		return nil
+	}
+	if len(rows) == 0 {
+		return records, nil
+	}
+	if header := rows[0]; slices.Contains(header, "Account ID") {
+		first := getCostColumnStart(header)
+		if first < 0 {
+			return nil, fmt.Errorf("no \"Currency\" column in the header")
+		}
+		column := func(row []string, name string) string {
+			if idx := slices.Index(header, name); idx >= 0 && idx < len(row) {
+				return row[idx]
+			}
+			return ""
+		}
+		for _, row := range rows[1:] {
+			for idx := first; idx < len(row) && idx < len(header); idx++ {
+				err := add(column(row, "Date"), column(row, "Account ID"), column(row, "Cloud Provider"),
+					column(row, "Team"), header[idx], row[idx])
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return records, nil
+	}
+	for _, row := range rows {
+		if len(row) < 4+len(awsNormalizedColumns) {
+			return nil, fmt.Errorf("unexpected row with %d columns: %v", len(row), row)
+		}
+		for i, column := range awsNormalizedColumns {
+			category := categoryTaxonomy.getCategory("aws", column)
+			if err := add(row[1], row[2], row[3], row[0], category, row[4+i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Process exit codes, so that wrapper scripts and schedulers can react to the
+// kind of failure.  Errors which are not otherwise classified (such as
+// problems with the accounts file) are reported with fatalf(), which exits
+// with ExitError; the flag package exits with ExitUsage for command line
+// errors.
+const (
+	ExitSuccess            = 0
+	ExitError              = 1
+	ExitUsage              = 2
+	ExitWarnings           = 3 // The run completed, but there were warnings
+	ExitAuthFailure        = 4
+	ExitProviderError      = 5
+	ExitConsistencyFailure = 6 // The run completed, but consistency checks failed
+	ExitOutputFailure      = 7
+	ExitLocked             = 8 // Another run for the month holds the run lock
+	ExitPartialSuccess     = 9 // The run completed, but some providers' data is missing
+)
+
+// exitStatus is the exit code for a run which completes, and runWarnings
+// describes the conditions which determined it; they are set by
+// noteExitStatus().  checkFailures holds the descriptions of the failed
+// consistency checks, which are also among the warnings.
+var exitStatus = ExitSuccess
+var runWarnings []string
+var checkFailures []string
+
+// noteExitStatus records a condition which does not stop the run but which
+// should be reflected in its exit code; the most severe condition noted
+// (i.e., the highest code) is used.  The description of the condition is
+// saved for the run summary.
+func noteExitStatus(code int, description string) {
+	if code > exitStatus {
+		exitStatus = code
+	}
+	runWarnings = append(runWarnings, description)
+	if code == ExitConsistencyFailure {
+		checkFailures = append(checkFailures, description)
+	}
+}
+
+// exitHooks are called by exitf() and fatalf() before the process exits,
+// since deferred functions are not run (e.g., to release the run lock).
+var exitHooks []func()
+
+// runExitHooks calls the exitHooks, once.
+func runExitHooks() {
+	hooks := exitHooks
+	exitHooks = nil
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+// exitf logs the message and exits the process with the given code.  During
+// a provider pull run by pullIsolated(), it stops only the pull.
+func exitf(code int, format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	log.Print(msg)
+	if isolatingPull {
+		panic(ProviderFailure{Code: code, Message: msg})
+	}
+	runExitHooks()
+	os.Exit(code)
+}
+
+// fatalf logs the message and exits the process with ExitError, like
+// log.Fatalf(), but calls the exitHooks first.  Unlike exitf(), it stops the
+// run even during an isolated provider pull, since the errors which it
+// reports (e.g., in the configuration) would stop every pull.
+func fatalf(format string, v ...any) {
+	log.Printf(format, v...)
+	runExitHooks()
+	os.Exit(ExitError)
+}
+
+// getHttpExitCode returns the exit code for an unsuccessful HTTP response
+// from a provider API.
+func getHttpExitCode(statusCode int) int {
+	if statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden {
+		return ExitAuthFailure
+	}
+	return ExitProviderError
+}
+
+// awsAuthErrorCodes lists the AWS error codes which indicate that the
+// credentials are missing, expired, or insufficient.
+var awsAuthErrorCodes = map[string]struct{}{
+	"AccessDenied":                {},
+	"AccessDeniedException":       {},
+	"ExpiredToken":                {},
+	"ExpiredTokenException":       {},
+	"InvalidClientTokenId":        {},
+	"NoCredentialProviders":       {},
+	"SharedCredsLoad":             {},
+	"UnrecognizedClientException": {},
+}
+
+// getAwsExitCode returns the exit code for an error returned by an AWS API.
+func getAwsExitCode(err error) int {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		if _, exists := awsAuthErrorCodes[awsErr.Code()]; exists {
+			return ExitAuthFailure
+		}
+	}
+	return ExitProviderError
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// fingerprintMetadataKey is the key of the developer metadata entry, on each
+// raw data sheet, which holds the fingerprint of the data written to it.
+const fingerprintMetadataKey = "costpuller-fingerprint"
+
+// getSheetFingerprint returns a digest of the sheet data (values, formulas,
+// and formats), which identifies it for the idempotency check.
+func getSheetFingerprint(sheetData []*sheets.RowData) string {
+	data, err := json.Marshal(sheetData)
+	if err != nil {
+		fatalf("[getSheetFingerprint] error encoding the sheet data: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// getFingerprintEntries returns the fingerprint metadata entries of the sheet.
+func getFingerprintEntries(
+	srv *sheets.Service,
+	spreadsheetId string,
+	sheetId int64,
+) ([]*sheets.DeveloperMetadata, error) {
+	response, err := srv.Spreadsheets.DeveloperMetadata.Search(spreadsheetId, &sheets.SearchDeveloperMetadataRequest{
+		DataFilters: []*sheets.DataFilter{{
+			DeveloperMetadataLookup: &sheets.DeveloperMetadataLookup{MetadataKey: fingerprintMetadataKey},
+		}},
+	}).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error searching for the data fingerprint in spreadsheet %s: %w", spreadsheetId, err)
+	}
+	var entries []*sheets.DeveloperMetadata
+	for _, matched := range response.MatchedDeveloperMetadata {
+		entry := matched.DeveloperMetadata
+		if entry.Location != nil && entry.Location.SheetId == sheetId {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// checkSheetFingerprint compares the fingerprint of the new data with the one
+// recorded on the existing raw data sheet.  It reports whether they match, in
+// which case the sheet need not be written; if they differ (or the sheet has
+// no fingerprint), it returns an error, unless force is set.
+func checkSheetFingerprint(
+	srv *sheets.Service,
+	spreadsheetId string,
+	sheet *sheets.SheetProperties,
+	fingerprint string,
+	force bool,
+) (bool, error) {
+	entries, err := getFingerprintEntries(srv, spreadsheetId, sheet.SheetId)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.MetadataValue == fingerprint {
+			return true, nil
+		}
+	}
+	if !force {
+		return false, fmt.Errorf("sheet %q already holds different data (use -force to overwrite it)", sheet.Title)
+	}
+	log.Printf("Warning:  sheet %q holds different data; overwriting it, as -force was specified", sheet.Title)
+	return false, nil
+}
+
+// setSheetFingerprint records the fingerprint of the data written to the raw
+// data sheet, replacing any previous one.
+func setSheetFingerprint(srv *sheets.Service, spreadsheetId string, sheetId int64, fingerprint string) error {
+	entries, err := getFingerprintEntries(srv, spreadsheetId, sheetId)
+	if err != nil {
+		return err
+	}
+	var requests []*sheets.Request
+	for _, entry := range entries {
+		requests = append(requests, &sheets.Request{
+			DeleteDeveloperMetadata: &sheets.DeleteDeveloperMetadataRequest{
+				DataFilter: &sheets.DataFilter{
+					DeveloperMetadataLookup: &sheets.DeveloperMetadataLookup{MetadataId: entry.MetadataId},
+				},
+			},
+		})
+	}
+	requests = append(requests, &sheets.Request{
+		CreateDeveloperMetadata: &sheets.CreateDeveloperMetadataRequest{
+			DeveloperMetadata: &sheets.DeveloperMetadata{
+				Location:      &sheets.DeveloperMetadataLocation{SheetId: sheetId, ForceSendFields: []string{"SheetId"}},
+				MetadataKey:   fingerprintMetadataKey,
+				MetadataValue: fingerprint,
+				Visibility:    "DOCUMENT",
+			},
+		},
+	})
+	response, err := srv.Spreadsheets.BatchUpdate(spreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: requests,
+	}).Do()
+	if err != nil {
+		return fmt.Errorf("error recording the data fingerprint: %w, [%v]", err, response)
+	}
+	return nil
+}
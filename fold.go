@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// defaultOtherColumn is the column into which the small costs are folded,
+// unless the data already has a column by that name (in any case).
+const defaultOtherColumn = "Other"
+
+// foldSmallColumns returns a copy of the cost grid in which, for each account,
+// the costs in the service/category columns which are smaller (in magnitude)
+// than the "threshold" amount, or than the "threshold_percent" of the
+// account's total, configured in the "fold_columns" section, are added to the
+// "Other" column instead; columns which are left without any costs are
+// dropped.  Each account's total is unchanged.  The grid is returned as is if
+// the section is absent.
+func foldSmallColumns(
+	configMap Configuration,
+	costCells map[string]map[string]float64,
+	columnHeadsSet map[string]struct{},
+) (map[string]map[string]float64, map[string]struct{}) {
+	if configMap == nil {
+		return costCells, columnHeadsSet
+	}
+	var threshold, thresholdPercent float64
+	if value := getMapKeyValue(configMap, "threshold", ""); value != nil {
+		threshold = getFloatFromAny(value, "fold_columns threshold")
+	}
+	if value := getMapKeyValue(configMap, "threshold_percent", ""); value != nil {
+		thresholdPercent = getFloatFromAny(value, "fold_columns threshold_percent")
+	}
+	other := defaultOtherColumn
+	for column := range columnHeadsSet {
+		if strings.EqualFold(column, defaultOtherColumn) {
+			other = column // e.g., the "other" column of the direct AWS pull
+			break
+		}
+	}
+
+	foldedCells := make(map[string]map[string]float64, len(costCells))
+	foldedHeads := make(map[string]struct{})
+	for accountId, row := range costCells {
+		var total float64
+		for _, value := range row {
+			total += value
+		}
+		limit := math.Max(threshold, thresholdPercent/100*math.Abs(total))
+		folded := make(map[string]float64, len(row))
+		for column, value := range row {
+			if column != other && math.Abs(value) < limit {
+				folded[other] += value
+			} else {
+				folded[column] += value
+				foldedHeads[column] = struct{}{}
+			}
+		}
+		if _, exists := folded[other]; exists {
+			foldedHeads[other] = struct{}{}
+		}
+		foldedCells[accountId] = folded
+	}
+	return foldedCells, foldedHeads
+}
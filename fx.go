@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ExchangeRateSource provides the rate for converting a currency to the
+// reporting currency (i.e., the value of one unit of the currency in USD) in
+// a given month (in the format yyyy-mm).
+type ExchangeRateSource interface {
+	getRate(currency string, month string) (float64, error)
+}
+
+// newExchangeRateSource returns the exchange rate source configured by the
+// "conversion_rates" subsection of the configuration, or nil if there is none.
+// Any rates listed in the subsection (see StaticRates) take precedence; its
+// optional "source" key selects a live source for the other currencies:
+// "ecb" (the European Central Bank's monthly average reference rates) or
+// "exchangerate.host" (end-of-month rates, which require an "access_key").
+// Rates from a live source for past months are cached.
+func newExchangeRateSource(ratesConfig Configuration) ExchangeRateSource {
+	if ratesConfig == nil {
+		return nil
+	}
+	sources := ChainedRates{StaticRates{ratesConfig}}
+	client := newAuditedHttpClient("fx", time.Second*30)
+	switch name := getMapKeyString(ratesConfig, "source", ""); name {
+	case "":
+	case "ecb":
+		sources = append(sources, newCachedRates(name, EcbRates{client: client}))
+	case "exchangerate.host":
+		accessKey := getMapKeyString(ratesConfig, "access_key", "conversion_rates")
+		sources = append(sources, newCachedRates(name, ExchangeRateHostRates{client: client, accessKey: accessKey}))
+	default:
+		fatalf("Error in \"conversion_rates\" source (%q), expected \"ecb\" or \"exchangerate.host\"", name)
+	}
+	return sources
+}
+
+// errNoRate is returned by sources which do not have a rate for a currency.
+var errNoRate = errors.New("no conversion rate")
+
+// StaticRates provides the rates listed in the configuration:  a mapping of
+// currency codes to rates; rates for a particular month may be given in a
+// nested mapping, keyed by the month (e.g., "2025-01"), which takes
+// precedence.
+type StaticRates struct {
+	config Configuration
+}
+
+func (s StaticRates) getRate(currency string, month string) (float64, error) {
+	if currency == reportingCurrency {
+		return 1, nil
+	}
+	if monthRatesAny, exists := s.config[month]; exists {
+		monthRates := getConfigurationFromAny(monthRatesAny, "conversion_rates for "+month)
+		if rate, exists := monthRates[currency]; exists {
+			return getFloatFromAny(rate, "conversion rate for "+currency), nil
+		}
+	}
+	if rate, exists := s.config[currency]; exists {
+		return getFloatFromAny(rate, "conversion rate for "+currency), nil
+	}
+	return 0, errNoRate
+}
+
+// ChainedRates returns the rate from the first of its sources which has one.
+type ChainedRates []ExchangeRateSource
+
+func (c ChainedRates) getRate(currency string, month string) (float64, error) {
+	for _, source := range c {
+		rate, err := source.getRate(currency, month)
+		if !errors.Is(err, errNoRate) {
+			return rate, err
+		}
+	}
+	return 0, errNoRate
+}
+
+// CachedRates remembers the rates from a live source, saving those for past
+// months (which do not change) in the tool's cache directory.  The rates are
+// also saved in the response archive, if it is enabled.
+type CachedRates struct {
+	name   string
+	source ExchangeRateSource
+	rates  map[string]map[string]float64 // Month -> currency -> rate
+}
+
+func newCachedRates(name string, source ExchangeRateSource) *CachedRates {
+	return &CachedRates{name: name, source: source, rates: make(map[string]map[string]float64)}
+}
+
+func (c *CachedRates) getRate(currency string, month string) (float64, error) {
+	if _, loaded := c.rates[month]; !loaded {
+		rates := make(map[string]float64)
+		if path, err := c.getPath(month); err == nil {
+			if data, err := os.ReadFile(path); err == nil {
+				if err := json.Unmarshal(data, &rates); err != nil {
+					log.Printf("[CachedRates] ignoring unreadable exchange rate cache %q: %v", path, err)
+				}
+			}
+		}
+		c.rates[month] = rates
+	}
+	if rate, exists := c.rates[month][currency]; exists {
+		return rate, nil
+	}
+	rate, err := archived("fx", c.name, month+"-"+currency, func() (float64, error) {
+		return c.source.getRate(currency, month)
+	})
+	if err != nil {
+		return 0, err
+	}
+	c.rates[month][currency] = rate
+	if !isMonthOpen(month) {
+		path, err := c.getPath(month)
+		var data []byte
+		if err == nil {
+			data, err = json.Marshal(c.rates[month])
+		}
+		if err == nil {
+			err = os.WriteFile(path, data, 0600)
+		}
+		if err != nil {
+			log.Printf("[CachedRates] unable to cache exchange rates: %v", err)
+		}
+	}
+	return rate, nil
+}
+
+func (c *CachedRates) getPath(month string) (string, error) {
+	return getCachePath(fmt.Sprintf("fx-%s-%s.json", c.name, month))
+}
+
+// EcbRates provides the European Central Bank's monthly average reference
+// rates, which are quoted against the euro; the rate to USD is derived from
+// the USD and currency rates.
+type EcbRates struct {
+	client *http.Client
+}
+
+func (e EcbRates) getRate(currency string, month string) (float64, error) {
+	if currency == reportingCurrency {
+		return 1, nil
+	}
+	query := url.Values{"startPeriod": {month}, "endPeriod": {month}, "format": {"csvdata"}}
+	requestUrl := "https://data-api.ecb.europa.eu/service/data/EXR/M." + reportingCurrency + "+" + currency +
+		".EUR.SP00.A?" + query.Encode()
+	response, err := e.client.Get(requestUrl)
+	if err != nil {
+		return 0, fmt.Errorf("error requesting ECB rates: %w", err)
+	}
+	defer closeBody(response)
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("error getting ECB rates for %s: %s", month, response.Status)
+	}
+	records, err := csv.NewReader(response.Body).ReadAll()
+	if err != nil || len(records) < 2 {
+		return 0, fmt.Errorf("error reading ECB rates for %s: %v", month, err)
+	}
+	currencyColumn := slices.Index(records[0], "CURRENCY")
+	valueColumn := slices.Index(records[0], "OBS_VALUE")
+	if currencyColumn < 0 || valueColumn < 0 {
+		return 0, fmt.Errorf("unexpected ECB response columns: %v", records[0])
+	}
+	perEuro := map[string]float64{"EUR": 1}
+	for _, record := range records[1:] {
+		if value, err := strconv.ParseFloat(record[valueColumn], 64); err == nil {
+			perEuro[record[currencyColumn]] = value
+		}
+	}
+	if perEuro[reportingCurrency] == 0 || perEuro[currency] == 0 {
+		return 0, fmt.Errorf("the ECB has no %s rate for %s", currency, month)
+	}
+	return perEuro[reportingCurrency] / perEuro[currency], nil
+}
+
+// ExchangeRateHostRates provides the rates from the exchangerate.host API, as
+// of the last day of the month (or today, for the current month).
+type ExchangeRateHostRates struct {
+	client    *http.Client
+	accessKey string
+}
+
+func (x ExchangeRateHostRates) getRate(currency string, month string) (float64, error) {
+	if currency == reportingCurrency {
+		return 1, nil
+	}
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return 0, err
+	}
+	date := start.AddDate(0, 1, -1)
+	if date.After(time.Now()) {
+		date = time.Now()
+	}
+	query := url.Values{
+		"access_key": {x.accessKey},
+		"currencies": {currency},
+		"date":       {date.Format("2006-01-02")},
+		"source":     {reportingCurrency},
+	}
+	response, err := x.client.Get("https://api.exchangerate.host/historical?" + query.Encode())
+	if err != nil {
+		return 0, fmt.Errorf("error requesting exchangerate.host rates: %w", err)
+	}
+	defer closeBody(response)
+	var result struct {
+		Success bool               `json:"success"`
+		Quotes  map[string]float64 `json:"quotes"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("error reading exchangerate.host rates: %w", err)
+	}
+	quote := result.Quotes[reportingCurrency+currency] // Units of the currency per USD
+	if !result.Success || quote == 0 {
+		return 0, fmt.Errorf("exchangerate.host has no %s rate for %s", currency, month)
+	}
+	return 1 / quote, nil
+}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -37,19 +38,23 @@ const tokenFileName = "costpuller_token.json"
 // The Google OAuth 2.0 Client configuration is constructed from a local
 // credentials file (which can be downloaded from https://console.developers.google.com,
 // under "Credentials").  It is located using the default mechanisms (e.g., in
-// ${HOME}/.config/gcloud/application_default_credentials.json).  (Currently,
-// the scope of the authorization is limited to the Google Sheets APIs.)
-func getGoogleOAuthHttpClient(oauthConfigMap Configuration) *http.Client {
-	ctx := context.Background()
+// ${HOME}/.config/gcloud/application_default_credentials.json).  The scope of
+// the authorization is the Google Sheets APIs plus any extra scopes given
+// (e.g., for uploading the output files to Google Drive); the token for each
+// set of scopes is cached separately.
+func getGoogleOAuthHttpClient(oauthConfigMap Configuration, extraScopes ...string) *http.Client {
+	// Use an audited HTTP client for the OAuth and Google API requests.
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, newAuditedHttpClient("google", 0))
 
-	credObj, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/spreadsheets")
+	scopes := append([]string{"https://www.googleapis.com/auth/spreadsheets"}, extraScopes...)
+	credObj, err := google.FindDefaultCredentials(ctx, scopes...)
 	if err != nil {
-		log.Fatalf("Unable to read OAuth client credentials file: %v", err)
+		exitf(ExitAuthFailure, "Unable to read OAuth client credentials file: %v", err)
 	}
 
-	config, err := google.ConfigFromJSON(credObj.JSON, "https://www.googleapis.com/auth/spreadsheets")
+	config, err := google.ConfigFromJSON(credObj.JSON, scopes...)
 	if err != nil {
-		log.Fatalf("Unable to construct a client configuration: %v", err)
+		exitf(ExitAuthFailure, "Unable to construct a client configuration: %v", err)
 	}
 
 	token, tokenCachePath := getToken(oauthConfigMap, config, ctx)
@@ -69,6 +74,13 @@ func getToken(
 	var tokenCacheFile *os.File
 	path := getMapKeyString(oauthConfigMap, "tokenCachePath", "")
 	tokenCachePath, err := getCacheFileName(path)
+	if err == nil && len(config.Scopes) > 1 {
+		// A token for additional scopes is cached separately, so that the
+		// user is prompted to authorize them.
+		hash := sha256.Sum256([]byte(strings.Join(config.Scopes, " ")))
+		tokenCachePath = strings.TrimSuffix(tokenCachePath, ".json") +
+			"-" + base64.RawURLEncoding.EncodeToString(hash[:6]) + ".json"
+	}
 	if err == nil {
 		tokenCacheFile, err = os.Open(tokenCachePath)
 	}
@@ -79,7 +91,7 @@ func getToken(
 		port := getMapKeyString(oauthConfigMap, "port", "")
 		token = getNewToken(config, port, ctx)
 	} else {
-		log.Fatalf("Unexpected error accessing the token cache file, %q: %v", tokenCachePath, err)
+		exitf(ExitAuthFailure, "Unexpected error accessing the token cache file, %q: %v", tokenCachePath, err)
 	}
 	return
 }
@@ -136,12 +148,12 @@ func getCachedToken(config *oauth2.Config, cacheFile *os.File, ctx context.Conte
 	token := &oauth2.Token{}
 	err := json.NewDecoder(cacheFile).Decode(token)
 	if err != nil {
-		log.Fatalf("Unable to parse cached OAuth tokens, %q: %v", cacheFile.Name(), err)
+		exitf(ExitAuthFailure, "Unable to parse cached OAuth tokens, %q: %v", cacheFile.Name(), err)
 	}
 
 	token, err = config.TokenSource(ctx, token).Token()
 	if err != nil {
-		log.Fatalf("Unable to refresh the cached OAuth tokens: %v", err)
+		exitf(ExitAuthFailure, "Unable to refresh the cached OAuth tokens: %v", err)
 	}
 
 	return token
@@ -176,7 +188,7 @@ func getNewToken(config *oauth2.Config, listenerPort string, ctx context.Context
 	// Exchange the authorization code for an access token and refresh token.
 	token, err := config.Exchange(ctx, authCode)
 	if err != nil {
-		log.Fatalf("Unable to retrieve access token: %v", err)
+		exitf(ExitAuthFailure, "Unable to retrieve access token: %v", err)
 	}
 	return token
 }
@@ -195,18 +207,19 @@ func getStateToken() string {
 // otherwise it exits the process with a failure.
 func getAuthCode(authResp url.Values, stateToken string) string {
 	if authResp.Get("state") != stateToken {
-		log.Fatalf(
+		exitf(
+			ExitAuthFailure,
 			"Error in authorization state, expected %q, got %q",
 			stateToken,
 			authResp.Get("state"),
 		)
 	}
 	if authResp.Get("error") != "" {
-		log.Fatalf("Error returned from authorization: %s", authResp.Get("error"))
+		exitf(ExitAuthFailure, "Error returned from authorization: %s", authResp.Get("error"))
 	}
 	authCode := authResp.Get("code")
 	if authCode == "" {
-		log.Fatalf("No authorization code received.")
+		exitf(ExitAuthFailure, "No authorization code received.")
 	}
 	return authCode
 }
@@ -244,7 +257,7 @@ func redirectListener(urlString string) url.Values {
 	// shutdown is requested.
 	if err := server.ListenAndServe(); err != nil {
 		if !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("Error running redirect listener: %v", err)
+			fatalf("Error running redirect listener: %v", err)
 		}
 	}
 
@@ -275,7 +288,7 @@ func handleRedirectResponse(w http.ResponseWriter, queryParams url.Values) {
 func requestShutdown(server *http.Server) {
 	err := server.Shutdown(context.Background())
 	if err != nil {
-		log.Fatalf("Error shutting down redirect listener: %v", err)
+		fatalf("Error shutting down redirect listener: %v", err)
 	}
 }
 
@@ -294,7 +307,7 @@ var RedirectUrlPattern = regexp.MustCompile(`^(?:http://)?([^:/]+)(:[0-9]{1,5})$
 func getListenAddress(urlString string) string {
 	matches := RedirectUrlPattern.FindStringSubmatch(urlString)
 	if matches == nil {
-		log.Fatalf("Could not parse redirect URL: %s", urlString)
+		fatalf("Could not parse redirect URL: %s", urlString)
 	}
 	address := matches[1]
 	if matches[2] != "" {
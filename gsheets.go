@@ -8,6 +8,7 @@ import (
 	"google.golang.org/api/sheets/v4"
 	"log"
 	"net/http"
+	"regexp"
 	"slices"
 	"strings"
 	"time"
@@ -19,18 +20,67 @@ type providerAccountMetadata struct {
 	CostCenter     string
 	Date           string
 	PayerAccountId string
+	Tags           map[string][]string // Tag dimension -> values
+	Details        map[string]float64  // Detail column -> value (breakdowns, not included in the total)
+	Currency       string              // The currency in which the provider reports the costs
+	ExchangeRate   float64             // Set when the costs are converted
+	NativeTotal    float64             // The total in Currency, set when the costs are converted
+}
+
+// getGsheetDestinations returns the configuration for each spreadsheet to which
+// the output is written.  Usually, this is just the "gsheet" configuration
+// section itself; but, if it has a "spreadsheets" list, there is one
+// destination for each entry in the list, whose values (e.g., "spreadsheetId"
+// and "sheetNameTemplate") override those in the section.
+func getGsheetDestinations(configMap Configuration) (destinations []Configuration) {
+	list, exists := configMap["spreadsheets"]
+	if !exists {
+		return []Configuration{configMap}
+	}
+	entries, ok := list.([]any)
+	if !ok || len(entries) == 0 {
+		fatalf("[getGsheetDestinations] the \"spreadsheets\" key of the \"gsheet\" configuration section " +
+			"must be a non-empty list")
+	}
+	for idx, entry := range entries {
+		destination := make(Configuration)
+		for key, value := range configMap {
+			if key != "spreadsheets" {
+				destination[key] = value
+			}
+		}
+		for key, value := range getConfigurationFromAny(entry, fmt.Sprintf("gsheet spreadsheets entry %d", idx)) {
+			destination[key] = value
+		}
+		destinations = append(destinations, destination)
+	}
+	return
 }
 
 // postToGSheet creates a new sheet in a Google Sheets spreadsheet and loads it
 // with the specified data.  Requests are made to the Google API using the
 // specified HTTP client which has already been authenticated and authorized.
 // The new sheet name is constructed based on the reference time passed in the
-// last parameter.  Details such as the spreadsheet ID and sheet names are found
-// in the configuration map.
-func postToGSheet(sheetData []*sheets.RowData, client *http.Client, configMap Configuration, ref time.Time) {
+// ref parameter.  Details such as the spreadsheet ID and sheet names are found
+// in the configuration map.  If the main sheet has no reference to the new
+// sheet and rollForward is set, the previous month's reference block is copied
+// for it (see rollForwardMainSheet).  If the raw data sheet already exists,
+// it is left alone when it holds the same data (per its fingerprint, see
+// checkSheetFingerprint), and it is overwritten only if force is set.  The
+// column headers are renamed as configured (see renameHeaders).  An error is
+// returned if the spreadsheet cannot be updated.
+func postToGSheet(
+	sheetData []*sheets.RowData,
+	client *http.Client,
+	configMap Configuration,
+	ref time.Time,
+	rollForward bool,
+	force bool,
+) error {
+	sheetData = renameHeaders(sheetData, configMap)
 	srv, err := sheets.NewService(context.Background(), option.WithHTTPClient(client))
 	if err != nil {
-		log.Fatalf("Unable to create Google Sheets client: %v", err)
+		return fmt.Errorf("unable to create Google Sheets client: %w", err)
 	}
 
 	// Construct the name for the raw data sheet using the template-name from
@@ -40,7 +90,8 @@ func postToGSheet(sheetData []*sheets.RowData, client *http.Client, configMap Co
 	// value while non-digits are copied literally, so, if the template-name is
 	// "Raw Data 01/2006" and the reference time is in August 2024, the result
 	// will be "Raw Data 08/2024".
-	newSheetName := ref.Format(getMapKeyString(configMap, "sheetNameTemplate", "gsheet"))
+	template := getMapKeyString(configMap, "sheetNameTemplate", "gsheet")
+	newSheetName := ref.Format(template)
 
 	spreadsheetId := getMapKeyString(configMap, "spreadsheetId", "gsheet")
 	log.Println("Fetching Spreadsheet information")
@@ -49,15 +100,30 @@ func postToGSheet(sheetData []*sheets.RowData, client *http.Client, configMap Co
 		Fields("sheets/properties(gridProperties(columnCount,rowCount),sheetId,title)", "spreadsheetId").
 		Do()
 	if err != nil {
-		log.Fatalf("Error retrieving spreadsheet: %v", err)
+		return fmt.Errorf("error retrieving spreadsheet %s: %w", spreadsheetId, err)
 	}
 
-	newDataRef := getUpdateLocation(srv, sheetObject, newSheetName, len(sheetData[0].Values), len(sheetData))
+	fingerprint := getSheetFingerprint(sheetData)
+	if existing := getSheetIdFromName(sheetObject, newSheetName); existing != nil {
+		unchanged, err := checkSheetFingerprint(srv, spreadsheetId, existing, fingerprint, force)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			log.Printf("Sheet %q already holds this data; not updating spreadsheet %s", newSheetName, spreadsheetId)
+			return nil
+		}
+	}
+
+	newDataRef, err := getUpdateLocation(srv, sheetObject, newSheetName, len(sheetData[0].Values), len(sheetData), true)
+	if err != nil {
+		return err
+	}
 
 	mainSheetName := getMapKeyString(configMap, "mainSheetName", "gsheet")
 	mainSheetProperties := getSheetIdFromName(sheetObject, mainSheetName)
 	if mainSheetProperties == nil {
-		log.Fatalf("Error updating spreadsheet sheet: main sheet %q not found", mainSheetName)
+		return fmt.Errorf("error updating spreadsheet %s: main sheet %q not found", spreadsheetId, mainSheetName)
 	}
 	mainSheetID := mainSheetProperties.SheetId
 	cells, err := srv.Spreadsheets.Values.Get(spreadsheetId, fmt.Sprintf(
@@ -67,56 +133,204 @@ func postToGSheet(sheetData []*sheets.RowData, client *http.Client, configMap Co
 		mainSheetProperties.GridProperties.RowCount,
 	)).Do()
 	if err != nil {
-		log.Fatalf("Error fetching main sheet (%q) values: %v", mainSheetID, err)
+		return fmt.Errorf("error fetching main sheet (%q) values: %w", mainSheetName, err)
 	}
 	// Increase the length by one to cover the "Total" row
 	mainSheetRef := getNewSheetReference(cells, mainSheetID, newSheetName, len(sheetData)+1)
+	if mainSheetRef == nil && rollForward {
+		r, c, err := rollForwardMainSheet(srv, spreadsheetId, mainSheetProperties, cells, template, ref)
+		if err != nil {
+			return err
+		}
+		mainSheetRef = &sheets.GridRange{
+			EndColumnIndex:   int64(c + 1),
+			EndRowIndex:      int64(r+1) + int64(len(sheetData)+1) + 1,
+			SheetId:          mainSheetID,
+			StartColumnIndex: int64(c),
+			StartRowIndex:    int64(r + 1),
+		}
+	}
 	if mainSheetRef == nil {
-		log.Fatalf("No reference to %q found in main sheet (%q)", newSheetName, mainSheetName)
+		return fmt.Errorf("no reference to %q found in main sheet (%q)", newSheetName, mainSheetName)
+	}
+	if err := loadNewData(srv, spreadsheetId, sheetData, newDataRef, mainSheetRef); err != nil {
+		return err
+	}
+	return setSheetFingerprint(srv, spreadsheetId, newDataRef.SheetId, fingerprint)
+}
+
+// rollForwardMainSheet prepares the main sheet for a new month's raw data
+// sheet by copying the previous month's reference block:  a column is inserted
+// after the column holding the cell which names the previous month's raw data
+// sheet, the previous month's column is copied into it (so that relative
+// references are shifted, as when copying by hand), and the previous month's
+// sheet name is replaced with the new one in the copy.  It returns the
+// (zero-based) row and column of the cell naming the new sheet, or an error.
+func rollForwardMainSheet(
+	srv *sheets.Service,
+	spreadsheetId string,
+	mainSheet *sheets.SheetProperties,
+	cells *sheets.ValueRange,
+	template string,
+	ref time.Time,
+) (row int, column int, err error) {
+	newSheetName := ref.Format(template)
+	previousSheetName := ref.AddDate(0, -1, 0).Format(template)
+	r, c, found := findSheetNameCell(cells.Values, previousSheetName)
+	if !found {
+		return 0, 0, fmt.Errorf("unable to roll the main sheet (%q) forward:  no reference to %q found",
+			mainSheet.Title, previousSheetName)
+	}
+	log.Printf("Rolling the main sheet forward:  copying the reference block for %q (column %s) for %q",
+		previousSheetName, colNumToRef(c), newSheetName)
+	previousColumn := &sheets.GridRange{
+		SheetId:          mainSheet.SheetId,
+		StartColumnIndex: int64(c),
+		EndColumnIndex:   int64(c + 1),
+	}
+	newColumn := &sheets.GridRange{
+		SheetId:          mainSheet.SheetId,
+		StartColumnIndex: int64(c + 1),
+		EndColumnIndex:   int64(c + 2),
+	}
+	response, err := srv.Spreadsheets.BatchUpdate(spreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{
+				InsertDimension: &sheets.InsertDimensionRequest{
+					InheritFromBefore: true,
+					Range: &sheets.DimensionRange{
+						Dimension:  "COLUMNS",
+						EndIndex:   int64(c + 2),
+						SheetId:    mainSheet.SheetId,
+						StartIndex: int64(c + 1),
+					},
+				},
+			},
+			{
+				CopyPaste: &sheets.CopyPasteRequest{
+					Destination:      newColumn,
+					PasteOrientation: "NORMAL",
+					PasteType:        "PASTE_NORMAL",
+					Source:           previousColumn,
+				},
+			},
+			{
+				FindReplace: &sheets.FindReplaceRequest{
+					Find:            previousSheetName,
+					IncludeFormulas: true,
+					MatchCase:       true,
+					Range:           newColumn,
+					Replacement:     newSheetName,
+				},
+			},
+		},
+	}).Do()
+	if err != nil {
+		return 0, 0, fmt.Errorf("error rolling the main sheet forward: %w, [%v]", err, response)
+	}
+	return r, c + 1, nil
+}
+
+// postAuxiliaryToGSheet creates (or replaces) a visible sheet with the given
+// name in the configured spreadsheet and loads it with the specified data.
+// Unlike postToGSheet, the main sheet is not updated.
+func postAuxiliaryToGSheet(
+	sheetData []*sheets.RowData,
+	client *http.Client,
+	configMap Configuration,
+	sheetName string,
+) error {
+	sheetData = renameHeaders(sheetData, configMap)
+	srv, err := sheets.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return fmt.Errorf("unable to create Google Sheets client: %w", err)
+	}
+
+	spreadsheetId := getMapKeyString(configMap, "spreadsheetId", "gsheet")
+	sheetObject, err := srv.Spreadsheets.
+		Get(spreadsheetId).
+		Fields("sheets/properties(gridProperties(columnCount,rowCount),sheetId,title)", "spreadsheetId").
+		Do()
+	if err != nil {
+		return fmt.Errorf("error retrieving spreadsheet %s: %w", spreadsheetId, err)
+	}
+
+	dataRef, err := getUpdateLocation(srv, sheetObject, sheetName, len(sheetData[0].Values), len(sheetData), false)
+	if err != nil {
+		return err
 	}
-	loadNewData(srv, spreadsheetId, sheetData, newDataRef, mainSheetRef)
+	response, err := srv.Spreadsheets.BatchUpdate(spreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{
+				UpdateCells: &sheets.UpdateCellsRequest{
+					Fields: "userEnteredValue,userEnteredFormat",
+					Range:  dataRef,
+					Rows:   sheetData,
+				},
+			},
+			{
+				AutoResizeDimensions: &sheets.AutoResizeDimensionsRequest{
+					Dimensions: &sheets.DimensionRange{
+						Dimension: "COLUMNS",
+						SheetId:   dataRef.SheetId,
+					},
+				},
+			},
+		},
+	}).Do()
+	if err != nil {
+		return fmt.Errorf("error updating sheet %q: %w, [%v]", sheetName, err, response)
+	}
+	return nil
 }
 
 // getUpdateLocation is a helper function which returns the GridRange to
 // receive the new data.  This includes looking up the existing sheet or
-// creating a new one with the indicated number of columns and rows.
+// creating a new one (hidden, if requested) with the indicated number of
+// columns and rows.
 func getUpdateLocation(
 	srv *sheets.Service,
 	sheetObject *sheets.Spreadsheet,
 	newSheetName string,
 	newColumnCount int,
 	newRowCount int,
-) (newDataRef *sheets.GridRange) {
+	hidden bool,
+) (newDataRef *sheets.GridRange, err error) {
 	newSheetProperties := getSheetIdFromName(sheetObject, newSheetName)
 	if newSheetProperties == nil {
 		log.Printf("Adding new sheet %q", newSheetName)
 		spreadsheetId := sheetObject.SpreadsheetId
-		newSheetProperties = createNewSheet(
+		newSheetProperties, err = createNewSheet(
 			srv,
 			spreadsheetId,
 			newSheetName,
 			int64(len(sheetObject.Sheets)), // Insert the sheet at the end
 			int64(newColumnCount),
 			int64(newRowCount),
+			hidden,
 		)
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		log.Printf("Warning:  overwriting sheet %q", newSheetName)
 	}
-	return getDataGridRange(newSheetProperties)
+	return getDataGridRange(newSheetProperties), nil
 }
 
 // loadNewData updates the data cells (avoiding the header row and the totals
 // column) in the indicated sheet of the indicated spreadsheet from the
 // provided RowData using the provided service client; it then copies a range
 // of cells new sheet with the new data, and then poke the main sheet
-// to get it to update its references to the new sheet.
+// to get it to update its references to the new sheet.  An error is returned
+// if either update fails.
 func loadNewData(
 	srv *sheets.Service,
 	spreadsheetId string,
 	sheetData []*sheets.RowData,
 	newSheetRef *sheets.GridRange,
 	mainSheetRef *sheets.GridRange,
-) {
+) error {
 	response, err := srv.Spreadsheets.BatchUpdate(spreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
 		Requests: []*sheets.Request{
 			{
@@ -137,7 +351,7 @@ func loadNewData(
 		},
 	}).Do()
 	if err != nil {
-		log.Fatalf("Error updating sheet: %v, [%v]", err, response)
+		return fmt.Errorf("error updating sheet: %w, [%v]", err, response)
 	}
 	// Auto-resizing the columns doesn't work well until after the data has
 	// been updated (and, even then, it seems about 10% too narrow on my
@@ -155,14 +369,16 @@ func loadNewData(
 		},
 	}).Do()
 	if err != nil {
-		log.Fatalf("Error updating column widths again: %v, [%v]", err, response)
+		return fmt.Errorf("error updating column widths again: %w, [%v]", err, response)
 	}
+	return nil
 }
 
 // createNewSheet creates a new sheet with the provided number of columns and
 // rows in the provided spreadsheet using the provided service client inserting
-// it into the spreadsheet at the indicated position with the provided name; it
-// then returns a pointer to the resulting sheet's properties.
+// it into the spreadsheet at the indicated position with the provided name and
+// visibility; it then returns a pointer to the resulting sheet's properties.
+// An error is returned if the sheet cannot be created.
 func createNewSheet(
 	srv *sheets.Service,
 	spreadsheetId string,
@@ -170,7 +386,8 @@ func createNewSheet(
 	position int64,
 	columnCount int64,
 	rowCount int64,
-) *sheets.SheetProperties {
+	hidden bool,
+) (*sheets.SheetProperties, error) {
 	buResp, err := srv.Spreadsheets.BatchUpdate(spreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
 		Requests: []*sheets.Request{
 			{
@@ -180,7 +397,7 @@ func createNewSheet(
 							ColumnCount: columnCount,
 							RowCount:    rowCount,
 						},
-						Hidden: true,
+						Hidden: hidden,
 						Index:  position,
 						Title:  newSheetName,
 					},
@@ -189,10 +406,10 @@ func createNewSheet(
 		},
 	}).Do()
 	if err != nil {
-		log.Fatalf("Error creating sheet: %v", err)
+		return nil, fmt.Errorf("error creating sheet %q: %w", newSheetName, err)
 	}
 
-	return buResp.Replies[0].AddSheet.Properties
+	return buResp.Replies[0].AddSheet.Properties, nil
 }
 
 // getGridRange is a helper function which, given the sheet's properties
@@ -219,25 +436,34 @@ func getNewSheetReference(
 	newSheetName string,
 	rowCount int,
 ) *sheets.GridRange {
-	for r, row := range cells.Values {
-		for c, cell := range row {
-			if str, ok := cell.(string); ok {
-				if strings.Contains(str, newSheetName) {
-					msColumn := int64(c)
-					msRow := int64(r + 1)
-					// Indices are zero-based, starts are inclusive, ends are exclusive.
-					return &sheets.GridRange{
-						EndColumnIndex:   msColumn + 1,
-						EndRowIndex:      msRow + int64(rowCount) + 1,
-						SheetId:          mainSheetID,
-						StartColumnIndex: msColumn,
-						StartRowIndex:    msRow,
-					}
-				}
+	r, c, found := findSheetNameCell(cells.Values, newSheetName)
+	if !found {
+		return nil
+	}
+	msColumn := int64(c)
+	msRow := int64(r + 1)
+	// Indices are zero-based, starts are inclusive, ends are exclusive.
+	return &sheets.GridRange{
+		EndColumnIndex:   msColumn + 1,
+		EndRowIndex:      msRow + int64(rowCount) + 1,
+		SheetId:          mainSheetID,
+		StartColumnIndex: msColumn,
+		StartRowIndex:    msRow,
+	}
+}
+
+// findSheetNameCell returns the (zero-based) row and column of the first cell
+// in the provided values which contains the given sheet name, and whether one
+// was found.
+func findSheetNameCell(values [][]any, sheetName string) (row int, column int, found bool) {
+	for r, cells := range values {
+		for c, cell := range cells {
+			if str, ok := cell.(string); ok && strings.Contains(str, sheetName) {
+				return r, c, true
 			}
 		}
 	}
-	return nil
+	return 0, 0, false
 }
 
 // getSheetIdFromName is a helper function which returns the sheet properties
@@ -256,7 +482,15 @@ func newStringCell(val string) *sheets.CellData {
 	return &sheets.CellData{UserEnteredValue: &sheets.ExtendedValue{StringValue: &val}}
 }
 
+// newNumberCell returns a cell holding the given amount, rounded according to
+// the reconciliation policy.
 func newNumberCell(val float64) *sheets.CellData {
+	return newExactNumberCell(reconciliation.round(val))
+}
+
+// newExactNumberCell returns a cell holding the given value, unrounded (e.g.,
+// for exchange rates).
+func newExactNumberCell(val float64) *sheets.CellData {
 	return &sheets.CellData{UserEnteredValue: &sheets.ExtendedValue{NumberValue: &val}}
 }
 
@@ -268,13 +502,121 @@ func newFormulaCell(formula string) *sheets.CellData {
 	}
 }
 
-// getSheetFromCostCells converts the cost data into a Google Sheet.
+// newHeaderRow returns a sheet row containing the provided column headers,
+// formatted to stand out from the data.
+func newHeaderRow(columnHeadsList []string) *sheets.RowData {
+	sheetRow := make([]*sheets.CellData, len(columnHeadsList))
+	for idx, header := range columnHeadsList {
+		sheetRow[idx] = newStringCell(header)
+		sheetRow[idx].UserEnteredFormat = &sheets.CellFormat{
+			BackgroundColorStyle: &sheets.ColorStyle{
+				RgbColor: &sheets.Color{
+					Blue:  204.0 / 256.0,
+					Green: 204.0 / 256.0,
+					Red:   204.0 / 256.0,
+				},
+			},
+			HorizontalAlignment: "CENTER",
+			TextFormat:          &sheets.TextFormat{Bold: true},
+		}
+	}
+	return &sheets.RowData{Values: sheetRow}
+}
+
+// renameHeaders returns the sheet data with the column headers renamed
+// according to the "headers" map of the spreadsheet's configuration, which
+// maps a column's standard header (e.g., "Team", "Payer ID", or "TOTAL") to
+// the text to be shown in its place.  Only the header row (the first row, if
+// it was built by newHeaderRow()) is changed, so the layout of the sheet is
+// the same; the data is returned as is if no headers are configured.
+func renameHeaders(sheetData []*sheets.RowData, configMap Configuration) []*sheets.RowData {
+	headersAny := getMapKeyValue(configMap, "headers", "")
+	if headersAny == nil || len(sheetData) == 0 {
+		return sheetData
+	}
+	headers := getConfigurationFromAny(headersAny, "gsheet headers")
+	header := sheetData[0]
+	renamed := &sheets.RowData{Values: make([]*sheets.CellData, len(header.Values))}
+	for idx, cell := range header.Values {
+		if cell.UserEnteredFormat == nil || cell.UserEnteredFormat.TextFormat == nil ||
+			!cell.UserEnteredFormat.TextFormat.Bold || cell.UserEnteredValue == nil ||
+			cell.UserEnteredValue.StringValue == nil {
+			return sheetData // Not a header row
+		}
+		renamed.Values[idx] = cell
+		if name, exists := headers[*cell.UserEnteredValue.StringValue]; exists {
+			text := getStringFromAny(name, fmt.Sprintf("gsheet headers %q", *cell.UserEnteredValue.StringValue))
+			copied := *cell
+			copied.UserEnteredValue = &sheets.ExtendedValue{StringValue: &text}
+			renamed.Values[idx] = &copied
+		}
+	}
+	return append([]*sheets.RowData{renamed}, sheetData[1:]...)
+}
+
+// restoreHeaders replaces, in place, the configured names in a header read
+// from a spreadsheet with the standard names of the columns (the reverse of
+// renameHeaders).
+func restoreHeaders(header []string, configMap Configuration) {
+	headersAny := getMapKeyValue(configMap, "headers", "")
+	if headersAny == nil {
+		return
+	}
+	for standard, name := range getConfigurationFromAny(headersAny, "gsheet headers") {
+		if idx := slices.Index(header, getStringFromAny(name, fmt.Sprintf("gsheet headers %q", standard))); idx >= 0 {
+			header[idx] = standard
+		}
+	}
+}
+
+// getCostColumnStart returns the index of the first cost column in the header
+// of a sheet produced by getSheetFromCostCells() (the cost columns follow the
+// currency columns), or -1 if the header is not of that form.
+func getCostColumnStart(header []string) int {
+	idx := slices.Index(header, "Currency")
+	if idx < 0 {
+		return -1
+	}
+	idx++
+	for idx < len(header) && (header[idx] == "Exchange Rate" || header[idx] == "Native Total") {
+		idx++
+	}
+	return idx
+}
+
+// getLabelColumns returns the account labels (see AccountEntry.Labels) listed
+// in the "columns" key of the "labels" configuration section, which are added
+// to the sheet as columns.
+func getLabelColumns(configMap Configuration) []string {
+	columns := getMapKeyStringList(configMap, "columns", "")
+	for _, column := range columns {
+		if slices.Contains([]string{"Team", "Date", "Cloud Provider", "Payer ID", "Cost Center", "Account Name",
+			"Account ID", "TOTAL", "Forecast", "Notes", "Currency", "Exchange Rate", "Native Total"}, column) {
+			fatalf("[getLabelColumns] label column %q has the same name as a standard column", column)
+		}
+	}
+	return columns
+}
+
+// getSheetFromCostCells converts the cost data into a Google Sheet.  If
+// forecasts (keyed by account ID) are provided, a "Forecast" column is added.
+// The tag columns, the label columns, and the detail columns (such as the
+// breakdowns of the direct AWS pull) hold the corresponding metadata values;
+// they are not included in the total.  If any of the accounts has notes in the
+// accounts file, they are written to a "Notes" column (after the labels), as
+// well as to the note on the account ID cell.
 func getSheetFromCostCells(
 	costCells map[string]map[string]float64,
 	columnHeadsSet map[string]struct{},
 	accountsMetadata map[string]*AccountMetadata,
 	metadata map[string]providerAccountMetadata,
+	forecasts map[string]float64,
+	tagColumns []string,
+	labelColumns []string,
+	detailColumns []string,
+	converted bool,
 ) (output []*sheets.RowData) {
+	defer startPhase("sheet.build")()
 	// Build a list of column headers, starting with a fixed set of strings for
 	// metadata and ending with the headers collected from the data.
 	//
@@ -283,31 +625,32 @@ func getSheetFromCostCells(
 	// looked up.
 	columnHeadsList := []string{"Team", "Date", "Cloud Provider", "Payer ID",
 		"Cost Center", "Account Name", "Account ID", "TOTAL"}
+	if forecasts != nil {
+		columnHeadsList = append(columnHeadsList, "Forecast")
+	}
+	columnHeadsList = append(columnHeadsList, tagColumns...)
+	columnHeadsList = append(columnHeadsList, labelColumns...)
+	for accountId := range costCells {
+		if accountsMetadata[accountId].Notes != "" {
+			columnHeadsList = append(columnHeadsList, "Notes")
+			break
+		}
+	}
+	columnHeadsList = append(columnHeadsList, detailColumns...)
+	columnHeadsList = append(columnHeadsList, "Currency")
+	if converted {
+		columnHeadsList = append(columnHeadsList, "Exchange Rate", "Native Total")
+	}
 	fixed := len(columnHeadsList)
-	columnHeadsList = append(columnHeadsList, sortedKeys(columnHeadsSet)...)
+	columnHeadsList = append(columnHeadsList, categoryTaxonomy.sortColumns(columnHeadsSet)...)
 
 	// Add the headers to the sheet data as the first row.
-	sheetRow := make([]*sheets.CellData, len(columnHeadsList))
-	for idx, header := range columnHeadsList {
-		sheetRow[idx] = newStringCell(header)
-		sheetRow[idx].UserEnteredFormat = &sheets.CellFormat{
-			BackgroundColorStyle: &sheets.ColorStyle{
-				RgbColor: &sheets.Color{
-					Blue:  204.0 / 256.0,
-					Green: 204.0 / 256.0,
-					Red:   204.0 / 256.0,
-				},
-			},
-			HorizontalAlignment: "CENTER",
-			TextFormat:          &sheets.TextFormat{Bold: true},
-		}
-	}
-	output = append(output, &sheets.RowData{Values: sheetRow})
+	output = append(output, newHeaderRow(columnHeadsList))
 
 	// Fill in the sheet with one row for each account, iterating over the
 	// column headers and inserting the appropriate values into each cell.
 	for accountId, dataRow := range costCells {
-		sheetRow = make([]*sheets.CellData, len(columnHeadsList))
+		sheetRow := make([]*sheets.CellData, len(columnHeadsList))
 		for idx, key := range columnHeadsList {
 			var val *sheets.CellData
 			switch {
@@ -325,8 +668,31 @@ func getSheetFromCostCells(
 				val = newStringCell(metadata[accountId].PayerAccountId)
 			case key == "Account ID": // Use the ID from the YAML file, not from Cloudability
 				val = newStringCell(accountsMetadata[accountId].AccountId)
+				val.Note = strings.TrimSpace(accountsMetadata[accountId].Note + "\n" + accountsMetadata[accountId].Notes)
 			case key == "Account Name":
 				val = newStringCell(metadata[accountId].AccountName)
+			case key == "Forecast":
+				val = newNumberCell(forecasts[accountsMetadata[accountId].AccountId])
+				val.UserEnteredFormat = &sheets.CellFormat{
+					NumberFormat: &sheets.NumberFormat{Type: "CURRENCY"},
+				}
+			case key == "Currency":
+				val = newStringCell(cmp.Or(metadata[accountId].Currency, reportingCurrency))
+			case key == "Exchange Rate":
+				val = newExactNumberCell(metadata[accountId].ExchangeRate)
+				val.UserEnteredFormat = &sheets.CellFormat{NumberFormat: &sheets.NumberFormat{Type: "NUMBER"}}
+			case key == "Native Total":
+				val = newNumberCell(metadata[accountId].NativeTotal)
+			case key == "Notes":
+				val = newStringCell(accountsMetadata[accountId].Notes)
+			case slices.Contains(tagColumns, key):
+				// An account may have resources with different values
+				values := slices.Sorted(slices.Values(metadata[accountId].Tags[key]))
+				val = newStringCell(strings.Join(values, ", "))
+			case slices.Contains(labelColumns, key):
+				val = newStringCell(accountsMetadata[accountId].Labels[key])
+			case slices.Contains(detailColumns, key):
+				val = newNumberCell(metadata[accountId].Details[key])
 			default:
 				val = newNumberCell(dataRow[key])
 				val.UserEnteredFormat = &sheets.CellFormat{
@@ -390,6 +756,55 @@ func getTotalsFormula(row int, startCol int, endCol int) string {
 	)
 }
 
+// sumFormulaPattern matches the "TOTAL" formulas constructed by
+// getTotalsFormula(); the groups are the first and last columns summed.
+var sumFormulaPattern = regexp.MustCompile(`^=SUM\(([A-Z]+)[0-9]+:([A-Z]+)[0-9]+\)$`)
+
+// getTotalsFormulaValue computes the value of a formula constructed by
+// getTotalsFormula() for the given row:  the sum of the numbers in the range
+// (rounded according to the reconciliation policy).  It returns false if the
+// formula is not of that form.
+func getTotalsFormulaValue(formula string, row *sheets.RowData) (float64, bool) {
+	matches := sumFormulaPattern.FindStringSubmatch(formula)
+	if matches == nil {
+		return 0, false
+	}
+	var total float64
+	for idx := colRefToNum(matches[1]); idx <= colRefToNum(matches[2]) && idx < len(row.Values); idx++ {
+		if value := row.Values[idx].UserEnteredValue; value != nil && value.NumberValue != nil {
+			total += *value.NumberValue
+		}
+	}
+	return reconciliation.round(total), true
+}
+
+// getStaticTotalsRows returns the rows with the formulas constructed by
+// getTotalsFormula() replaced by their values (keeping the cells' formats),
+// for consumers which cannot evaluate formulas.  The rows are copied only if
+// they hold such formulas.
+func getStaticTotalsRows(rows []*sheets.RowData) []*sheets.RowData {
+	static := make([]*sheets.RowData, len(rows))
+	for r, row := range rows {
+		static[r] = row
+		for c, cell := range row.Values {
+			if cell == nil || cell.UserEnteredValue == nil || cell.UserEnteredValue.FormulaValue == nil {
+				continue
+			}
+			total, ok := getTotalsFormulaValue(*cell.UserEnteredValue.FormulaValue, row)
+			if !ok {
+				continue
+			}
+			if static[r] == row {
+				static[r] = &sheets.RowData{Values: slices.Clone(row.Values)}
+			}
+			copied := *cell
+			copied.UserEnteredValue = &sheets.ExtendedValue{NumberValue: &total}
+			static[r].Values[c] = &copied
+		}
+	}
+	return static
+}
+
 // colNumToRef converts a zero-based column ordinal to a letter-reference
 // (e.g., 0 yields "A"; 25 yields "Z"; 26 yields "AA"; 676 yields "AAA").
 func colNumToRef(n int) (s string) {
@@ -399,3 +814,12 @@ func colNumToRef(n int) (s string) {
 	}
 	return s + fmt.Sprintf("%c", 'A'+r)
 }
+
+// colRefToNum converts a column letter-reference to a zero-based column
+// ordinal; it is the inverse of colNumToRef.
+func colRefToNum(ref string) (n int) {
+	for _, letter := range ref {
+		n = n*26 + int(letter-'A') + 1
+	}
+	return n - 1
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"google.golang.org/api/sheets/v4"
+)
+
+// historyRecordsBucket is the name of the bbolt bucket which holds the cost
+// records; historyRunsBucket holds a summary of each run.
+var historyRecordsBucket = []byte("records")
+var historyRunsBucket = []byte("runs")
+
+// historyTimestampFormat is used for the run timestamps in the record keys; it
+// sorts lexically in chronological order.
+const historyTimestampFormat = "20060102T150405Z"
+
+// historyImportedCostType is the cost type of the runs imported from the raw
+// data sheets, whose actual cost type is unknown; their records are used with
+// any cost type.
+const historyImportedCostType = "imported"
+
+// HistoryRecord is the normalized cost data for one account for one month, as
+// recorded by one run.
+type HistoryRecord struct {
+	Month         string             `json:"month"`
+	AccountID     string             `json:"accountId"`
+	CloudProvider string             `json:"cloudProvider"`
+	Group         string             `json:"group"`
+	Costs         map[string]float64 `json:"costs"`
+	Total         float64            `json:"total"`
+	CostType      string             `json:"costType,omitempty"`
+	RunTime       time.Time          `json:"runTime"`
+}
+
+// matchesCostType reports whether the record is of the given cost type; an
+// empty cost type matches every record, as does an imported record.
+func (r HistoryRecord) matchesCostType(costType string) bool {
+	return costType == "" || r.CostType == costType || r.CostType == historyImportedCostType
+}
+
+// HistoryRun summarizes a single run recorded in the history store.
+type HistoryRun struct {
+	RunTime  time.Time `json:"runTime"`
+	Month    string    `json:"month"`
+	CostType string    `json:"costType"`
+	Accounts int       `json:"accounts"`
+	Total    float64   `json:"total"`
+}
+
+// HistoryStore is an embedded database recording the normalized results of
+// every run, so that cost trends can be queried across months.
+type HistoryStore struct {
+	db *bbolt.DB
+}
+
+// getHistoryPath returns the path of the history database from the "history"
+// subsection of the configuration, defaulting to a file in the tool's cache
+// directory.
+func getHistoryPath(configuration map[string]Configuration) string {
+	path := getMapKeyString(configuration["history"], "path", "")
+	if path == "" {
+		var err error
+		path, err = getCachePath("history.db")
+		if err != nil {
+			fatalf("[getHistoryPath] unable to locate the history database: %v", err)
+		}
+	}
+	return path
+}
+
+// openHistoryStore opens (creating, if necessary) the history database at the
+// given path.
+func openHistoryStore(path string) (*HistoryStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening history database %q: %w", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{historyRecordsBucket, historyRunsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error initializing history database %q: %w", path, err)
+	}
+	return &HistoryStore{db: db}, nil
+}
+
+// readHistory returns the latest records of the given cost type from the
+// history database configured in the accounts file (see latestRecords()).
+func readHistory(accountsFile AccountsFile, costType string) ([]HistoryRecord, error) {
+	store, err := openHistoryStore(getHistoryPath(accountsFile.Configuration))
+	if err != nil {
+		return nil, err
+	}
+	defer store.close()
+	return store.latestRecords("", costType)
+}
+
+func (h *HistoryStore) close() {
+	if err := h.db.Close(); err != nil {
+		log.Printf("Ignoring error closing history database: %v", err)
+	}
+}
+
+// recordRun stores the given records, all from the same run, along with a
+// summary of the run.  Records are keyed by month, account ID, cost type, and
+// run time, so that earlier runs for the same month, and runs for other cost
+// types, are retained.
+func (h *HistoryStore) recordRun(run HistoryRun, records []HistoryRecord) error {
+	timestamp := run.RunTime.UTC().Format(historyTimestampFormat)
+	return h.db.Update(func(tx *bbolt.Tx) error {
+		recordsBucket := tx.Bucket(historyRecordsBucket)
+		for _, record := range records {
+			record.RunTime = run.RunTime
+			record.CostType = run.CostType
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			key := strings.Join([]string{record.Month, record.AccountID, record.CostType, timestamp}, "|")
+			if err := recordsBucket.Put([]byte(key), data); err != nil {
+				return err
+			}
+			run.Accounts++
+			run.Total += record.Total
+		}
+		data, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(historyRunsBucket).Put([]byte(timestamp), data)
+	})
+}
+
+// latestRecords returns the most recently recorded data of the given cost
+// type (or, if it is empty, of any cost type) for each account for each month,
+// optionally limited to a single account, ordered by month and then account
+// ID.  (The records of early runs are keyed without the cost type, which is
+// then taken from the run's summary.)
+func (h *HistoryStore) latestRecords(accountID string, costType string) (records []HistoryRecord, err error) {
+	err = h.db.View(func(tx *bbolt.Tx) error {
+		latest := make(map[string]HistoryRecord)
+		runsBucket := tx.Bucket(historyRunsBucket)
+		err := tx.Bucket(historyRecordsBucket).ForEach(func(k, v []byte) error {
+			parts := strings.Split(string(k), "|")
+			if (len(parts) != 3 && len(parts) != 4) || (accountID != "" && parts[1] != accountID) {
+				return nil
+			}
+			var record HistoryRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return fmt.Errorf("bad history record %q: %w", k, err)
+			}
+			if record.CostType == "" {
+				var run HistoryRun
+				if data := runsBucket.Get([]byte(parts[len(parts)-1])); data != nil {
+					_ = json.Unmarshal(data, &run)
+				}
+				record.CostType = run.CostType
+			}
+			if !record.matchesCostType(costType) {
+				return nil
+			}
+			key := parts[0] + "|" + parts[1]
+			if previous, exists := latest[key]; !exists || !record.RunTime.Before(previous.RunTime) {
+				latest[key] = record
+			}
+			return nil
+		})
+		for _, key := range sortedKeys(latest) {
+			records = append(records, latest[key])
+		}
+		return err
+	})
+	return
+}
+
+// runs returns the summaries of all the recorded runs, in chronological order.
+func (h *HistoryStore) runs() (runs []HistoryRun, err error) {
+	err = h.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(historyRunsBucket).ForEach(func(k, v []byte) error {
+			var run HistoryRun
+			if err := json.Unmarshal(v, &run); err != nil {
+				return fmt.Errorf("bad history run %q: %w", k, err)
+			}
+			runs = append(runs, run)
+			return nil
+		})
+	})
+	return
+}
+
+// recordHistory stores the records from the current run in the history
+// database configured in the accounts file.  Failures are reported but are not
+// fatal, since the history is secondary to the run's output.
+func recordHistory(accountsFile AccountsFile, options CommandLineOptions, records []HistoryRecord) {
+	defer startPhase("history.record")()
+	store, err := openHistoryStore(getHistoryPath(accountsFile.Configuration))
+	if err == nil {
+		defer store.close()
+		run := HistoryRun{RunTime: time.Now(), Month: *options.monthPtr, CostType: *options.costTypePtr}
+		err = store.recordRun(run, records)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("error recording run history: %v", err)
+		log.Printf("[recordHistory] Warning:  %s", msg)
+		noteExitStatus(ExitWarnings, msg)
+		return
+	}
+	log.Printf("[recordHistory] recorded %d accounts in the history database", len(records))
+}
+
+// defaultBaselineMonths is the default number of months averaged for the
+// trailing-average deviation baseline.
+const defaultBaselineMonths = 3
+
+// getDeviationBaselines returns, keyed by account ID, the average total of
+// each account over the months preceding the given one (three, unless the
+// "baseline_months" key of the "history" subsection is set), when the
+// "deviation_baseline" key is "trailing_average"; the consistency check then
+// compares the accounts' totals with these in place of their hand-maintained
+// standard values.  It returns nil if the mode is not configured.  Accounts
+// without history (of the given cost type) for those months are absent from
+// the result.
+func getDeviationBaselines(accountsFile AccountsFile, month string, costType string) map[string]float64 {
+	historyConfig := accountsFile.Configuration["history"]
+	switch mode := getMapKeyString(historyConfig, "deviation_baseline", ""); mode {
+	case "", "standardvalue":
+		return nil
+	case "trailing_average":
+	default:
+		fatalf("[getDeviationBaselines] unrecognized deviation baseline %q; must be \"standardvalue\" or "+
+			"\"trailing_average\"", mode)
+	}
+	if getMapKeyBool(historyConfig, "disabled", "") {
+		fatalf("[getDeviationBaselines] the trailing-average deviation baseline requires the history database")
+	}
+	window := defaultBaselineMonths
+	if getMapKeyValue(historyConfig, "baseline_months", "") != nil {
+		window = getMapKeyInt(historyConfig, "baseline_months", "history")
+	}
+	ref, err := time.Parse("2006-01", month)
+	if err != nil {
+		fatalf("[getDeviationBaselines] error parsing month value, %q: %v", month, err)
+	}
+	first := ref.AddDate(0, -window, 0).Format("2006-01")
+	last := ref.AddDate(0, -1, 0).Format("2006-01")
+	baselines := getAverageTotals(accountsFile, first, last, costType)
+	log.Printf("[getDeviationBaselines] using the average of %s through %s as the baseline for %d accounts",
+		first, last, len(baselines))
+	return baselines
+}
+
+// getAverageTotals returns, keyed by account ID, the average of each
+// account's recorded monthly totals for the months from first through last
+// (in "yyyy-mm" format) of the given cost type, over the months for which it
+// has records.
+func getAverageTotals(accountsFile AccountsFile, first string, last string, costType string) map[string]float64 {
+	stored, err := readHistory(accountsFile, costType)
+	if err != nil {
+		fatalf("[getAverageTotals] error reading history: %v", err)
+	}
+	totals := make(map[string]float64)
+	months := make(map[string]int)
+	for _, record := range stored {
+		if record.Month >= first && record.Month <= last {
+			totals[record.AccountID] += record.Total
+			months[record.AccountID]++
+		}
+	}
+	averages := make(map[string]float64)
+	for accountID, total := range totals {
+		averages[accountID] = total / float64(months[accountID])
+	}
+	return averages
+}
+
+// trendMonths is the number of months shown on the trends sheet.
+const trendMonths = 12
+
+// getTrendsSheet builds the trends sheet from the history database:  one row
+// per team with its total for each of the twelve months ending with the given
+// reference month (of the given cost type), a SPARKLINE chart of those
+// totals, and the growth over the previous month.
+func getTrendsSheet(accountsFile AccountsFile, ref time.Time, costType string) (output []*sheets.RowData) {
+	records, err := readHistory(accountsFile, costType)
+	if err != nil {
+		fatalf("[getTrendsSheet] error reading history: %v", err)
+	}
+
+	var months []string
+	for i := trendMonths - 1; i >= 0; i-- {
+		months = append(months, ref.AddDate(0, -i, 0).Format("2006-01"))
+	}
+	totals := make(map[string]map[string]float64)
+	for _, record := range records {
+		if _, exists := totals[record.Group]; !exists {
+			totals[record.Group] = make(map[string]float64)
+		}
+		totals[record.Group][record.Month] += record.Total
+	}
+
+	output = append(output, newHeaderRow(append(append([]string{"Team"}, months...), "Trend", "Growth")))
+	for idx, team := range sortedKeys(totals) {
+		row := []*sheets.CellData{newStringCell(team)}
+		for _, month := range months {
+			cell := newNumberCell(totals[team][month])
+			cell.UserEnteredFormat = &sheets.CellFormat{NumberFormat: &sheets.NumberFormat{Type: "CURRENCY"}}
+			row = append(row, cell)
+		}
+		r := idx + 2 // Rows are one-based, and the first is the header
+		first, last, previous := colNumToRef(1), colNumToRef(trendMonths), colNumToRef(trendMonths-1)
+		row = append(row, newFormulaCell(fmt.Sprintf("=SPARKLINE(%s%d:%s%d)", first, r, last, r)))
+		growth := newFormulaCell(fmt.Sprintf(`=IF(%s%d=0,"",(%s%d-%s%d)/%s%d)`,
+			previous, r, last, r, previous, r, previous, r))
+		growth.UserEnteredFormat = &sheets.CellFormat{NumberFormat: &sheets.NumberFormat{Type: "PERCENT"}}
+		row = append(row, growth)
+		output = append(output, &sheets.RowData{Values: row})
+	}
+	return
+}
+
+// defaultForecastMonths is the default number of months of history used for
+// the forecast.
+const defaultForecastMonths = 6
+
+// getForecasts projects each account's total for the month following the
+// given one from its history (including the current run's records, which
+// have not yet been stored), using the method configured by the "forecast"
+// key of the "history" subsection:  "linear" (the default) fits a
+// least-squares line through the monthly totals; "average" uses their mean;
+// and "last" repeats the most recent total.  The "forecast_months" key sets
+// the number of months used.  Only the history of the given cost type is
+// used.  The result is keyed by account ID.
+func getForecasts(
+	accountsFile AccountsFile,
+	records []HistoryRecord,
+	month string,
+	costType string,
+) map[string]float64 {
+	forecaster := newForecaster(accountsFile, month, costType)
+	for _, record := range records {
+		forecaster.add(forecaster.series, record)
+	}
+	forecasts := make(map[string]float64)
+	for accountID, points := range forecaster.series {
+		forecasts[accountID] = forecast(points, forecaster.method)
+	}
+	return forecasts
+}
+
+// Forecaster holds the stored history used to make forecasts, so that
+// forecasts can be made for accounts as their records are produced.
+type Forecaster struct {
+	method string
+	window int
+	ref    time.Time
+	series map[string]map[int]float64 // Account ID -> month offset -> total
+}
+
+// newForecaster reads the stored history and returns a Forecaster for the
+// given month, configured as described for getForecasts().
+func newForecaster(accountsFile AccountsFile, month string, costType string) *Forecaster {
+	historyConfig := accountsFile.Configuration["history"]
+	f := &Forecaster{
+		method: getMapKeyString(historyConfig, "forecast", ""),
+		window: defaultForecastMonths,
+		series: make(map[string]map[int]float64),
+	}
+	if f.method == "" {
+		f.method = "linear"
+	}
+	if n, ok := getMapKeyValue(historyConfig, "forecast_months", "").(int); ok && n > 0 {
+		f.window = n
+	}
+	var err error
+	f.ref, err = time.Parse("2006-01", month)
+	if err != nil {
+		fatalf("[getForecasts] error parsing month value, %q: %v", month, err)
+	}
+
+	stored, err := readHistory(accountsFile, costType)
+	if err != nil {
+		fatalf("[getForecasts] error reading history: %v", err)
+	}
+	for _, record := range stored {
+		f.add(f.series, record)
+	}
+	return f
+}
+
+// add adds a record's total to the series, keyed by month offset (0 for the
+// current month, -1 for the previous, ...), if it is within the window; a
+// later record replaces an earlier one for the same month.
+func (f *Forecaster) add(series map[string]map[int]float64, record HistoryRecord) {
+	recordMonth, err := time.Parse("2006-01", record.Month)
+	if err != nil {
+		return
+	}
+	offset := (recordMonth.Year()-f.ref.Year())*12 + int(recordMonth.Month()-f.ref.Month())
+	if offset > 0 || offset <= -f.window {
+		return
+	}
+	if _, exists := series[record.AccountID]; !exists {
+		series[record.AccountID] = make(map[int]float64)
+	}
+	series[record.AccountID][offset] = record.Total
+}
+
+// getForecasts returns the forecasts for the accounts of the given records
+// (from this run), keyed by account ID; the stored history is not changed.
+func (f *Forecaster) getForecasts(records []HistoryRecord) map[string]float64 {
+	series := make(map[string]map[int]float64)
+	for _, record := range records {
+		if _, exists := series[record.AccountID]; !exists {
+			series[record.AccountID] = maps.Clone(f.series[record.AccountID])
+			if series[record.AccountID] == nil {
+				series[record.AccountID] = make(map[int]float64)
+			}
+		}
+		f.add(series, record)
+	}
+	forecasts := make(map[string]float64)
+	for accountID, points := range series {
+		forecasts[accountID] = forecast(points, f.method)
+	}
+	return forecasts
+}
+
+// forecast projects the value at offset 1 from the given points (values keyed
+// by month offset) using the indicated method.
+func forecast(points map[int]float64, method string) float64 {
+	var n, sumX, sumY, sumXY, sumXX float64
+	last, lastX := 0.0, math.MinInt
+	for x, y := range points {
+		n++
+		sumX += float64(x)
+		sumY += y
+		sumXY += float64(x) * y
+		sumXX += float64(x) * float64(x)
+		if x > lastX {
+			last, lastX = y, x
+		}
+	}
+	switch method {
+	case "last":
+		return last
+	case "average":
+		return sumY / n
+	case "linear":
+		denominator := n*sumXX - sumX*sumX
+		if denominator == 0 {
+			return sumY / n // A single point has no slope
+		}
+		slope := (n*sumXY - sumX*sumY) / denominator
+		intercept := (sumY - slope*sumX) / n
+		return math.Max(0, intercept+slope)
+	default:
+		fatalf("Unrecognized forecast method %q; must be \"linear\", \"average\", or \"last\"", method)
+	}
+	return 0
+}
+
+// awsNormalizedColumns are the names of the cost columns (indices 4 through
+// 12) of the rows produced by AwsPuller.NormalizeResponse().
+var awsNormalizedColumns = []string{"dataTransfer", "machines", "storage", "keyManagement", "registrar",
+	"dns", "other", "tax", "rebate"}
+
+// getHistoryRecordsFromAwsRows extracts history records from the rows
+// produced by the direct AWS pull, with the costs in their categories (see
+// CategoryTaxonomy).  (Rows for the same account, such as those split by Cost
+// Category, are combined.)
+func getHistoryRecordsFromAwsRows(rows []*sheets.RowData) (records []HistoryRecord) {
+	index := make(map[string]int)
+	for _, row := range rows {
+		month := *row.Values[1].UserEnteredValue.StringValue
+		accountID := *row.Values[2].UserEnteredValue.StringValue
+		key := month + "|" + accountID
+		idx, exists := index[key]
+		if !exists {
+			idx = len(records)
+			index[key] = idx
+			records = append(records, HistoryRecord{
+				Month:         month,
+				AccountID:     accountID,
+				CloudProvider: "AWS",
+				Group:         *row.Values[0].UserEnteredValue.StringValue,
+				Costs:         make(map[string]float64),
+			})
+		}
+		for i, column := range awsNormalizedColumns {
+			value := *row.Values[4+i].UserEnteredValue.NumberValue
+			records[idx].Costs[categoryTaxonomy.getCategory("aws", column)] += value
+			records[idx].Total += value
+		}
+	}
+	return
+}
+
+// getHistoryRecordsFromCostCells extracts history records from the sparse cost
+// grid built from the Cloudability and IBM Cloud data.
+func getHistoryRecordsFromCostCells(
+	costCells map[string]map[string]float64,
+	accountsMetadata map[string]*AccountMetadata,
+	metadata map[string]providerAccountMetadata,
+) (records []HistoryRecord) {
+	for _, accountId := range sortedKeys(costCells) {
+		record := HistoryRecord{
+			Month:         metadata[accountId].Date,
+			AccountID:     accountsMetadata[accountId].AccountId,
+			CloudProvider: accountsMetadata[accountId].CloudProvider,
+			Group:         accountsMetadata[accountId].Group,
+			Costs:         make(map[string]float64),
+		}
+		for category, cost := range costCells[accountId] {
+			record.Costs[category] = cost
+			record.Total += cost
+		}
+		records = append(records, record)
+	}
+	return
+}
+
+// historyCommand implements the "history" subcommand, which queries the
+// history database:
+//
+//	costpuller history runs
+//	costpuller history account <account-id>
+//	costpuller history export [-csv <file>]
+//
+// The account and export queries are limited to the runs of the -costtype,
+// if it is given.
+func historyCommand(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	accountsFilePtr := flags.String("accounts", "accounts.yaml", "file to read the configuration from")
+	csvFilePtr := flags.String("csv", "", "output file for exported time series (default standard output)")
+	costTypePtr := flags.String("costtype", "", "cost type of the runs which are queried (default all)")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "Usage: costpuller history [options] runs | account <account-id> | export | import")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	accountsFile, err := loadAccountsFile(*accountsFilePtr)
+	if err != nil {
+		fatalf("[history] error loading accounts file: %v", err)
+	}
+	store, err := openHistoryStore(getHistoryPath(accountsFile.Configuration))
+	if err != nil {
+		fatalf("[history] %v", err)
+	}
+	defer store.close()
+
+	switch flags.Arg(0) {
+	case "runs":
+		runs, err := store.runs()
+		if err != nil {
+			fatalf("[history] error reading history: %v", err)
+		}
+		for _, run := range runs {
+			fmt.Printf("%s  %s  %-16s %5d accounts  %14.2f\n", run.RunTime.Format(time.RFC3339),
+				run.Month, run.CostType, run.Accounts, run.Total)
+		}
+	case "account":
+		if flags.NArg() != 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		records, err := store.latestRecords(flags.Arg(1), *costTypePtr)
+		if err != nil {
+			fatalf("[history] error reading history: %v", err)
+		}
+		var previous float64
+		for idx, record := range records {
+			change := ""
+			if idx > 0 && previous != 0 {
+				change = fmt.Sprintf("%+.1f%%", (record.Total-previous)/previous*100)
+			}
+			fmt.Printf("%s  %-20s %14.2f  %8s\n", record.Month, record.Group, record.Total, change)
+			previous = record.Total
+		}
+	case "export":
+		records, err := store.latestRecords("", *costTypePtr)
+		if err != nil {
+			fatalf("[history] error reading history: %v", err)
+		}
+		out := os.Stdout
+		if *csvFilePtr != "" {
+			out, err = os.Create(*csvFilePtr)
+			if err != nil {
+				fatalf("[history] error creating output file: %v", err)
+			}
+			defer closeFile(out)
+		}
+		if err := writeHistoryTimeSeries(out, records); err != nil {
+			fatalf("[history] error writing time series: %v", err)
+		}
+	case "import":
+		importHistoryFromSheets(accountsFile, store)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// writeHistoryTimeSeries writes the records as a CSV time series, with one row
+// per account and one column per month containing the account's total.
+func writeHistoryTimeSeries(out *os.File, records []HistoryRecord) error {
+	monthsSet := make(map[string]struct{})
+	totals := make(map[string]map[string]float64)
+	groups := make(map[string]string)
+	for _, record := range records {
+		monthsSet[record.Month] = struct{}{}
+		if _, exists := totals[record.AccountID]; !exists {
+			totals[record.AccountID] = make(map[string]float64)
+		}
+		totals[record.AccountID][record.Month] = record.Total
+		groups[record.AccountID] = record.Group
+	}
+	months := sortedKeys(monthsSet)
+	writer := csv.NewWriter(out)
+	defer writer.Flush()
+	if err := writer.Write(append([]string{"Team", "Account ID"}, months...)); err != nil {
+		return err
+	}
+	for _, accountID := range sortedKeys(totals) {
+		row := []string{groups[accountID], accountID}
+		for _, month := range months {
+			if total, exists := totals[accountID][month]; exists {
+				row = append(row, fmt.Sprintf("%.2f", total))
+			} else {
+				row = append(row, "")
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
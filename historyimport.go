@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// importHistoryFromSheets loads the raw data sheets of the months which
+// predate the tool's history from the configured spreadsheet into the history
+// store.  A sheet is recognized by its name matching the "sheetNameTemplate"
+// (e.g., "Raw Data 01/2006"); each is recorded as a run at the start of its
+// month, so that the data from any run of the tool for the same month takes
+// precedence.  Months which already have records are skipped.
+func importHistoryFromSheets(accountsFile AccountsFile, store *HistoryStore) {
+	oauthConfig := getMapKeyValue(accountsFile.Configuration, "oauth", "configuration")
+	// The history is imported from the first (i.e., the master) spreadsheet.
+	gsheetConfig := getGsheetDestinations(getMapKeyValue(accountsFile.Configuration, "gsheet", "configuration"))[0]
+	configureTaxonomy(accountsFile.Configuration["taxonomy"], accountsFile.Configuration["azure"])
+	template := getMapKeyString(gsheetConfig, "sheetNameTemplate", "gsheet")
+	spreadsheetId := getMapKeyString(gsheetConfig, "spreadsheetId", "gsheet")
+
+	srv, err := sheets.NewService(context.Background(),
+		option.WithHTTPClient(getGoogleOAuthHttpClient(oauthConfig)))
+	if err != nil {
+		exitf(ExitOutputFailure, "[history] unable to create Google Sheets client: %v", err)
+	}
+	spreadsheet, err := srv.Spreadsheets.Get(spreadsheetId).Fields("sheets/properties/title").Do()
+	if err != nil {
+		exitf(ExitProviderError, "[history] error retrieving spreadsheet: %v", err)
+	}
+
+	existing, err := store.latestRecords("", "")
+	if err != nil {
+		fatalf("[history] error reading history: %v", err)
+	}
+	recorded := make(map[string]bool)
+	for _, record := range existing {
+		recorded[record.Month] = true
+	}
+
+	for _, sheet := range spreadsheet.Sheets {
+		title := sheet.Properties.Title
+		ref, err := time.Parse(template, title)
+		if err != nil {
+			continue // Not a raw data sheet
+		}
+		month := ref.Format("2006-01")
+		if recorded[month] {
+			log.Printf("[history] skipping sheet %q:  %s is already in the history", title, month)
+			continue
+		}
+		values, err := srv.Spreadsheets.Values.Get(spreadsheetId, fmt.Sprintf("'%s'", title)).
+			ValueRenderOption("UNFORMATTED_VALUE").Do()
+		if err != nil {
+			exitf(ExitProviderError, "[history] error reading sheet %q: %v", title, err)
+		}
+		rows := make([][]string, len(values.Values))
+		for i, row := range values.Values {
+			rows[i] = make([]string, len(row))
+			for j, cell := range row {
+				rows[i][j] = fmt.Sprint(cell)
+			}
+		}
+		if len(rows) > 0 {
+			restoreHeaders(rows[0], gsheetConfig)
+		}
+		recordMap, err := getRunRecordsFromRows(rows)
+		if err != nil {
+			log.Printf("[history] skipping sheet %q:  %v", title, err)
+			continue
+		}
+		var records []HistoryRecord
+		for _, key := range sortedKeys(recordMap) {
+			record := recordMap[key]
+			if record.Month == "" {
+				record.Month = month
+			}
+			records = append(records, record)
+		}
+		run := HistoryRun{RunTime: ref, Month: month, CostType: historyImportedCostType}
+		if err := store.recordRun(run, records); err != nil {
+			fatalf("[history] error recording imported history: %v", err)
+		}
+		log.Printf("[history] imported %d accounts for %s from sheet %q", len(records), month, title)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// HttpFixture is a recorded HTTP interaction:  the request (method and URL)
+// and the response to it, with the credentials removed (see sanitizeUrl(),
+// sanitizeHeader(), and sanitizeBody()).
+type HttpFixture struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body"`
+}
+
+// HttpFixtures records the HTTP interactions with the Cloudability and IBM
+// Cloud APIs, or replays them in place of the APIs, so that the parsing of
+// real responses can be exercised (e.g., in CI) without credentials, and
+// changes in the APIs' schemas are caught.  The interactions of each service
+// (see httpFixtureServices) are kept in <service>.jsonl in the directory, one
+// JSON object per line; recording replaces the files.  When replaying, the
+// responses to each request (method and URL) are returned in the order in
+// which they were recorded, the last one being repeated (e.g., for token
+// refreshes and polling); a request which was not recorded fails.
+type HttpFixtures struct {
+	dir    string
+	replay bool
+
+	mutex    sync.Mutex
+	files    map[string]*os.File      // Recording:  the file for each service
+	recorded map[string][]HttpFixture // Replaying:  by service, method, and URL
+	served   map[string]int           // Replaying:  the number served, by key
+}
+
+// httpFixtureServices are the (audit log) names of the HTTP clients whose
+// interactions are recorded or replayed.
+var httpFixtureServices = []string{"cloudability", "ibmcloud", "ibmcloud-iam", "ibmcloud-tagging"}
+
+// httpFixtures is the recorder (or player) for the process; when it is nil
+// (the default), the HTTP interactions are neither recorded nor replayed.
+var httpFixtures *HttpFixtures
+
+// openHttpFixtures enables recording the HTTP interactions to the first
+// directory, or replaying them from the second; empty directory names leave
+// it disabled.
+func openHttpFixtures(recordDir string, replayDir string) {
+	switch {
+	case recordDir != "" && replayDir != "":
+		exitf(ExitUsage, "[openHttpFixtures] -record-http cannot be used with -replay-http")
+	case recordDir != "":
+		if err := os.MkdirAll(recordDir, 0700); err != nil {
+			exitf(ExitOutputFailure, "[openHttpFixtures] error creating %s: %v", recordDir, err)
+		}
+		httpFixtures = &HttpFixtures{dir: recordDir, files: make(map[string]*os.File)}
+		exitHooks = append(exitHooks, httpFixtures.close)
+	case replayDir != "":
+		httpFixtures = &HttpFixtures{dir: replayDir, replay: true,
+			recorded: make(map[string][]HttpFixture), served: make(map[string]int)}
+		for _, service := range httpFixtureServices {
+			if err := httpFixtures.load(service); err != nil {
+				exitf(ExitUsage, "[openHttpFixtures] error reading the %s fixtures: %v", service, err)
+			}
+		}
+	}
+}
+
+// load reads the recorded interactions of the service, if there are any.
+func (f *HttpFixtures) load(service string) error {
+	file, err := os.Open(filepath.Join(f.dir, service+".jsonl"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer closeFile(file)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		var fixture HttpFixture
+		if err := json.Unmarshal(scanner.Bytes(), &fixture); err != nil {
+			return err
+		}
+		key := getHttpFixtureKey(service, fixture.Method, fixture.URL)
+		f.recorded[key] = append(f.recorded[key], fixture)
+	}
+	return scanner.Err()
+}
+
+// close closes the recording files.
+func (f *HttpFixtures) close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for service, file := range f.files {
+		closeFile(file)
+		delete(f.files, service)
+	}
+}
+
+// wrap returns the transport for the service's HTTP client:  the given one,
+// wrapped to record or replay its interactions, if the service is covered.
+func (f *HttpFixtures) wrap(service string, base http.RoundTripper) http.RoundTripper {
+	if f == nil || !slices.Contains(httpFixtureServices, service) {
+		return base
+	}
+	return &httpFixtureTransport{fixtures: f, service: service, base: base}
+}
+
+// httpFixtureTransport is an HTTP transport which records or replays the
+// interactions of one service.
+type httpFixtureTransport struct {
+	fixtures *HttpFixtures
+	service  string
+	base     http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *httpFixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.fixtures.replay {
+		if req.Body != nil {
+			_ = req.Body.Close() // The request body is not recorded
+		}
+		return t.fixtures.play(t.service, req)
+	}
+	response, err := t.base.RoundTrip(req)
+	if err != nil {
+		return response, err
+	}
+	body, err := io.ReadAll(response.Body)
+	closeBody(response)
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	t.fixtures.record(t.service, HttpFixture{
+		Method: req.Method,
+		URL:    sanitizeUrl(req.URL),
+		Status: response.StatusCode,
+		Header: sanitizeHeader(response.Header),
+		Body:   sanitizeBody(body),
+	})
+	return response, nil
+}
+
+// record appends the interaction to the service's file; failures are logged
+// but do not stop the run.
+func (f *HttpFixtures) record(service string, fixture HttpFixture) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	file, exists := f.files[service]
+	if !exists {
+		var err error
+		file, err = os.OpenFile(filepath.Join(f.dir, service+".jsonl"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Printf("[HttpFixtures] error creating the %s fixtures file: %v", service, err)
+			return
+		}
+		f.files[service] = file
+	}
+	data, err := json.Marshal(fixture)
+	if err == nil {
+		_, err = file.Write(append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("[HttpFixtures] error recording %s %s: %v", fixture.Method, fixture.URL, err)
+	}
+}
+
+// play returns the recorded response to the request.
+func (f *HttpFixtures) play(service string, req *http.Request) (*http.Response, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := getHttpFixtureKey(service, req.Method, sanitizeUrl(req.URL))
+	fixtures := f.recorded[key]
+	if len(fixtures) == 0 {
+		return nil, fmt.Errorf("no recorded %s response for %s %s", service, req.Method, sanitizeUrl(req.URL))
+	}
+	fixture := fixtures[min(f.served[key], len(fixtures)-1)]
+	f.served[key]++
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fixture.Status, http.StatusText(fixture.Status)),
+		StatusCode:    fixture.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        fixture.Header.Clone(),
+		Body:          io.NopCloser(strings.NewReader(fixture.Body)),
+		ContentLength: int64(len(fixture.Body)),
+		Request:       req,
+	}, nil
+}
+
+// getHttpFixtureKey returns the key under which an interaction is replayed.
+func getHttpFixtureKey(service string, method string, url string) string {
+	return service + " " + method + " " + url
+}
+
+// httpFixtureSecretPattern matches the names of the query parameters, headers,
+// and JSON fields whose values are credentials.
+var httpFixtureSecretPattern = regexp.MustCompile(`(?i)token|secret|password|api_?key|authorization|cookie`)
+
+// httpFixtureRedacted replaces the credentials in the recorded interactions.
+const httpFixtureRedacted = "REDACTED"
+
+// sanitizeUrl returns the URL with the values of any credential parameters
+// replaced.
+func sanitizeUrl(requestUrl *url.URL) string {
+	sanitized := *requestUrl
+	sanitized.User = nil
+	query := sanitized.Query()
+	for name := range query {
+		if httpFixtureSecretPattern.MatchString(name) {
+			query[name] = []string{httpFixtureRedacted}
+		}
+	}
+	sanitized.RawQuery = query.Encode()
+	return sanitized.String()
+}
+
+// sanitizeHeader returns a copy of the response headers with the values of
+// the credential headers replaced.  (The Content-Length is dropped, since the
+// body may be changed by sanitizeBody().)
+func sanitizeHeader(header http.Header) http.Header {
+	sanitized := header.Clone()
+	sanitized.Del("Content-Length")
+	for name := range sanitized {
+		if httpFixtureSecretPattern.MatchString(name) {
+			sanitized[name] = []string{httpFixtureRedacted}
+		}
+	}
+	return sanitized
+}
+
+// sanitizeBody returns the response body with the values of any credential
+// fields replaced, if it is JSON; other bodies are returned as they are.
+func sanitizeBody(body []byte) string {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // Keep the numbers exactly as they were
+	if err := decoder.Decode(&value); err != nil {
+		return string(body)
+	}
+	data, err := json.Marshal(sanitizeJsonValue(value))
+	if err != nil {
+		return string(body)
+	}
+	return string(data)
+}
+
+// sanitizeJsonValue replaces the values of the credential fields in a decoded
+// JSON value.
+func sanitizeJsonValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for name, field := range value {
+			if _, isString := field.(string); isString && httpFixtureSecretPattern.MatchString(name) {
+				value[name] = httpFixtureRedacted
+			} else {
+				value[name] = sanitizeJsonValue(field)
+			}
+		}
+	case []any:
+		for idx, item := range value {
+			value[idx] = sanitizeJsonValue(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// replayHttpFixtures replays the sanitized fixtures in testdata/httpfixtures
+// for the duration of the test, and restores the run's global state (the
+// notes of skipped costs, untracked accounts, and warnings) afterwards.
+func replayHttpFixtures(t *testing.T) {
+	t.Helper()
+	isolateRunNotes(t)
+	savedFixtures, savedArchive := httpFixtures, responseArchive
+	t.Cleanup(func() { httpFixtures, responseArchive = savedFixtures, savedArchive })
+	responseArchive = nil
+	openHttpFixtures("", "testdata/httpfixtures")
+}
+
+// newReplayOptions returns the command line options for pulling the month of
+// the fixtures.
+func newReplayOptions() CommandLineOptions {
+	month, costType, granularity, debug := "2024-03", "UnblendedCost", "", false
+	return CommandLineOptions{monthPtr: &month, costTypePtr: &costType, granularityPtr: &granularity, debugPtr: &debug}
+}
+
+// checkCostCells compares the cost cells with the expected ones.
+func checkCostCells(t *testing.T, costCells map[string]map[string]float64, expected map[string]map[string]float64) {
+	t.Helper()
+	if len(costCells) != len(expected) {
+		t.Errorf("there are cost cells for accounts %v, expected %v", sortedKeys(costCells), sortedKeys(expected))
+	}
+	for account, row := range expected {
+		if len(costCells[account]) != len(row) {
+			t.Errorf("account %s has costs %v, expected %v", account, costCells[account], row)
+			continue
+		}
+		for category, cost := range row {
+			if math.Abs(costCells[account][category]-cost) > 1e-9 {
+				t.Errorf("account %s has %s cost %.2f, expected %.2f", account, category,
+					costCells[account][category], cost)
+			}
+		}
+	}
+}
+
+func TestCloudabilityReplay(t *testing.T) {
+	replayHttpFixtures(t)
+	configMap := Configuration{
+		"api":         "https://api.cloudability.com",
+		"api_key":     "REDACTED",
+		"cost_center": []any{"Engineering"},
+	}
+	accountsMetadata := map[string]*AccountMetadata{
+		"1234-5678-9012": {AccountId: "1234-5678-9012", CloudProvider: "Amazon"},
+		"2109-8765-4321": {AccountId: "2109-8765-4321", CloudProvider: "Amazon"},
+	}
+
+	cldy := getCloudabilityData(configMap, nil, newReplayOptions())
+	costCells := make(map[string]map[string]float64)
+	columnHeadsSet := make(map[string]struct{})
+	metadata := make(map[string]providerAccountMetadata)
+	getSheetDataFromCloudability(cldy, accountsMetadata, configMap, costCells, columnHeadsSet, metadata)
+
+	// The Storage costs billed to two payers are summed.
+	checkCostCells(t, costCells, map[string]map[string]float64{
+		"1234-5678-9012": {"Instance Usage": 100.25, "Storage": 25.00},
+		"2109-8765-4321": {"Data Transfer": 7.75},
+	})
+	if name := metadata["1234-5678-9012"].AccountName; name != "eng-prod" {
+		t.Errorf("account 1234-5678-9012 has name %q, expected \"eng-prod\"", name)
+	}
+	if date := metadata["2109-8765-4321"].Date; date != "2024-03" {
+		t.Errorf("account 2109-8765-4321 has date %q, expected \"2024-03\"", date)
+	}
+	if len(untrackedAccounts) != 1 || untrackedAccounts[0].AccountId != "5555-6666-7777" {
+		t.Errorf("the untracked accounts are %v, expected only 5555-6666-7777", untrackedAccounts)
+	}
+	for _, warning := range runWarnings {
+		if strings.Contains(warning, "schema") {
+			t.Errorf("the Cloudability response does not match the schema:  %s", warning)
+		}
+	}
+}
+
+func TestIbmcloudReplay(t *testing.T) {
+	replayHttpFixtures(t)
+	configMap := Configuration{
+		"api_key":     "REDACTED",
+		"account_id":  "0123456789abcdef0123456789abcdef",
+		"cost_center": "Engineering",
+		"retries":     0,
+	}
+	accountsMetadata := map[string]*AccountMetadata{
+		"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {AccountId: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", CloudProvider: "IBM"},
+		"bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb": {AccountId: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", CloudProvider: "IBM"},
+	}
+
+	results := getIbmcloudData(configMap, newReplayOptions(), accountsMetadata)
+	if len(results) != 2 {
+		t.Fatalf("the pull returned %d accounts, expected 2 (one on each page of the enterprise report)", len(results))
+	}
+	costCells := make(map[string]map[string]float64)
+	columnHeadsSet := make(map[string]struct{})
+	metadata := make(map[string]providerAccountMetadata)
+	getSheetDataFromIbmcloud(results, accountsMetadata, configMap, costCells, columnHeadsSet, metadata, nil)
+
+	// Resources are costed before discounts; "Event Streams" is not mapped.
+	checkCostCells(t, costCells, map[string]map[string]float64{
+		"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {
+			"Instance Usage": 100, "Storage": 20, "Other": 5, "Discounts": -10, "Credits": -15,
+		},
+		"bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb": {"VPC Endpoint": 100, "Storage": 35, "Discounts": -5},
+	})
+	if costCenter := metadata["bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"].CostCenter; costCenter != "Engineering" {
+		t.Errorf("account bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb has cost center %q, expected \"Engineering\"", costCenter)
+	}
+	if currency := metadata["aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"].Currency; currency != "USD" {
+		t.Errorf("account aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa has currency %q, expected \"USD\"", currency)
+	}
+	if checkFailures != nil {
+		t.Errorf("the IBM Cloud pull failed the consistency checks:  %q", checkFailures)
+	}
+}
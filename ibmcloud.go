@@ -1,9 +1,17 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"github.com/IBM/platform-services-go-sdk/usagereportsv4"
 	"log"
+	"math"
+	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/IBM/go-sdk-core/v5/core"
 	"github.com/IBM/platform-services-go-sdk/enterpriseusagereportsv1"
@@ -14,19 +22,23 @@ const CloudProvider = "IBM"   // Key in the 'cloud_providers' section of the acc
 
 type IbmcResultsEntry struct {
 	ResultsEntry
-	Data *usagereportsv4.AccountSummary
+	Data     *usagereportsv4.AccountSummary
+	TagCosts map[string]float64 // Tag value -> cost, when "tag_key" is configured
 }
 
-func getIbmcloudData(configMap Configuration, options CommandLineOptions) []IbmcResultsEntry {
+// getIbmcloudData returns the usage of the accounts in the configured account
+// group for the month, with, when "tag_key" is configured, the costs of those
+// in the accounts file (and not excluded) attributed by the tag.
+func getIbmcloudData(
+	configMap Configuration,
+	options CommandLineOptions,
+	accountsMetadata map[string]*AccountMetadata,
+) []IbmcResultsEntry {
+	defer startPhase("ibmcloud.pull")()
 	accountIdStr := getMapKeyString(configMap, "account_id", ConfigSect)
 
 	log.Println("[getIbmcloudData] creating session")
-	authenticator, err := core.NewIamAuthenticatorBuilder().
-		SetApiKey(getMapKeyString(configMap, "api_key", ConfigSect)).
-		Build()
-	if err != nil {
-		log.Fatalf("Error creating IBM Cloud authenticator: %v", err)
-	}
+	authenticator := getIbmcloudAuthenticator(configMap)
 
 	eurOpts := enterpriseusagereportsv1.EnterpriseUsageReportsV1Options{
 		//URL:           getMapKeyString(configMap, "endpoint", ConfigSect),  // The default works.
@@ -34,32 +46,138 @@ func getIbmcloudData(configMap Configuration, options CommandLineOptions) []Ibmc
 	}
 	eurServiceClient, err := enterpriseusagereportsv1.NewEnterpriseUsageReportsV1(&eurOpts)
 	if err != nil {
-		log.Fatalf("Error creating IBM Cloud enterprise usage reports client: %v", err)
+		fatalf("Error creating IBM Cloud enterprise usage reports client: %v", err)
 	}
+	configureIbmcloudService(eurServiceClient.Service, configMap, "ibmcloud")
 
 	grurOpts := eurServiceClient.NewGetResourceUsageReportOptions().
 		SetAccountGroupID(accountIdStr).
 		SetMonth(*options.monthPtr)
 
 	costCenter := getAccountGroupName(grurOpts, eurServiceClient)
-	result := getUsageReport(grurOpts, eurServiceClient)
+	reports := getUsageReport(grurOpts, eurServiceClient)
 
 	urOpts := usagereportsv4.UsageReportsV4Options{Authenticator: authenticator} // Use the default URL
 	urServiceClient, err := usagereportsv4.NewUsageReportsV4(&urOpts)
 	if err != nil {
-		log.Fatalf("Error creating IBM Cloud Usage Reports client: %v", err)
+		fatalf("Error creating IBM Cloud Usage Reports client: %v", err)
 	}
+	configureIbmcloudService(urServiceClient.Service, configMap, "ibmcloud")
 
-	return getAccountResults(result, costCenter, *options.monthPtr, urServiceClient)
+	results := getAccountResults(reports, costCenter, *options.monthPtr, urServiceClient)
+	if tagKey := getMapKeyString(configMap, "tag_key", ""); tagKey != "" {
+		taggingClient := newIbmcloudTaggingClient(authenticator, configMap)
+		for idx := range results {
+			// The tags are fetched for each resource instance, so they are
+			// fetched only for the accounts which are reported.
+			if md := accountsMetadata[results[idx].AccountID]; md == nil || md.Excluded {
+				continue
+			}
+			results[idx].TagCosts = getIbmcloudTagCosts(
+				tagKey, results[idx].AccountID, *options.monthPtr, urServiceClient, taggingClient)
+		}
+	}
+	return results
 }
 
+// getIbmcloudAuthenticator returns the authenticator for the IBM Cloud APIs.
+// When a trusted profile is configured (by "trusted_profile_id" or
+// "trusted_profile_name"), the compute resource token which is mounted in the
+// pod (the SDK looks in the standard locations, such as
+// /var/run/secrets/tokens/sa-token, unless "cr_token_filename" is set) is
+// exchanged for an IAM token for the profile, so that no long-lived API key
+// is needed; otherwise, the "api_key" value is used.
+func getIbmcloudAuthenticator(configMap Configuration) (authenticator core.Authenticator) {
+	client := newAuditedHttpClient("ibmcloud-iam", time.Second*30)
+	profileID := getMapKeyString(configMap, "trusted_profile_id", "")
+	profileName := getMapKeyString(configMap, "trusted_profile_name", "")
+	var err error
+	if profileID != "" || profileName != "" {
+		builder := core.NewContainerAuthenticatorBuilder().SetClient(client)
+		if profileID != "" {
+			builder.SetIAMProfileID(profileID)
+		} else {
+			builder.SetIAMProfileName(profileName)
+		}
+		if filename := getMapKeyString(configMap, "cr_token_filename", ""); filename != "" {
+			builder.SetCRTokenFilename(filename)
+		}
+		authenticator, err = builder.Build()
+	} else {
+		authenticator, err = core.NewIamAuthenticatorBuilder().
+			SetApiKey(getMapKeyString(configMap, "api_key", ConfigSect)).
+			SetClient(client).
+			Build()
+	}
+	if err != nil {
+		exitf(ExitAuthFailure, "Error creating IBM Cloud authenticator: %v", err)
+	}
+	return
+}
+
+// defaultIbmcloudRetries and defaultIbmcloudRetrySeconds are the number of
+// times that a failed IBM Cloud API call (with a 429 or 5xx status, or a
+// connection error) is retried and the longest wait between attempts, unless
+// the "retries" and "max_retry_interval_seconds" keys are configured.
+const defaultIbmcloudRetries = 3
+const defaultIbmcloudRetrySeconds = 30
+
+// configureIbmcloudService sets up the HTTP client for an IBM Cloud service:
+// its requests are recorded in the audit log, limited to the configured
+// "requests_per_second" (if any), and retried with exponential backoff.
+// Setting "retries" to zero disables the retries.
+func configureIbmcloudService(service *core.BaseService, configMap Configuration, auditName string) {
+	client := newAuditedHttpClient(auditName, time.Second*60)
+	if perSecond := getMapKeyInt(configMap, "requests_per_second", ""); perSecond > 0 {
+		client.Transport = &rateLimitTransport{interval: time.Second / time.Duration(perSecond), base: client.Transport}
+	}
+	service.SetHTTPClient(client)
+
+	retries := defaultIbmcloudRetries
+	if _, exists := configMap["retries"]; exists {
+		retries = getMapKeyInt(configMap, "retries", "")
+	}
+	interval := getMapKeyInt(configMap, "max_retry_interval_seconds", "")
+	if interval <= 0 {
+		interval = defaultIbmcloudRetrySeconds
+	}
+	if retries > 0 {
+		service.EnableRetries(retries, time.Duration(interval)*time.Second)
+	}
+}
+
+// rateLimitTransport is an HTTP transport which spaces the starts of its
+// requests at least the given interval apart.
+type rateLimitTransport struct {
+	interval time.Duration
+	base     http.RoundTripper
+	mutex    sync.Mutex
+	next     time.Time
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mutex.Lock()
+	wait := time.Until(t.next)
+	t.next = time.Now().Add(max(wait, 0) + t.interval)
+	t.mutex.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// getAccountResults fetches the account summary for each of the accounts in
+// the enterprise report.  (Unlike the enterprise report, the account summary
+// response is not paginated:  it has no "next" link, and it includes all of
+// the account's resources.)
 func getAccountResults(
-	result *enterpriseusagereportsv1.Reports,
+	reports []enterpriseusagereportsv1.ResourceUsageReport,
 	costCenter string,
 	month string,
 	urServiceClient *usagereportsv4.UsageReportsV4,
 ) (returnValue []IbmcResultsEntry) {
-	for _, account := range result.Reports {
+	for _, account := range reports {
 		resultEntry := IbmcResultsEntry{
 			ResultsEntry: ResultsEntry{
 				AccountID:      *account.EntityID,
@@ -73,18 +191,22 @@ func getAccountResults(
 
 		log.Printf("[getIbmcloudData] getting account summary for %s", *account.EntityID)
 		summaryOpts := urServiceClient.NewGetAccountSummaryOptions(*account.EntityID, month)
-		as, response, err := urServiceClient.GetAccountSummary(summaryOpts)
-		if err != nil {
-			log.Fatalf("Error getting IBM Cloud account summary: %v", err)
-		}
-		if response.StatusCode != 200 {
-			log.Fatalf(
-				"HTTP error %d getting IBM Cloud account summary: %v",
-				response.StatusCode,
-				response,
-			)
-		}
-		resultEntry.Data = as
+		resultEntry.Data, _ = archived("ibmcloud", *account.EntityID, "account summary",
+			func() (*usagereportsv4.AccountSummary, error) {
+				as, response, err := urServiceClient.GetAccountSummary(summaryOpts)
+				if err != nil {
+					exitf(getIbmcloudExitCode(response, err), "Error getting IBM Cloud account summary: %v", err)
+				}
+				if response.StatusCode != 200 {
+					exitf(
+						getHttpExitCode(response.StatusCode),
+						"HTTP error %d getting IBM Cloud account summary: %v",
+						response.StatusCode,
+						response,
+					)
+				}
+				return as, nil
+			})
 		returnValue = append(returnValue, resultEntry)
 	}
 	return
@@ -99,12 +221,26 @@ func getAccountGroupName(
 	return *result.Reports[0].EntityName
 }
 
+// getUsageReport returns the reports for the accounts in the account group,
+// following the "next" links to fetch each page of the enterprise report.
 func getUsageReport(
 	serviceOptions *enterpriseusagereportsv1.GetResourceUsageReportOptions,
 	serviceClient *enterpriseusagereportsv1.EnterpriseUsageReportsV1,
-) *enterpriseusagereportsv1.Reports {
+) (reports []enterpriseusagereportsv1.ResourceUsageReport) {
 	serviceOptions.SetChildren(true) // Get the accounts in the group
-	return serviceCall(serviceOptions, serviceClient, "enterprise summaries")
+	for {
+		result := serviceCall(serviceOptions, serviceClient, "enterprise summaries")
+		reports = append(reports, result.Reports...)
+		if result.Next == nil || result.Next.Href == nil {
+			return
+		}
+		offset, err := core.GetQueryParam(result.Next.Href, "offset")
+		if err != nil || offset == nil {
+			exitf(ExitProviderError, "Error getting the offset of the next page of IBM Cloud enterprise summaries from %q: %v",
+				*result.Next.Href, err)
+		}
+		serviceOptions.SetOffset(*offset)
+	}
 }
 
 func serviceCall(
@@ -113,14 +249,21 @@ func serviceCall(
 	logId string,
 ) *enterpriseusagereportsv1.Reports {
 	log.Printf("[getIbmcloudData] getting %s", logId)
-	result, response, err := serviceClient.GetResourceUsageReport(serviceOptions)
-	if err != nil {
-		log.Fatalf("Error getting IBM Cloud %s: %v", logId, err)
-	}
-	if response.StatusCode != 200 {
-		log.Fatalf("HTTP error %d getting IBM Cloud %s: %v",
-			response.StatusCode, logId, response)
+	name := logId
+	if serviceOptions.Offset != nil {
+		name += "-" + *serviceOptions.Offset
 	}
+	result, _ := archived("ibmcloud", *serviceOptions.AccountGroupID, name, func() (*enterpriseusagereportsv1.Reports, error) {
+		result, response, err := serviceClient.GetResourceUsageReport(serviceOptions)
+		if err != nil {
+			exitf(getIbmcloudExitCode(response, err), "Error getting IBM Cloud %s: %v", logId, err)
+		}
+		if response.StatusCode != 200 {
+			exitf(getHttpExitCode(response.StatusCode), "HTTP error %d getting IBM Cloud %s: %v",
+				response.StatusCode, logId, response)
+		}
+		return result, nil
+	})
 	return result
 }
 
@@ -130,27 +273,31 @@ func getSheetDataFromIbmcloud(
 	accountsMetadata map[string]*AccountMetadata,
 	configMap Configuration,
 	costCells map[string]map[string]float64,
+	columnHeadsSet map[string]struct{},
 	metadata map[string]providerAccountMetadata,
+	reportFile *os.File,
 ) {
+	defer startPhase("ibmcloud.normalize")()
 	// Build a two-dimensional map in which the first key is the account ID,
 	// the second key is the usage family, and the value is the corresponding
 	// cost -- this amounts to a sparse sheet grid.  While we're at it, collect
 	// the column headers for the grid (using a map "trick" where we only care
 	// about the keys), and collect some metadata for each account.
-	ignored := make(map[string]struct{}) // Suppress multiple warnings
+	ourCostCenters := getCostCenterMatcher(configMap)
 	for _, accountSummary := range accounts {
-		// Skip accounts that we're not looking for, but keep a list of them so
-		// that we don't issue multiple warnings for them; warn about accounts
-		// attributed to our cost center that we're not currently tracking.
+		// Skip accounts that we're not looking for, accounting for their
+		// costs; warn about accounts attributed to our cost center that we're
+		// not currently tracking.
 		accountId := accountSummary.AccountID
+		cost, _ := strconv.ParseFloat(accountSummary.Cost, 64) // Formatted by getAccountResults()
 		if skipAccountEntry(
 			accountsMetadata[accountId],
 			accountId,
 			accountSummary.CostCenter,
 			accountSummary.CloudProvider,
 			accountSummary.AccountName,
-			ignored,
-			configMap,
+			cost,
+			ourCostCenters,
 			"IBM Cloud",
 		) {
 			continue
@@ -160,7 +307,7 @@ func getSheetDataFromIbmcloud(
 		if _, exists := costCells[accountId]; !exists {
 			costCells[accountId] = make(map[string]float64)
 		} else {
-			log.Fatalf(
+			fatalf(
 				"[getSheetDataFromIbmcloud] Cost cell row for account %q already exists",
 				accountId)
 		}
@@ -171,40 +318,29 @@ func getSheetDataFromIbmcloud(
 			CostCenter:     accountSummary.CostCenter,
 			Date:           *accountSummary.Data.Month,
 			PayerAccountId: accountSummary.PayerAccountId,
+			Currency:       getStringValue(accountSummary.Data.BillingCurrencyCode),
 		}
 
+		// The resource costs are placed in the buckets before discounts, and the
+		// discounts and the credits (from offers and subscriptions) used this
+		// month are placed in their own (negative) columns, so that the total
+		// matches the invoice.
+		var billable, nonBillable, discounts float64
 		for _, resource := range accountSummary.Data.AccountResources {
-			// Place costs according to their resource ID into the Cloudability
-			// "Usage Family" buckets.
-			//
-			// Note:  in several cases, the bucketing is arbitrary and probably
-			//        incorrect....
-			bucket := "Other"
-			switch *resource.ResourceName {
-			case "Block Storage for VPC",
-				"Cloud Object Storage":
-				bucket = "Storage"
-			case "Cloud Activity Tracker", "Cloud Monitoring":
-				bucket = "Notifications"
-			case "Continuous Delivery", "Log Analysis":
-				bucket = "Other"
-			case "Floating IP for VPC":
-				bucket = "IP Address"
-			case "Kubernetes Service":
-				bucket = "Instance Usage"
-			case "Load Balancer for VPC":
-				bucket = "Load Balancer"
-			case "Virtual Private Cloud":
-				bucket = "VPN"
-			case "Virtual Private Endpoint for VPC", "Virtual Server for VPC":
-				bucket = "VPC Endpoint"
-			default:
+			// Place costs according to their resource name into the
+			// Cloudability "Usage Family" buckets (see CategoryTaxonomy).
+			bucket, mapped := categoryTaxonomy.lookup("ibmcloud", *resource.ResourceName)
+			if !mapped {
+				bucket = categoryTaxonomy.Other
 				log.Printf(
 					"[getSheetDataFromIbmcloud] unexpected resource %q (%s); using category %q",
 					*resource.ResourceName, *resource.ResourceID, bucket)
 			}
 
-			costCells[accountId][bucket] += *resource.BillableCost
+			costCells[accountId][bucket] += getFloatValue(resource.BillableRatedCost)
+			billable += getFloatValue(resource.BillableCost)
+			nonBillable += getFloatValue(resource.NonBillableCost)
+			discounts += getFloatValue(resource.BillableRatedCost) - getFloatValue(resource.BillableCost)
 
 			//for _, plan := range resource.Plans {
 			//	for _, usage := range plan.Usage {
@@ -300,5 +436,96 @@ func getSheetDataFromIbmcloud(
 			//	}
 			//}
 		}
+
+		credits := getIbmcloudCreditsUsed(accountSummary.Data)
+		for bucket, value := range map[string]float64{"Discounts": -discounts, "Credits": -credits} {
+			if math.Abs(value) >= 0.005 {
+				costCells[accountId][bucket] = value
+				columnHeadsSet[bucket] = struct{}{}
+			}
+		}
+
+		if err := checkIbmcloudConsistency(accountSummary, billable, nonBillable); err != nil {
+			log.Printf("[getSheetDataFromIbmcloud] consistency check failed for account %s: %v", accountId, err)
+			writeReport(reportFile, accountId+": "+err.Error())
+			noteExitStatus(ExitConsistencyFailure, "consistency check failed for account "+accountId+": "+err.Error())
+		}
+	}
+}
+
+// getIbmcloudCreditsUsed returns the total of the offer and subscription
+// credits used by the account in the month.
+func getIbmcloudCreditsUsed(summary *usagereportsv4.AccountSummary) (credits float64) {
+	for _, offer := range summary.Offers {
+		if offer.Credits != nil {
+			credits += getFloatValue(offer.Credits.Used)
+		}
+	}
+	if summary.Subscription != nil {
+		for _, subscription := range summary.Subscription.Subscriptions {
+			for _, term := range subscription.Terms {
+				if term.Credits != nil {
+					credits += getFloatValue(term.Credits.Used)
+				}
+			}
+		}
+	}
+	return
+}
+
+// checkIbmcloudConsistency reconciles the billable and non-billable costs of
+// the account's resources with the totals in its account summary, and the
+// billable cost with the enterprise report; it returns an error describing
+// any discrepancy beyond the reconciliation tolerance.
+func checkIbmcloudConsistency(account IbmcResultsEntry, billable float64, nonBillable float64) error {
+	var problems []string
+	if summary := account.Data.Resources; summary != nil {
+		if total := getFloatValue(summary.BillableCost); !reconciliation.reconciles(total, billable) {
+			problems = append(problems, fmt.Sprintf(
+				"resources' billable cost (%.2f) does not match the summary (%.2f)", billable, total))
+		}
+		if total := getFloatValue(summary.NonBillableCost); !reconciliation.reconciles(total, nonBillable) {
+			problems = append(problems, fmt.Sprintf(
+				"resources' non-billable cost (%.2f) does not match the summary (%.2f)", nonBillable, total))
+		}
+	}
+	if reported, err := strconv.ParseFloat(account.Cost, 64); err == nil && !reconciliation.reconciles(reported, billable) {
+		problems = append(problems, fmt.Sprintf(
+			"resources' billable cost (%.2f) does not match the enterprise report (%.2f)", billable, reported))
+	}
+	if problems != nil {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// getIbmcloudExitCode returns the exit code for an error returned by an IBM
+// Cloud API; IAM token failures are reported as authentication failures.
+func getIbmcloudExitCode(response *core.DetailedResponse, err error) int {
+	var authErr *core.AuthenticationError
+	if errors.As(err, &authErr) {
+		return ExitAuthFailure
+	}
+	if response != nil {
+		return getHttpExitCode(response.StatusCode)
+	}
+	return ExitProviderError
+}
+
+// getFloatValue returns the value of an optional numeric field of an IBM
+// Cloud API response, or zero if it is not present.
+func getFloatValue(value *float64) float64 {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+// getStringValue returns the value of an optional string field of an IBM
+// Cloud API response, or an empty string if it is not present.
+func getStringValue(value *string) string {
+	if value == nil {
+		return ""
 	}
+	return *value
 }
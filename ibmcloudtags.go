@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/IBM/platform-services-go-sdk/globaltaggingv1"
+	"github.com/IBM/platform-services-go-sdk/usagereportsv4"
+	"google.golang.org/api/sheets/v4"
+)
+
+// untaggedValue is the tag value under which the costs of resources which
+// lack the attribution tag are reported.
+const untaggedValue = "(untagged)"
+
+// getIbmcloudTagCosts returns the account's costs for the month, keyed by
+// the value of the given tag key on each resource instance.  The instances are
+// listed from the account's resource usage, and their tags (user tags of the
+// form "<key>:<value>") are fetched from the Global Tagging service.  As in
+// the category columns of the main sheet, the costs are the rated costs
+// (i.e., before discounts) of the billable instances, so that an account's tag
+// costs sum to its total before the "Discounts" and "Credits" columns.
+func getIbmcloudTagCosts(
+	tagKey string,
+	accountID string,
+	month string,
+	urServiceClient *usagereportsv4.UsageReportsV4,
+	taggingClient *globaltaggingv1.GlobalTaggingV1,
+) map[string]float64 {
+	log.Printf("[getIbmcloudData] getting resource tags for %s", accountID)
+	options := urServiceClient.NewGetResourceUsageAccountOptions(accountID, month)
+	costs := make(map[string]float64)
+	for page := 1; ; page++ {
+		usage, _ := archived("ibmcloud", accountID, fmt.Sprintf("resource usage-%d", page),
+			func() (*usagereportsv4.InstancesUsage, error) {
+				usage, response, err := urServiceClient.GetResourceUsageAccount(options)
+				if err != nil {
+					exitf(getIbmcloudExitCode(response, err), "Error getting IBM Cloud resource usage for %s: %v", accountID, err)
+				}
+				return usage, nil
+			})
+		for _, instance := range usage.Resources {
+			if instance.Billable == nil || !*instance.Billable {
+				continue
+			}
+			value := getIbmcloudTagValue(taggingClient, *instance.ResourceInstanceID, tagKey)
+			for _, metric := range instance.Usage {
+				costs[value] += getFloatValue(metric.RatedCost)
+			}
+		}
+		if usage.Next == nil || usage.Next.Offset == nil {
+			return costs
+		}
+		options.SetStart(*usage.Next.Offset)
+	}
+}
+
+// getIbmcloudTagValue returns the value of the resource's user tag with the
+// given key, or untaggedValue if it has none.
+func getIbmcloudTagValue(taggingClient *globaltaggingv1.GlobalTaggingV1, crn string, tagKey string) string {
+	options := taggingClient.NewListTagsOptions().
+		SetAttachedTo(crn).
+		SetTagType(globaltaggingv1.ListTagsOptionsTagTypeUserConst)
+	tags, _ := archived("ibmcloud", "tags", crn, func() (*globaltaggingv1.TagList, error) {
+		tags, response, err := taggingClient.ListTags(options)
+		if err != nil {
+			exitf(getIbmcloudExitCode(response, err), "Error getting IBM Cloud tags for %s: %v", crn, err)
+		}
+		return tags, nil
+	})
+	for _, tag := range tags.Items {
+		if value, found := strings.CutPrefix(*tag.Name, tagKey+":"); found {
+			return value
+		}
+	}
+	return untaggedValue
+}
+
+// newIbmcloudTaggingClient returns a Global Tagging service client.
+func newIbmcloudTaggingClient(authenticator core.Authenticator, configMap Configuration) *globaltaggingv1.GlobalTaggingV1 {
+	taggingClient, err := globaltaggingv1.NewGlobalTaggingV1(
+		&globaltaggingv1.GlobalTaggingV1Options{Authenticator: authenticator}) // Use the default URL
+	if err != nil {
+		fatalf("Error creating IBM Cloud Global Tagging client: %v", err)
+	}
+	configureIbmcloudService(taggingClient.Service, configMap, "ibmcloud-tagging")
+	return taggingClient
+}
+
+// getSheetFromIbmcloudTagCosts builds a sheet with a row for each tag value
+// of each account, attributing the account's costs by the configured tag.
+// Accounts which are not in the accounts file, or which are excluded, are
+// skipped.
+func getSheetFromIbmcloudTagCosts(
+	accounts []IbmcResultsEntry,
+	accountsMetadata map[string]*AccountMetadata,
+	tagKey string,
+) (output []*sheets.RowData) {
+	output = append(output, newHeaderRow([]string{"Account ID", "Account Name", tagKey, "Cost"}))
+	for _, account := range accounts {
+		if md := accountsMetadata[account.AccountID]; md == nil || md.Excluded {
+			continue
+		}
+		for _, value := range sortedKeys(account.TagCosts) {
+			output = append(output, &sheets.RowData{Values: []*sheets.CellData{
+				newStringCell(account.AccountID),
+				newStringCell(account.AccountName),
+				newStringCell(value),
+				newNumberCell(account.TagCosts[value]),
+			}})
+		}
+	}
+	return
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultKafkaBatchSize is the number of messages sent in each request to the
+// Kafka REST proxy, unless the "batch_size" key is configured.
+const defaultKafkaBatchSize = 500
+
+// KafkaRecord is a message to be produced to a Kafka topic.
+type KafkaRecord struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+// KafkaCostMessage is the value of the message for each account's costs.
+type KafkaCostMessage struct {
+	Kind string `json:"kind"` // "cost"
+	HistoryRecord
+}
+
+// KafkaSummaryMessage is the value of the message for the run summary.
+type KafkaSummaryMessage struct {
+	Kind string `json:"kind"` // "summary"
+	RunSummary
+}
+
+// publishToKafka produces a JSON message for each account's normalized costs
+// (keyed by the account ID), followed by one for the run summary (keyed by the
+// month), to the topic configured in the "kafka" configuration section.  No
+// Kafka client library is needed:  the messages are sent through a Kafka
+// REST proxy (e.g., the Confluent REST Proxy or the Strimzi Kafka Bridge),
+// whose "url" is configured, with either a bearer "token" or a "username" and
+// "password", if it requires authentication.  The summary can be sent to a
+// separate "summary_topic".  Nothing is published if the section is absent or
+// if the run was restricted to selected accounts; failures are reported but
+// do not stop the run.
+func publishToKafka(
+	configMap Configuration,
+	options CommandLineOptions,
+	started time.Time,
+	providers []string,
+	records []HistoryRecord,
+	missing []string,
+) {
+	if configMap == nil {
+		return
+	}
+	if hasAccountFilter(options) {
+		log.Printf("[publishToKafka] not publishing the results of a partial run")
+		return
+	}
+	topic := getMapKeyString(configMap, "topic", "kafka")
+	summaryTopic := cmp.Or(getMapKeyString(configMap, "summary_topic", ""), topic)
+
+	var messages []KafkaRecord
+	for _, record := range records {
+		record.RunTime = started
+		messages = append(messages, KafkaRecord{Key: record.AccountID, Value: KafkaCostMessage{"cost", record}})
+	}
+	summary := getRunSummary(options, started, providers, records, missing)
+	err := produceKafkaRecords(configMap, topic, messages)
+	if err == nil {
+		err = produceKafkaRecords(configMap, summaryTopic,
+			[]KafkaRecord{{Key: summary.Month, Value: KafkaSummaryMessage{"summary", summary}}})
+	}
+	if err != nil {
+		msg := fmt.Sprintf("error publishing the results to Kafka: %v", err)
+		log.Printf("[publishToKafka] %s", msg)
+		noteExitStatus(ExitOutputFailure, msg)
+		return
+	}
+	log.Printf("[publishToKafka] published %d cost messages to %q and the summary to %q", len(messages), topic,
+		summaryTopic)
+}
+
+// produceKafkaRecords sends the records to the topic through the REST proxy,
+// in batches.
+func produceKafkaRecords(configMap Configuration, topic string, records []KafkaRecord) error {
+	baseUrl, err := url.Parse(getMapKeyString(configMap, "url", "kafka"))
+	if err != nil {
+		return fmt.Errorf("error parsing the Kafka REST proxy URL: %w", err)
+	}
+	batchSize := defaultKafkaBatchSize
+	if getMapKeyValue(configMap, "batch_size", "") != nil {
+		batchSize = getMapKeyInt(configMap, "batch_size", "kafka")
+	}
+	client := newAuditedHttpClient("kafka", time.Second*60)
+	topicUrl := baseUrl.JoinPath("topics", topic).String()
+	for start := 0; start < len(records); start += batchSize {
+		body, err := json.Marshal(map[string]any{"records": records[start:min(start+batchSize, len(records))]})
+		if err != nil {
+			return err
+		}
+		request, err := http.NewRequest(http.MethodPost, topicUrl, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+		request.Header.Set("Accept", "application/vnd.kafka.v2+json, application/json")
+		if token := getMapKeyString(configMap, "token", ""); token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		} else if username := getMapKeyString(configMap, "username", ""); username != "" {
+			request.SetBasicAuth(username, getMapKeyString(configMap, "password", "kafka"))
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+		closeBody(response)
+		if response.StatusCode/100 != 2 {
+			return fmt.Errorf("producing to %q: %d, %q: %s", topic, response.StatusCode, response.Status, message)
+		}
+		// The proxy reports the failure of individual records in the offsets.
+		var produced struct {
+			Offsets []struct {
+				Error string `json:"error"`
+			} `json:"offsets"`
+		}
+		if err := json.Unmarshal(message, &produced); err == nil {
+			for _, offset := range produced.Offsets {
+				if offset.Error != "" {
+					return fmt.Errorf("producing to %q: %s", topic, offset.Error)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// The severities of the findings of the accounts file lint rules.
+const (
+	LintWarning = "warning"
+	LintError   = "error"
+)
+
+// LintRule is an opinionated check of the accounts file.  Its ID is stable,
+// so that it can be referred to in the -disable flag and in merge checks.
+type LintRule struct {
+	Id       string
+	Severity string
+	check    func(accountsFile AccountsFile) []string
+}
+
+// LintFinding is a problem found by a lint rule.
+type LintFinding struct {
+	Rule    LintRule
+	Message string
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s %s: %s", f.Rule.Id, f.Rule.Severity, f.Message)
+}
+
+// lintRules lists the lint rules, in the order in which they are applied.
+var lintRules = []LintRule{
+	{"ACC001", LintError, lintZeroDeviation},
+	{"ACC002", LintError, lintLargeDeviation},
+	{"ACC003", LintWarning, lintEmptyDescription},
+	{"ACC004", LintWarning, lintEmptyGroup},
+	{"ACC005", LintWarning, lintDuplicateDescription},
+}
+
+// accountsLintCommand implements "costpuller accounts lint", which applies
+// the lint rules to an accounts file (including the files it includes) and
+// lists the findings.  The exit code is ExitConsistencyFailure if any finding
+// is at least as severe as the -fail-on severity.
+func accountsLintCommand(args []string) {
+	flags := flag.NewFlagSet("accounts lint", flag.ExitOnError)
+	failOn := flags.String("fail-on", LintError, `least severe finding which fails the check ("error" or "warning")`)
+	disable := flags.String("disable", "", "comma-separated list of the IDs of rules to skip")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "Usage: costpuller accounts lint [options] <accounts.yaml>")
+		flags.PrintDefaults()
+		_, _ = fmt.Fprintln(flags.Output(), "Rules:")
+		for _, rule := range lintRules {
+			_, _ = fmt.Fprintf(flags.Output(), "  %s (%s)\n", rule.Id, rule.Severity)
+		}
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 || (*failOn != LintError && *failOn != LintWarning) {
+		flags.Usage()
+		os.Exit(ExitUsage)
+	}
+	var disabled []string
+	if *disable != "" {
+		disabled = strings.Split(*disable, ",")
+	}
+	for _, id := range disabled {
+		if !slices.ContainsFunc(lintRules, func(rule LintRule) bool { return rule.Id == id }) {
+			fatalf("[accounts] unknown lint rule %q", id)
+		}
+	}
+
+	accountsFile, err := loadAccountsFile(flags.Arg(0))
+	if err != nil {
+		fatalf("[accounts] error loading %s: %v", flags.Arg(0), err)
+	}
+	failed := false
+	findings := lintAccountsFile(accountsFile, disabled)
+	for _, finding := range findings {
+		fmt.Println(finding)
+		if finding.Rule.Severity == LintError || *failOn == LintWarning {
+			failed = true
+		}
+	}
+	if len(findings) == 0 {
+		fmt.Println("No lint findings.")
+	}
+	if failed {
+		os.Exit(ExitConsistencyFailure)
+	}
+}
+
+// lintAccountsFile applies the lint rules, other than the disabled ones, to
+// the accounts file.
+func lintAccountsFile(accountsFile AccountsFile, disabled []string) (findings []LintFinding) {
+	for _, rule := range lintRules {
+		if slices.Contains(disabled, rule.Id) {
+			continue
+		}
+		for _, message := range rule.check(accountsFile) {
+			findings = append(findings, LintFinding{Rule: rule, Message: message})
+		}
+	}
+	return
+}
+
+// forEachAccountEntry calls the function for each account in the file, in a
+// stable order.
+func forEachAccountEntry(accountsFile AccountsFile, f func(provider string, group string, entry AccountEntry)) {
+	for _, provider := range sortedKeys(accountsFile.Providers) {
+		for _, group := range sortedKeys(accountsFile.Providers[provider]) {
+			for _, entry := range accountsFile.Providers[provider][group] {
+				f(provider, group, entry)
+			}
+		}
+	}
+}
+
+// lintZeroDeviation finds accounts with a standard value but no allowed
+// deviation, whose consistency check fails unless the cost is unchanged to
+// the cent.
+func lintZeroDeviation(accountsFile AccountsFile) (messages []string) {
+	forEachAccountEntry(accountsFile, func(provider string, group string, entry AccountEntry) {
+		if entry.StandardValue > 0 && entry.DeviationPercent == 0 {
+			messages = append(messages, fmt.Sprintf("%s %s (group %q) has a standard value of %.2f but a "+
+				"deviation percent of 0", provider, entry.AccountID, group, entry.StandardValue))
+		}
+	})
+	return
+}
+
+// lintLargeDeviation finds accounts whose allowed deviation is over 100%,
+// which makes the consistency check meaningless (a cost of zero passes).
+func lintLargeDeviation(accountsFile AccountsFile) (messages []string) {
+	forEachAccountEntry(accountsFile, func(provider string, group string, entry AccountEntry) {
+		if entry.DeviationPercent > 100 {
+			messages = append(messages, fmt.Sprintf("%s %s (group %q) has a deviation percent of %d, over 100",
+				provider, entry.AccountID, group, entry.DeviationPercent))
+		}
+	})
+	return
+}
+
+// lintEmptyDescription finds accounts without a description.
+func lintEmptyDescription(accountsFile AccountsFile) (messages []string) {
+	forEachAccountEntry(accountsFile, func(provider string, group string, entry AccountEntry) {
+		if strings.TrimSpace(entry.Description) == "" {
+			messages = append(messages, fmt.Sprintf("%s %s (group %q) has no description",
+				provider, entry.AccountID, group))
+		}
+	})
+	return
+}
+
+// lintEmptyGroup finds groups which have no accounts.
+func lintEmptyGroup(accountsFile AccountsFile) (messages []string) {
+	for _, provider := range sortedKeys(accountsFile.Providers) {
+		for _, group := range sortedKeys(accountsFile.Providers[provider]) {
+			if len(accountsFile.Providers[provider][group]) == 0 {
+				messages = append(messages, fmt.Sprintf("%s group %q has no accounts", provider, group))
+			}
+		}
+	}
+	return
+}
+
+// lintDuplicateDescription finds descriptions (ignoring case) which are
+// shared by more than one account, which makes the output ambiguous.
+func lintDuplicateDescription(accountsFile AccountsFile) (messages []string) {
+	accounts := make(map[string][]string)
+	forEachAccountEntry(accountsFile, func(provider string, group string, entry AccountEntry) {
+		if description := strings.ToLower(strings.TrimSpace(entry.Description)); description != "" {
+			accounts[description] = append(accounts[description], provider+" "+entry.AccountID)
+		}
+	})
+	for _, description := range sortedKeys(accounts) {
+		if len(accounts[description]) > 1 {
+			messages = append(messages, fmt.Sprintf("description %q is used by %s", description,
+				strings.Join(accounts[description], ", ")))
+		}
+	}
+	return
+}
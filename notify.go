@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sendNotifications dispatches a message to each of the notification sinks
+// configured in the "notifications" subsection of the configuration.
+// Currently, the only sinks are webhooks:  the "webhooks" key lists URLs to
+// which the message is POSTed as a JSON object with a "text" field (the format
+// accepted by Slack and Google Chat incoming webhooks).  Delivery failures are
+// reported but are not fatal.
+func sendNotifications(configMap Configuration, subject string, lines []string) {
+	webhooks := getMapKeyStringList(configMap, "webhooks", "")
+	if len(webhooks) == 0 || len(lines) == 0 {
+		return
+	}
+	text := subject + "\n" + strings.Join(lines, "\n")
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		log.Printf("[sendNotifications] error encoding notification: %v", err)
+		return
+	}
+	client := newAuditedHttpClient("webhook", time.Second*30)
+	for _, webhook := range webhooks {
+		response, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[sendNotifications] error sending notification: %v", err)
+			continue
+		}
+		closeBody(response)
+		if response.StatusCode/100 != 2 {
+			log.Printf("[sendNotifications] error sending notification: %d, %q", response.StatusCode, response.Status)
+		}
+	}
+}
+
+// closeBody is a helper function which allows closing an HTTP response body
+// to be deferred and which ignores any errors.
+func closeBody(response *http.Response) {
+	_ = response.Body.Close()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// ProviderFailure describes the failure of the pull from one provider, when
+// the run continues with the others.
+type ProviderFailure struct {
+	Provider string
+	Code     int    // The exit code with which the pull stopped
+	Message  string // The message passed to exitf()
+}
+
+// providerFailures lists the providers whose pulls failed during the run.
+var providerFailures []ProviderFailure
+
+// isolatingPull is set while pullIsolated() runs a provider's pull, so that
+// exitf() stops only that pull, rather than the process.
+var isolatingPull bool
+
+// pullIsolated runs the pull from the named provider so that a failure which
+// would exit the process (via exitf()) stops only the pull:  the failure is
+// logged, noted in the exit status (as ExitPartialSuccess) and in
+// providerFailures, and returned, so that the run can complete with the data
+// from the other providers.  Errors reported with fatalf() (such as
+// configuration errors) still stop the run.
+func pullIsolated(provider string, pull func()) (failure *ProviderFailure) {
+	isolatingPull = true
+	defer func() {
+		isolatingPull = false
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		stopped, ok := recovered.(ProviderFailure)
+		if !ok {
+			panic(recovered)
+		}
+		stopped.Provider = provider
+		providerFailures = append(providerFailures, stopped)
+		msg := fmt.Sprintf("the %s pull failed (exit code %d); its data is missing from the output", provider,
+			stopped.Code)
+		log.Printf("[pullIsolated] Warning:  %s", msg)
+		noteExitStatus(ExitPartialSuccess, msg)
+		failure = &stopped
+	}()
+	pull()
+	return nil
+}
+
+// ProviderRows holds the cost grid produced by one provider's pull (see
+// getSheetDataFromCloudability()) until the pull completes, so that the rows
+// of a pull which fails part way are not left in the output.
+type ProviderRows struct {
+	costCells      map[string]map[string]float64
+	columnHeadsSet map[string]struct{}
+	metadata       map[string]providerAccountMetadata
+}
+
+// newProviderRows returns an empty set of provider rows.
+func newProviderRows() ProviderRows {
+	return ProviderRows{
+		costCells:      make(map[string]map[string]float64),
+		columnHeadsSet: make(map[string]struct{}),
+		metadata:       make(map[string]providerAccountMetadata),
+	}
+}
+
+// merge adds the rows of a completed pull to the output's cost grid.
+func (r ProviderRows) merge(
+	costCells map[string]map[string]float64,
+	columnHeadsSet map[string]struct{},
+	metadata map[string]providerAccountMetadata,
+) {
+	for accountId, row := range r.costCells {
+		if _, exists := costCells[accountId]; !exists {
+			costCells[accountId] = make(map[string]float64)
+		}
+		for column, cost := range row {
+			costCells[accountId][column] += cost
+		}
+	}
+	for column := range r.columnHeadsSet {
+		columnHeadsSet[column] = struct{}{}
+	}
+	for accountId, entry := range r.metadata {
+		metadata[accountId] = entry
+	}
+}
+
+// discard drops the rows of a failed pull, marking their accounts as having
+// no data, so that they are reported as missing.
+func (r ProviderRows) discard(accountsMetadata map[string]*AccountMetadata) {
+	for accountId := range r.costCells {
+		if entry, exists := accountsMetadata[accountId]; exists {
+			entry.DataFound = false
+		}
+	}
+}
+
+// checkProviderFailures exits, with the code of the first failure, if every
+// provider pull failed, since there is then nothing to write; otherwise, it
+// lists the failures in the report file.
+func checkProviderFailures(providers []string, reportFile *os.File) {
+	if len(providerFailures) == 0 {
+		return
+	}
+	if len(providerFailures) == len(providers) {
+		exitf(providerFailures[0].Code, "[main] every provider pull failed")
+	}
+	for _, failure := range providerFailures {
+		writeReport(reportFile, fmt.Sprintf("%s: pull failed (exit code %d), its accounts' data is missing: %s",
+			failure.Provider, failure.Code, failure.Message))
+	}
+}
+
+// getSheetFromProviderFailures returns a sheet listing the failed provider
+// pulls, so that the missing data is flagged alongside the month's sheet.
+func getSheetFromProviderFailures() (output []*sheets.RowData) {
+	output = append(output, newHeaderRow([]string{"Provider", "Exit Code", "Error"}))
+	for _, failure := range providerFailures {
+		output = append(output, &sheets.RowData{Values: []*sheets.CellData{
+			newStringCell(failure.Provider),
+			newNumberCell(float64(failure.Code)),
+			newStringCell(failure.Message),
+		}})
+	}
+	return
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// PeriodCostKey identifies the cost of a usage family (for the direct AWS
+// pull, a normalized category) for an account in a day or week of the month.
+type PeriodCostKey struct {
+	Period      string
+	AccountID   string
+	UsageFamily string
+}
+
+// getGranularity returns the granularity of the period sheet:  the value of
+// the -granularity option or, if it is not set, the given configured value
+// (e.g., the Cloudability "granularity"); "monthly" (the default) means that
+// no period sheet is written.
+func getGranularity(options CommandLineOptions, configured string) string {
+	granularity := cmp.Or(*options.granularityPtr, configured, "monthly")
+	if !slices.Contains([]string{"monthly", "daily", "weekly"}, granularity) {
+		fatalf("Error in granularity value (%q), expected \"monthly\", \"daily\", or \"weekly\"", granularity)
+	}
+	return granularity
+}
+
+// getPeriodSheetName returns the default name template of the period sheet,
+// e.g., "Weekly 01/2006".
+func getPeriodSheetName(granularity string) string {
+	return strings.ToUpper(granularity[:1]) + granularity[1:] + " 01/2006"
+}
+
+// getPeriod returns the period containing the date:  the day (yyyy-mm-dd) or,
+// for the "weekly" granularity, the ISO week (e.g., "2025-W03").
+func getPeriod(date time.Time, granularity string) string {
+	if granularity == "weekly" {
+		year, week := date.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return date.Format("2006-01-02")
+}
+
+// getPeriodSheet builds a sheet with a row for each cost, ordered by period,
+// account, and usage family.
+func getPeriodSheet(costs map[PeriodCostKey]float64, names map[string]string) (output []*sheets.RowData) {
+	keys := slices.SortedFunc(maps.Keys(costs), func(a, b PeriodCostKey) int {
+		return cmp.Or(
+			cmp.Compare(a.Period, b.Period),
+			cmp.Compare(a.AccountID, b.AccountID),
+			cmp.Compare(a.UsageFamily, b.UsageFamily),
+		)
+	})
+	output = append(output, newHeaderRow([]string{"Date", "Account ID", "Account Name", "Usage Family", "Cost"}))
+	for _, key := range keys {
+		output = append(output, &sheets.RowData{Values: []*sheets.CellData{
+			newStringCell(key.Period),
+			newStringCell(key.AccountID),
+			newStringCell(names[key.AccountID]),
+			newStringCell(key.UsageFamily),
+			newNumberCell(costs[key]),
+		}})
+	}
+	return
+}
+
+// addPeriodCosts pulls the daily costs of the accounts and adds them to the
+// period costs, by category (see CategoryTaxonomy), summed
+// into days or ISO weeks according to the granularity; the account names
+// are added to the names.
+func (a *AwsPuller) addPeriodCosts(
+	accounts map[string][]AccountEntry,
+	options CommandLineOptions,
+	granularity string,
+	costs map[PeriodCostKey]float64,
+	names map[string]string,
+) {
+	organization := a.getOrganizationInfo()
+	for _, group := range sortedKeys(accounts) {
+		for _, account := range accounts[group] {
+			log.Printf("[addPeriodCosts] pulling daily data for account %s (group %s)\n", account.AccountID, group)
+			days, err := a.PullDailyServiceCosts(account.AccountID, *options.monthPtr, *options.costTypePtr)
+			if err != nil {
+				exitf(getAwsExitCode(err), "[addPeriodCosts] error pulling daily data: %v", err)
+			}
+			for _, day := range sortedKeys(days) {
+				date, err := time.Parse("2006-01-02", day)
+				if err != nil {
+					fatalf("[addPeriodCosts] unexpected date %q for account %s: %v", day, account.AccountID, err)
+				}
+				normalized, err := a.NormalizeResponse(group, day, account.AccountID, days[day])
+				if err != nil {
+					exitf(ExitProviderError, "[addPeriodCosts] error normalizing the costs of account %s for %s: %v",
+						account.AccountID, day, err)
+				}
+				for category, cost := range getHistoryRecordsFromAwsRows([]*sheets.RowData{normalized})[0].Costs {
+					if cost != 0 {
+						costs[PeriodCostKey{getPeriod(date, granularity), account.AccountID, category}] += cost
+					}
+				}
+			}
+			names[account.AccountID] = organization.AccountNames[account.AccountID]
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// quarterPattern matches a -quarter value, e.g., "2024-Q3", capturing the
+// fiscal year and the quarter number.
+var quarterPattern = regexp.MustCompile(`^(\d{4})-Q([1-4])$`)
+
+// getFiscalStartMonth returns the first month (1-12) of the fiscal year, from
+// the "start_month" key of the "fiscal_year" configuration section; by
+// default, the fiscal year is the calendar year.
+func getFiscalStartMonth(configMap Configuration) int {
+	if getMapKeyValue(configMap, "start_month", "") == nil {
+		return 1
+	}
+	startMonth := getMapKeyInt(configMap, "start_month", "")
+	if startMonth < 1 || startMonth > 12 {
+		fatalf("Error in \"fiscal_year\" configuration:  \"start_month\" must be 1 through 12, found %d",
+			startMonth)
+	}
+	return startMonth
+}
+
+// getQuarterMonths returns the months (in yyyy-mm format) of the given fiscal
+// quarter (e.g., "2024-Q3"), and the name of its sheet (e.g., "Q3 FY2024").
+// A fiscal year which does not start in January is named for the calendar
+// year in which it ends:  with a start month of 3, FY2025 runs from March 2024
+// through February 2025.
+func getQuarterMonths(quarter string, startMonth int) (months []string, name string, err error) {
+	match := quarterPattern.FindStringSubmatch(quarter)
+	if match == nil {
+		return nil, "", fmt.Errorf("bad quarter %q, expected yyyy-Qn (e.g., \"2024-Q3\")", quarter)
+	}
+	year, _ := strconv.Atoi(match[1])
+	number, _ := strconv.Atoi(match[2])
+	start := time.Date(year, time.Month(startMonth), 1, 0, 0, 0, 0, time.UTC)
+	if startMonth != 1 {
+		start = start.AddDate(-1, 0, 0)
+	}
+	start = start.AddDate(0, 3*(number-1), 0)
+	for i := 0; i < 3; i++ {
+		months = append(months, start.AddDate(0, i, 0).Format("2006-01"))
+	}
+	return months, fmt.Sprintf("Q%d FY%d", number, year), nil
+}
+
+// getMonthFileName returns the name of the file for one month of a quarterly
+// run, derived from the name given for the whole run (e.g., "report.txt"
+// becomes "report-2024-07.txt").
+func getMonthFileName(fileName string, month string) string {
+	ext := filepath.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + "-" + month + ext
+}
+
+// runQuarter implements the -quarter option:  it runs the tool (as a
+// subprocess, with the same options) for each month of the fiscal quarter,
+// which writes the monthly sheets and records the results in the history
+// database, and then writes a quarterly sheet with each account's monthly
+// totals and their sum, from the history.  With -quarterly-only, the monthly
+// runs write CSV files in a temporary directory instead, so that only the
+// quarterly sheet is written to the output.  File options which were given
+// explicitly (e.g., -report) are suffixed with the month for each run.
+func runQuarter(options CommandLineOptions, accountsFile AccountsFile, sources map[string]string) {
+	if hasAccountFilter(options) || *options.awsWriteTagsPtr {
+		exitf(ExitUsage, "[runQuarter] -quarter cannot be combined with -account, -group, or -awswritetags")
+	}
+	if getMapKeyBool(accountsFile.Configuration["history"], "disabled", "") {
+		fatalf("[runQuarter] -quarter requires the history database, which is disabled")
+	}
+	months, sheetName, err := getQuarterMonths(*options.quarterPtr,
+		getFiscalStartMonth(accountsFile.Configuration["fiscal_year"]))
+	if err != nil {
+		exitf(ExitUsage, "[runQuarter] %v", err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fatalf("[runQuarter] unable to locate the executable: %v", err)
+	}
+	var tempDir string
+	if *options.quarterlyOnlyPtr {
+		tempDir, err = os.MkdirTemp("", "costpuller-quarter-")
+		if err != nil {
+			fatalf("[runQuarter] error creating a temporary directory: %v", err)
+		}
+		defer func() { _ = os.RemoveAll(tempDir) }()
+	}
+
+	for _, month := range months {
+		// Later occurrences of an option override earlier ones.
+		args := append(slices.Clone(os.Args[1:]), "-quarter=", "-month="+month)
+		for _, name := range []string{"csv", "drilldown", "report", "summary-file", "untracked-file"} {
+			if sources[name] != OptionSourceDefault {
+				fileName := flag.CommandLine.Lookup(name).Value.String()
+				args = append(args, "-"+name+"="+getMonthFileName(fileName, month))
+			}
+		}
+		if tempDir != "" {
+			args = append(args, "-output=csv", "-csv="+filepath.Join(tempDir, "output-"+month+".csv"))
+		}
+		log.Printf("[runQuarter] running for %s", month)
+		command := exec.Command(executable, args...)
+		command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := command.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				fatalf("[runQuarter] error running for %s: %v", month, err)
+			}
+			code := exitErr.ExitCode()
+			if code != ExitWarnings && code != ExitConsistencyFailure {
+				exitf(code, "[runQuarter] the run for %s failed (exit code %d)", month, code)
+			}
+			noteExitStatus(code, fmt.Sprintf("the run for %s completed with exit code %d", month, code))
+		}
+	}
+
+	*options.monthPtr = months[0]
+	if sources["csv"] == OptionSourceDefault {
+		*options.csvfilePtr = fmt.Sprintf("output-%s.csv", *options.quarterPtr)
+	}
+	output := newOutputObject(options, accountsFile)
+	defer output.close()
+	output.writeNamedSheet("the quarterly sheet", sheetName, getQuarterlySheet(accountsFile, months, *options.costTypePtr))
+	log.Printf("[runQuarter] wrote %q", sheetName)
+}
+
+// getQuarterlySheet returns a sheet with each account's totals for the months
+// of the quarter (from the history database) and their sum.
+func getQuarterlySheet(accountsFile AccountsFile, months []string, costType string) (output []*sheets.RowData) {
+	records, err := readHistory(accountsFile, costType)
+	if err != nil {
+		fatalf("[getQuarterlySheet] error reading history: %v", err)
+	}
+	type accountKey struct{ group, provider, accountID string }
+	totals := make(map[accountKey]map[string]float64)
+	for _, record := range records {
+		if !slices.Contains(months, record.Month) {
+			continue
+		}
+		key := accountKey{record.Group, record.CloudProvider, record.AccountID}
+		if _, exists := totals[key]; !exists {
+			totals[key] = make(map[string]float64)
+		}
+		totals[key][record.Month] += record.Total
+	}
+	keys := make([]accountKey, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b accountKey) int {
+		return strings.Compare(a.group+"|"+a.provider+"|"+a.accountID, b.group+"|"+b.provider+"|"+b.accountID)
+	})
+
+	header := append(append([]string{"Team", "Cloud Provider", "Account ID"}, months...), "TOTAL")
+	output = append(output, newHeaderRow(header))
+	for idx, key := range keys {
+		row := []*sheets.CellData{newStringCell(key.group), newStringCell(key.provider), newStringCell(key.accountID)}
+		for _, month := range months {
+			cell := newNumberCell(totals[key][month])
+			cell.UserEnteredFormat = &sheets.CellFormat{NumberFormat: &sheets.NumberFormat{Type: "CURRENCY"}}
+			row = append(row, cell)
+		}
+		total := newFormulaCell(getTotalsFormula(idx+1, 3, 2+len(months)))
+		total.UserEnteredFormat = &sheets.CellFormat{NumberFormat: &sheets.NumberFormat{Type: "CURRENCY"}}
+		output = append(output, &sheets.RowData{Values: append(row, total)})
+	}
+	return
+}
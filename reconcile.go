@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+)
+
+// getCloudabilityTotals returns the total cost of each of the given
+// provider's accounts in the Cloudability data, keyed by the canonical
+// account ID.
+func getCloudabilityTotals(cldy *CloudabilityCostData, provider string) map[string]float64 {
+	totals := make(map[string]float64)
+	for _, entry := range cldy.Results {
+		if getCanonicalProvider(entry.CloudProvider) != provider {
+			continue
+		}
+		accountID, _ := getCanonicalAccountId(provider, entry.AccountID)
+		cost, err := strconv.ParseFloat(entry.Cost, 64)
+		if err != nil {
+			log.Printf("[getCloudabilityTotals] ignoring unparseable cost %q for account %s", entry.Cost, accountID)
+			continue
+		}
+		totals[accountID] += cost
+	}
+	return totals
+}
+
+// reconcileWithCloudability compares the total of each of the given accounts,
+// as pulled directly from the named source (and recorded in the cost grid),
+// with its total in the Cloudability data, and reports the accounts whose
+// totals differ materially (see ReconciliationPolicy.reconcilesAcrossSources),
+// which usually indicates a gap in Cloudability's ingestion of the provider's
+// billing data.
+func reconcileWithCloudability(
+	source string,
+	accounts []string,
+	costCells map[string]map[string]float64,
+	cldyTotals map[string]float64,
+	reportFile *os.File,
+) {
+	for _, accountID := range accounts {
+		var direct float64
+		for _, value := range costCells[accountID] {
+			direct += value
+		}
+		cldyTotal := cldyTotals[accountID]
+		if reconciliation.reconcilesAcrossSources(direct, cldyTotal) {
+			continue
+		}
+		msg := fmt.Sprintf("%s total %.2f differs from the Cloudability total %.2f by %.2f", source, direct,
+			cldyTotal, cldyTotal-direct)
+		if direct != 0 {
+			msg += fmt.Sprintf(" (%+.1f%%)", (cldyTotal-direct)/math.Abs(direct)*100)
+		}
+		log.Printf("[reconcileWithCloudability] Warning:  account %s: %s", accountID, msg)
+		writeReport(reportFile, accountID+": "+msg)
+		noteExitStatus(ExitWarnings, "account "+accountID+": "+msg)
+	}
+}
+
+// checkCloudabilityAggregate cross-checks the Cloudability data against the
+// aggregate total which Cloudability reports for the metric (over all the
+// results matching the query):  if the results do not add up to it, the
+// response is incomplete, which is a warning; and if the rows accepted into
+// the cost grid (together with those for the accounts pulled directly, which
+// are expected to be skipped) do not add up to the results, the difference
+// (e.g., rows skipped because their accounts are not in the accounts file) is
+// written to the report file.  The grid must hold only the Cloudability data.
+func checkCloudabilityAggregate(
+	cldy *CloudabilityCostData,
+	metric string,
+	costCells map[string]map[string]float64,
+	accountsMetadata map[string]*AccountMetadata,
+	reportFile *os.File,
+) {
+	var aggregate *AggregatesEntry
+	for idx := range cldy.Meta.Aggregates {
+		if cldy.Meta.Aggregates[idx].Name == metric {
+			aggregate = &cldy.Meta.Aggregates[idx]
+		}
+	}
+	if aggregate == nil {
+		log.Printf("[checkCloudabilityAggregate] the Cloudability response has no %q aggregate", metric)
+		return
+	}
+	aggregateTotal, err := strconv.ParseFloat(aggregate.Value, 64)
+	if err != nil {
+		log.Printf("[checkCloudabilityAggregate] ignoring unparseable %q aggregate %q", metric, aggregate.Value)
+		return
+	}
+
+	var results, direct, accepted float64
+	for _, entry := range cldy.Results {
+		cost, err := strconv.ParseFloat(entry.Cost, 64)
+		if err != nil {
+			continue // Reported by getSheetDataFromCloudability()
+		}
+		results += cost
+		accountID, _ := getCanonicalAccountId(getCanonicalProvider(entry.CloudProvider), entry.AccountID)
+		if entry := accountsMetadata[accountID]; entry != nil && entry.PulledDirectly {
+			direct += cost
+		}
+	}
+	for _, row := range costCells {
+		for _, value := range row {
+			accepted += value
+		}
+	}
+
+	if !reconciliation.reconciles(results, aggregateTotal) {
+		msg := fmt.Sprintf("the Cloudability results total %.2f, but the reported aggregate is %.2f (a gap "+
+			"of %.2f); the response may be incomplete", results, aggregateTotal, aggregateTotal-results)
+		log.Printf("[checkCloudabilityAggregate] Warning:  %s", msg)
+		writeReport(reportFile, "Cloudability: "+msg)
+		noteExitStatus(ExitWarnings, msg)
+	}
+	if skipped := results - accepted - direct; !reconciliation.reconciles(accepted+direct, results) {
+		msg := fmt.Sprintf("Cloudability rows totaling %.2f", skipped)
+		if aggregateTotal != 0 {
+			msg += fmt.Sprintf(" (%.1f%% of the aggregate %.2f)", skipped/math.Abs(aggregateTotal)*100,
+				aggregateTotal)
+		}
+		msg += " were skipped (e.g., accounts which are not in the accounts file, or excluded by -account or -group)"
+		log.Printf("[checkCloudabilityAggregate] %s", msg)
+		writeReport(reportFile, "Cloudability: "+msg)
+	}
+}
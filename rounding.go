@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+)
+
+// ReconciliationPolicy controls how amounts are rounded in the output and how
+// closely totals from different sources must agree.
+type ReconciliationPolicy struct {
+	// DecimalPlaces is the number of places to which emitted amounts are
+	// rounded (negative to disable rounding); HalfEven selects banker's
+	// rounding rather than rounding halves away from zero.
+	DecimalPlaces int
+	HalfEven      bool
+
+	// AbsoluteTolerance and RelativeTolerance (a fraction of the larger
+	// total) give the largest difference between two totals which is not
+	// considered a discrepancy; a difference within either is accepted.
+	AbsoluteTolerance float64
+	RelativeTolerance float64
+
+	// CrossSourceTolerance is the largest difference (as a fraction of the
+	// larger total) between the totals of an account from two different
+	// sources (e.g., AWS and Cloudability) which is not reported.
+	CrossSourceTolerance float64
+}
+
+// reconciliation is the policy in effect for the run; it is configured from
+// the "reconciliation" section of the configuration by configureReconciliation.
+var reconciliation = ReconciliationPolicy{DecimalPlaces: 2, AbsoluteTolerance: 0.01, CrossSourceTolerance: 0.01}
+
+// configureReconciliation sets the reconciliation policy from the given
+// configuration subsection (which may be nil).
+func configureReconciliation(config Configuration) {
+	if getMapKeyValue(config, "decimal_places", "") != nil {
+		reconciliation.DecimalPlaces = getMapKeyInt(config, "decimal_places", "")
+	}
+	switch mode := getMapKeyString(config, "rounding", ""); mode {
+	case "", "half_up":
+	case "half_even":
+		reconciliation.HalfEven = true
+	default:
+		fatalf("Error in \"reconciliation\" rounding (%q), expected \"half_up\" or \"half_even\"", mode)
+	}
+	if value := getMapKeyValue(config, "absolute_tolerance", ""); value != nil {
+		reconciliation.AbsoluteTolerance = getFloatFromAny(value, "reconciliation absolute_tolerance")
+	}
+	if value := getMapKeyValue(config, "relative_tolerance_percent", ""); value != nil {
+		reconciliation.RelativeTolerance = getFloatFromAny(value, "reconciliation relative_tolerance_percent") / 100
+	}
+	if value := getMapKeyValue(config, "cross_source_tolerance_percent", ""); value != nil {
+		reconciliation.CrossSourceTolerance = getFloatFromAny(value, "reconciliation cross_source_tolerance_percent") / 100
+	}
+}
+
+// round returns the amount rounded according to the policy.
+func (p ReconciliationPolicy) round(amount float64) float64 {
+	if p.DecimalPlaces < 0 {
+		return amount
+	}
+	scale := math.Pow(10, float64(p.DecimalPlaces))
+	if p.HalfEven {
+		return math.RoundToEven(amount*scale) / scale
+	}
+	return math.Round(amount*scale) / scale
+}
+
+// reconcilesAcrossSources reports whether the totals of an account from two
+// different sources agree:  within the absolute tolerance or the cross-source
+// tolerance.
+func (p ReconciliationPolicy) reconcilesAcrossSources(a float64, b float64) bool {
+	diff := math.Abs(a - b)
+	return diff <= p.AbsoluteTolerance || diff <= p.CrossSourceTolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// reconciles reports whether the two totals agree within the tolerance.
+func (p ReconciliationPolicy) reconciles(a float64, b float64) bool {
+	diff := math.Abs(a - b)
+	return diff <= p.AbsoluteTolerance || diff <= p.RelativeTolerance*math.Max(math.Abs(a), math.Abs(b))
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// defaultRunLockTTL is how long a run lock is honored, unless the "ttl" key of
+// the "run_lock" section is configured; a lock left behind by a run which was
+// killed (or which failed without releasing it) is ignored once it is older.
+const defaultRunLockTTL = 2 * time.Hour
+
+// RunLockHolder identifies the run which holds a lock.
+type RunLockHolder struct {
+	Host    string    `json:"host"`
+	Pid     int       `json:"pid"`
+	User    string    `json:"user"`
+	Started time.Time `json:"started"`
+}
+
+func (h RunLockHolder) String() string {
+	return fmt.Sprintf("%s (process %d on %s, started %s)", h.User, h.Pid, h.Host, h.Started.Format(time.RFC3339))
+}
+
+// RunLock prevents concurrent runs for the same month from interleaving their
+// updates to the output:  it is a lock file in the cache directory and,
+// optionally, a developer metadata entry on each destination spreadsheet, so
+// that runs on different machines are excluded, too.
+type RunLock struct {
+	path      string
+	srv       *sheets.Service
+	markers   map[string]int64 // Spreadsheet ID -> ID of our metadata entry
+	markerKey string
+}
+
+// acquireRunLock takes the run lock for the month, unless the output is a
+// local CSV file or the "run_lock" section has "disabled: true".  If another
+// run holds the lock (and it has not expired), the process exits with
+// ExitLocked.  With "spreadsheet_marker: true", the lock is also recorded on
+// each Google Sheets destination.  The lock is released by release() or, if
+// the run is stopped by exitf(), on exit.
+func acquireRunLock(configMap Configuration, output *OutputObject, month string) *RunLock {
+	if output.csvFile != nil || getMapKeyBool(configMap, "disabled", "") {
+		return nil
+	}
+	ttl := defaultRunLockTTL
+	if value := getMapKeyString(configMap, "ttl", ""); value != "" {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil {
+			fatalf("Error in \"run_lock\" configuration:  bad \"ttl\" value %q: %v", value, err)
+		}
+	}
+	host, _ := os.Hostname()
+	holder := RunLockHolder{Host: host, Pid: os.Getpid(), User: os.Getenv("USER"), Started: time.Now()}
+	data, err := json.Marshal(holder)
+	if err != nil {
+		fatalf("[acquireRunLock] error encoding the lock: %v", err)
+	}
+
+	lock := &RunLock{markerKey: "costpuller-lock-" + month, markers: make(map[string]int64)}
+	lock.path, err = getCachePath(fmt.Sprintf("run-%s.lock", month))
+	if err != nil {
+		fatalf("[acquireRunLock] unable to locate the lock file: %v", err)
+	}
+	if err := createLockFile(lock.path, data, ttl); err != nil {
+		exitf(ExitLocked, "[acquireRunLock] %v", err)
+	}
+	exitHooks = append(exitHooks, lock.release)
+
+	if getMapKeyBool(configMap, "spreadsheet_marker", "") && output.httpClient != nil {
+		lock.srv, err = sheets.NewService(context.Background(), option.WithHTTPClient(output.httpClient))
+		if err != nil {
+			exitf(ExitOutputFailure, "[acquireRunLock] unable to create Google Sheets client: %v", err)
+		}
+		for _, gsheetConfig := range output.gsheetConfigs {
+			spreadsheetId := getMapKeyString(gsheetConfig, "spreadsheetId", "gsheet")
+			if err := lock.addMarker(spreadsheetId, string(data), ttl); err != nil {
+				exitf(ExitLocked, "[acquireRunLock] %v", err)
+			}
+		}
+	}
+	log.Printf("[acquireRunLock] acquired the lock for %s", month)
+	return lock
+}
+
+// createLockFile creates the lock file with the given contents, replacing an
+// existing one only if it is older than the TTL.
+func createLockFile(path string, data []byte, ttl time.Duration) error {
+	for {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_, err = file.Write(data)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("error creating lock file %q: %w", path, err)
+		}
+		var holder RunLockHolder
+		existing, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(existing, &holder)
+		}
+		if err == nil && time.Since(holder.Started) < ttl {
+			return fmt.Errorf("another run for the month holds the lock:  %s; if it is no longer running, "+
+				"remove %q", holder, path)
+		}
+		log.Printf("[acquireRunLock] removing expired or unreadable lock file %q", path)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing lock file %q: %w", path, err)
+		}
+	}
+}
+
+// addMarker records the lock as a developer metadata entry on the spreadsheet.
+// Expired entries are removed; if an unexpired entry exists, or another run
+// added one at the same time (the earlier entry wins), the lock is not taken.
+func (l *RunLock) addMarker(spreadsheetId string, value string, ttl time.Duration) error {
+	markers, err := l.getMarkers(spreadsheetId)
+	if err != nil {
+		return err
+	}
+	for _, marker := range markers {
+		var holder RunLockHolder
+		if json.Unmarshal([]byte(marker.MetadataValue), &holder) == nil && time.Since(holder.Started) < ttl {
+			return fmt.Errorf("another run for the month holds the lock on spreadsheet %s:  %s", spreadsheetId, holder)
+		}
+		log.Printf("[acquireRunLock] removing expired lock marker from spreadsheet %s", spreadsheetId)
+		if err := l.deleteMarker(spreadsheetId, marker.MetadataId); err != nil {
+			return err
+		}
+	}
+
+	response, err := l.srv.Spreadsheets.BatchUpdate(spreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{
+			CreateDeveloperMetadata: &sheets.CreateDeveloperMetadataRequest{
+				DeveloperMetadata: &sheets.DeveloperMetadata{
+					Location:      &sheets.DeveloperMetadataLocation{Spreadsheet: true},
+					MetadataKey:   l.markerKey,
+					MetadataValue: value,
+					Visibility:    "DOCUMENT",
+				},
+			},
+		}},
+	}).Do()
+	if err != nil {
+		return fmt.Errorf("error adding the lock marker to spreadsheet %s: %w", spreadsheetId, err)
+	}
+	id := response.Replies[0].CreateDeveloperMetadata.DeveloperMetadata.MetadataId
+	l.markers[spreadsheetId] = id
+
+	// Check for a marker added by another run between the search and the
+	// update.
+	markers, err = l.getMarkers(spreadsheetId)
+	if err != nil {
+		return err
+	}
+	for _, marker := range markers {
+		if marker.MetadataId < id {
+			return fmt.Errorf("another run for the month took the lock on spreadsheet %s at the same time",
+				spreadsheetId)
+		}
+	}
+	return nil
+}
+
+// getMarkers returns the lock markers for the month on the spreadsheet.
+func (l *RunLock) getMarkers(spreadsheetId string) ([]*sheets.DeveloperMetadata, error) {
+	response, err := l.srv.Spreadsheets.DeveloperMetadata.Search(spreadsheetId, &sheets.SearchDeveloperMetadataRequest{
+		DataFilters: []*sheets.DataFilter{{
+			DeveloperMetadataLookup: &sheets.DeveloperMetadataLookup{MetadataKey: l.markerKey},
+		}},
+	}).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error searching for lock markers on spreadsheet %s: %w", spreadsheetId, err)
+	}
+	var markers []*sheets.DeveloperMetadata
+	for _, matched := range response.MatchedDeveloperMetadata {
+		markers = append(markers, matched.DeveloperMetadata)
+	}
+	return markers, nil
+}
+
+// deleteMarker removes the developer metadata entry with the given ID.
+func (l *RunLock) deleteMarker(spreadsheetId string, id int64) error {
+	_, err := l.srv.Spreadsheets.BatchUpdate(spreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{
+			DeleteDeveloperMetadata: &sheets.DeleteDeveloperMetadataRequest{
+				DataFilter: &sheets.DataFilter{
+					DeveloperMetadataLookup: &sheets.DeveloperMetadataLookup{MetadataId: id},
+				},
+			},
+		}},
+	}).Do()
+	if err != nil {
+		return fmt.Errorf("error removing the lock marker from spreadsheet %s: %w", spreadsheetId, err)
+	}
+	return nil
+}
+
+// release removes the lock's markers and its lock file.  Failures are logged;
+// a lock which is left behind expires after its TTL.
+func (l *RunLock) release() {
+	if l == nil || l.path == "" {
+		return
+	}
+	for spreadsheetId, id := range l.markers {
+		if err := l.deleteMarker(spreadsheetId, id); err != nil {
+			log.Printf("[releaseRunLock] %v", err)
+		}
+	}
+	if err := os.Remove(l.path); err != nil {
+		log.Printf("[releaseRunLock] error removing lock file %q: %v", l.path, err)
+	}
+	l.path = ""
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// SftpDelivery delivers the main output, as CSV, to an SFTP server (e.g., the
+// drop directory of finance's ingestion system) at the end of a successful
+// run.  It is configured by the "sftp" configuration section:  the "host",
+// "user", "port" (optional), private "key" file, optional "known_hosts" file,
+// and the "remotePathTemplate", which is expanded with the month (see
+// https://pkg.go.dev/time#Layout), e.g., "/incoming/costs-2006-01.csv".
+type SftpDelivery struct {
+	configMap Configuration
+	rows      []*sheets.RowData
+}
+
+// newSftpDelivery returns the SFTP delivery configured by the "sftp"
+// configuration section, or nil if there is none.
+func newSftpDelivery(configMap Configuration) *SftpDelivery {
+	if configMap == nil {
+		return nil
+	}
+	for _, key := range []string{"host", "user", "key", "remotePathTemplate"} {
+		_ = getMapKeyString(configMap, key, "sftp") // Exits if the key is missing
+	}
+	return &SftpDelivery{configMap: configMap}
+}
+
+// deliver uploads the main output rows, as CSV, to the path for the month on
+// the SFTP server, if the run was successful (i.e., it completed with, at
+// worst, warnings) and complete (i.e., not restricted to selected accounts).
+// The file is uploaded under a temporary name and then renamed, so that the
+// ingestion system never sees a partial file.  The transfer is made by the
+// sftp command (which must be installed), so that the user's usual SSH
+// configuration applies.
+func (d *SftpDelivery) deliver(output *OutputObject, options CommandLineOptions) {
+	if hasAccountFilter(options) {
+		log.Printf("[sftp] not delivering the output of a partial run")
+		return
+	}
+	if exitStatus > ExitWarnings {
+		log.Printf("[sftp] not delivering the output, since the run was not successful (exit code %d)", exitStatus)
+		return
+	}
+	if len(d.rows) == 0 {
+		log.Printf("[sftp] no output to deliver")
+		return
+	}
+	remotePath := output.refTime.Format(getMapKeyString(d.configMap, "remotePathTemplate", "sftp"))
+	if err := d.upload(remotePath); err != nil {
+		msg := fmt.Sprintf("error delivering the output to %s:%s: %v",
+			getMapKeyString(d.configMap, "host", "sftp"), remotePath, err)
+		log.Printf("[sftp] %s", msg)
+		noteExitStatus(ExitOutputFailure, msg)
+		return
+	}
+	log.Printf("[sftp] delivered the output to %s:%s", getMapKeyString(d.configMap, "host", "sftp"), remotePath)
+}
+
+// upload writes the rows to a temporary CSV file and runs sftp to put it at
+// the remote path.
+func (d *SftpDelivery) upload(remotePath string) error {
+	local, err := os.CreateTemp("", "costpuller-sftp-*.csv")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(local.Name()) }()
+	err = writeCsvFromSheet(local, d.rows)
+	closeFile(local)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-b", "-", "-o", "BatchMode=yes", "-i", getMapKeyString(d.configMap, "key", "sftp")}
+	if port := getMapKeyId(d.configMap, "port"); port != "" {
+		if _, err := strconv.Atoi(port); err != nil {
+			return fmt.Errorf("invalid port %q", port)
+		}
+		args = append(args, "-P", port)
+	}
+	if knownHosts := getMapKeyString(d.configMap, "known_hosts", ""); knownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+knownHosts)
+	}
+	args = append(args, getMapKeyString(d.configMap, "user", "sftp")+"@"+getMapKeyString(d.configMap, "host", "sftp"))
+	// A leading "-" lets the batch continue if the command fails (i.e., if
+	// there is no previous file to remove).
+	partial := remotePath + ".part"
+	batch := fmt.Sprintf("put %s %s\n-rm %s\nrename %s %s\n", quoteSftpArg(local.Name()), quoteSftpArg(partial),
+		quoteSftpArg(remotePath), quoteSftpArg(partial), quoteSftpArg(remotePath))
+	cmd := exec.Command("sftp", args...)
+	cmd.Stdin = strings.NewReader(batch)
+	cmd.Stderr = os.Stderr
+	if out, err := cmd.Output(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// quoteSftpArg quotes a path for an sftp batch command.
+func quoteSftpArg(path string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(path, `\`, `\\`), `"`, `\"`) + `"`
+}
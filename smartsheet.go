@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// defaultSmartsheetUrl is the base URL of the Smartsheet API, unless the "url"
+// key of the "smartsheet" configuration section is set (e.g., for the EU
+// region, "https://api.smartsheet.eu/2.0").
+const defaultSmartsheetUrl = "https://api.smartsheet.com/2.0"
+
+// smartsheetRowBatch is the number of rows added to a sheet in each request.
+const smartsheetRowBatch = 500
+
+// SmartsheetOutput writes the output to Smartsheet, mirroring the Google
+// Sheets output:  the month's data replaces any sheet with the same name
+// (built from the "sheetNameTemplate"), in the configured folder or in the
+// user's sheets, and the teams' totals for the month are updated on the
+// summary sheet, if one is configured.
+type SmartsheetOutput struct {
+	client         *http.Client
+	baseUrl        *url.URL
+	accessToken    string
+	configMap      Configuration
+	folderId       string
+	summarySheetId string
+}
+
+// SmartsheetColumn is a column of a Smartsheet sheet.
+type SmartsheetColumn struct {
+	Id      int64  `json:"id,omitempty"`
+	Title   string `json:"title"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SmartsheetCell is a cell of a Smartsheet row.
+type SmartsheetCell struct {
+	ColumnId int64 `json:"columnId"`
+	Value    any   `json:"value,omitempty"`
+}
+
+// SmartsheetRow is a row of a Smartsheet sheet.
+type SmartsheetRow struct {
+	Id       int64            `json:"id,omitempty"`
+	ToBottom bool             `json:"toBottom,omitempty"`
+	Cells    []SmartsheetCell `json:"cells"`
+}
+
+// SmartsheetSheet is a sheet, as listed and as fetched from Smartsheet.
+type SmartsheetSheet struct {
+	Id      int64              `json:"id,omitempty"`
+	Name    string             `json:"name"`
+	Columns []SmartsheetColumn `json:"columns,omitempty"`
+	Rows    []SmartsheetRow    `json:"rows,omitempty"`
+}
+
+// newSmartsheetOutput returns the Smartsheet output configured by the
+// "smartsheet" configuration section.
+func newSmartsheetOutput(configMap Configuration) *SmartsheetOutput {
+	baseUrl, err := url.Parse(cmp.Or(getMapKeyString(configMap, "url", ""), defaultSmartsheetUrl))
+	if err != nil {
+		fatalf("[newSmartsheetOutput] error parsing the Smartsheet URL: %v", err)
+	}
+	return &SmartsheetOutput{
+		client:         newAuditedHttpClient("smartsheet", time.Second*60),
+		baseUrl:        baseUrl,
+		accessToken:    getMapKeyString(configMap, "access_token", "smartsheet"),
+		configMap:      configMap,
+		folderId:       getMapKeyId(configMap, "folderId"),
+		summarySheetId: getMapKeyId(configMap, "summarySheetId"),
+	}
+}
+
+// postAuxiliarySheet writes supplementary data to a sheet with the given
+// name, replacing any existing sheet of the same name.
+func (s *SmartsheetOutput) postAuxiliarySheet(sheetData []*sheets.RowData, name string) error {
+	return s.replaceSheet(name, sheetData)
+}
+
+// postSheet writes the main output for the month referenced by ref to a new
+// sheet, replacing any existing sheet of the same name, and updates the
+// summary sheet, if one is configured.
+func (s *SmartsheetOutput) postSheet(sheetData []*sheets.RowData, ref time.Time) error {
+	name := ref.Format(getMapKeyString(s.configMap, "sheetNameTemplate", "smartsheet"))
+	if err := s.replaceSheet(name, sheetData); err != nil {
+		return err
+	}
+	if s.summarySheetId == "" {
+		return nil
+	}
+	return s.updateSummary(ref.Format("2006-01"), sheetData)
+}
+
+// replaceSheet creates a sheet with the given name holding the data, deleting
+// any existing sheet with that name first.  The columns are named by the
+// header row, if the data has one (and are otherwise numbered).
+func (s *SmartsheetOutput) replaceSheet(name string, sheetData []*sheets.RowData) error {
+	listPath := "sheets"
+	if s.folderId != "" {
+		listPath = "folders/" + s.folderId
+	}
+	var listing struct {
+		Data   []SmartsheetSheet `json:"data"`   // For sheets
+		Sheets []SmartsheetSheet `json:"sheets"` // For folders
+	}
+	if err := s.request(http.MethodGet, listPath, url.Values{"includeAll": {"true"}}, nil, &listing); err != nil {
+		return err
+	}
+	for _, sheet := range append(listing.Data, listing.Sheets...) {
+		if sheet.Name == name {
+			log.Printf("[smartsheet] Warning:  replacing sheet %q", name)
+			if err := s.request(http.MethodDelete, fmt.Sprintf("sheets/%d", sheet.Id), nil, nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	var titles []string
+	rows := sheetData
+	if len(rows) > 0 && rows[0].Values[0].UserEnteredFormat != nil { // See newHeaderRow()
+		for _, cell := range rows[0].Values {
+			titles = append(titles, getCellText(cell, sheetData[0]))
+		}
+		rows = rows[1:]
+	} else {
+		for idx := range sheetData[0].Values {
+			titles = append(titles, fmt.Sprintf("Column %d", idx+1))
+		}
+	}
+	newSheet := SmartsheetSheet{Name: name}
+	for idx, title := range titles {
+		newSheet.Columns = append(newSheet.Columns,
+			SmartsheetColumn{Title: title, Type: "TEXT_NUMBER", Primary: idx == 0})
+	}
+	createPath := "sheets"
+	if s.folderId != "" {
+		createPath = "folders/" + s.folderId + "/sheets"
+	}
+	var created struct {
+		Result SmartsheetSheet `json:"result"`
+	}
+	log.Printf("[smartsheet] creating sheet %q", name)
+	if err := s.request(http.MethodPost, createPath, nil, newSheet, &created); err != nil {
+		return err
+	}
+
+	var newRows []SmartsheetRow
+	for _, row := range rows {
+		newRow := SmartsheetRow{ToBottom: true}
+		for idx, cell := range row.Values {
+			if value := getSmartsheetCellValue(cell, row); value != nil && idx < len(created.Result.Columns) {
+				newRow.Cells = append(newRow.Cells,
+					SmartsheetCell{ColumnId: created.Result.Columns[idx].Id, Value: value})
+			}
+		}
+		newRows = append(newRows, newRow)
+	}
+	return s.addRows(created.Result.Id, newRows)
+}
+
+// addRows adds the rows to the bottom of the sheet, in batches.
+func (s *SmartsheetOutput) addRows(sheetId int64, rows []SmartsheetRow) error {
+	for start := 0; start < len(rows); start += smartsheetRowBatch {
+		batch := rows[start:min(start+smartsheetRowBatch, len(rows))]
+		if err := s.request(http.MethodPost, fmt.Sprintf("sheets/%d/rows", sheetId), nil, batch, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateSummary replaces the rows for the month on the summary sheet with a
+// row for each team, holding its total.  The summary sheet must have columns
+// titled "Month", "Team", and "Total".
+func (s *SmartsheetOutput) updateSummary(month string, sheetData []*sheets.RowData) error {
+	var summary SmartsheetSheet
+	if err := s.request(http.MethodGet, "sheets/"+s.summarySheetId, nil, nil, &summary); err != nil {
+		return err
+	}
+	columnIds := make(map[string]int64)
+	for _, column := range summary.Columns {
+		columnIds[column.Title] = column.Id
+	}
+	for _, title := range []string{"Month", "Team", "Total"} {
+		if _, exists := columnIds[title]; !exists {
+			return fmt.Errorf("the Smartsheet summary sheet has no %q column", title)
+		}
+	}
+
+	var staleRows []string
+	for _, row := range summary.Rows {
+		for _, cell := range row.Cells {
+			if cell.ColumnId == columnIds["Month"] && fmt.Sprint(cell.Value) == month {
+				staleRows = append(staleRows, strconv.FormatInt(row.Id, 10))
+			}
+		}
+	}
+	if len(staleRows) > 0 {
+		err := s.request(http.MethodDelete, "sheets/"+s.summarySheetId+"/rows",
+			url.Values{"ids": {strings.Join(staleRows, ",")}}, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows := make([][]string, len(sheetData))
+	for idx, row := range sheetData {
+		for _, cell := range row.Values {
+			rows[idx] = append(rows[idx], formatCellText(cell, row, -1))
+		}
+	}
+	records, err := getRunRecordsFromRows(rows)
+	if err != nil {
+		return fmt.Errorf("error totaling the teams' costs: %w", err)
+	}
+	teamTotals := make(map[string]float64)
+	for _, record := range records {
+		teamTotals[record.Group] += record.Total
+	}
+	var newRows []SmartsheetRow
+	for _, team := range sortedKeys(teamTotals) {
+		newRows = append(newRows, SmartsheetRow{ToBottom: true, Cells: []SmartsheetCell{
+			{ColumnId: columnIds["Month"], Value: month},
+			{ColumnId: columnIds["Team"], Value: team},
+			{ColumnId: columnIds["Total"], Value: reconciliation.round(teamTotals[team])},
+		}})
+	}
+	summaryId, _ := strconv.ParseInt(s.summarySheetId, 10, 64)
+	return s.addRows(summaryId, newRows)
+}
+
+// getSmartsheetCellValue returns the value for Smartsheet of a cell in the
+// given row.  Since Smartsheet formulas do not use A1 references, the "TOTAL"
+// formulas are replaced by their values; other formulas are omitted (nil).
+func getSmartsheetCellValue(cell *sheets.CellData, row *sheets.RowData) any {
+	value := cell.UserEnteredValue
+	switch {
+	case value.StringValue != nil:
+		return *value.StringValue
+	case value.NumberValue != nil:
+		return *value.NumberValue
+	case value.FormulaValue != nil:
+		if total, ok := getTotalsFormulaValue(*value.FormulaValue, row); ok {
+			return total
+		}
+	}
+	return nil
+}
+
+// request makes a request to the Smartsheet API, sending the body (if any) as
+// JSON and decoding the JSON response into the result (if any).
+func (s *SmartsheetOutput) request(method string, path string, query url.Values, body any, result any) error {
+	requestUrl := s.baseUrl.JoinPath(path)
+	requestUrl.RawQuery = query.Encode()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding the Smartsheet request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequest(method, requestUrl.String(), reader)
+	if err != nil {
+		return fmt.Errorf("error creating the Smartsheet request: %w", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+s.accessToken)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("error sending the Smartsheet request (%s %s): %w", method, path, err)
+	}
+	defer closeBody(response)
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("smartsheet request (%s %s) failed: %d, %q: %s", method, path, response.StatusCode,
+			response.Status, message)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("error decoding the Smartsheet response (%s %s): %w", method, path, err)
+	}
+	return nil
+}
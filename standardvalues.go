@@ -0,0 +1,232 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// standardValueKeyPattern matches a "key: value" line of an account entry
+// (optionally the first line of the entry, after the "- "), capturing the
+// indentation up to the key, the key, the value, and any trailing comment.
+var standardValueKeyPattern = regexp.MustCompile(`^(\s*(?:-\s+)?)(\w+):(\s*)([^#]*?)(\s*#.*)?$`)
+
+// flowAccountPattern matches a line with an account entry in the flow style,
+// e.g., "- {accountid: ..., standardvalue: ...}".
+var flowAccountPattern = regexp.MustCompile(`[{,]\s*accountid\s*:`)
+
+// accountsUpdateCommand implements "costpuller accounts update-standardvalues",
+// which sets each account's standardvalue in the accounts file (and the files
+// it includes) to its total for the month, or to its average total for the
+// months ending with it, from the history database.  The files are edited in
+// place, line by line, so that their formatting and comments are preserved;
+// without -apply, the changes are only listed.
+func accountsUpdateCommand(args []string) {
+	lastMonth := time.Now().AddDate(0, -1, 0).Format("2006-01")
+	flags := flag.NewFlagSet("accounts update-standardvalues", flag.ExitOnError)
+	monthPtr := flags.String("month", lastMonth, "month (yyyy-mm) whose totals are used")
+	fromPtr := flags.String("from", "latest", `"latest" (the month's total) or "trailing_average"`)
+	costTypePtr := flags.String("costtype", "UnblendedCost", "cost type of the runs whose totals are used")
+	monthsPtr := flags.Int("months", defaultBaselineMonths, "number of months averaged, with -from trailing_average")
+	applyPtr := flags.Bool("apply", false, "write the changes (otherwise, they are only listed)")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "Usage: costpuller accounts update-standardvalues [options] <accounts.yaml>")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 || *monthsPtr < 1 {
+		flags.Usage()
+		os.Exit(ExitUsage)
+	}
+	ref, err := time.Parse("2006-01", *monthPtr)
+	if err != nil {
+		fatalf("[accounts] error parsing month value, %q: %v", *monthPtr, err)
+	}
+	first := *monthPtr
+	switch *fromPtr {
+	case "latest":
+	case "trailing_average":
+		first = ref.AddDate(0, 1-*monthsPtr, 0).Format("2006-01")
+	default:
+		flags.Usage()
+		os.Exit(ExitUsage)
+	}
+
+	accountsFileName := flags.Arg(0)
+	accountsFile, err := loadAccountsFile(accountsFileName)
+	if err != nil {
+		fatalf("[accounts] error loading %s: %v", accountsFileName, err)
+	}
+	values := getAverageTotals(accountsFile, first, *monthPtr, *costTypePtr)
+	if len(values) == 0 {
+		fatalf("[accounts] the history database has no totals for %s through %s", first, *monthPtr)
+	}
+
+	fileNames := []string{accountsFileName}
+	for _, pattern := range accountsFile.Include {
+		included, err := getIncludedFiles(accountsFileName, pattern)
+		if err != nil {
+			fatalf("[accounts] bad include pattern %q: %v", pattern, err)
+		}
+		fileNames = append(fileNames, included...)
+	}
+	changed := 0
+	var missing []string
+	for _, fileName := range fileNames {
+		if isRemoteAccountsFile(fileName) || strings.HasPrefix(fileName, "git+") {
+			fatalf("[accounts] %s is not a local file, and cannot be updated", fileName)
+		}
+		if ext := strings.ToLower(filepath.Ext(fileName)); ext != ".yaml" && ext != ".yml" {
+			fatalf("[accounts] %s is not a YAML file, and cannot be updated", fileName)
+		}
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			fatalf("[accounts] error reading %s: %v", fileName, err)
+		}
+		if isSopsEncrypted(data) {
+			fatalf("[accounts] %s is encrypted, and cannot be updated", fileName)
+		}
+		updated, changes, seen, warnings := setStandardValues(string(data), values)
+		for _, warning := range warnings {
+			fmt.Printf("%s: Warning:  %s\n", fileName, warning)
+		}
+		for _, accountID := range seen {
+			if _, exists := values[accountID]; !exists {
+				missing = append(missing, accountID)
+			}
+		}
+		for _, change := range changes {
+			fmt.Printf("%s: %s\n", fileName, change)
+		}
+		changed += len(changes)
+		if len(changes) == 0 || !*applyPtr {
+			continue
+		}
+		info, err := os.Stat(fileName)
+		if err != nil {
+			fatalf("[accounts] error updating %s: %v", fileName, err)
+		}
+		if err := os.WriteFile(fileName, []byte(updated), info.Mode().Perm()); err != nil {
+			fatalf("[accounts] error updating %s: %v", fileName, err)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("No history for %s through %s (left unchanged): %s\n", first, *monthPtr,
+			strings.Join(missing, ", "))
+	}
+	switch {
+	case changed == 0:
+		fmt.Println("No standard values changed.")
+	case *applyPtr:
+		fmt.Printf("Updated %d standard values.\n", changed)
+	default:
+		fmt.Println("Run with -apply to write the changes.")
+	}
+}
+
+// setStandardValues returns the YAML text with the standardvalue of each
+// account entry whose ID is in the values set to the (rounded) value, adding
+// the key to entries which lack it; only the value on the line is replaced,
+// so that the rest of the text, including comments, is unchanged.  It also
+// returns a description of each change, the IDs of all the accounts seen,
+// and warnings about the entries in the flow style (i.e., "{accountid: ...}"),
+// which are not updated.
+func setStandardValues(text string, values map[string]float64) (string, []string, []string, []string) {
+	type entry struct {
+		keyIndent     int
+		accountID     string
+		idLine        int
+		standardLine  int
+		standardMatch []string
+	}
+	lines := strings.Split(text, "\n")
+	replaced := make(map[int]string)
+	inserted := make(map[int]string) // Line index -> line to be inserted after it
+	var changes, seen, warnings []string
+
+	finish := func(current *entry) {
+		if current == nil || current.accountID == "" {
+			return
+		}
+		seen = append(seen, current.accountID)
+		value, exists := values[current.accountID]
+		if !exists {
+			return
+		}
+		formatted := strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+		if current.standardLine < 0 {
+			inserted[current.idLine] = strings.Repeat(" ", current.keyIndent) + "standardvalue: " + formatted
+			changes = append(changes, fmt.Sprintf("%s standard value (none) -> %s", current.accountID, formatted))
+			return
+		}
+		match := current.standardMatch
+		if old, err := strconv.ParseFloat(match[4], 64); err == nil && math.Round(old*100) == math.Round(value*100) {
+			return
+		}
+		replaced[current.standardLine] = match[1] + match[2] + ":" + match[3] + formatted + match[5]
+		changes = append(changes, fmt.Sprintf("%s standard value %s -> %s", current.accountID, match[4], formatted))
+	}
+
+	var current *entry
+	for idx, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		match := standardValueKeyPattern.FindStringSubmatch(line)
+		if flowAccountPattern.MatchString(line) {
+			warnings = append(warnings, fmt.Sprintf("line %d:  the account entry is in the flow style, and is "+
+				"not updated", idx+1))
+		}
+		switch {
+		case current != nil && current.keyIndent >= 0 && indent >= current.keyIndent:
+			// A key of the entry, or a line nested within one (including the
+			// items of a nested sequence)
+		case strings.HasPrefix(trimmed, "- ") || trimmed == "-":
+			// A new sequence item starts a new (possible) account entry.
+			finish(current)
+			current = &entry{keyIndent: -1, standardLine: -1}
+			if match != nil {
+				current.keyIndent = len(match[1])
+			} else if trimmed != "-" {
+				current = nil // A scalar item
+			}
+		case current != nil && current.keyIndent < 0:
+			current.keyIndent = indent // The item's keys start on the next line
+		case current != nil:
+			finish(current) // A line outdented from the entry's keys
+			current = nil
+		}
+		if current == nil || match == nil || len(match[1]) != current.keyIndent {
+			continue
+		}
+		switch match[2] {
+		case "accountid":
+			current.accountID = strings.Trim(match[4], `"'`)
+			current.idLine = idx
+		case "standardvalue":
+			current.standardLine = idx
+			current.standardMatch = match
+		}
+	}
+	finish(current)
+
+	var output []string
+	for idx, line := range lines {
+		if replacement, exists := replaced[idx]; exists {
+			line = replacement
+		}
+		output = append(output, line)
+		if insertion, exists := inserted[idx]; exists {
+			output = append(output, insertion)
+		}
+	}
+	return strings.Join(output, "\n"), changes, seen, warnings
+}
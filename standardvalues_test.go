@@ -0,0 +1,159 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSetStandardValues(t *testing.T) {
+	values := map[string]float64{"1111-1111-1111": 123.456, "2222-2222-2222": 50}
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+		seen     []string
+		warnings int
+	}{
+		{
+			name: "entry starting on the item line",
+			text: `accounts:
+  Amazon:
+    eng:
+      - accountid: 1111-1111-1111
+        standardvalue: 100
+      - accountid: 2222-2222-2222
+        standardvalue: 50
+`,
+			expected: `accounts:
+  Amazon:
+    eng:
+      - accountid: 1111-1111-1111
+        standardvalue: 123.46
+      - accountid: 2222-2222-2222
+        standardvalue: 50
+`,
+			seen: []string{"1111-1111-1111", "2222-2222-2222"},
+		},
+		{
+			name: "entry starting on the next line",
+			text: `eng:
+  -
+    description: eng-prod
+    standardvalue: 100
+    accountid: 1111-1111-1111
+`,
+			expected: `eng:
+  -
+    description: eng-prod
+    standardvalue: 123.46
+    accountid: 1111-1111-1111
+`,
+			seen: []string{"1111-1111-1111"},
+		},
+		{
+			name: "missing key",
+			text: `eng:
+  - accountid: 1111-1111-1111
+    description: eng-prod
+  -
+    accountid: 2222-2222-2222
+`,
+			expected: `eng:
+  - accountid: 1111-1111-1111
+    standardvalue: 123.46
+    description: eng-prod
+  -
+    accountid: 2222-2222-2222
+    standardvalue: 50
+`,
+			seen: []string{"1111-1111-1111", "2222-2222-2222"},
+		},
+		{
+			name: "trailing comments",
+			text: `eng:
+  - accountid: 1111-1111-1111 # eng-prod
+    standardvalue: 100   # reviewed in March
+`,
+			expected: `eng:
+  - accountid: 1111-1111-1111 # eng-prod
+    standardvalue: 123.46   # reviewed in March
+`,
+			seen: []string{"1111-1111-1111"},
+		},
+		{
+			name: "quoted IDs",
+			text: `eng:
+  - accountid: "1111-1111-1111"
+    standardvalue: 100
+  - accountid: '2222-2222-2222'
+    standardvalue: 10
+`,
+			expected: `eng:
+  - accountid: "1111-1111-1111"
+    standardvalue: 123.46
+  - accountid: '2222-2222-2222'
+    standardvalue: 50
+`,
+			seen: []string{"1111-1111-1111", "2222-2222-2222"},
+		},
+		{
+			name: "nested sequences",
+			text: `eng:
+  - accountid: 1111-1111-1111
+    owners:
+      - alice
+      - name: bob
+        standardvalue: 7
+    labels:
+    - prod
+    standardvalue: 100
+  - accountid: 3333-3333-3333
+include:
+  - teams/*.yaml
+`,
+			expected: `eng:
+  - accountid: 1111-1111-1111
+    owners:
+      - alice
+      - name: bob
+        standardvalue: 7
+    labels:
+    - prod
+    standardvalue: 123.46
+  - accountid: 3333-3333-3333
+include:
+  - teams/*.yaml
+`,
+			seen: []string{"1111-1111-1111", "3333-3333-3333"},
+		},
+		{
+			name: "flow style",
+			text: `eng:
+  - {accountid: 1111-1111-1111, standardvalue: 100}
+  - accountid: 2222-2222-2222
+    standardvalue: 10
+`,
+			expected: `eng:
+  - {accountid: 1111-1111-1111, standardvalue: 100}
+  - accountid: 2222-2222-2222
+    standardvalue: 50
+`,
+			seen:     []string{"2222-2222-2222"},
+			warnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated, _, seen, warnings := setStandardValues(tt.text, values)
+			if updated != tt.expected {
+				t.Errorf("the updated text is\n%s\nexpected\n%s", updated, tt.expected)
+			}
+			if !slices.Equal(seen, tt.seen) {
+				t.Errorf("the accounts seen are %q, expected %q", seen, tt.seen)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("the warnings are %q, expected %d", warnings, tt.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/csv"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// rowStreamBuffer is the number of batches of rows (typically, one batch per
+// account) which can be waiting between the producer and the consumer in
+// streamRows().
+const rowStreamBuffer = 16
+
+// streamRows runs the producer in a separate goroutine, passing each batch of
+// rows which it emits through a bounded channel to the consumer, which is
+// called (on the caller's goroutine) for each batch in order.  It returns
+// when the producer has finished and all the batches have been consumed.
+// When the buffer is full, the producer blocks, so that a slow consumer
+// limits the number of rows held in memory.
+func streamRows(produce func(emit func(rows []*sheets.RowData)), consume func(rows []*sheets.RowData)) {
+	stream := make(chan []*sheets.RowData, rowStreamBuffer)
+	go func() {
+		defer close(stream)
+		produce(func(rows []*sheets.RowData) { stream <- rows })
+	}()
+	for rows := range stream {
+		consume(rows)
+	}
+}
+
+// RowSink receives the rows of the main output sheet as they are produced.
+// For CSV output, each row is written to the file immediately; the Google
+// Sheets and Smartsheet outputs are written in a single update, so the rows
+// are collected until finish() is called.
+type RowSink struct {
+	output    *OutputObject
+	csvWriter *csv.Writer
+	collected []*sheets.RowData
+	count     int
+}
+
+// newRowSink returns a sink for the main output sheet.
+func (o *OutputObject) newRowSink() *RowSink {
+	sink := &RowSink{output: o}
+	if o.csvFile != nil {
+		sink.csvWriter = csv.NewWriter(o.csvFile)
+	}
+	return sink
+}
+
+// writeRows adds rows to the output.
+func (s *RowSink) writeRows(rows []*sheets.RowData) {
+	if s.output.staticTotals {
+		rows = getStaticTotalsRows(rows)
+	}
+	s.count += len(rows)
+	if s.csvWriter != nil {
+		if err := writeCsvRows(s.csvWriter, rows); err != nil {
+			exitf(ExitOutputFailure, "[writeRows] error writing to output file: %v", err)
+		}
+	}
+	if s.output.collectsRows() {
+		s.collected = append(s.collected, rows...)
+	}
+}
+
+// collectsRows reports whether the output needs all the rows at once (i.e.,
+// for anything other than the CSV file).
+func (o *OutputObject) collectsRows() bool {
+	return o.httpClient != nil || o.smartsheet != nil || len(o.uploaders) > 0 || o.sftp != nil
+}
+
+// finish completes the output, flushing the CSV file or posting the sheet.
+func (s *RowSink) finish() {
+	defer startPhase("output.write")()
+	if s.count == 0 {
+		fatalf("[writeSheet] no sheet data")
+	}
+	if s.csvWriter != nil {
+		s.csvWriter.Flush()
+		if err := s.csvWriter.Error(); err != nil {
+			exitf(ExitOutputFailure, "[writeSheet] error writing to output file: %v", err)
+		}
+	}
+	if s.output.httpClient != nil {
+		s.output.postToSpreadsheets("the main sheet", func(configMap Configuration) error {
+			return postToGSheet(s.collected, s.output.httpClient, configMap, s.output.refTime, s.output.rollForward,
+				s.output.force)
+		})
+	}
+	if s.output.smartsheet != nil {
+		if err := s.output.smartsheet.postSheet(s.collected, s.output.refTime); err != nil {
+			exitf(ExitOutputFailure, "[writeSheet] error writing to Smartsheet: %v", err)
+		}
+	}
+	s.output.uploadSheetArtifact(s.output.artifactName, s.collected)
+	if s.output.sftp != nil {
+		s.output.sftp.rows = s.collected // Delivered at the end of the run
+	}
+}
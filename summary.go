@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+)
+
+// RunSummary is the machine-readable summary of a run, written to the file
+// named by the -summary-file option.
+type RunSummary struct {
+	Month             string             `json:"month"`
+	CostType          string             `json:"cost_type"`
+	Started           time.Time          `json:"started"`
+	DurationSeconds   float64            `json:"duration_seconds"`
+	ExitCode          int                `json:"exit_code"`
+	Providers         []string           `json:"providers"`
+	ProvidersFailed   []string           `json:"providers_failed"`
+	AccountsProcessed int                `json:"accounts_processed"`
+	AccountsMissing   []string           `json:"accounts_missing"`
+	Warnings          []string           `json:"warnings"`
+	UnattributedSpend map[string]float64 `json:"unattributed_spend"`
+	TeamTotals        map[string]float64 `json:"team_totals"`
+	PhaseSeconds      map[string]float64 `json:"phase_seconds"`
+	AccountSeconds    map[string]float64 `json:"account_seconds"`
+}
+
+// getMissingAccounts returns the accounts from the accounts file for which no
+// cost data was found (ignoring those excluded from the run), formatted as
+// "<provider>:<group>:<account-id>".
+func getMissingAccounts(accountsMetadata map[string]*AccountMetadata) (missing []string) {
+	for id, entry := range accountsMetadata {
+		if !entry.DataFound && !entry.Excluded {
+			missing = append(missing, fmt.Sprintf("%s:%s:%s", entry.CloudProvider, entry.Group, id))
+		}
+	}
+	slices.Sort(missing)
+	return
+}
+
+// getRunSummary returns the summary of the run.
+func getRunSummary(
+	options CommandLineOptions,
+	started time.Time,
+	providers []string,
+	records []HistoryRecord,
+	missing []string,
+) RunSummary {
+	summary := RunSummary{
+		Month:             *options.monthPtr,
+		CostType:          *options.costTypePtr,
+		Started:           started,
+		DurationSeconds:   time.Since(started).Seconds(),
+		ExitCode:          exitStatus,
+		Providers:         providers,
+		ProvidersFailed:   []string{},
+		AccountsMissing:   missing,
+		Warnings:          runWarnings,
+		UnattributedSpend: getUnattributedSpend(),
+		TeamTotals:        make(map[string]float64),
+		PhaseSeconds:      getTimingSeconds(phaseTimings),
+		AccountSeconds:    getTimingSeconds(accountTimings),
+	}
+	accounts := make(map[string]struct{})
+	for _, record := range records {
+		accounts[record.AccountID] = struct{}{}
+		summary.TeamTotals[record.Group] += record.Total
+	}
+	summary.AccountsProcessed = len(accounts)
+	for _, failure := range providerFailures {
+		summary.ProvidersFailed = append(summary.ProvidersFailed, failure.Provider)
+	}
+	if summary.AccountsMissing == nil {
+		summary.AccountsMissing = []string{}
+	}
+	if summary.Warnings == nil {
+		summary.Warnings = []string{}
+	}
+	return summary
+}
+
+// writeRunSummary writes the run summary, as JSON, to the file named by the
+// -summary-file option, if any.
+func writeRunSummary(
+	options CommandLineOptions,
+	started time.Time,
+	providers []string,
+	records []HistoryRecord,
+	missing []string,
+) {
+	if *options.summaryFilePtr == "" {
+		return
+	}
+	summary := getRunSummary(options, started, providers, records, missing)
+
+	summaryFile, err := os.Create(*options.summaryFilePtr)
+	if err != nil {
+		exitf(ExitOutputFailure, "[writeRunSummary] error creating summary file: %v", err)
+	}
+	defer closeFile(summaryFile)
+	encoder := json.NewEncoder(summaryFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(summary); err != nil {
+		exitf(ExitOutputFailure, "[writeRunSummary] error writing summary file: %v", err)
+	}
+	log.Printf("[writeRunSummary] run summary written to %s", *options.summaryFilePtr)
+}
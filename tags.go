@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AccountTags is the exported form of an AWS account's tags.  The name and
+// status are included for the reviewer's benefit; they are ignored on import.
+type AccountTags struct {
+	AccountID string            `yaml:"accountid"`
+	Name      string            `yaml:"name"`
+	Status    string            `yaml:"status"`
+	Tags      map[string]string `yaml:"tags"`
+}
+
+// tagsCommand implements the "tags" subcommand, which exports the tags of all
+// the accounts in an AWS organization to a CSV or YAML file (selected by the
+// file extension) or imports a reviewed file, applying the differences:
+//
+//	costpuller tags [-payer <name>] export <file>
+//	costpuller tags [-payer <name>] [-apply] import <file>
+//
+// On import, a tag with an empty value is removed from the account; accounts
+// and tags which are absent from the file are left unchanged.
+func tagsCommand(args []string) {
+	flags := flag.NewFlagSet("tags", flag.ExitOnError)
+	accountsFilePtr := flags.String("accounts", "accounts.yaml", "file to read the configuration from")
+	auditLogPtr := flags.String("audit-log", "", "file to which a JSON line is appended for each external API call")
+	applyPtr := flags.Bool("apply", false, "on import, write the planned tag changes (otherwise, they are only listed)")
+	debugPtr := flags.Bool("debug", false, "outputs debug info")
+	payerPtr := flags.String("payer", "", "name of the AWS payer to use (default the first configured)")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "Usage: costpuller tags [options] export|import <file.csv|file.yaml>")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	fileName := flags.Arg(1)
+
+	accountsFile, err := loadAccountsFile(*accountsFilePtr)
+	if err != nil {
+		fatalf("[tags] error loading accounts file: %v", err)
+	}
+	payers := getAwsPayers(getMapKeyValue(accountsFile.Configuration, "aws", "configuration"))
+	payer := payers[0]
+	if *payerPtr != "" {
+		idx := slices.IndexFunc(payers, func(p AwsPayer) bool { return p.Name == *payerPtr })
+		if idx < 0 {
+			fatalf("[tags] payer %q is not configured", *payerPtr)
+		}
+		payer = payers[idx]
+	}
+	openAuditLog(*auditLogPtr)
+	defer auditLog.close()
+	awsPuller := NewAwsPuller(payer, *debugPtr)
+
+	switch flags.Arg(0) {
+	case "export":
+		accountTags, err := awsPuller.getAccountTags()
+		if err != nil {
+			exitf(getAwsExitCode(err), "[tags] error reading account tags: %v", err)
+		}
+		outfile, err := os.Create(fileName)
+		if err != nil {
+			exitf(ExitOutputFailure, "[tags] error creating output file: %v", err)
+		}
+		defer closeFile(outfile)
+		if isYamlFile(fileName) {
+			err = yaml.NewEncoder(outfile).Encode(accountTags)
+		} else {
+			err = writeAccountTagsCsv(outfile, accountTags)
+		}
+		if err != nil {
+			exitf(ExitOutputFailure, "[tags] error writing output file: %v", err)
+		}
+		log.Printf("[tags] exported tags for %d accounts to %s", len(accountTags), fileName)
+	case "import":
+		accountTags, err := readAccountTagsFile(fileName)
+		if err != nil {
+			fatalf("[tags] error reading %s: %v", fileName, err)
+		}
+		var changes []AwsTagChange
+		for _, entry := range accountTags {
+			current, err := awsPuller.getTagsForAWSAccount(entry.AccountID)
+			if err != nil {
+				exitf(getAwsExitCode(err), "[tags] error reading tags for account %s: %v", entry.AccountID, err)
+			}
+			for _, key := range sortedKeys(entry.Tags) {
+				if value := entry.Tags[key]; current[key] != value && (value != "" || current[key] != "") {
+					changes = append(changes, AwsTagChange{
+						AccountID: entry.AccountID,
+						Key:       key,
+						OldValue:  current[key],
+						NewValue:  value,
+					})
+				}
+			}
+		}
+		if len(changes) == 0 {
+			fmt.Println("All account tags already match the file.")
+			return
+		}
+		fmt.Println("Planned tag changes:")
+		for _, change := range changes {
+			fmt.Printf("  %s\n", change)
+		}
+		if !*applyPtr {
+			fmt.Println("Not applied; re-run with -apply to write these tags.")
+			return
+		}
+		if err := awsPuller.ApplyAwsTags(changes); err != nil {
+			exitf(getAwsExitCode(err), "[tags] error writing account tag: %v", err)
+		}
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// getAccountTags returns the tags of every account in the organization,
+// ordered by account ID.
+func (a *AwsPuller) getAccountTags() (result []AccountTags, err error) {
+	accounts, err := a.getAllAWSAccountData()
+	if err != nil {
+		return nil, err
+	}
+	for _, accountID := range sortedKeys(accounts) {
+		log.Printf("[getAccountTags] pulling tags for account %s", accountID)
+		tags, err := a.getTagsForAWSAccount(accountID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, AccountTags{
+			AccountID: accountID,
+			Name:      accounts[accountID][AwsMetadataDescription],
+			Status:    accounts[accountID][AwsMetadataStatus],
+			Tags:      tags,
+		})
+	}
+	return
+}
+
+// isYamlFile reports whether the file name has a YAML extension.
+func isYamlFile(fileName string) bool {
+	ext := filepath.Ext(fileName)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// writeAccountTagsCsv writes the account tags as CSV, with a column for each
+// tag key found on any account.
+func writeAccountTagsCsv(out io.Writer, accountTags []AccountTags) error {
+	keysSet := make(map[string]struct{})
+	for _, entry := range accountTags {
+		for key := range entry.Tags {
+			keysSet[key] = struct{}{}
+		}
+	}
+	keys := sortedKeys(keysSet)
+	writer := csv.NewWriter(out)
+	defer writer.Flush()
+	if err := writer.Write(append([]string{"accountid", "name", "status"}, keys...)); err != nil {
+		return err
+	}
+	for _, entry := range accountTags {
+		row := []string{entry.AccountID, entry.Name, entry.Status}
+		for _, key := range keys {
+			row = append(row, entry.Tags[key])
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAccountTagsFile reads a file in the format written by the export
+// command.
+func readAccountTagsFile(fileName string) (accountTags []AccountTags, err error) {
+	infile, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile(infile)
+	if isYamlFile(fileName) {
+		err = yaml.NewDecoder(infile).Decode(&accountTags)
+		return
+	}
+	rows, err := csv.NewReader(infile).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || len(rows[0]) < 3 || rows[0][0] != "accountid" {
+		return nil, fmt.Errorf("expected a header row beginning with \"accountid,name,status\"")
+	}
+	header := rows[0]
+	for _, row := range rows[1:] {
+		entry := AccountTags{AccountID: row[0], Name: row[1], Status: row[2], Tags: make(map[string]string)}
+		for idx, key := range header[3:] {
+			entry.Tags[key] = row[idx+3]
+		}
+		accountTags = append(accountTags, entry)
+	}
+	return
+}
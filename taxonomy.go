@@ -0,0 +1,160 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+)
+
+// CategoryTaxonomy defines the cost categories of the output (the cost
+// columns of the sheet, and the categories of the history records), with a
+// table for each data source which maps the source's own categories onto
+// them, so that the data from every provider lands in the same columns.  The
+// sources are "aws" (the normalized columns, see awsNormalizedColumns),
+// "azure" (the meter categories of the Azure rows from Cloudability),
+// "cloudability" (the usage families of the other Cloudability rows), and
+// "ibmcloud" (the resource names).
+type CategoryTaxonomy struct {
+	// Categories optionally lists the categories, in the order of their
+	// columns; when it is set, every sheet has all of them, and any other
+	// category is placed in the Other category.
+	Categories []string
+	Other      string
+
+	// Mappings maps each source's categories onto the output categories;
+	// unmapped categories are used as they are (except for IBM Cloud).
+	Mappings map[string]map[string]string
+}
+
+// defaultCategoryMappings are the built-in mapping tables, which take each
+// source's categories onto the Cloudability usage families.
+var defaultCategoryMappings = map[string]map[string]string{
+	"aws": {
+		"dataTransfer":  "Data Transfer",
+		"dns":           "DNS",
+		"keyManagement": "Key Management",
+		"machines":      "Instance Usage",
+		"other":         "Other",
+		"rebate":        "Credit",
+		"registrar":     "Registrar",
+		"storage":       "Storage",
+		"tax":           "Tax",
+	},
+	"azure": {
+		"Azure App Service":        "Instance Usage",
+		"Azure Kubernetes Service": "Instance Usage",
+		"Bandwidth":                "Data Transfer",
+		"Container Registry":       "Storage",
+		"Load Balancer":            "Load Balancer",
+		"Storage":                  "Storage",
+		"Virtual Machines":         "Instance Usage",
+		"Virtual Network":          "VPC Endpoint",
+		"VPN Gateway":              "VPN",
+	},
+	"cloudability": {},
+	"ibmcloud": {
+		// Note:  in several cases, the bucketing is arbitrary and probably
+		//        incorrect....
+		"Block Storage for VPC":            "Storage",
+		"Cloud Activity Tracker":           "Notifications",
+		"Cloud Monitoring":                 "Notifications",
+		"Cloud Object Storage":             "Storage",
+		"Continuous Delivery":              "Other",
+		"Floating IP for VPC":              "IP Address",
+		"Kubernetes Service":               "Instance Usage",
+		"Load Balancer for VPC":            "Load Balancer",
+		"Log Analysis":                     "Other",
+		"Virtual Private Cloud":            "VPN",
+		"Virtual Private Endpoint for VPC": "VPC Endpoint",
+		"Virtual Server for VPC":           "VPC Endpoint",
+	},
+}
+
+// categoryTaxonomy is the taxonomy in effect for the run; it is configured
+// from the "taxonomy" section of the configuration by configureTaxonomy.
+var categoryTaxonomy = CategoryTaxonomy{Other: "Other", Mappings: defaultCategoryMappings}
+
+// configureTaxonomy sets the category taxonomy from the given configuration
+// subsection (which may be nil):  its "categories" list, its "other"
+// category, and its "mappings", a table for each source which adds to or
+// overrides the built-in one.  (The "meter_categories" mapping in the "azure"
+// section is applied to the "azure" table before them.)
+func configureTaxonomy(config Configuration, azureConfig Configuration) {
+	taxonomy := CategoryTaxonomy{
+		Categories: getMapKeyStringList(config, "categories", ""),
+		Other:      cmp.Or(getMapKeyString(config, "other", ""), "Other"),
+		Mappings:   make(map[string]map[string]string),
+	}
+	for source, mapping := range defaultCategoryMappings {
+		taxonomy.Mappings[source] = maps.Clone(mapping)
+	}
+	if meterCategories := getMapKeyValue(azureConfig, "meter_categories", ""); meterCategories != nil {
+		for name, category := range getConfigurationFromAny(meterCategories, "Azure meter category mapping") {
+			taxonomy.Mappings["azure"][name] = getStringFromAny(category, "Azure meter category mapping")
+		}
+	}
+	if mappings := getMapKeyValue(config, "mappings", ""); mappings != nil {
+		overrides := getConfigurationFromAny(mappings, "taxonomy mappings")
+		for _, source := range sortedKeys(overrides) {
+			if _, exists := taxonomy.Mappings[source]; !exists {
+				fatalf("Error in \"taxonomy\" configuration:  unknown mapping source %q, expected \"aws\", "+
+					"\"azure\", \"cloudability\", or \"ibmcloud\"", source)
+			}
+			for name, category := range getConfigurationFromAny(overrides[source], "taxonomy "+source+" mapping") {
+				taxonomy.Mappings[source][name] = getStringFromAny(category, "taxonomy "+source+" mapping")
+			}
+		}
+	}
+	if len(taxonomy.Categories) > 0 && !slices.Contains(taxonomy.Categories, taxonomy.Other) {
+		fatalf("Error in \"taxonomy\" configuration:  the \"other\" category (%q) is not in \"categories\"",
+			taxonomy.Other)
+	}
+	categoryTaxonomy = taxonomy
+}
+
+// getCategory returns the output category of a source's category (e.g., an
+// AWS normalized column or a Cloudability usage family).
+func (t CategoryTaxonomy) getCategory(source string, name string) string {
+	category, _ := t.lookup(source, name)
+	return category
+}
+
+// lookup returns the output category of a source's category, and whether the
+// source's table maps it; an unmapped category is used as it is.  When the
+// categories are listed, any other category is replaced by the Other one.
+func (t CategoryTaxonomy) lookup(source string, name string) (category string, mapped bool) {
+	category, mapped = t.Mappings[source][name]
+	if !mapped {
+		category = name
+	}
+	if len(t.Categories) > 0 && !slices.Contains(t.Categories, category) {
+		category = t.Other
+	}
+	return category, mapped
+}
+
+// newColumnHeadsSet returns the set of cost column headers, initially holding
+// the listed categories, if any, so that every sheet has all of them.
+func (t CategoryTaxonomy) newColumnHeadsSet() map[string]struct{} {
+	columnHeadsSet := make(map[string]struct{})
+	for _, category := range t.Categories {
+		columnHeadsSet[category] = struct{}{}
+	}
+	return columnHeadsSet
+}
+
+// sortColumns returns the cost column headers in order:  the listed
+// categories, in the order of the list, followed by the others,
+// alphabetically.
+func (t CategoryTaxonomy) sortColumns(columnHeadsSet map[string]struct{}) []string {
+	return slices.SortedFunc(maps.Keys(columnHeadsSet), func(a, b string) int {
+		ia, ib := slices.Index(t.Categories, a), slices.Index(t.Categories, b)
+		if ia < 0 {
+			ia = len(t.Categories)
+		}
+		if ib < 0 {
+			ib = len(t.Categories)
+		}
+		return cmp.Or(cmp.Compare(ia, ib), cmp.Compare(a, b))
+	})
+}
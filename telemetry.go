@@ -0,0 +1,164 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// telemetryCtx is the context of the span for the whole run; phase spans are
+// created as its children.  Until telemetry is initialized, it holds no span,
+// and the global (no-op) tracer and meter providers are used.
+var telemetryCtx = context.Background()
+
+// phaseDuration is the histogram of phase durations, by phase name.
+var phaseDuration metric.Float64Histogram
+
+// initTelemetry sets up OpenTelemetry tracing and metrics, exported via OTLP
+// over HTTP, when the configuration has a "telemetry" section or the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT environment variable is set.  The optional
+// "endpoint" key (host:port) and "insecure" key (use HTTP rather than HTTPS)
+// override the environment; the other OTEL_* variables (e.g., for headers)
+// are honored by the exporters.  It starts a span for the whole run and
+// returns a function which ends it and flushes the exporters.
+func initTelemetry(configMap Configuration, configured bool, options CommandLineOptions) (shutdown func()) {
+	if !configured && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return func() {}
+	}
+	ctx := context.Background()
+	var traceOptions []otlptracehttp.Option
+	var metricOptions []otlpmetrichttp.Option
+	if endpoint := getMapKeyString(configMap, "endpoint", ""); endpoint != "" {
+		traceOptions = append(traceOptions, otlptracehttp.WithEndpoint(endpoint))
+		metricOptions = append(metricOptions, otlpmetrichttp.WithEndpoint(endpoint))
+	}
+	if getMapKeyBool(configMap, "insecure", "") {
+		traceOptions = append(traceOptions, otlptracehttp.WithInsecure())
+		metricOptions = append(metricOptions, otlpmetrichttp.WithInsecure())
+	}
+	traceExporter, err := otlptracehttp.New(ctx, traceOptions...)
+	if err != nil {
+		log.Printf("[initTelemetry] error creating trace exporter, telemetry disabled: %v", err)
+		return func() {}
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, metricOptions...)
+	if err != nil {
+		log.Printf("[initTelemetry] error creating metric exporter, telemetry disabled: %v", err)
+		return func() {}
+	}
+
+	res := resource.NewSchemaless(semconv.ServiceName("costpuller"))
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+
+	phaseDuration, err = otel.Meter("costpuller").Float64Histogram(
+		"costpuller.phase.duration",
+		metric.WithDescription("Duration of each phase of the run"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		log.Printf("[initTelemetry] error creating phase duration metric: %v", err)
+	}
+
+	var runSpan trace.Span
+	telemetryCtx, runSpan = otel.Tracer("costpuller").Start(ctx, "costpuller.run", trace.WithAttributes(
+		attribute.String("costpuller.month", *options.monthPtr),
+		attribute.String("costpuller.cost_type", *options.costTypePtr),
+	))
+	return func() {
+		runSpan.End()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			log.Printf("[initTelemetry] error flushing traces: %v", err)
+		}
+		if err := meterProvider.Shutdown(ctx); err != nil {
+			log.Printf("[initTelemetry] error flushing metrics: %v", err)
+		}
+	}
+}
+
+// phaseTimings and accountTimings accumulate the wall-clock time spent in
+// each phase of the run and on each account, for the run summary.
+var phaseTimings = make(map[string]time.Duration)
+var accountTimings = make(map[string]time.Duration)
+var timingsMutex sync.Mutex
+
+// startPhase starts a span for a phase of the run (e.g., a provider pull or a
+// sheet write) and returns a function which ends it and records its duration;
+// typical usage is `defer startPhase("cloudability.pull")()`.  If the phase
+// has an "account" attribute, the duration is also added to the account's
+// timing.
+func startPhase(name string, attributes ...attribute.KeyValue) (end func()) {
+	start := time.Now()
+	_, span := otel.Tracer("costpuller").Start(telemetryCtx, name, trace.WithAttributes(attributes...))
+	return func() {
+		span.End()
+		elapsed := time.Since(start)
+		timingsMutex.Lock()
+		phaseTimings[name] += elapsed
+		for _, attr := range attributes {
+			if attr.Key == "account" {
+				accountTimings[attr.Value.AsString()] += elapsed
+			}
+		}
+		timingsMutex.Unlock()
+		if phaseDuration != nil {
+			phaseDuration.Record(telemetryCtx, elapsed.Seconds(),
+				metric.WithAttributes(attribute.String("phase", name)))
+		}
+	}
+}
+
+// logTimings logs the time spent in each phase and the accounts which took
+// the longest.
+func logTimings() {
+	timingsMutex.Lock()
+	defer timingsMutex.Unlock()
+	log.Println("[logTimings] time by phase:")
+	for _, name := range sortedKeys(phaseTimings) {
+		log.Printf("[logTimings]   %-24s %8.1fs", name, phaseTimings[name].Seconds())
+	}
+	accounts := sortedKeys(accountTimings)
+	if len(accounts) == 0 {
+		return
+	}
+	slices.SortStableFunc(accounts, func(a, b string) int {
+		return cmp.Compare(accountTimings[b], accountTimings[a])
+	})
+	log.Println("[logTimings] slowest accounts:")
+	for _, account := range accounts[:min(len(accounts), 10)] {
+		log.Printf("[logTimings]   %-24s %8.1fs", account, accountTimings[account].Seconds())
+	}
+}
+
+// getTimingSeconds converts a map of durations to seconds.
+func getTimingSeconds(timings map[string]time.Duration) map[string]float64 {
+	timingsMutex.Lock()
+	defer timingsMutex.Unlock()
+	seconds := make(map[string]float64, len(timings))
+	for key, duration := range timings {
+		seconds[key] = duration.Seconds()
+	}
+	return seconds
+}
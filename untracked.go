@@ -0,0 +1,182 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// untrackedGroup is the placeholder group under which the untracked accounts
+// are listed in the YAML snippet, to be replaced when the snippet is reviewed.
+const untrackedGroup = "<team>"
+
+// UntrackedAccount is an account attributed to one of our cost centers, found
+// in the provider or Cloudability data, which is not in the accounts file.
+type UntrackedAccount struct {
+	Provider   string // The "cloud_providers" key under which it belongs
+	AccountId  string
+	Name       string
+	CostCenter string
+	Source     string // Where it was found
+}
+
+// untrackedAccounts lists the untracked accounts found during the run.
+var untrackedAccounts []UntrackedAccount
+
+// SkippedCostKey identifies the entries skipped from one data source's
+// results (e.g., "Cloudability") for one cost center.
+type SkippedCostKey struct {
+	Source     string
+	CostCenter string
+}
+
+// SkippedCost is the number of entries skipped, and their total cost.
+type SkippedCost struct {
+	Entries int
+	Cost    float64
+}
+
+// skippedCosts accumulates the entries skipped during the run because their
+// accounts are not in the accounts file, i.e., the spend which the run does
+// not attribute to any team.
+var skippedCosts = make(map[SkippedCostKey]*SkippedCost)
+
+// noteSkippedCost records an entry skipped because its account is not in the
+// accounts file.
+func noteSkippedCost(source string, costCenter string, cost float64) {
+	key := SkippedCostKey{source, costCenter}
+	if skippedCosts[key] == nil {
+		skippedCosts[key] = &SkippedCost{}
+	}
+	skippedCosts[key].Entries++
+	skippedCosts[key].Cost += cost
+}
+
+// getUnattributedSpend returns the total cost of the skipped entries, by data
+// source.
+func getUnattributedSpend() map[string]float64 {
+	spend := make(map[string]float64)
+	for key, skipped := range skippedCosts {
+		spend[key.Source] += skipped.Cost
+	}
+	return spend
+}
+
+// reportSkippedCosts writes to the report the number and total cost of the
+// skipped entries for each cost center, and each data source's unattributed
+// spend, so that the spend which the accounts file does not cover is visible.
+func reportSkippedCosts(reportFile *os.File) {
+	keys := slices.SortedFunc(maps.Keys(skippedCosts), func(a, b SkippedCostKey) int {
+		return cmp.Or(cmp.Compare(a.Source, b.Source), cmp.Compare(a.CostCenter, b.CostCenter))
+	})
+	for _, key := range keys {
+		skipped := skippedCosts[key]
+		costCenter := cmp.Or(key.CostCenter, "(none)")
+		log.Printf("[reportSkippedCosts] %s: skipped %d entries for cost center %s, totaling %.2f",
+			key.Source, skipped.Entries, costCenter, skipped.Cost)
+		writeReport(reportFile, fmt.Sprintf("%s: skipped %d entries for accounts not in the accounts file, "+
+			"cost center %s, totaling %.2f", key.Source, skipped.Entries, costCenter, skipped.Cost))
+	}
+	spend := getUnattributedSpend()
+	for _, source := range sortedKeys(spend) {
+		writeReport(reportFile, fmt.Sprintf("%s: unattributed spend %.2f", source, spend[source]))
+	}
+}
+
+// noteUntrackedAccount warns of an untracked account, unless it has already
+// been reported, and records it, so that it can be included in the
+// -untracked-file snippet.
+func noteUntrackedAccount(account UntrackedAccount) {
+	provider := getCanonicalProvider(account.Provider)
+	accountId, _ := getCanonicalAccountId(provider, account.AccountId)
+	for _, other := range untrackedAccounts {
+		otherProvider := getCanonicalProvider(other.Provider)
+		if otherId, _ := getCanonicalAccountId(otherProvider, other.AccountId); otherProvider == provider &&
+			otherId == accountId {
+			return // Already reported (e.g., found in both AWS Organizations and Cloudability)
+		}
+	}
+	msg := fmt.Sprintf("found account which is not in the accounts file:  %s:%s:%s:%s (%s)",
+		account.Source, account.CostCenter, account.Provider, account.AccountId, account.Name)
+	log.Printf("Warning:  %s; ignoring", msg)
+	noteExitStatus(ExitWarnings, msg)
+	untrackedAccounts = append(untrackedAccounts, account)
+}
+
+// checkUntrackedAccounts looks, in the AWS Organizations account inventory,
+// for active accounts whose cost center tag (the payer's "cost_center_tag")
+// has one of our cost centers (see CostCenterMatcher; the "cost_center" list
+// of the "aws" section)
+// but which are not in the accounts file.
+func (a *AwsPuller) checkUntrackedAccounts(
+	accounts map[string][]AccountEntry,
+	payer AwsPayer,
+	ourCostCenters CostCenterMatcher,
+) {
+	inventory, err := a.getAccountInventory()
+	if err != nil {
+		exitf(getAwsExitCode(err), "[checkUntrackedAccounts] error getting the accounts list: %v", err)
+	}
+	tracked := make(map[string]struct{})
+	for _, accountList := range accounts {
+		for _, account := range accountList {
+			tracked[account.AccountID] = struct{}{}
+		}
+	}
+	for _, accountID := range sortedKeys(inventory) {
+		metadata := inventory[accountID]
+		costCenter := metadata[payer.CostCenterTag]
+		if _, exists := tracked[accountID]; exists || metadata[AwsMetadataStatus] != "ACTIVE" ||
+			!ourCostCenters.matches(costCenter) {
+			continue
+		}
+		noteUntrackedAccount(UntrackedAccount{
+			Provider:   payer.Accounts,
+			AccountId:  accountID,
+			Name:       metadata[AwsMetadataDescription],
+			CostCenter: costCenter,
+			Source:     "AWS Organizations",
+		})
+	}
+}
+
+// writeUntrackedAccounts writes the untracked accounts to the named file as a
+// YAML snippet, ready to be reviewed, assigned to teams, and merged into the
+// "cloud_providers" section of the accounts file.
+func writeUntrackedAccounts(fileName string) {
+	if fileName == "" {
+		return
+	}
+	if len(untrackedAccounts) == 0 {
+		log.Printf("[writeUntrackedAccounts] no untracked accounts found; %s not written", fileName)
+		return
+	}
+	byProvider := make(map[string][]UntrackedAccount)
+	for _, account := range untrackedAccounts {
+		byProvider[account.Provider] = append(byProvider[account.Provider], account)
+	}
+	var snippet strings.Builder
+	snippet.WriteString("# Accounts attributed to our cost centers which are not in the accounts file.\n")
+	snippet.WriteString("# Review them, replace \"" + untrackedGroup + "\" with each one's team, and merge them into\n")
+	snippet.WriteString("# the \"cloud_providers\" section.\n")
+	snippet.WriteString("cloud_providers:\n")
+	for _, provider := range sortedKeys(byProvider) {
+		_, _ = fmt.Fprintf(&snippet, "  %s:\n    %s:\n", strconv.Quote(provider), strconv.Quote(untrackedGroup))
+		for _, account := range byProvider[provider] {
+			_, _ = fmt.Fprintf(&snippet, "      - accountid: %s  # Cost center %s, found in %s\n",
+				strconv.Quote(account.AccountId), account.CostCenter, account.Source)
+			_, _ = fmt.Fprintf(&snippet, "        description: %s\n", strconv.Quote(account.Name))
+		}
+	}
+	if err := os.WriteFile(fileName, []byte(snippet.String()), 0644); err != nil {
+		log.Printf("[writeUntrackedAccounts] error writing %s: %v", fileName, err)
+		noteExitStatus(ExitOutputFailure, fmt.Sprintf("error writing %s: %v", fileName, err))
+		return
+	}
+	log.Printf("[writeUntrackedAccounts] wrote %d untracked accounts to %s", len(untrackedAccounts), fileName)
+}
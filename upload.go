@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// uploadCommand implements the "upload" subcommand:
+//
+//	costpuller upload [options]
+//
+// It reads the CSV file written by a previous run (named by the -csv option,
+// which defaults to the file for the -month) and posts it to the Google
+// Sheet, as the run would have, so that a run whose sheet update failed can
+// be completed without pulling the data from the providers again.
+func uploadCommand(args []string) {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	options, accountsFile, _ := getOptions(flags, args)
+	if flags.NArg() != 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "Usage: costpuller upload [options]")
+		os.Exit(2)
+	}
+	rows, err := getRowsFromCsv(*options.csvfilePtr, *options.staticTotalsPtr)
+	if err != nil {
+		exitf(ExitUsage, "[upload] error reading %s: %v", *options.csvfilePtr, err)
+	}
+	log.Printf("[upload] posting %d rows from %s to the spreadsheet", len(rows), *options.csvfilePtr)
+	*options.outputTypePtr = "gsheet"
+	output := newOutputObject(options, accountsFile)
+	defer output.close()
+	output.writeSheet(rows)
+}
+
+// getRowsFromCsv reads an output CSV file and returns its rows as sheet data,
+// restoring the types of the cells:  the header row (if any) is formatted as
+// such, formulas are restored, and the cost columns hold numbers.  Since the
+// CSV file holds the values of the "TOTAL" formulas, the formulas are rebuilt,
+// unless staticTotals is set.  Other columns, such as account IDs, are kept
+// as strings.
+func getRowsFromCsv(fileName string, staticTotals bool) ([]*sheets.RowData, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile(file)
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the file is empty")
+	}
+	var rows []*sheets.RowData
+	// In the headerless layout of the direct AWS pull, the first four
+	// columns are the group, month, account ID, and provider.
+	firstNumber := 4
+	numberColumns := map[int]bool{}
+	totalColumn, lastColumn := -1, 0
+	if header := records[0]; slices.Contains(header, "Account ID") {
+		rows = append(rows, newHeaderRow(header))
+		records = records[1:]
+		firstNumber = getCostColumnStart(header)
+		if firstNumber < 0 {
+			firstNumber = len(header)
+		}
+		for _, name := range []string{"Forecast", "Exchange Rate", "Native Total"} {
+			if idx := slices.Index(header, name); idx >= 0 {
+				numberColumns[idx] = true
+			}
+		}
+		if !staticTotals {
+			totalColumn, lastColumn = slices.Index(header, "TOTAL"), len(header)-1
+		}
+	}
+	for _, record := range records {
+		row := &sheets.RowData{Values: make([]*sheets.CellData, len(record))}
+		for idx, value := range record {
+			number, err := strconv.ParseFloat(value, 64)
+			switch {
+			case idx == totalColumn && err == nil:
+				row.Values[idx] = newFormulaCell(getTotalsFormula(len(rows), firstNumber, lastColumn))
+			case strings.HasPrefix(value, "="):
+				row.Values[idx] = newFormulaCell(value)
+			case err == nil && (idx >= firstNumber || numberColumns[idx]):
+				row.Values[idx] = newExactNumberCell(number)
+			default:
+				row.Values[idx] = newStringCell(value)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
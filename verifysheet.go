@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// sheetRangePattern matches a reference in a formula to a range of a sheet
+// whose name is quoted, e.g., "'Raw Data 08/2024'!A1:Q120" or
+// "'Raw Data 08/2024'!$C:$C"; the groups are the sheet name and the start and
+// end columns and rows (the rows are empty for whole-column references).
+var sheetRangePattern = regexp.MustCompile(
+	`'((?:[^']|'')+)'!\$?([A-Z]+)\$?([0-9]*)(?::\$?([A-Z]+)\$?([0-9]*))?`)
+
+// verifySheetCommand implements the "verify-sheet" subcommand:
+//
+//	costpuller verify-sheet [options]
+//
+// It checks the main sheet of each configured spreadsheet against its raw data
+// sheets, without modifying anything:  that each raw data sheet (a sheet whose
+// name matches the "sheetNameTemplate") has a reference block on the main
+// sheet (see postToGSheet), that no month between the first and last raw data
+// sheets is missing, that each block and each explicit reference to a raw data
+// sheet covers all of its data, and that no cell of the main sheet contains a
+// #REF! error.  The problems are listed on the standard output, and the exit
+// code is ExitConsistencyFailure if there are any.
+func verifySheetCommand(args []string) {
+	flags := flag.NewFlagSet("verify-sheet", flag.ExitOnError)
+	accountsFilePtr := flags.String("accounts", "accounts.yaml", "file to read the configuration from")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "Usage: costpuller verify-sheet [options]")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	accountsFile, err := loadAccountsFile(*accountsFilePtr)
+	if err != nil {
+		fatalf("[verify-sheet] error loading accounts file: %v", err)
+	}
+	oauthConfig := getMapKeyValue(accountsFile.Configuration, "oauth", "configuration")
+	gsheetConfig := getMapKeyValue(accountsFile.Configuration, "gsheet", "configuration")
+	srv, err := sheets.NewService(context.Background(),
+		option.WithHTTPClient(getGoogleOAuthHttpClient(oauthConfig)))
+	if err != nil {
+		exitf(ExitOutputFailure, "[verify-sheet] unable to create Google Sheets client: %v", err)
+	}
+
+	var problems []string
+	destinations := getGsheetDestinations(gsheetConfig)
+	for _, configMap := range destinations {
+		prefix := ""
+		if len(destinations) > 1 {
+			prefix = getMapKeyString(configMap, "spreadsheetId", "gsheet") + ": "
+		}
+		for _, problem := range verifyMainSheet(srv, configMap) {
+			problems = append(problems, prefix+problem)
+		}
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	log.Printf("[verify-sheet] %d problems found", len(problems))
+	if len(problems) > 0 {
+		os.Exit(ExitConsistencyFailure)
+	}
+}
+
+// verifyMainSheet fetches the main sheet and the raw data sheets of the
+// configured spreadsheet and returns a description of each problem found (see
+// verifySheetCommand).
+func verifyMainSheet(srv *sheets.Service, configMap Configuration) (problems []string) {
+	spreadsheetId := getMapKeyString(configMap, "spreadsheetId", "gsheet")
+	mainSheetName := getMapKeyString(configMap, "mainSheetName", "gsheet")
+	template := getMapKeyString(configMap, "sheetNameTemplate", "gsheet")
+
+	spreadsheet, err := srv.Spreadsheets.Get(spreadsheetId).
+		Fields("sheets/properties(gridProperties(columnCount,rowCount),sheetId,title)").
+		Do()
+	if err != nil {
+		exitf(ExitProviderError, "[verify-sheet] error retrieving spreadsheet: %v", err)
+	}
+	if getSheetIdFromName(spreadsheet, mainSheetName) == nil {
+		exitf(ExitOutputFailure, "[verify-sheet] main sheet %q not found", mainSheetName)
+	}
+
+	// The raw data sheets, by month.
+	rawSheets := make(map[string]string)
+	for _, sheet := range spreadsheet.Sheets {
+		if ref, err := time.Parse(template, sheet.Properties.Title); err == nil {
+			rawSheets[ref.Format("2006-01")] = sheet.Properties.Title
+		}
+	}
+	if len(rawSheets) == 0 {
+		return []string{fmt.Sprintf("no raw data sheets matching %q found", template)}
+	}
+	months := sortedKeys(rawSheets)
+	first, _ := time.Parse("2006-01", months[0])
+	for ref := first; ref.Format("2006-01") < months[len(months)-1]; ref = ref.AddDate(0, 1, 0) {
+		if _, exists := rawSheets[ref.Format("2006-01")]; !exists {
+			problems = append(problems, fmt.Sprintf("%s: no raw data sheet %q", ref.Format("2006-01"),
+				ref.Format(template)))
+		}
+	}
+
+	// The main sheet is fetched both as displayed (to find the cells naming
+	// the raw data sheets and any errors) and as formulas (to find the
+	// references).
+	mainRange := fmt.Sprintf("'%s'", mainSheetName)
+	displayed, err := srv.Spreadsheets.Values.Get(spreadsheetId, mainRange).Do()
+	if err != nil {
+		exitf(ExitProviderError, "[verify-sheet] error reading main sheet %q: %v", mainSheetName, err)
+	}
+	formulas, err := srv.Spreadsheets.Values.Get(spreadsheetId, mainRange).ValueRenderOption("FORMULA").Do()
+	if err != nil {
+		exitf(ExitProviderError, "[verify-sheet] error reading main sheet %q formulas: %v", mainSheetName, err)
+	}
+	for r, row := range displayed.Values {
+		for c, cell := range row {
+			if str, ok := cell.(string); ok && strings.Contains(str, "#REF!") {
+				problems = append(problems, fmt.Sprintf("%s!%s%d: %s (formula %v)", mainSheetName, colNumToRef(c),
+					r+1, str, getCellValue(formulas.Values, r, c)))
+			}
+		}
+	}
+
+	// The dimensions of the data in each raw data sheet.
+	var ranges []string
+	for _, month := range months {
+		ranges = append(ranges, fmt.Sprintf("'%s'", rawSheets[month]))
+	}
+	rawValues, err := srv.Spreadsheets.Values.BatchGet(spreadsheetId).Ranges(ranges...).Do()
+	if err != nil {
+		exitf(ExitProviderError, "[verify-sheet] error reading raw data sheets: %v", err)
+	}
+	dataRows := make(map[string]int)
+	dataColumns := make(map[string]int)
+	for idx, valueRange := range rawValues.ValueRanges {
+		name := rawSheets[months[idx]]
+		dataRows[name] = len(valueRange.Values)
+		for _, row := range valueRange.Values {
+			dataColumns[name] = max(dataColumns[name], len(row))
+		}
+	}
+
+	for _, month := range months {
+		name := rawSheets[month]
+		r, c, found := findSheetNameCell(displayed.Values, name)
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: no reference block for %q on the main sheet", month, name))
+			continue
+		}
+		// The block is the run of cells below the name cell holding formulas.
+		blockRows := 0
+		for row := r + 1; row < len(formulas.Values); row++ {
+			if !strings.HasPrefix(fmt.Sprint(getCellValue(formulas.Values, row, c)), "=") {
+				break
+			}
+			blockRows++
+		}
+		if blockRows < dataRows[name] {
+			problems = append(problems, fmt.Sprintf("%s: the reference block at %s!%s%d has %d rows, "+
+				"but %q has %d rows of data", month, mainSheetName, colNumToRef(c), r+1, blockRows, name,
+				dataRows[name]))
+		}
+	}
+
+	for r, row := range formulas.Values {
+		for c, cell := range row {
+			str, ok := cell.(string)
+			if !ok || !strings.HasPrefix(str, "=") {
+				continue
+			}
+			for _, match := range sheetRangePattern.FindAllStringSubmatch(str, -1) {
+				name := strings.ReplaceAll(match[1], "''", "'")
+				rows, isRaw := dataRows[name]
+				if !isRaw {
+					continue
+				}
+				if problem := checkSheetRange(match, rows, dataColumns[name]); problem != "" {
+					problems = append(problems, fmt.Sprintf("%s!%s%d: %s %s", mainSheetName, colNumToRef(c),
+						r+1, match[0], problem))
+				}
+			}
+		}
+	}
+	return
+}
+
+// checkSheetRange checks a reference matched by sheetRangePattern against the
+// dimensions of the data in the referenced sheet, and returns a description
+// of the problem if the range does not cover all the data.  References to
+// single cells or single rows are not checked, nor are the rows of
+// whole-column references.
+func checkSheetRange(match []string, rows int, columns int) string {
+	startColumn, startRow, endColumn, endRow := match[2], match[3], match[4], match[5]
+	if endColumn == "" || (startRow != "" && startRow == endRow) {
+		return ""
+	}
+	if last := colRefToNum(endColumn); last > colRefToNum(startColumn) && last < columns-1 {
+		return fmt.Sprintf("ends at column %s, but the data extends to column %s", endColumn,
+			colNumToRef(columns-1))
+	}
+	if last, err := strconv.Atoi(endRow); err == nil && last < rows {
+		return fmt.Sprintf("ends at row %d, but the data extends to row %d", last, rows)
+	}
+	return ""
+}
+
+// getCellValue returns the value of the cell at the given (zero-based) row and
+// column of the values, or nil if it is beyond the data.
+func getCellValue(values [][]any, row int, column int) any {
+	if row >= len(values) || column >= len(values[row]) {
+		return nil
+	}
+	return values[row][column]
+}